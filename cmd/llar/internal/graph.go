@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/goplus/llar/internal/modules"
+	"github.com/goplus/llar/mod/module"
+	"github.com/spf13/cobra"
+)
+
+var graphFormat string
+
+var graphCmd = &cobra.Command{
+	Use:   "graph [module@version]",
+	Short: "Print the resolved dependency graph of a module",
+	Long: `Graph prints the module requirement graph selected by MVS, without
+building anything.
+
+For the main module and every module version selected by MVS, each of its
+requirements is printed as an edge "module@version dep@version". The
+required version may be lower than the one MVS selected for dep.
+
+The output format is chosen with --format:
+
+  text  one edge per line, like 'go mod graph' (default)
+  dot   a Graphviz digraph; versions that lost to a higher one are dashed
+  json  the main module, the build list and the edges
+
+Formulas may require dependencies only for some matrix combinations. The
+requirements are those of the combination make builds by default, for the
+host os and arch with the default options; --matrix and --option select
+another one.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runGraph,
+}
+
+func init() {
+	graphCmd.Flags().StringVarP(&graphFormat, "format", "f", "text", "Output format: text, dot or json")
+	addResolveFlags(graphCmd)
+	addMatrixFlags(graphCmd)
+	rootCmd.AddCommand(graphCmd)
+}
+
+func runGraph(cmd *cobra.Command, args []string) error {
+	var write func(w io.Writer, g *modules.Graph) error
+	switch graphFormat {
+	case "text":
+		write = writeGraphText
+	case "dot":
+		write = writeGraphDOT
+	case "json":
+		write = writeGraphJSON
+	default:
+		return fmt.Errorf("unknown graph format %q: want text, dot or json", graphFormat)
+	}
+
+	pattern, version, isLocal, err := parseModuleArg(args[0])
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	store, targets, err := resolveTargets(pattern, version, isLocal)
+	if err != nil {
+		return err
+	}
+	opts, err := loadOptions(store)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		opts, err := matrixOptions(ctx, target, opts)
+		if err != nil {
+			return err
+		}
+		g, err := modules.LoadGraph(ctx, target, opts)
+		if err != nil {
+			return fmt.Errorf("failed to load modules: %w", err)
+		}
+		if err := write(cmd.OutOrStdout(), g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// graphEdge is a requirement of From on To, at the version From requires.
+type graphEdge struct {
+	From module.Version
+	To   module.Version
+}
+
+// graphEdges returns the requirements of every module in the build list of
+// g, in build list order. Versions that were not selected are not
+// expanded: their requirements do not affect the build.
+func graphEdges(g *modules.Graph) []graphEdge {
+	var edges []graphEdge
+	for _, m := range g.BuildList {
+		reqs, _ := g.RequiredBy(m)
+		for _, r := range reqs {
+			edges = append(edges, graphEdge{From: m, To: r})
+		}
+	}
+	return edges
+}
+
+// modString returns m in the form "path@version".
+func modString(m module.Version) string {
+	return m.Path + "@" + m.Version
+}
+
+func writeGraphText(w io.Writer, g *modules.Graph) error {
+	for _, e := range graphEdges(g) {
+		if _, err := fmt.Fprintf(w, "%s %s\n", modString(e.From), modString(e.To)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeGraphDOT(w io.Writer, g *modules.Graph) error {
+	selected := make(map[module.Version]bool, len(g.BuildList))
+	for _, m := range g.BuildList {
+		selected[m] = true
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph deps {\n")
+	fmt.Fprintf(&b, "\t%q [shape=box];\n", modString(g.Main))
+	declared := map[module.Version]bool{g.Main: true}
+	edges := graphEdges(g)
+	for _, e := range edges {
+		if declared[e.To] {
+			continue
+		}
+		declared[e.To] = true
+		if !selected[e.To] {
+			fmt.Fprintf(&b, "\t%q [style=dashed, color=gray];\n", modString(e.To))
+		}
+	}
+	for _, e := range edges {
+		attrs := ""
+		if !selected[e.To] {
+			attrs = " [style=dashed, color=gray]"
+		}
+		fmt.Fprintf(&b, "\t%q -> %q%s;\n", modString(e.From), modString(e.To), attrs)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// graphJSON is the JSON form of a module graph.
+type graphJSON struct {
+	Main      string          `json:"main"`
+	BuildList []string        `json:"buildList"`
+	Edges     []graphJSONEdge `json:"edges"`
+}
+
+type graphJSONEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func writeGraphJSON(w io.Writer, g *modules.Graph) error {
+	out := graphJSON{
+		Main:      modString(g.Main),
+		BuildList: make([]string, 0, len(g.BuildList)),
+		Edges:     []graphJSONEdge{},
+	}
+	for _, m := range g.BuildList {
+		out.BuildList = append(out.BuildList, modString(m))
+	}
+	for _, e := range graphEdges(g) {
+		out.Edges = append(out.Edges, graphJSONEdge{From: modString(e.From), To: modString(e.To)})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/internal/formula/repo"
+)
+
+// runGraphCmd executes `llar graph args...` in-process and returns its
+// output. test/app@1.0.0 requires test/libb@1.0.0 and test/liba@1.0.0, and
+// test/libb@1.0.0 requires test/liba@1.1.0, so MVS selects liba@1.1.0.
+func runGraphCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	withMockRemoteStore(t, repo.New(setupLocalFormulas(t), &noopVCSRepo{}))
+
+	graphFormat = "text"
+	resolveReplace, resolveExclude, resolveMatrix = nil, nil, matrixFlags{}
+	var buf bytes.Buffer
+	cmd := rootCmd
+	cmd.SetOut(&buf)
+	defer cmd.SetOut(nil)
+	cmd.SetArgs(append([]string{"graph"}, args...))
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+func TestGraph_Text(t *testing.T) {
+	out, err := runGraphCmd(t, "test/app@1.0.0")
+	if err != nil {
+		t.Fatalf("llar graph failed: %v", err)
+	}
+	want := `test/app@1.0.0 test/libb@1.0.0
+test/app@1.0.0 test/liba@1.0.0
+test/libb@1.0.0 test/liba@1.1.0
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestGraph_DOT(t *testing.T) {
+	out, err := runGraphCmd(t, "--format", "dot", "test/app@1.0.0")
+	if err != nil {
+		t.Fatalf("llar graph failed: %v", err)
+	}
+	for _, want := range []string{
+		"digraph deps {",
+		`"test/app@1.0.0" [shape=box];`,
+		`"test/liba@1.0.0" [style=dashed, color=gray];`,
+		`"test/app@1.0.0" -> "test/libb@1.0.0";`,
+		`"test/app@1.0.0" -> "test/liba@1.0.0" [style=dashed, color=gray];`,
+		`"test/libb@1.0.0" -> "test/liba@1.1.0";`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DOT output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGraph_JSON(t *testing.T) {
+	out, err := runGraphCmd(t, "-f", "json", "test/app@1.0.0")
+	if err != nil {
+		t.Fatalf("llar graph failed: %v", err)
+	}
+	var got graphJSON
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("invalid JSON output: %v\n%s", err, out)
+	}
+	if got.Main != "test/app@1.0.0" {
+		t.Errorf("main = %q, want %q", got.Main, "test/app@1.0.0")
+	}
+	wantList := []string{"test/app@1.0.0", "test/liba@1.1.0", "test/libb@1.0.0"}
+	if !slices.Equal(got.BuildList, wantList) {
+		t.Errorf("buildList = %q, want %q", got.BuildList, wantList)
+	}
+	if len(got.Edges) != 3 || got.Edges[2] != (graphJSONEdge{From: "test/libb@1.0.0", To: "test/liba@1.1.0"}) {
+		t.Errorf("edges = %+v", got.Edges)
+	}
+}
+
+func TestGraph_Exclude(t *testing.T) {
+	out, err := runGraphCmd(t, "--exclude", "test/liba@1.1.0", "test/app@1.0.0")
+	if err != nil {
+		t.Fatalf("llar graph failed: %v", err)
+	}
+	want := `test/app@1.0.0 test/libb@1.0.0
+test/app@1.0.0 test/liba@1.0.0
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+
+	if _, err := runGraphCmd(t, "--exclude", "test/liba", "test/app@1.0.0"); err == nil || !strings.Contains(err.Error(), "want path@version") {
+		t.Errorf("invalid exclusion error = %v", err)
+	}
+}
+
+func TestGraph_Errors(t *testing.T) {
+	if _, err := runGraphCmd(t, "-f", "svg", "test/app@1.0.0"); err == nil || !strings.Contains(err.Error(), "unknown graph format") {
+		t.Errorf("unknown format error = %v", err)
+	}
+	if _, err := runGraphCmd(t, "test/missing@1.0.0"); err == nil {
+		t.Error("expected error for a module without formula")
+	}
+}
+
+// test/libopt@1.0.0 requires test/liba@1.0.0 only with its zlib option on,
+// which it is by default.
+func TestGraph_Matrix(t *testing.T) {
+	out, err := runGraphCmd(t, "test/libopt@1.0.0")
+	if err != nil {
+		t.Fatalf("llar graph failed: %v", err)
+	}
+	if want := "test/libopt@1.0.0 test/liba@1.0.0\n"; out != want {
+		t.Errorf("output with the default options =\n%s\nwant\n%s", out, want)
+	}
+
+	out, err = runGraphCmd(t, "--option", "zlib=off", "test/libopt@1.0.0")
+	if err != nil {
+		t.Fatalf("llar graph --option failed: %v", err)
+	}
+	if out != "" {
+		t.Errorf("output with zlib=off =\n%s\nwant no edge", out)
+	}
+
+	if _, err := runGraphCmd(t, "--option", "zlib=maybe", "test/libopt@1.0.0"); err == nil || !strings.Contains(err.Error(), "invalid matrix for test/libopt") {
+		t.Errorf("invalid option error = %v", err)
+	}
+}
@@ -1,21 +1,122 @@
 package internal
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/goplus/llar/internal/build"
+	"github.com/goplus/llar/internal/prefix"
 	"github.com/spf13/cobra"
 )
 
+var installVerbose bool
+var installPrefix string
+var installJobs int
+
 var installCmd = &cobra.Command{
 	Use:   "install [module@version]",
 	Short: "Install a module",
-	Long:  `Install downloads, builds, and installs a module.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runInstall,
+	Long: `Install downloads, builds, and installs a module.
+
+The module is built the same way as 'llar make'. The module and all of its
+transitive dependencies are then copied into a single install prefix
+(include/, lib/, lib/pkgconfig/, bin/, ...), which defaults to ~/.llar/prefix.
+Tools used by the build are left out.
+
+Every installed module leaves a receipt under <prefix>/.llar/receipts that
+records the exact files it contributed. Installing another version of an
+already installed module, or a rebuild of the same version, removes the
+files of the old build first.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInstall,
 }
 
 func init() {
+	installCmd.Flags().BoolVarP(&installVerbose, "verbose", "v", false, "Enable verbose build output")
+	installCmd.Flags().StringVarP(&installPrefix, "prefix", "p", "", "Install prefix (default ~/.llar/prefix)")
+	installCmd.Flags().IntVarP(&installJobs, "jobs", "j", 1, "Number of modules to build in parallel")
+	addResolveFlags(installCmd)
 	rootCmd.AddCommand(installCmd)
 }
 
 func runInstall(cmd *cobra.Command, args []string) error {
-	panic("TODO")
+	pattern, version, isLocal, err := parseModuleArg(args[0])
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	prefixDir := installPrefix
+	if prefixDir == "" {
+		prefixDir, err = prefix.DefaultDir()
+		if err != nil {
+			return fmt.Errorf("failed to get default prefix: %w", err)
+		}
+	}
+	p, err := prefix.New(prefixDir)
+	if err != nil {
+		return fmt.Errorf("failed to open prefix: %w", err)
+	}
+
+	store, targets, err := resolveTargets(pattern, version, isLocal)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		main, err := loadMain(ctx, store, target.Path, target.Version)
+		if err != nil {
+			return err
+		}
+		combos, err := matrixFlags{}.combinations(main.Matrix)
+		if err != nil {
+			return fmt.Errorf("invalid matrix for %s: %w", target.Path, err)
+		}
+		matrixStr := combos[0]
+		mods, err := loadModules(ctx, store, target.Path, main.Version, matrixStr)
+		if err != nil {
+			return err
+		}
+		results, err := buildResults(ctx, store, mods, matrixStr, false, "", installVerbose, installJobs)
+		if err != nil {
+			return err
+		}
+		if err := installResults(p, results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installResults copies the build results of the linked modules into the
+// prefix, dependencies first, skipping modules whose installed build
+// already matches. Tools are left out. A module linked for two matrix
+// combinations is an error, since the prefix holds one build per module.
+func installResults(p *prefix.Prefix, results []build.Result) error {
+	unlock, err := p.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	matrices := make(map[string]string)
+	for _, result := range results {
+		if !result.Linked {
+			continue
+		}
+		mod := result.Module
+		if matrix, ok := matrices[mod.Path]; ok {
+			return fmt.Errorf("%s@%s is linked for both %s and %s", mod.Path, mod.Version, matrix, result.Matrix)
+		}
+		matrices[mod.Path] = result.Matrix
+		if r, err := p.Receipt(mod.Path); err == nil && r.Version == mod.Version && r.Matrix == result.Matrix && r.Key == result.Key {
+			fmt.Printf("%s@%s already installed\n", mod.Path, mod.Version)
+			continue
+		}
+		if _, err := p.Install(mod, result.Matrix, result.Key, result.OutputDir); err != nil {
+			return fmt.Errorf("failed to install %s@%s: %w", mod.Path, mod.Version, err)
+		}
+		fmt.Printf("installed %s@%s\n", mod.Path, mod.Version)
+	}
+	return nil
 }
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/internal/build"
+	"github.com/goplus/llar/internal/formula/repo"
+	"github.com/goplus/llar/internal/prefix"
+	"github.com/goplus/llar/mod/module"
+)
+
+// runInstallCmd mirrors runMakeCmd for the `llar install` subcommand.
+func runInstallCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get cwd: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	installVerbose = true
+	installPrefix = ""
+
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+	defer func() { os.Stdout = old }()
+
+	var buf bytes.Buffer
+	copyDone := make(chan error, 1)
+	go func() {
+		_, copyErr := io.Copy(&buf, r)
+		copyDone <- copyErr
+	}()
+
+	cmd := rootCmd
+	cmd.SetArgs(append([]string{"install"}, args...))
+	err = cmd.Execute()
+
+	_ = w.Close()
+	if copyErr := <-copyDone; copyErr != nil {
+		t.Fatalf("failed to capture stdout: %v", copyErr)
+	}
+	return buf.String(), err
+}
+
+func TestInstallLocal_FromCache(t *testing.T) {
+	formulaDir := setupLocalFormulas(t)
+	withMockRemoteStore(t, repo.New(formulaDir, &noopVCSRepo{}))
+
+	origDir, _ := os.Getwd()
+	os.Chdir(filepath.Join(formulaDir, "test", "liba"))
+	defer os.Chdir(origDir)
+
+	workspaceDir := isolatedWorkspaceDir(t)
+	prepopulateCache(t, workspaceDir, "test/liba", "1.0.0", computeMatrixStr(), "-lA")
+
+	prefixDir := t.TempDir()
+	out, err := runInstallCmd(t, "-v", "--prefix", prefixDir, "./@1.0.0")
+	if err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	if !strings.Contains(out, "installed test/liba@1.0.0") {
+		t.Errorf("output = %q, want install notice", out)
+	}
+
+	data, err := os.ReadFile(filepath.Join(prefixDir, "lib", "liba.a"))
+	if err != nil {
+		t.Fatalf("prefix missing lib/liba.a: %v", err)
+	}
+	if string(data) != "testlib" {
+		t.Errorf("lib/liba.a = %q, want %q", data, "testlib")
+	}
+
+	p, err := prefix.New(prefixDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.Receipt("test/liba")
+	if err != nil {
+		t.Fatalf("missing receipt: %v", err)
+	}
+	if r.Version != "1.0.0" {
+		t.Errorf("receipt version = %q, want %q", r.Version, "1.0.0")
+	}
+
+	// Installing the same version again is a no-op.
+	out, err = runInstallCmd(t, "-v", "--prefix", prefixDir, "./@1.0.0")
+	if err != nil {
+		t.Fatalf("reinstall failed: %v", err)
+	}
+	if !strings.Contains(out, "test/liba@1.0.0 already installed") {
+		t.Errorf("output = %q, want already-installed notice", out)
+	}
+}
+
+func TestInstall_InvalidDotSyntax(t *testing.T) {
+	_, err := runInstallCmd(t, ".@v1.0.0")
+	if err == nil {
+		t.Fatal("expected error for .@version syntax")
+	}
+}
+
+func TestInstallResults(t *testing.T) {
+	p, err := prefix.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := func(file string) string {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "lib"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "lib", file), []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	liba := build.Result{Module: module.Version{Path: "test/liba", Version: "1.0.0"}, Matrix: "m", Key: "k1", OutputDir: output("liba.a"), Linked: true}
+	tool := build.Result{Module: module.Version{Path: "test/tool", Version: "1.0.0"}, Matrix: "host", Key: "t", OutputDir: output("tool.a")}
+
+	if err := installResults(p, []build.Result{tool, liba}); err != nil {
+		t.Fatalf("installResults: %v", err)
+	}
+	if _, err := p.Receipt("test/tool"); err == nil {
+		t.Error("tool test/tool was installed")
+	}
+	if r, err := p.Receipt("test/liba"); err != nil || r.Key != "k1" {
+		t.Fatalf("receipt of test/liba = %+v, %v, want key k1", r, err)
+	}
+
+	// A rebuild of the same version and matrix is installed again.
+	rebuilt := liba
+	rebuilt.Key, rebuilt.OutputDir = "k2", output("liba2.a")
+	if err := installResults(p, []build.Result{rebuilt}); err != nil {
+		t.Fatalf("installResults: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir(), "lib", "liba2.a")); err != nil {
+		t.Errorf("rebuilt test/liba not installed: %v", err)
+	}
+
+	variant := liba
+	variant.Matrix = "m2"
+	if err := installResults(p, []build.Result{liba, variant}); err == nil || !strings.Contains(err.Error(), "linked for both") {
+		t.Errorf("installResults of two variants error = %v", err)
+	}
+}
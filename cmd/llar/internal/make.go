@@ -2,13 +2,16 @@ package internal
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
 	"fmt"
 	stdbuild "go/build"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/goplus/llar/formula"
@@ -16,6 +19,7 @@ import (
 	"github.com/goplus/llar/internal/formula/repo"
 	"github.com/goplus/llar/internal/modules"
 	"github.com/goplus/llar/internal/modules/modlocal"
+	"github.com/goplus/llar/internal/prefix"
 	"github.com/goplus/llar/internal/vcs"
 	"github.com/goplus/llar/mod/module"
 	"github.com/spf13/cobra"
@@ -23,6 +27,20 @@ import (
 
 var makeVerbose bool
 var makeOutput string
+var makeJobs int
+var makeLockFile string
+var makeLocked bool
+var makeMatrix matrixFlags
+var makeCflags, makeLibs, makeStatic bool
+var makeWithDeps bool
+
+// Resolution flags, shared by every command that loads modules.
+var resolveReplace []string
+var resolveExclude []string
+
+// resolveMatrix selects the matrix combination that graph, why, upgrade and
+// downgrade resolve the requirements of formulas for.
+var resolveMatrix matrixFlags
 
 // newRemoteStore creates the remote formula store. Overridable for testing.
 var newRemoteStore = func() (repo.Store, error) {
@@ -40,17 +58,108 @@ var newRemoteStore = func() (repo.Store, error) {
 var makeCmd = &cobra.Command{
 	Use:   "make [module@version]",
 	Short: "Build a module to FormulaDir",
-	Long:  `Make downloads and builds a module to FormulaDir.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runMake,
+	Long: `Make downloads and builds a module to FormulaDir.
+
+With --lockfile, the resolved build list is recorded in a lock file: every
+module@version, the formula it was read from and the source commit its
+version resolved to. Module versions already in the lock file keep their
+locked source commits, and the main module version defaults to the locked
+one. A resolution that differs from the lock file is recorded with a
+warning. With --locked, make fails instead if the resolution differs from
+the lock file, which is left untouched. A lock file records the build list of
+one matrix combination, so neither flag can be used with --all-matrix.
+
+The module is built for the host os and arch by default. --matrix and
+--option select another combination of the matrix the formula declares,
+such as a cross target, and are checked against it; options left out take
+their default values. --all-matrix builds every combination of the matrix,
+each into its own output directory (a subdirectory of -o named after the
+combination).
+
+Make prints the metadata of the module. With --cflags, --libs or both, it
+prints instead the flags needed to compile or link against the module and
+all its transitive dependencies, a module before its dependencies and
+without duplicates, like pkg-config. --static adds the flags only needed
+to link statically, like pkg-config --static, and implies --libs unless
+--cflags is set.
+
+With --with-deps, -o receives the outputs of the module and of every
+module linked into it, merged as an install prefix: a file written by two
+modules is an error, and .llar/receipts records, per module, the version,
+matrix combination and files it contributed. Tools are left out.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runMake,
 }
 
 func init() {
 	makeCmd.Flags().BoolVarP(&makeVerbose, "verbose", "v", false, "Enable verbose build output")
 	makeCmd.Flags().StringVarP(&makeOutput, "output", "o", "", "Output path (directory or .zip file)")
+	makeCmd.Flags().IntVarP(&makeJobs, "jobs", "j", 1, "Number of modules to build in parallel")
+	makeCmd.Flags().StringVar(&makeLockFile, "lockfile", "", "Record and honor the resolution in this lock file")
+	makeCmd.Flags().BoolVar(&makeLocked, "locked", false, "Fail if the resolution differs from the lock file (default "+modules.LockFileName+")")
+	makeCmd.Flags().StringArrayVar(&makeMatrix.require, "matrix", nil, "Build for these matrix values: key=value[,key=value...] (default the host os and arch)")
+	makeCmd.Flags().StringArrayVar(&makeMatrix.options, "option", nil, "Build with these matrix options: key=value[,key=value...]")
+	makeCmd.Flags().BoolVar(&makeMatrix.all, "all-matrix", false, "Build every combination of the matrix")
+	makeCmd.Flags().BoolVar(&makeCflags, "cflags", false, "Print the compiler flags of the module and its dependencies")
+	makeCmd.Flags().BoolVar(&makeLibs, "libs", false, "Print the linker flags of the module and its dependencies")
+	makeCmd.Flags().BoolVar(&makeStatic, "static", false, "Print the linker flags to link statically")
+	makeCmd.Flags().BoolVar(&makeWithDeps, "with-deps", false, "Write the outputs of the dependencies to -o too")
+	addResolveFlags(makeCmd)
 	rootCmd.AddCommand(makeCmd)
 }
 
+// addResolveFlags registers the flags that change how the dependencies of
+// a module are resolved.
+func addResolveFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&resolveReplace, "replace", nil, "Replace the source of a module: old[@v]=new[@v], new being a repository or a local directory")
+	cmd.Flags().StringArrayVar(&resolveExclude, "exclude", nil, "Exclude a module version from resolution: path@version")
+}
+
+// addMatrixFlags registers the flags that select the matrix combination
+// the requirements of formulas are resolved for, as make does.
+func addMatrixFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&resolveMatrix.require, "matrix", nil, "Resolve for these matrix values: key=value[,key=value...] (default the host os and arch)")
+	cmd.Flags().StringArrayVar(&resolveMatrix.options, "option", nil, "Resolve with these matrix options: key=value[,key=value...]")
+}
+
+// matrixOptions returns opts set to resolve the requirements of target for
+// the matrix combination selected by resolveMatrix: by default the one make
+// builds, for the host with the default options. Formulas may require
+// dependencies only for some combinations.
+func matrixOptions(ctx context.Context, target module.Version, opts modules.Options) (modules.Options, error) {
+	main, err := modules.LoadMain(ctx, target, opts)
+	if err != nil {
+		return modules.Options{}, fmt.Errorf("failed to load modules: %w", err)
+	}
+	combos, err := resolveMatrix.combinations(main.Matrix)
+	if err != nil {
+		return modules.Options{}, fmt.Errorf("invalid matrix for %s: %w", target.Path, err)
+	}
+	opts.Matrix = combos[0]
+	return opts, nil
+}
+
+// loadOptions returns the options to load modules from store with, as
+// set by the resolution flags.
+func loadOptions(store repo.Store) (modules.Options, error) {
+	opts := modules.Options{FormulaStore: store}
+	for _, s := range resolveReplace {
+		r, err := modules.ParseReplace(s)
+		if err != nil {
+			return modules.Options{}, err
+		}
+		opts.Replace = append(opts.Replace, r)
+	}
+	for _, s := range resolveExclude {
+		m, err := modules.ParseExclude(s)
+		if err != nil {
+			return modules.Options{}, err
+		}
+		opts.Exclude = append(opts.Exclude, m)
+	}
+	return opts, nil
+}
+
 func runMake(cmd *cobra.Command, args []string) error {
 	pattern, version, isLocal, err := parseModuleArg(args[0])
 	if err != nil {
@@ -59,7 +168,11 @@ func runMake(cmd *cobra.Command, args []string) error {
 
 	ctx := context.Background()
 
-	// Resolve output path to absolute before build (build may change cwd)
+	if makeWithDeps && makeOutput == "" {
+		return fmt.Errorf("--with-deps requires -o")
+	}
+
+	// Resolve output path to absolute before build
 	if makeOutput != "" {
 		abs, err := filepath.Abs(makeOutput)
 		if err != nil {
@@ -68,88 +181,307 @@ func runMake(cmd *cobra.Command, args []string) error {
 		makeOutput = abs
 	}
 
-	matrixStr := hostMatrixCombo()
+	store, targets, err := resolveTargets(pattern, version, isLocal)
+	if err != nil {
+		return err
+	}
+	if (makeLockFile != "" || makeLocked) && len(targets) > 1 {
+		return fmt.Errorf("a lock file records a single main module, but %s matches %d modules", args[0], len(targets))
+	}
+	if (makeLockFile != "" || makeLocked) && makeMatrix.all {
+		return fmt.Errorf("a lock file records the build list of a single matrix combination: --all-matrix cannot be combined with --lockfile or --locked")
+	}
+	for _, target := range targets {
+		if err := buildModule(ctx, store, target.Path, target.Version, makeMatrix, false, makeVerbose, makeJobs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// resolveTargets returns the formula store and the module versions selected
+// by a parsed module argument. Remote arguments select a single module served
+// by the remote store. Local patterns are resolved from disk and served
+// through an overlay store, with dependencies still coming from remote; a
+// version pinned by the local formula wins over the global @version.
+func resolveTargets(pattern, version string, isLocal bool) (repo.Store, []module.Version, error) {
 	// Set up remote formula store (always needed for deps)
 	remoteStore, err := newRemoteStore()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	if !isLocal {
-		return buildModule(ctx, remoteStore, pattern, version, matrixStr, false)
+		return remoteStore, []module.Version{{Path: pattern, Version: version}}, nil
 	}
 
 	// Resolve local pattern
 	cwd, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("failed to get working directory: %w", err)
+		return nil, nil, fmt.Errorf("failed to get working directory: %w", err)
 	}
 
 	localMods, err := modlocal.Resolve(cwd, pattern)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Build overlay: local modules from disk, deps from remote
 	locals := make(map[string]string, len(localMods))
+	targets := make([]module.Version, 0, len(localMods))
 	for _, m := range localMods {
 		locals[m.Path] = m.Dir
-	}
-	store := repo.NewOverlayStore(remoteStore, locals)
-
-	for _, m := range localMods {
 		ver := m.Version
 		if ver == "" {
 			ver = version // global @version from arg
 		}
-		if err := buildModule(ctx, store, m.Path, ver, matrixStr, false); err != nil {
+		targets = append(targets, module.Version{Path: m.Path, Version: ver})
+	}
+	return repo.NewOverlayStore(remoteStore, locals), targets, nil
+}
+
+// matrixFlags select the matrix combinations to build a module for.
+type matrixFlags struct {
+	require []string // key=value[,key=value...] of the matrix Require
+	options []string // key=value[,key=value...] of the matrix Options
+	all     bool     // every combination of the matrix
+}
+
+// combinations returns the combinations of m selected by f, in their
+// canonical encoding (see formula.Combination). Unless all is set, it is a
+// single combination, for the host os and arch unless specified otherwise.
+// A formula declaring no matrix can be built for any os and arch, and has
+// no other key.
+func (f matrixFlags) combinations(m formula.Matrix) ([]string, error) {
+	if f.all {
+		if len(f.require) > 0 || len(f.options) > 0 {
+			return nil, fmt.Errorf("--all-matrix cannot be combined with --matrix or --option")
+		}
+		if combos := m.Expand(); len(combos) > 0 {
+			strs := make([]string, len(combos))
+			for i, c := range combos {
+				strs[i] = c.String()
+			}
+			return strs, nil
+		}
+	}
+	require, err := parseMatrixValues(f.require)
+	if err != nil {
+		return nil, err
+	}
+	options, err := parseMatrixValues(f.options)
+	if err != nil {
+		return nil, err
+	}
+
+	host := map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH}
+	if len(m.Require) == 0 && len(m.Options) == 0 {
+		m = formula.Matrix{Require: make(map[string][]string)}
+		for k, v := range host {
+			if r, ok := require[k]; ok {
+				v = r
+			}
+			m.Require[k] = []string{v}
+		}
+	}
+	for k, v := range host {
+		if _, ok := require[k]; !ok && slices.Contains(m.Require[k], v) {
+			require[k] = v
+		}
+	}
+	combo, err := m.Select(require, options)
+	if err != nil {
+		return nil, err
+	}
+	return []string{combo.String()}, nil
+}
+
+// parseMatrixValues parses a list of "key=value[,key=value...]".
+func parseMatrixValues(list []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, s := range list {
+		for _, kv := range strings.Split(s, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" || v == "" {
+				return nil, fmt.Errorf("invalid matrix value %q: want key=value", kv)
+			}
+			if old, ok := values[k]; ok && old != v {
+				return nil, fmt.Errorf("conflicting matrix values %s=%s and %s=%s", k, old, k, v)
+			}
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// buildModule loads a module and builds it for every matrix combination
+// selected by sel. When runTest is true, the builder additionally runs the
+// root target's onTest hook against the module's artifacts (freshly built
+// or reused from cache). Transitive dependencies still honor the build
+// cache and do not have their onTest hooks triggered — each dependency is
+// verified by its own `llar test <dep>` invocation. verbose and jobs are
+// passed on to buildResults.
+func buildModule(ctx context.Context, store repo.Store, modPath, version string, sel matrixFlags, runTest, verbose bool, jobs int) error {
+	main, err := loadMain(ctx, store, modPath, version)
+	if err != nil {
+		return err
+	}
+	combos, err := sel.combinations(main.Matrix)
+	if err != nil {
+		return fmt.Errorf("invalid matrix for %s: %w", modPath, err)
+	}
+	multi := len(combos) > 1
+	if multi && strings.HasSuffix(makeOutput, ".zip") {
+		return fmt.Errorf("cannot write %d matrix combinations to %s: use a directory", len(combos), makeOutput)
+	}
+	for _, matrixStr := range combos {
+		output := makeOutput
+		if multi && output != "" {
+			output = filepath.Join(output, matrixStr)
+		}
+		mods, err := loadModules(ctx, store, modPath, main.Version, matrixStr)
+		if err != nil {
 			return err
 		}
+		if err := buildCombination(ctx, store, mods, matrixStr, runTest, output, multi, verbose, jobs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildCombination builds mods for one matrix combination and writes the
+// main module's output to output, if set, with those of its dependencies
+// with --with-deps. The metadata printed is
+// prefixed with the combination if prefix is set.
+func buildCombination(ctx context.Context, store repo.Store, mods []*modules.Module, matrixStr string, runTest bool, output string, prefix, verbose bool, jobs int) error {
+	var workspaceDir string
+	if output != "" {
+		tmpDir, err := os.MkdirTemp("", "llar-make-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp workspace: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		workspaceDir = tmpDir
+	}
+
+	results, err := buildResults(ctx, store, mods, matrixStr, runTest, workspaceDir, verbose, jobs)
+	if err != nil {
+		return err
+	}
+
+	if len(results) > 0 {
+		main := results[len(results)-1]
+		if flags := resultFlags(main); flags != "" {
+			if prefix {
+				fmt.Printf("%s: %s\n", matrixStr, flags)
+			} else {
+				fmt.Println(flags)
+			}
+		}
+		if output != "" {
+			write := func() error { return outputResult(main.OutputDir, output) }
+			if makeWithDeps {
+				write = func() error { return outputWithDeps(results, output) }
+			}
+			if err := write(); err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+		}
 	}
+
 	return nil
 }
 
-// hostMatrixCombo returns the matrix combination for the current host
-// (os+arch). It is used by both `llar make` and `llar test` to select
-// the default build variant when the user does not specify one.
-func hostMatrixCombo() string {
-	matrix := formula.Matrix{
-		Require: map[string][]string{
-			"os":   {runtime.GOOS},
-			"arch": {runtime.GOARCH},
-		},
-	}
-	return matrix.Combinations()[0]
+// resultFlags returns what make prints for the result of the main module:
+// its metadata or, with --cflags, --libs or --static, the flags selected
+// from the closure of its dependencies.
+func resultFlags(r build.Result) string {
+	if !makeCflags && !makeLibs && !makeStatic {
+		return r.Metadata
+	}
+	return selectFlags(r.Closure, makeCflags, makeLibs, makeStatic)
 }
 
-// buildModule loads and builds a single module. When runTest is true, the
-// builder additionally runs the root target's onTest hook against the
-// module's artifacts (freshly built or reused from cache). Transitive
-// dependencies still honor the build cache and do not have their onTest
-// hooks triggered — each dependency is verified by its own
-// `llar test <dep>` invocation.
-func buildModule(ctx context.Context, store repo.Store, modPath, version, matrixStr string, runTest bool) error {
-	mods, err := modules.Load(ctx, module.Version{Path: modPath, Version: version}, modules.Options{
-		FormulaStore: store,
-	})
+// selectFlags returns the compiler flags of pc if cflags is set, followed
+// by its linker flags if libs is set, static selecting those to link
+// statically. static alone implies libs.
+func selectFlags(pc formula.PkgConfig, cflags, libs, static bool) string {
+	var flags []string
+	if cflags {
+		flags = append(flags, pc.CflagsString())
+	}
+	if libs || (static && !cflags) {
+		flags = append(flags, pc.LibsString(static))
+	}
+	return strings.Join(slices.DeleteFunc(flags, func(s string) bool { return s == "" }), " ")
+}
+
+// loadMain loads the formula of a module, resolving its version as
+// loadModules does, to select the matrix combinations to build.
+func loadMain(ctx context.Context, store repo.Store, modPath, version string) (*modules.Module, error) {
+	opts, err := loadModuleOptions(store)
+	if err != nil {
+		return nil, err
+	}
+	main, err := modules.LoadMain(ctx, module.Version{Path: modPath, Version: version}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load modules: %w", err)
+	}
+	return main, nil
+}
+
+// loadModules loads a module and its build list for the matrix
+// combination matrixStr, which the requirements of formulas may depend
+// on. The resolution is recorded in or checked against the lock
+// file set by --lockfile and --locked.
+func loadModules(ctx context.Context, store repo.Store, modPath, version, matrixStr string) ([]*modules.Module, error) {
+	opts, err := loadModuleOptions(store)
+	if err != nil {
+		return nil, err
+	}
+	opts.Matrix = matrixStr
+	mods, err := modules.Load(ctx, module.Version{Path: modPath, Version: version}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load modules: %w", err)
+	}
+	return mods, nil
+}
+
+// loadModuleOptions returns the options of loadModules: those of
+// loadOptions and the lock file flags.
+func loadModuleOptions(store repo.Store) (modules.Options, error) {
+	opts, err := loadOptions(store)
 	if err != nil {
-		return fmt.Errorf("failed to load modules: %w", err)
+		return modules.Options{}, err
+	}
+	opts.LockFile, opts.Locked = makeLockFile, makeLocked
+	opts.Stderr = os.Stderr
+	if opts.Locked && opts.LockFile == "" {
+		opts.LockFile = modules.LockFileName
 	}
+	return opts, nil
+}
 
+// buildResults builds the modules loaded by loadModules for matrixStr,
+// returning one result per module in build order (the main module last).
+// An empty workspaceDir selects the default workspace. Up to jobs
+// independent modules are built in parallel. Unless verbose is set,
+// build output is discarded; stdout and stderr are restored before
+// buildResults returns.
+func buildResults(ctx context.Context, store repo.Store, mods []*modules.Module, matrixStr string, runTest bool, workspaceDir string, verbose bool, jobs int) ([]build.Result, error) {
 	// Handle verbose output
-	var savedStdout, savedStderr *os.File
-	if !makeVerbose {
+	if !verbose {
 		for _, mod := range mods {
 			mod.SetStdout(io.Discard)
 			mod.SetStderr(io.Discard)
 		}
 
-		savedStdout = os.Stdout
-		savedStderr = os.Stderr
+		savedStdout := os.Stdout
+		savedStderr := os.Stderr
 		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
 		if err != nil {
-			return fmt.Errorf("failed to open devnull: %w", err)
+			return nil, fmt.Errorf("failed to open devnull: %w", err)
 		}
 		os.Stdout = devNull
 		os.Stderr = devNull
@@ -160,49 +492,22 @@ func buildModule(ctx context.Context, store repo.Store, modPath, version, matrix
 		}()
 	}
 
-	buildOpts := build.Options{
-		Store:     store,
-		MatrixStr: matrixStr,
-		RunTest:   runTest,
-	}
-	if makeOutput != "" {
-		tmpDir, err := os.MkdirTemp("", "llar-make-*")
-		if err != nil {
-			return fmt.Errorf("failed to create temp workspace: %w", err)
-		}
-		defer os.RemoveAll(tmpDir)
-		buildOpts.WorkspaceDir = tmpDir
-	}
-
-	builder, err := build.NewBuilder(buildOpts)
+	builder, err := build.NewBuilder(build.Options{
+		Store:        store,
+		MatrixStr:    matrixStr,
+		RunTest:      runTest,
+		WorkspaceDir: workspaceDir,
+		Jobs:         jobs,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create builder: %w", err)
+		return nil, fmt.Errorf("failed to create builder: %w", err)
 	}
 
 	results, err := builder.Build(ctx, mods)
 	if err != nil {
-		return fmt.Errorf("failed to build %s@%s: %w", modPath, version, err)
-	}
-
-	// Restore stdout before printing results
-	if !makeVerbose {
-		os.Stdout = savedStdout
-		os.Stderr = savedStderr
-	}
-
-	if len(results) > 0 {
-		main := results[len(results)-1]
-		if main.Metadata != "" {
-			fmt.Println(main.Metadata)
-		}
-		if makeOutput != "" {
-			if err := outputResult(main.OutputDir, makeOutput); err != nil {
-				return fmt.Errorf("failed to write output: %w", err)
-			}
-		}
+		return nil, fmt.Errorf("failed to build %s@%s: %w", mods[0].Path, mods[0].Version, err)
 	}
-
-	return nil
+	return results, nil
 }
 
 // parseModuleArg parses a module argument and detects local filesystem patterns.
@@ -241,6 +546,70 @@ func outputResult(srcDir, dest string) error {
 	return os.CopyFS(dest, os.DirFS(srcDir))
 }
 
+// outputWithDeps writes the build outputs of the linked modules of results
+// to dest, merged into a prefix (see package prefix) that records which
+// module contributed each file. A file contributed by two modules, or a
+// module linked for two matrix combinations, is reported as a conflict.
+// If dest ends with ".zip", the prefix is archived, with the absolute
+// references to it in pkg-config files made relative to ${pcfiledir}.
+func outputWithDeps(results []build.Result, dest string) error {
+	dir := dest
+	zipped := strings.HasSuffix(dest, ".zip")
+	if zipped {
+		tmpDir, err := os.MkdirTemp("", "llar-export-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		dir = tmpDir
+	}
+	p, err := prefix.New(dir)
+	if err != nil {
+		return err
+	}
+	matrices := make(map[string]string)
+	for _, r := range results {
+		if !r.Linked {
+			continue
+		}
+		if matrix, ok := matrices[r.Module.Path]; ok {
+			return fmt.Errorf("%s@%s is linked for both %s and %s", r.Module.Path, r.Module.Version, matrix, r.Matrix)
+		}
+		matrices[r.Module.Path] = r.Matrix
+		if _, err := p.Install(r.Module, r.Matrix, r.Key, r.OutputDir); err != nil {
+			return err
+		}
+	}
+	if !zipped {
+		return nil
+	}
+	if err := relocatePkgConfig(p.Dir()); err != nil {
+		return err
+	}
+	return zipDir(p.Dir(), dest)
+}
+
+// relocatePkgConfig replaces dir in the pkg-config files below it with
+// the path of dir relative to ${pcfiledir}, so that they remain valid
+// wherever dir is extracted.
+func relocatePkgConfig(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || !strings.HasSuffix(path, ".pc") {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Dir(path), dir)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data = bytes.ReplaceAll(data, []byte(dir), []byte("${pcfiledir}/"+filepath.ToSlash(rel)))
+		return os.WriteFile(path, data, 0o644)
+	})
+}
+
 // zipDir creates a zip archive at dest from the contents of srcDir.
 func zipDir(srcDir, dest string) error {
 	f, err := os.Create(dest)
@@ -4,6 +4,8 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,13 +13,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/goplus/llar/formula"
+	"github.com/goplus/llar/internal/build"
 	"github.com/goplus/llar/internal/formula/repo"
+	"github.com/goplus/llar/internal/prefix"
+	"github.com/goplus/llar/mod/module"
 )
 
 func TestParseModuleArg(t *testing.T) {
@@ -308,6 +314,123 @@ func TestOutputResult_NestedDirs(t *testing.T) {
 	}
 }
 
+// withDepsResults returns build results for test/app, linked to test/liba,
+// and for the tool test/libb, with their output dirs. The pkg-config file
+// of test/liba refers to its output dir.
+func withDepsResults(t *testing.T) []build.Result {
+	t.Helper()
+	result := func(path string, linked bool, files map[string]string) build.Result {
+		dir := t.TempDir()
+		for name, content := range files {
+			content = strings.ReplaceAll(content, "$DIR", dir)
+			os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return build.Result{Module: module.Version{Path: path, Version: "1.0.0"}, Matrix: "amd64-linux", OutputDir: dir, Linked: linked}
+	}
+	return []build.Result{
+		result("test/libb", false, map[string]string{"bin/tool": "tool"}),
+		result("test/liba", true, map[string]string{"lib/liba.a": "liba", "lib/pkgconfig/liba.pc": "prefix=$DIR\nLibs: -L${prefix}/lib -la\n"}),
+		result("test/app", true, map[string]string{"bin/app": "app", "include/app.h": "#pragma once"}),
+	}
+}
+
+func TestOutputWithDeps(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out")
+	if err := outputWithDeps(withDepsResults(t), dest); err != nil {
+		t.Fatalf("outputWithDeps: %v", err)
+	}
+
+	for _, rel := range []string{"lib/liba.a", "bin/app", "include/app.h"} {
+		if _, err := os.Stat(filepath.Join(dest, rel)); err != nil {
+			t.Errorf("missing %s: %v", rel, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "bin", "tool")); !os.IsNotExist(err) {
+		t.Errorf("the output of a tool was exported: %v", err)
+	}
+	pc, err := os.ReadFile(filepath.Join(dest, "lib", "pkgconfig", "liba.pc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "prefix=" + dest + "\n"; !strings.HasPrefix(string(pc), want) {
+		t.Errorf("liba.pc = %q, want prefix %q", pc, want)
+	}
+
+	// The receipts record which module contributed each file.
+	p, err := prefix.New(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipts, err := p.Receipts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	for _, r := range receipts {
+		got[r.Path+"@"+r.Version+" "+r.Matrix] = r.Files
+	}
+	want := map[string][]string{
+		"test/app@1.0.0 amd64-linux":  {"bin/app", "include/app.h"},
+		"test/liba@1.0.0 amd64-linux": {"lib/liba.a", "lib/pkgconfig/liba.pc"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("receipts = %v, want %v", got, want)
+	}
+}
+
+func TestOutputWithDeps_Zip(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out.zip")
+	if err := outputWithDeps(withDepsResults(t), dest); err != nil {
+		t.Fatalf("outputWithDeps: %v", err)
+	}
+
+	r, err := zip.OpenReader(dest)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer r.Close()
+	files := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open zip entry: %v", err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+	for _, name := range []string{"lib/liba.a", "bin/app", "include/app.h", ".llar/receipts/test/liba.json", ".llar/receipts/test/app.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("zip missing %s", name)
+		}
+	}
+	if got, want := files["lib/pkgconfig/liba.pc"], "prefix=${pcfiledir}/../..\nLibs: -L${prefix}/lib -la\n"; got != want {
+		t.Errorf("liba.pc = %q, want %q", got, want)
+	}
+}
+
+func TestOutputWithDeps_Conflict(t *testing.T) {
+	results := withDepsResults(t)
+	os.MkdirAll(filepath.Join(results[2].OutputDir, "lib"), 0755)
+	if err := os.WriteFile(filepath.Join(results[2].OutputDir, "lib", "liba.a"), []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := outputWithDeps(results, filepath.Join(t.TempDir(), "out"))
+	if err == nil || !strings.Contains(err.Error(), "file lib/liba.a is already installed by test/liba@1.0.0") {
+		t.Errorf("expected a conflict on lib/liba.a, got: %v", err)
+	}
+
+	results = withDepsResults(t)
+	results[0].Module.Path, results[0].Linked = "test/liba", true
+	err = outputWithDeps(results, filepath.Join(t.TempDir(), "out"))
+	if err == nil || !strings.Contains(err.Error(), "test/liba@1.0.0 is linked for both") {
+		t.Errorf("expected a conflict on test/liba, got: %v", err)
+	}
+}
+
 // Integration tests that run the real `llar make` command.
 // Requires network, git, and cmake.
 
@@ -324,6 +447,11 @@ func runMakeCmd(t *testing.T, args ...string) (string, error) {
 	// Reset flags to defaults before each run
 	makeVerbose = true
 	makeOutput = ""
+	makeLockFile, makeLocked = "", false
+	makeMatrix = matrixFlags{}
+	makeCflags, makeLibs, makeStatic = false, false, false
+	makeWithDeps = false
+	resolveReplace, resolveExclude = nil, nil
 
 	// Execute rootCmd in-process to keep test coverage. Because build output
 	// flows through process-wide os.Stdout (including nested cmake commands),
@@ -518,6 +646,7 @@ type noopVCSRepo struct{}
 
 func (m *noopVCSRepo) Tags(ctx context.Context) ([]string, error)                 { return nil, nil }
 func (m *noopVCSRepo) Latest(ctx context.Context) (string, error)                 { return "", nil }
+func (m *noopVCSRepo) Resolve(ctx context.Context, ref string) (string, error)    { return ref, nil }
 func (m *noopVCSRepo) At(ref, localDir string) fs.FS                              { return nil }
 func (m *noopVCSRepo) Sync(ctx context.Context, ref, path, localDir string) error { return nil }
 
@@ -550,13 +679,13 @@ func setupLocalFormulas(t *testing.T) string {
 
 // computeMatrixStr returns the same matrix string that runMake computes.
 func computeMatrixStr() string {
-	matrix := formula.Matrix{
-		Require: map[string][]string{
-			"os":   {runtime.GOOS},
-			"arch": {runtime.GOARCH},
+	c := formula.Combination{
+		Require: map[string]string{
+			"os":   runtime.GOOS,
+			"arch": runtime.GOARCH,
 		},
 	}
-	return matrix.Combinations()[0]
+	return c.String()
 }
 
 // prepopulateCache writes a build cache entry so builder.Build returns
@@ -572,19 +701,58 @@ func prepopulateCache(t *testing.T, workspaceDir, modPath, version, matrixStr, m
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
 		t.Fatalf("create cache dir: %v", err)
 	}
+	// The entry must carry the build key the builder computes from the
+	// formula hash, source commit and matrix, or it is treated as stale.
+	formulaFiles, _ := filepath.Glob(filepath.Join(testdataFormulasDir(t), escaped, version, "*_llar.gox"))
+	if len(formulaFiles) != 1 {
+		t.Fatalf("formula for %s@%s not found", modPath, version)
+	}
+	formulaData, err := os.ReadFile(formulaFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	formulaSum := sha256.Sum256(formulaData)
+	type buildInputs struct {
+		Formula string `json:"formula"`
+		Source  string `json:"source"`
+		Matrix  string `json:"matrix"`
+	}
+	inputs := &buildInputs{
+		Formula: hex.EncodeToString(formulaSum[:]),
+		Source:  "prepopulated",
+		Matrix:  matrixStr,
+	}
+	inputsData, err := json.Marshal(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySum := sha256.Sum256(inputsData)
+
 	type buildEntry struct {
-		Metadata  string    `json:"metadata"`
-		BuildTime time.Time `json:"build_time"`
+		Metadata  string       `json:"metadata"`
+		BuildTime time.Time    `json:"build_time"`
+		Key       string       `json:"key"`
+		Inputs    *buildInputs `json:"inputs"`
 	}
 	type buildCache struct {
 		Cache map[string]*buildEntry `json:"cache"`
 	}
-	cache := buildCache{Cache: map[string]*buildEntry{
-		version + "-" + matrixStr: {
-			Metadata:  metadata,
-			BuildTime: time.Now(),
-		},
-	}}
+	// Keep the entries of other matrix combinations.
+	var cache buildCache
+	if data, err := os.ReadFile(filepath.Join(cacheDir, ".cache.json")); err == nil {
+		if err := json.Unmarshal(data, &cache); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cache.Cache == nil {
+		cache.Cache = make(map[string]*buildEntry)
+	}
+	cache.Cache[version+"-"+matrixStr] = &buildEntry{
+		Metadata:  metadata,
+		BuildTime: time.Now(),
+		Key:       hex.EncodeToString(keySum[:]),
+		Inputs:    inputs,
+	}
 	data, err := json.MarshalIndent(cache, "", "  ")
 	if err != nil {
 		t.Fatal(err)
@@ -635,6 +803,28 @@ func TestMake_RemoteStoreError(t *testing.T) {
 	}
 }
 
+func TestMake_LockedWithoutLockFile(t *testing.T) {
+	withMockRemoteStore(t, repo.New(setupLocalFormulas(t), &noopVCSRepo{}))
+
+	lockFile := filepath.Join(t.TempDir(), "llar.lock")
+	_, err := runMakeCmd(t, "--locked", "--lockfile", lockFile, "test/liba@1.0.0")
+	if err == nil || !strings.Contains(err.Error(), lockFile) {
+		t.Fatalf("expected missing lock file error, got: %v", err)
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("--locked created %s", lockFile)
+	}
+}
+
+func TestMake_WithDepsWithoutOutput(t *testing.T) {
+	withMockRemoteStore(t, repo.New(setupLocalFormulas(t), &noopVCSRepo{}))
+
+	_, err := runMakeCmd(t, "--with-deps", "test/liba@1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "--with-deps requires -o") {
+		t.Fatalf("expected --with-deps to require -o, got: %v", err)
+	}
+}
+
 func TestMakeLocal_BuildSuccess(t *testing.T) {
 	formulaDir := setupLocalFormulas(t)
 	withMockRemoteStore(t, repo.New(formulaDir, &noopVCSRepo{}))
@@ -677,6 +867,37 @@ func TestMakeLocal_BuildSuccess(t *testing.T) {
 	}
 }
 
+func TestMakeLocal_FlagSelectors(t *testing.T) {
+	formulaDir := setupLocalFormulas(t)
+	withMockRemoteStore(t, repo.New(formulaDir, &noopVCSRepo{}))
+
+	origDir, _ := os.Getwd()
+	os.Chdir(filepath.Join(formulaDir, "test", "liba"))
+	defer os.Chdir(origDir)
+
+	workspaceDir := isolatedWorkspaceDir(t)
+	prepopulateCache(t, workspaceDir, "test/liba", "1.0.0", computeMatrixStr(), "-I/a/include -DA_API -L/a/lib -lA")
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "-I/a/include -DA_API -L/a/lib -lA"},
+		{[]string{"--cflags"}, "-I/a/include -DA_API"},
+		{[]string{"--libs"}, "-L/a/lib -lA"},
+		{[]string{"--static"}, "-L/a/lib -lA"},
+		{[]string{"--cflags", "--libs", "--static"}, "-I/a/include -DA_API -L/a/lib -lA"},
+	} {
+		out, err := runMakeCmd(t, append(tt.args, "./@1.0.0")...)
+		if err != nil {
+			t.Fatalf("make %v failed: %v", tt.args, err)
+		}
+		if got := strings.TrimSpace(out); got != tt.want {
+			t.Errorf("make %v printed %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
 func TestBuildModule_SilentSuccess(t *testing.T) {
 	formulaDir := setupLocalFormulas(t)
 	store := repo.NewOverlayStore(
@@ -702,7 +923,7 @@ func TestBuildModule_SilentSuccess(t *testing.T) {
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 
-	err := buildModule(context.Background(), store, "test/liba", "1.0.0", matrixStr, false)
+	err := buildModule(context.Background(), store, "test/liba", "1.0.0", matrixFlags{}, false, false, 1)
 
 	w.Close()
 	os.Stdout = old
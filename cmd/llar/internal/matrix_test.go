@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/formula"
+	"github.com/goplus/llar/internal/formula/repo"
+)
+
+func TestMatrixFlags_Combinations(t *testing.T) {
+	declared := formula.Matrix{
+		Require:        map[string][]string{"os": {"linux"}, "arch": {"amd64", "arm64"}},
+		Options:        map[string][]string{"shared": {"on", "off"}},
+		DefaultOptions: map[string][]string{"shared": {"off"}},
+	}
+	host := "arch=" + runtime.GOARCH + ",os=" + runtime.GOOS
+	tests := []struct {
+		name    string
+		flags   matrixFlags
+		matrix  formula.Matrix
+		want    []string
+		wantErr string
+	}{
+		{name: "host", want: []string{host}},
+		{name: "cross", flags: matrixFlags{require: []string{"os=windows,arch=386"}}, want: []string{"arch=386,os=windows"}},
+		{name: "all without matrix", flags: matrixFlags{all: true}, want: []string{host}},
+		{name: "unknown key without matrix", flags: matrixFlags{require: []string{"libc=musl"}}, wantErr: `unknown matrix key "libc"`},
+		{name: "default options", flags: matrixFlags{require: []string{"arch=arm64"}}, matrix: declared, want: []string{"arch=arm64,os=linux|shared=off"}},
+		{name: "options", flags: matrixFlags{require: []string{"arch=arm64"}, options: []string{"shared=on"}}, matrix: declared, want: []string{"arch=arm64,os=linux|shared=on"}},
+		{name: "repeated", flags: matrixFlags{require: []string{"os=linux", "arch=amd64"}}, matrix: declared, want: []string{"arch=amd64,os=linux|shared=off"}},
+		{name: "all", flags: matrixFlags{all: true}, matrix: declared, want: []string{"arch=amd64,os=linux|shared=on", "arch=amd64,os=linux|shared=off", "arch=arm64,os=linux|shared=on", "arch=arm64,os=linux|shared=off"}},
+		{name: "invalid value", flags: matrixFlags{require: []string{"arch=riscv64"}}, matrix: declared, wantErr: `invalid value "riscv64" for matrix key "arch"`},
+		{name: "invalid option", flags: matrixFlags{require: []string{"arch=arm64"}, options: []string{"shared=maybe"}}, matrix: declared, wantErr: `invalid value "maybe" for matrix option "shared"`},
+		{name: "malformed", flags: matrixFlags{require: []string{"arch"}}, matrix: declared, wantErr: `invalid matrix value "arch": want key=value`},
+		{name: "conflict", flags: matrixFlags{require: []string{"arch=arm64", "arch=amd64"}}, matrix: declared, wantErr: "conflicting matrix values"},
+		{name: "all with values", flags: matrixFlags{all: true, options: []string{"shared=on"}}, matrix: declared, wantErr: "--all-matrix cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.flags.combinations(tt.matrix)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("combinations() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("combinations() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// test/libm@1.0.0 declares a matrix: os linux, arch amd64 or arm64, and
+// option shared on or off, off by default.
+
+func TestMake_Matrix(t *testing.T) {
+	withMockRemoteStore(t, repo.New(setupLocalFormulas(t), &noopVCSRepo{}))
+	workspaceDir := isolatedWorkspaceDir(t)
+	prepopulateCache(t, workspaceDir, "test/libm", "1.0.0", "arch=arm64,os=linux|shared=on", "-lM -shared")
+
+	out, err := runMakeCmd(t, "--matrix", "os=linux,arch=arm64", "--option", "shared=on", "test/libm@1.0.0")
+	if err != nil {
+		t.Fatalf("llar make failed: %v", err)
+	}
+	if got := strings.TrimSpace(out); got != "-lM -shared" {
+		t.Errorf("stdout = %q, want %q", got, "-lM -shared")
+	}
+
+	_, err = runMakeCmd(t, "--matrix", "os=darwin", "test/libm@1.0.0")
+	if err == nil || !strings.Contains(err.Error(), `invalid matrix for test/libm: invalid value "darwin" for matrix key "os"`) {
+		t.Errorf("llar make --matrix os=darwin error = %v", err)
+	}
+}
+
+func TestMake_AllMatrix(t *testing.T) {
+	withMockRemoteStore(t, repo.New(setupLocalFormulas(t), &noopVCSRepo{}))
+	workspaceDir := isolatedWorkspaceDir(t)
+	combos := []string{"arch=amd64,os=linux|shared=on", "arch=amd64,os=linux|shared=off", "arch=arm64,os=linux|shared=on", "arch=arm64,os=linux|shared=off"}
+	for _, combo := range combos {
+		prepopulateCache(t, workspaceDir, "test/libm", "1.0.0", combo, "-lM "+combo)
+	}
+
+	out, err := runMakeCmd(t, "--all-matrix", "test/libm@1.0.0")
+	if err != nil {
+		t.Fatalf("llar make --all-matrix failed: %v", err)
+	}
+	var want strings.Builder
+	for _, combo := range combos {
+		fmt.Fprintf(&want, "%s: -lM %s\n", combo, combo)
+	}
+	if out != want.String() {
+		t.Errorf("stdout =\n%s\nwant\n%s", out, want.String())
+	}
+
+	dest := filepath.Join(t.TempDir(), "out.zip")
+	if _, err := runMakeCmd(t, "--all-matrix", "-o", dest, "test/libm@1.0.0"); err == nil || !strings.Contains(err.Error(), "use a directory") {
+		t.Errorf("llar make --all-matrix -o x.zip error = %v", err)
+	}
+
+	lockFile := filepath.Join(t.TempDir(), "llar.lock")
+	t.Cleanup(func() { makeLockFile, makeMatrix = "", matrixFlags{} })
+	if _, err := runMakeCmd(t, "--all-matrix", "--lockfile", lockFile, "test/libm@1.0.0"); err == nil || !strings.Contains(err.Error(), "--all-matrix cannot be combined") {
+		t.Errorf("llar make --all-matrix --lockfile error = %v", err)
+	}
+	if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+		t.Errorf("llar make --all-matrix --lockfile wrote %s", lockFile)
+	}
+}
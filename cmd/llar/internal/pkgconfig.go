@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goplus/llar/formula"
+	"github.com/spf13/cobra"
+)
+
+// pkgConfigName is the name under which the llar executable behaves as
+// 'llar pkg-config', for PKG_CONFIG=llar-pkg-config.
+const pkgConfigName = "llar-pkg-config"
+
+var pkgConfigCflags, pkgConfigLibs, pkgConfigStatic bool
+var pkgConfigModVersion, pkgConfigExists bool
+var pkgConfigPrintErrors bool
+var pkgConfigAtLeast string
+
+var pkgConfigCmd = &cobra.Command{
+	Use:   "pkg-config [flags] module[@version]...",
+	Short: "Print the compiler and linker flags of modules, like pkg-config",
+	Long: `Pkg-config answers like pkg-config for modules built by llar, so that
+build systems outside llar can use them: a package is a module path, with
+an optional @version, such as madler/zlib@v1.3.1.
+
+--cflags and --libs print the flags needed to compile and link against the
+modules and all their transitive dependencies, --static adding those only
+needed to link statically. They come from the build cache and the install
+directories of the default workspace; a module not built yet for the host
+is built first, silently. --modversion prints the version of each module
+and --exists only checks that every module resolves, without building.
+
+Installed or linked as llar-pkg-config, the llar executable runs this
+command directly, so that it can replace pkg-config:
+
+	ln -s $(command -v llar) ~/bin/llar-pkg-config
+	PKG_CONFIG=llar-pkg-config ./configure`,
+	SilenceUsage: true,
+	RunE:         runPkgConfig,
+}
+
+func init() {
+	pkgConfigCmd.Flags().BoolVar(&pkgConfigCflags, "cflags", false, "Print the compiler flags")
+	pkgConfigCmd.Flags().BoolVar(&pkgConfigLibs, "libs", false, "Print the linker flags")
+	pkgConfigCmd.Flags().BoolVar(&pkgConfigStatic, "static", false, "Print the linker flags to link statically")
+	pkgConfigCmd.Flags().BoolVar(&pkgConfigModVersion, "modversion", false, "Print the version of the modules")
+	pkgConfigCmd.Flags().BoolVar(&pkgConfigExists, "exists", false, "Only check that the modules exist")
+	pkgConfigCmd.Flags().BoolVar(&pkgConfigPrintErrors, "print-errors", false, "Print errors (always done; for compatibility)")
+	pkgConfigCmd.Flags().StringVar(&pkgConfigAtLeast, "atleast-pkgconfig-version", "", "Succeed (for compatibility with pkg-config checks)")
+	rootCmd.AddCommand(pkgConfigCmd)
+}
+
+func runPkgConfig(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		// pkg-config checks such as PKG_PROG_PKG_CONFIG name no module.
+		if pkgConfigAtLeast != "" {
+			return nil
+		}
+		return fmt.Errorf("must specify module names")
+	}
+	ctx := context.Background()
+	printFlags := (pkgConfigCflags || pkgConfigLibs || pkgConfigStatic) && !pkgConfigExists && !pkgConfigModVersion
+
+	var pcs []formula.PkgConfig
+	for _, arg := range args {
+		pattern, version, isLocal, err := parseModuleArg(arg)
+		if err != nil {
+			return err
+		}
+		store, targets, err := resolveTargets(pattern, version, isLocal)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			main, err := loadMain(ctx, store, target.Path, target.Version)
+			if err != nil {
+				return err
+			}
+			if pkgConfigModVersion && !pkgConfigExists {
+				fmt.Fprintln(cmd.OutOrStdout(), pkgConfigVersion(main.Version))
+			}
+			if !printFlags {
+				continue
+			}
+			combos, err := matrixFlags{}.combinations(main.Matrix)
+			if err != nil {
+				return fmt.Errorf("invalid matrix for %s: %w", target.Path, err)
+			}
+			mods, err := loadModules(ctx, store, target.Path, main.Version, combos[0])
+			if err != nil {
+				return err
+			}
+			// Build output must not end up among the flags printed.
+			results, err := buildResults(ctx, store, mods, combos[0], false, "", false, 1)
+			if err != nil {
+				return err
+			}
+			pcs = append(pcs, results[len(results)-1].Closure)
+		}
+	}
+	if printFlags {
+		fmt.Fprintln(cmd.OutOrStdout(), selectFlags(formula.MergePkgConfig(pcs...), pkgConfigCflags, pkgConfigLibs, pkgConfigStatic))
+	}
+	return nil
+}
+
+// pkgConfigVersion returns a module version as pkg-config reports the
+// versions of packages: without the "v" of tags such as v1.3.1.
+func pkgConfigVersion(version string) string {
+	if len(version) > 1 && version[0] == 'v' && version[1] >= '0' && version[1] <= '9' {
+		return version[1:]
+	}
+	return version
+}
+
+// isPkgConfigShim reports whether the executable runs as llar-pkg-config.
+func isPkgConfigShim() bool {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	return name == pkgConfigName
+}
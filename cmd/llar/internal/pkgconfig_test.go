@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/goplus/llar/internal/formula/repo"
+)
+
+// runPkgConfigCmd executes `llar pkg-config args...` in-process, with
+// test/liba@1.0.0 in the build cache, and returns its output.
+func runPkgConfigCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	withMockRemoteStore(t, repo.New(setupLocalFormulas(t), &noopVCSRepo{}))
+	workspaceDir := isolatedWorkspaceDir(t)
+	prepopulateCache(t, workspaceDir, "test/liba", "1.0.0", computeMatrixStr(), "-I/a/include -DA_API -L/a/lib -lA")
+
+	// Save and restore cwd — builder.Build may os.Chdir during build
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(origDir)
+
+	pkgConfigCflags, pkgConfigLibs, pkgConfigStatic = false, false, false
+	pkgConfigModVersion, pkgConfigExists, pkgConfigAtLeast = false, false, ""
+	resolveReplace, resolveExclude = nil, nil
+	var buf bytes.Buffer
+	cmd := rootCmd
+	cmd.SetOut(&buf)
+	defer cmd.SetOut(nil)
+	cmd.SetArgs(append([]string{"pkg-config"}, args...))
+	err = cmd.Execute()
+	return buf.String(), err
+}
+
+func TestPkgConfig(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--cflags", "test/liba@1.0.0"}, "-I/a/include -DA_API\n"},
+		{[]string{"--libs", "test/liba@1.0.0"}, "-L/a/lib -lA\n"},
+		{[]string{"--cflags", "--libs", "--static", "test/liba@1.0.0"}, "-I/a/include -DA_API -L/a/lib -lA\n"},
+		{[]string{"--modversion", "test/liba@1.0.0"}, "1.0.0\n"},
+		{[]string{"--exists", "--print-errors", "test/liba@1.0.0"}, ""},
+		{[]string{"--atleast-pkgconfig-version", "0.9.0"}, ""},
+	} {
+		out, err := runPkgConfigCmd(t, tt.args...)
+		if err != nil {
+			t.Fatalf("pkg-config %v failed: %v", tt.args, err)
+		}
+		if out != tt.want {
+			t.Errorf("pkg-config %v printed %q, want %q", tt.args, out, tt.want)
+		}
+	}
+}
+
+func TestPkgConfig_Errors(t *testing.T) {
+	if _, err := runPkgConfigCmd(t, "--exists", "test/nonexistent@1.0.0"); err == nil {
+		t.Error("pkg-config --exists of an unknown module succeeded")
+	}
+	if _, err := runPkgConfigCmd(t, "--cflags"); err == nil {
+		t.Error("pkg-config without module succeeded")
+	}
+}
+
+func TestPkgConfigVersion(t *testing.T) {
+	for version, want := range map[string]string{
+		"v1.3.1": "1.3.1",
+		"1.0.0":  "1.0.0",
+		"v":      "v",
+		"vendor": "vendor",
+	} {
+		if got := pkgConfigVersion(version); got != want {
+			t.Errorf("pkgConfigVersion(%q) = %q, want %q", version, got, want)
+		}
+	}
+}
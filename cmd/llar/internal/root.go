@@ -2,6 +2,7 @@ package internal
 
 import (
 	"log"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -15,6 +16,9 @@ var rootCmd = &cobra.Command{
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	if isPkgConfigShim() {
+		rootCmd.SetArgs(append([]string{pkgConfigCmd.Name()}, os.Args[1:]...))
+	}
 	err := rootCmd.Execute()
 	if err != nil {
 		log.Fatal(err)
@@ -2,15 +2,12 @@ package internal
 
 import (
 	"context"
-	"fmt"
-	"os"
 
-	"github.com/goplus/llar/internal/formula/repo"
-	"github.com/goplus/llar/internal/modules/modlocal"
 	"github.com/spf13/cobra"
 )
 
 var testVerbose bool
+var testJobs int
 
 var testCmd = &cobra.Command{
 	Use:   "test [module@version]",
@@ -28,6 +25,8 @@ invocations before onTest executes.`,
 
 func init() {
 	testCmd.Flags().BoolVarP(&testVerbose, "verbose", "v", false, "Enable verbose build/test output")
+	testCmd.Flags().IntVarP(&testJobs, "jobs", "j", 1, "Number of modules to build in parallel")
+	addResolveFlags(testCmd)
 	rootCmd.AddCommand(testCmd)
 }
 
@@ -39,45 +38,12 @@ func runTest(cmd *cobra.Command, args []string) error {
 
 	ctx := context.Background()
 
-	// Reuse the verbose-redirection logic in buildModule by toggling the
-	// shared makeVerbose flag for the duration of the test run.
-	savedVerbose := makeVerbose
-	makeVerbose = testVerbose
-	defer func() { makeVerbose = savedVerbose }()
-
-	matrixStr := hostMatrixCombo()
-
-	remoteStore, err := newRemoteStore()
+	store, targets, err := resolveTargets(pattern, version, isLocal)
 	if err != nil {
 		return err
 	}
-
-	if !isLocal {
-		return buildModule(ctx, remoteStore, pattern, version, matrixStr, true)
-	}
-
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("failed to get working directory: %w", err)
-	}
-
-	localMods, err := modlocal.Resolve(cwd, pattern)
-	if err != nil {
-		return err
-	}
-
-	locals := make(map[string]string, len(localMods))
-	for _, m := range localMods {
-		locals[m.Path] = m.Dir
-	}
-	store := repo.NewOverlayStore(remoteStore, locals)
-
-	for _, m := range localMods {
-		ver := m.Version
-		if ver == "" {
-			ver = version
-		}
-		if err := buildModule(ctx, store, m.Path, ver, matrixStr, true); err != nil {
+	for _, target := range targets {
+		if err := buildModule(ctx, store, target.Path, target.Version, matrixFlags{}, true, testVerbose, testJobs); err != nil {
 			return err
 		}
 	}
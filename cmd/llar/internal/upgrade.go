@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	stdbuild "go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goplus/llar/internal/modules"
+	"github.com/goplus/llar/internal/modules/modlocal"
+	"github.com/goplus/llar/mod/module"
+	"github.com/spf13/cobra"
+)
+
+var updateWrite bool
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade module@version [dep[@version]...]",
+	Short: "Upgrade the dependencies of a module",
+	Long: `Upgrade computes the build list of module@version after upgrading the
+given dependencies, as "go get" does, and prints it, marking the versions
+that changed. A dependency without version is upgraded to its latest
+version. Without any dependency, every dependency is upgraded to its
+latest version.
+
+The versions of a module are the tags of its source repository that one of
+its formulas applies to. With --write, the dependencies of module@version
+in the versions.json of a local module are replaced with the requirements
+selecting the new build list, which fails if onRequire of the module
+overrides them. Nothing is built.
+
+Formulas may require dependencies only for some matrix combinations. The
+requirements are those of the combination make builds by default, for the
+host os and arch with the default options; --matrix and --option select
+another one.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runUpdate(cmd, args, modules.Upgrade)
+	},
+}
+
+var downgradeCmd = &cobra.Command{
+	Use:   "downgrade module@version dep@version...",
+	Short: "Downgrade the dependencies of a module",
+	Long: `Downgrade computes the build list of module@version after downgrading
+the given dependencies and prints it, marking the versions that changed.
+Modules requiring newer versions of them are downgraded as well, or
+removed if no older version of them is available. The version "none"
+removes a dependency.
+
+With --write, the dependencies of module@version in the versions.json of a
+local module are replaced with the requirements selecting the new build
+list, which fails if onRequire of the module overrides them. Nothing is
+built.
+
+Formulas may require dependencies only for some matrix combinations. The
+requirements are those of the combination make builds by default, for the
+host os and arch with the default options; --matrix and --option select
+another one.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runUpdate(cmd, args, modules.Downgrade)
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{upgradeCmd, downgradeCmd} {
+		cmd.Flags().BoolVarP(&updateWrite, "write", "w", false, "Write the new requirements to the versions.json of the local module")
+		addResolveFlags(cmd)
+		addMatrixFlags(cmd)
+		rootCmd.AddCommand(cmd)
+	}
+}
+
+type updateFunc func(ctx context.Context, main module.Version, opts modules.Options, mods ...module.Version) (*modules.Update, error)
+
+func runUpdate(cmd *cobra.Command, args []string, update updateFunc) error {
+	pattern, version, isLocal, err := parseModuleArg(args[0])
+	if err != nil {
+		return err
+	}
+	if updateWrite && !isLocal {
+		return fmt.Errorf("--write requires a local module, got %s", args[0])
+	}
+	var mods []module.Version
+	for _, arg := range args[1:] {
+		m, err := parseDepArg(arg)
+		if err != nil {
+			return err
+		}
+		mods = append(mods, m)
+	}
+
+	ctx := context.Background()
+
+	store, targets, err := resolveTargets(pattern, version, isLocal)
+	if err != nil {
+		return err
+	}
+	opts, err := loadOptions(store)
+	if err != nil {
+		return err
+	}
+	for i, target := range targets {
+		opts, err := matrixOptions(ctx, target, opts)
+		if err != nil {
+			return err
+		}
+		u, err := update(ctx, target, opts, mods...)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(cmd.OutOrStdout())
+		}
+		if err := writeUpdate(cmd.OutOrStdout(), u); err != nil {
+			return err
+		}
+		if updateWrite {
+			if err := writeRequirements(pattern, u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseDepArg parses a dependency argument of the form "path[@version]".
+func parseDepArg(arg string) (module.Version, error) {
+	path, version, _ := strings.Cut(arg, "@")
+	if path == "" || stdbuild.IsLocalImport(path) || filepath.IsAbs(path) {
+		return module.Version{}, fmt.Errorf("invalid dependency %q: want path[@version]", arg)
+	}
+	return module.Version{Path: path, Version: version}, nil
+}
+
+// writeUpdate prints the new build list of u, marking the versions that
+// changed, followed by the modules removed from it.
+func writeUpdate(w io.Writer, u *modules.Update) error {
+	oldVersions := make(map[string]string, len(u.Old))
+	for _, m := range u.Old {
+		oldVersions[m.Path] = m.Version
+	}
+	var b strings.Builder
+	for _, m := range u.New {
+		old, ok := oldVersions[m.Path]
+		switch {
+		case !ok:
+			fmt.Fprintf(&b, "%s (added)\n", modString(m))
+		case old != m.Version:
+			fmt.Fprintf(&b, "%s (was %s)\n", modString(m), old)
+		default:
+			fmt.Fprintf(&b, "%s\n", modString(m))
+		}
+	}
+	for _, m := range u.Old {
+		if !slices.ContainsFunc(u.New, func(n module.Version) bool { return n.Path == m.Path }) {
+			fmt.Fprintf(&b, "%s (removed)\n", modString(m))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeRequirements replaces the dependencies of u.Main in the
+// versions.json of the local module matched by pattern. It fails, writing
+// nothing, if onRequire of u.Main overrides some of them.
+func writeRequirements(pattern string, u *modules.Update) error {
+	if len(u.Overridden) > 0 {
+		var list []string
+		for _, m := range u.Overridden {
+			list = append(list, modString(m))
+		}
+		return fmt.Errorf("cannot write the requirements of %s: its onRequire overrides %s in versions.json", modString(u.Main), strings.Join(list, ", "))
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	localMods, err := modlocal.Resolve(cwd, pattern)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(localMods, func(m modlocal.Module) bool { return m.Path == u.Main.Path })
+	if i < 0 {
+		return fmt.Errorf("%s is not a local module", u.Main.Path)
+	}
+	return os.WriteFile(filepath.Join(localMods[i].Dir, "versions.json"), u.Versions, 0o644)
+}
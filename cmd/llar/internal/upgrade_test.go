@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/internal/formula/repo"
+	"github.com/goplus/llar/mod/versions"
+	"github.com/goplus/llar/x/gnu"
+)
+
+// setupTaggedFormulas returns a copy of testdata/formulas in which the
+// source of test/liba, test/libb and test/libc is a local git repository
+// tagged 1.0.0, 1.1.0 and 1.2.0.
+func setupTaggedFormulas(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	remote := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = remote
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "--quiet")
+	for _, tag := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		run("commit", "--quiet", "--allow-empty", "-m", tag)
+		run("tag", tag)
+	}
+
+	formulaDir := setupLocalFormulas(t)
+	for _, path := range []string{"test/liba", "test/libb", "test/libc"} {
+		file := filepath.Join(formulaDir, path, "versions.json")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := versions.Parse(file, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.Source = "file://" + filepath.ToSlash(remote)
+		if err := os.WriteFile(file, v.Format(gnu.Compare), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return formulaDir
+}
+
+// runUpdateCmd executes `llar args...` in-process and returns its output.
+// test/multi@1.0.0 requires test/liba@1.0.0, test/libb@1.0.0 and
+// test/libc@1.0.0; libb and libc both require test/liba@1.1.0.
+func runUpdateCmd(t *testing.T, formulaDir string, args ...string) (string, error) {
+	t.Helper()
+	withMockRemoteStore(t, repo.New(formulaDir, &noopVCSRepo{}))
+
+	updateWrite = false
+	resolveReplace, resolveExclude, resolveMatrix = nil, nil, matrixFlags{}
+	var buf bytes.Buffer
+	cmd := rootCmd
+	cmd.SetOut(&buf)
+	defer cmd.SetOut(nil)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+func TestUpgrade(t *testing.T) {
+	formulaDir := setupTaggedFormulas(t)
+
+	out, err := runUpdateCmd(t, formulaDir, "upgrade", "test/multi@1.0.0", "test/liba")
+	if err != nil {
+		t.Fatalf("llar upgrade failed: %v", err)
+	}
+	want := `test/multi@1.0.0
+test/liba@1.2.0 (was 1.1.0)
+test/libb@1.0.0
+test/libc@1.0.0
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestDowngrade(t *testing.T) {
+	formulaDir := setupTaggedFormulas(t)
+
+	// libb and libc have no version older than 1.0.0, so they go.
+	out, err := runUpdateCmd(t, formulaDir, "downgrade", "test/multi@1.0.0", "test/liba@1.0.0")
+	if err != nil {
+		t.Fatalf("llar downgrade failed: %v", err)
+	}
+	want := `test/multi@1.0.0
+test/liba@1.0.0 (was 1.1.0)
+test/libb@1.0.0 (removed)
+test/libc@1.0.0 (removed)
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestUpgrade_Write(t *testing.T) {
+	formulaDir := setupTaggedFormulas(t)
+	t.Chdir(formulaDir)
+
+	if _, err := runUpdateCmd(t, formulaDir, "upgrade", "./test/multi@1.0.0", "test/liba@1.1.0", "-w"); err != nil {
+		t.Fatalf("llar upgrade -w failed: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(formulaDir, "test", "multi", "versions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+	"path": "test/multi",
+	"deps": {
+		"1.0.0": [
+			{"path": "test/liba", "version": "1.1.0"},
+			{"path": "test/libb", "version": "1.0.0"},
+			{"path": "test/libc", "version": "1.0.0"}
+		]
+	}
+}
+`
+	if string(got) != want {
+		t.Errorf("versions.json =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUpgrade_WriteOverridden(t *testing.T) {
+	formulaDir := setupTaggedFormulas(t)
+	t.Chdir(formulaDir)
+	formula := `id "test/multi"
+
+fromVer "1.0.0"
+
+onRequire (proj, deps) => {
+	deps.require "test/liba", "1.0.0"
+	deps.require "test/libb", ""
+	deps.require "test/libc", ""
+}
+
+onBuild (ctx, proj, out) => {
+	out.setMetadata "-lmulti"
+}
+`
+	if err := os.WriteFile(filepath.Join(formulaDir, "test", "multi", "1.0.0", "Multi_llar.gox"), []byte(formula), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(formulaDir, "test", "multi", "versions.json")
+	before, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = runUpdateCmd(t, formulaDir, "upgrade", "./test/multi@1.0.0", "test/liba@1.2.0", "-w")
+	if err == nil || !strings.Contains(err.Error(), "its onRequire overrides test/liba@1.2.0") {
+		t.Errorf("llar upgrade -w error = %v, want test/liba overridden", err)
+	}
+	if after, _ := os.ReadFile(file); string(after) != string(before) {
+		t.Errorf("versions.json was written:\n%s", after)
+	}
+}
+
+func TestUpgrade_Errors(t *testing.T) {
+	formulaDir := setupLocalFormulas(t)
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"upgrade", "test/multi@1.0.0", "-w"}, "--write requires a local module"},
+		{[]string{"upgrade", "test/multi@1.0.0", "./test/liba"}, "invalid dependency"},
+		{[]string{"downgrade", "test/multi@1.0.0", "test/liba"}, "downgrade of test/liba has no version"},
+		{[]string{"downgrade", "test/multi@1.0.0"}, "requires at least 2 arg(s)"},
+	}
+	for _, tt := range tests {
+		_, err := runUpdateCmd(t, formulaDir, tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("llar %q error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"fmt"
+	stdbuild "go/build"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goplus/llar/internal/modules/modlocal"
+	"github.com/goplus/llar/internal/vet"
+	"github.com/spf13/cobra"
+)
+
+var vetCmd = &cobra.Command{
+	Use:   "vet [dir...]",
+	Short: "Report mistakes in local formulas",
+	Long: `Vet checks the formulas of local modules for mistakes that would
+otherwise only show up when building them: a missing or mismatched id, a
+fromVer that is not a string literal or is declared by two formulas of a
+module, matrix keys and options the formula does not declare, or a
+comparator that does not set compareVer.
+
+Every argument is a local directory: "." (the default) is the module of
+the current directory, found as make does, "./owner/repo" the module in
+that directory and "./..." or "./owner/..." every module below the
+directory. Vet only parses the files: it neither builds nor runs formulas
+and does not access the network.
+
+Problems are printed as file:line:column: message, and vet fails if it
+finds any.`,
+	// Problems found are not usage errors.
+	SilenceUsage: true,
+	RunE:         runVet,
+}
+
+func init() {
+	rootCmd.AddCommand(vetCmd)
+}
+
+func runVet(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	var dirs []string
+	for _, arg := range args {
+		d, err := vetDirs(cwd, arg)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, d...)
+	}
+
+	problems := 0
+	for _, dir := range dirs {
+		diags, err := vet.Module(dir)
+		if err != nil {
+			return err
+		}
+		for _, d := range diags {
+			if rel, err := filepath.Rel(cwd, d.Pos.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+				d.Pos.Filename = rel
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), d)
+		}
+		problems += len(diags)
+	}
+	if problems > 0 {
+		return fmt.Errorf("vet found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// vetDirs returns the directories of the modules a vet argument denotes.
+func vetDirs(cwd, arg string) ([]string, error) {
+	pattern, wildcard := strings.CutSuffix(filepath.ToSlash(arg), "/...")
+	if arg == "..." {
+		pattern, wildcard = ".", true
+	}
+	if !stdbuild.IsLocalImport(pattern) && !filepath.IsAbs(pattern) {
+		return nil, fmt.Errorf("invalid argument %q: vet checks local directories, such as ./...", arg)
+	}
+	if !wildcard {
+		if pattern = filepath.Clean(pattern); pattern == "." {
+			pattern = ""
+		}
+		mods, err := modlocal.Resolve(cwd, pattern)
+		if err != nil {
+			return nil, err
+		}
+		dirs := make([]string, len(mods))
+		for i, m := range mods {
+			dirs[i] = m.Dir
+		}
+		return dirs, nil
+	}
+
+	root := pattern
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(cwd, root)
+	}
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(path, "versions.json")); err == nil {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no modules matching %s", arg)
+	}
+	return dirs, nil
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runVetCmd executes `llar vet args...` in-process in dir and returns its
+// output.
+func runVetCmd(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	t.Chdir(dir)
+	var buf bytes.Buffer
+	cmd := rootCmd
+	cmd.SetOut(&buf)
+	defer cmd.SetOut(nil)
+	cmd.SetArgs(append([]string{"vet"}, args...))
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+func TestVet(t *testing.T) {
+	formulaDir, err := filepath.Abs(filepath.Join("testdata", "formulas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runVetCmd(t, formulaDir, "./..."); err != nil || out != "" {
+		t.Errorf("llar vet ./... of the test formulas = %q, %v, want no problems", out, err)
+	}
+	if out, err := runVetCmd(t, filepath.Join(formulaDir, "test", "liba"), "."); err != nil || out != "" {
+		t.Errorf("llar vet . = %q, %v, want no problems", out, err)
+	}
+
+	// A module whose id does not match its path, below a module without
+	// problems.
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		t.Helper()
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("towner/good/versions.json", `{"path": "towner/good"}`)
+	writeFile("towner/good/Good_llar.gox", "id \"towner/good\"\n\nfromVer \"1.0.0\"\n")
+	writeFile("towner/bad/versions.json", `{"path": "towner/bad"}`)
+	writeFile("towner/bad/Bad_llar.gox", "id \"towner/other\"\n\nfromVer \"1.0.0\"\n")
+
+	out, err := runVetCmd(t, dir, "./...")
+	if err == nil || !strings.Contains(err.Error(), "vet found 1 problem(s)") {
+		t.Errorf("llar vet ./... error = %v, want 1 problem", err)
+	}
+	if want := filepath.Join("towner", "bad", "Bad_llar.gox") + ":1:4: id \"towner/other\" does not match module path towner/bad\n"; out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+	if out, err := runVetCmd(t, dir, "./towner/good"); err != nil || out != "" {
+		t.Errorf("llar vet ./towner/good = %q, %v, want no problems", out, err)
+	}
+
+	if _, err := runVetCmd(t, dir, "towner/bad"); err == nil || !strings.Contains(err.Error(), "vet checks local directories") {
+		t.Errorf("llar vet of a module path error = %v", err)
+	}
+}
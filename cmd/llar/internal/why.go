@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/goplus/llar/internal/modules"
+	"github.com/goplus/llar/mod/module"
+	"github.com/spf13/cobra"
+)
+
+var whyCmd = &cobra.Command{
+	Use:   "why [module@version] [dep/path]",
+	Short: "Explain why a version of a dependency was selected",
+	Long: `Why explains which requirements made MVS select the version of
+dep/path that a build of module@version uses.
+
+It prints every requirement chain from the main module to the selected
+version, shortest first, followed by each other version of dep/path that
+was requested and lost the comparison, together with the modules that
+requested it. Nothing is built.
+
+Formulas may require dependencies only for some matrix combinations. The
+requirements are those of the combination make builds by default, for the
+host os and arch with the default options; --matrix and --option select
+another one.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runWhy,
+}
+
+func init() {
+	addResolveFlags(whyCmd)
+	addMatrixFlags(whyCmd)
+	rootCmd.AddCommand(whyCmd)
+}
+
+func runWhy(cmd *cobra.Command, args []string) error {
+	pattern, version, isLocal, err := parseModuleArg(args[0])
+	if err != nil {
+		return err
+	}
+	depPath := args[1]
+	if strings.Contains(depPath, "@") {
+		return fmt.Errorf("invalid dependency %q: want a module path without a version", depPath)
+	}
+
+	ctx := context.Background()
+
+	store, targets, err := resolveTargets(pattern, version, isLocal)
+	if err != nil {
+		return err
+	}
+	opts, err := loadOptions(store)
+	if err != nil {
+		return err
+	}
+	for i, target := range targets {
+		opts, err := matrixOptions(ctx, target, opts)
+		if err != nil {
+			return err
+		}
+		g, err := modules.LoadGraph(ctx, target, opts)
+		if err != nil {
+			return fmt.Errorf("failed to load modules: %w", err)
+		}
+		if i > 0 {
+			fmt.Fprintln(cmd.OutOrStdout())
+		}
+		if err := writeWhy(cmd.OutOrStdout(), g, depPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWhy explains the version of depPath selected in g.
+func writeWhy(w io.Writer, g *modules.Graph, depPath string) error {
+	i := slices.IndexFunc(g.BuildList, func(m module.Version) bool {
+		return m.Path == depPath
+	})
+	if i < 0 {
+		return fmt.Errorf("%s does not depend on %s", modString(g.Main), depPath)
+	}
+	selected := g.BuildList[i]
+
+	// Every module version in the graph requiring depPath, in
+	// breadth-first order, so shorter chains come first.
+	requiredBy := make(map[string][]module.Version) // version -> requirers
+	var versions []string
+	g.WalkBreadthFirst(func(m module.Version) {
+		reqs, _ := g.RequiredBy(m)
+		for _, r := range reqs {
+			if r.Path != depPath {
+				continue
+			}
+			if _, ok := requiredBy[r.Version]; !ok {
+				versions = append(versions, r.Version)
+			}
+			requiredBy[r.Version] = append(requiredBy[r.Version], m)
+		}
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s is selected\n", modString(selected))
+	if selected == g.Main {
+		fmt.Fprintf(&b, "\t%s is the main module\n", modString(selected))
+	}
+	for _, m := range requiredBy[selected.Version] {
+		chain := g.FindPath(func(v module.Version) bool { return v == m })
+		chain = append(chain, selected)
+		names := make([]string, len(chain))
+		for i, v := range chain {
+			names[i] = modString(v)
+		}
+		fmt.Fprintf(&b, "\t%s\n", strings.Join(names, " -> "))
+	}
+
+	var lost []string
+	for _, v := range versions {
+		if v != selected.Version {
+			lost = append(lost, v)
+		}
+	}
+	if len(lost) > 0 {
+		fmt.Fprintf(&b, "lost to %s:\n", selected.Version)
+		for _, v := range lost {
+			names := make([]string, len(requiredBy[v]))
+			for i, m := range requiredBy[v] {
+				names[i] = modString(m)
+			}
+			fmt.Fprintf(&b, "\t%s required by %s\n", modString(module.Version{Path: depPath, Version: v}), strings.Join(names, ", "))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
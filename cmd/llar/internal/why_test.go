@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/internal/formula/repo"
+)
+
+// runWhyCmd executes `llar why args...` in-process and returns its output.
+// test/multi@1.0.0 requires test/liba@1.0.0, test/libb@1.0.0 and
+// test/libc@1.0.0; libb and libc both require test/liba@1.1.0.
+func runWhyCmd(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	withMockRemoteStore(t, repo.New(setupLocalFormulas(t), &noopVCSRepo{}))
+
+	resolveReplace, resolveExclude, resolveMatrix = nil, nil, matrixFlags{}
+	var buf bytes.Buffer
+	cmd := rootCmd
+	cmd.SetOut(&buf)
+	defer cmd.SetOut(nil)
+	cmd.SetArgs(append([]string{"why"}, args...))
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+func TestWhy(t *testing.T) {
+	out, err := runWhyCmd(t, "test/multi@1.0.0", "test/liba")
+	if err != nil {
+		t.Fatalf("llar why failed: %v", err)
+	}
+	want := `test/liba@1.1.0 is selected
+	test/multi@1.0.0 -> test/libb@1.0.0 -> test/liba@1.1.0
+	test/multi@1.0.0 -> test/libc@1.0.0 -> test/liba@1.1.0
+lost to 1.1.0:
+	test/liba@1.0.0 required by test/multi@1.0.0
+`
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestWhy_DirectDependency(t *testing.T) {
+	out, err := runWhyCmd(t, "test/multi@1.0.0", "test/libb")
+	if err != nil {
+		t.Fatalf("llar why failed: %v", err)
+	}
+	want := "test/libb@1.0.0 is selected\n\ttest/multi@1.0.0 -> test/libb@1.0.0\n"
+	if out != want {
+		t.Errorf("output =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestWhy_Errors(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"test/app@1.0.0", "test/libc"}, "test/app@1.0.0 does not depend on test/libc"},
+		{[]string{"test/app@1.0.0", "test/liba@1.0.0"}, "want a module path without a version"},
+		{[]string{"test/app@1.0.0"}, "accepts 2 arg(s)"},
+	}
+	for _, tt := range tests {
+		_, err := runWhyCmd(t, tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("llar why %q error = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
@@ -5,8 +5,18 @@
 package formula
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
 	"slices"
 	"sort"
+	"strings"
 
 	"github.com/goplus/llar/mod/module"
 	"github.com/qiniu/x/gsh"
@@ -27,6 +37,18 @@ type ModuleF struct {
 	modPath    string
 	modFromVer string
 	matrix     Matrix
+
+	srcURL    string
+	srcSHA256 string
+	patches   []string
+	variants  map[string]map[string]string
+
+	env *Env   // ctx.env of the build in progress, set by the builder
+	dir string // ctx.SourceDir of the build in progress, set by the builder
+
+	fout io.Writer // where commands write their standard output, set by capout
+	cout string
+	err  error
 }
 
 type Matrix struct {
@@ -38,6 +60,8 @@ type Matrix struct {
 // Combinations returns all cartesian product combinations of the matrix.
 // Keys are sorted alphabetically, and combinations are built layer by layer.
 // Require fields are joined with "-", then combined with options using "|".
+// The values of a combination cannot be told apart in this short form; see
+// Expand and Combination.String for the canonical one.
 func (m *Matrix) Combinations() []string {
 	// Helper function to compute cartesian product for a map
 	cartesian := func(kvs map[string][]string) []string {
@@ -122,10 +146,273 @@ func (m *Matrix) CombinationCount() int {
 	return requireCount * optionsCount
 }
 
+// Select returns the combination of the matrix selected by require and
+// options, which map keys of Require and Options to one of their values.
+// Every key of Require must have a value, unless it only has one. An
+// option without value takes the first of its DefaultOptions, or else the
+// first of its Options.
+func (m *Matrix) Select(require, options map[string]string) (Combination, error) {
+	req, err := selectValues("key", m.Require, require, nil, false)
+	if err != nil {
+		return Combination{}, err
+	}
+	opts, err := selectValues("option", m.Options, options, m.DefaultOptions, true)
+	if err != nil {
+		return Combination{}, err
+	}
+	return Combination{Require: req, Options: opts}, nil
+}
+
+// Inherit returns the combination of m a module declaring m is built for
+// when a module depending on it is built for c, with the values of keys
+// of m given by values overriding those of c.
+//
+// It keeps the values of the keys of Require of m that c has, and those
+// of the options of m c has when they are valid for m; the other options
+// take their defaults, see Select.
+func (m *Matrix) Inherit(c Combination, values map[string]string) (Combination, error) {
+	require := make(map[string]string)
+	for k := range m.Require {
+		if v, ok := c.Require[k]; ok {
+			require[k] = v
+		}
+	}
+	options := make(map[string]string)
+	for k, allowed := range m.Options {
+		if v, ok := c.Options[k]; ok && slices.Contains(allowed, v) {
+			options[k] = v
+		}
+	}
+	for k, v := range values {
+		if _, ok := m.Require[k]; ok {
+			require[k] = v
+		} else {
+			options[k] = v
+		}
+	}
+	return m.Select(require, options)
+}
+
+// Expand returns every combination of the matrix, in the order of
+// Combinations.
+func (m *Matrix) Expand() []Combination {
+	cartesian := func(kvs map[string][]string) []map[string]string {
+		if len(kvs) == 0 {
+			return nil
+		}
+		result := []map[string]string{{}}
+		for _, k := range slices.Sorted(maps.Keys(kvs)) {
+			next := make([]map[string]string, 0, len(result)*len(kvs[k]))
+			for _, prev := range result {
+				for _, v := range kvs[k] {
+					values := maps.Clone(prev)
+					values[k] = v
+					next = append(next, values)
+				}
+			}
+			result = next
+		}
+		return result
+	}
+	requires := cartesian(m.Require)
+	options := cartesian(m.Options)
+	switch {
+	case len(requires) == 0 && len(options) == 0:
+		return nil
+	case len(requires) == 0:
+		requires = []map[string]string{nil}
+	case len(options) == 0:
+		options = []map[string]string{nil}
+	}
+	result := make([]Combination, 0, len(requires)*len(options))
+	for _, req := range requires {
+		for _, opt := range options {
+			result = append(result, Combination{Require: req, Options: opt})
+		}
+	}
+	return result
+}
+
+// selectValues checks values against kvs and returns the value selected
+// for each key of kvs. A key without value takes the first of its
+// defaults, or its only value, or, if optional, its first.
+func selectValues(kind string, kvs map[string][]string, values map[string]string, defaults map[string][]string, optional bool) (map[string]string, error) {
+	for k, v := range values {
+		allowed, ok := kvs[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown matrix %s %q", kind, k)
+		}
+		if !slices.Contains(allowed, v) {
+			return nil, fmt.Errorf("invalid value %q for matrix %s %q: want one of %s", v, kind, k, strings.Join(allowed, ", "))
+		}
+	}
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+	selected := make(map[string]string, len(kvs))
+	for _, k := range slices.Sorted(maps.Keys(kvs)) {
+		v, ok := values[k]
+		switch {
+		case ok:
+		case len(defaults[k]) > 0:
+			v = defaults[k][0]
+		case len(kvs[k]) == 1 || optional && len(kvs[k]) > 0:
+			v = kvs[k][0]
+		default:
+			return nil, fmt.Errorf("missing value for matrix %s %q: want one of %s", kind, k, strings.Join(kvs[k], ", "))
+		}
+		selected[k] = v
+	}
+	return selected, nil
+}
+
 func (p *ModuleF) app() *gsh.App {
 	return &p.App
 }
 
+// The exec commands of gsh.App run with the process environment in the
+// working directory of the process; those of a formula run with the
+// environment of the build in progress, ctx.env, in its source directory,
+// ctx.SourceDir, so that builds running side by side do not depend on the
+// state of the process. They see the variables set by the formula, the
+// tools on its PATH and the dependencies added with the Use of x/cmake or
+// x/autotools.
+
+// XGo_Env returns the value of the environment variable key of the build.
+// In DSL: ${key}
+func (p *ModuleF) XGo_Env(key string) string {
+	if p.env == nil {
+		return p.App.XGo_Env(key)
+	}
+	return p.env.Get(key)
+}
+
+// XGo_Exec executes a command with the environment of the build.
+func (p *ModuleF) XGo_Exec(name string, args ...string) error {
+	return p.exec(nil, name, args...)
+}
+
+// Exec__0 executes a command with the environment of the build, overridden
+// by env.
+func (p *ModuleF) Exec__0(env map[string]string, name string, args ...string) error {
+	return p.exec(env, name, args...)
+}
+
+// Exec__1 executes a command line such as "CC=clang make -j4", expanding
+// $var references with the environment of the build.
+func (p *ModuleF) Exec__1(cmdline string) error {
+	items := strings.Fields(cmdline)
+	env := make(map[string]string)
+	for len(items) > 0 {
+		k, v, ok := strings.Cut(items[0], "=")
+		if !ok || strings.Contains(k, "$") {
+			break
+		}
+		env[k] = os.Expand(v, p.XGo_Env)
+		items = items[1:]
+	}
+	if len(items) == 0 {
+		p.err = errors.New("exec: no command")
+		return p.err
+	}
+	for i, item := range items {
+		items[i] = os.Expand(item, p.XGo_Env)
+	}
+	return p.exec(env, items[0], items[1:]...)
+}
+
+// Exec__2 executes a command with the environment of the build.
+func (p *ModuleF) Exec__2(name string, args ...string) error {
+	return p.exec(nil, name, args...)
+}
+
+// LastErr returns the error of the last command executed.
+func (p *ModuleF) LastErr() error {
+	return p.err
+}
+
+// ExitCode returns the exit code of the last command executed, following
+// the conventions of gsh.App.ExitCode.
+func (p *ModuleF) ExitCode() int {
+	if p.err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(p.err, &exitErr):
+		return exitErr.ProcessState.ExitCode()
+	case p.err.Error() == "exec: no command":
+		return 127
+	case p.err.Error() == "exec: not started":
+		return 126
+	}
+	return 254
+}
+
+// Capout captures the standard output of the commands doSth executes and
+// saves it to output.
+func (p *ModuleF) Capout(doSth func()) (string, error) {
+	var out bytes.Buffer
+	old := p.fout
+	p.fout = &out
+	defer func() {
+		p.fout = old
+	}()
+	doSth()
+	p.cout = out.String()
+	return p.cout, p.err
+}
+
+// Output returns the result of the last capout.
+func (p *ModuleF) Output() string {
+	return p.cout
+}
+
+// exec runs the program name in the source directory of the build with the
+// environment of the build, overridden by env.
+func (p *ModuleF) exec(env map[string]string, name string, args ...string) error {
+	environ := os.Environ()
+	if p.env != nil {
+		environ = p.env.Environ()
+	}
+	cmd := exec.Command(p.lookPath(name), args...)
+	cmd.Dir = p.dir
+	cmd.Env = gsh.Setenv__0(environ, env)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	if p.fout != nil {
+		cmd.Stdout = p.fout
+	}
+	cmd.Stderr = os.Stderr
+	p.err = gsh.Sys.Run(cmd)
+	return p.err
+}
+
+// lookPath returns the path of the program name in the PATH of the build,
+// or name if it is not found there. exec.Command only searches the PATH of
+// the process.
+func (p *ModuleF) lookPath(name string) string {
+	if p.env == nil || strings.ContainsAny(name, `/\`) {
+		return name
+	}
+	exts := []string{""}
+	if runtime.GOOS == "windows" {
+		exts = strings.Split(strings.ToLower(p.env.Get("PATHEXT")), ";")
+	}
+	for _, dir := range filepath.SplitList(p.env.Get("PATH")) {
+		if dir == "" {
+			continue
+		}
+		for _, ext := range exts {
+			path := filepath.Join(dir, name+ext)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && (runtime.GOOS == "windows" || info.Mode()&0o111 != 0) {
+				return path
+			}
+		}
+	}
+	return name
+}
+
 func (p *ModuleF) Matrix(m Matrix) {
 	p.matrix = m
 }
@@ -141,11 +428,86 @@ func (p *ModuleF) FromVer(ver string) {
 	p.modFromVer = ver
 }
 
+// Source declares that the source of the module is the release archive
+// at url (.tar.gz, .tar.bz2, .tar.xz or .zip) rather than a checkout of
+// its repository. sha256 is the hex-encoded SHA-256 checksum the archive
+// must have. "{version}" in url is replaced with the version being built.
+func (p *ModuleF) Source(url, sha256 string) {
+	p.srcURL = url
+	p.srcSHA256 = sha256
+}
+
+// Patch declares patch files to apply to the module source before it is
+// built. Names are slash-separated paths relative to the directory of the
+// formula file, and patches are applied in the order they are declared.
+func (p *ModuleF) Patch(files ...string) {
+	p.patches = append(p.patches, files...)
+}
+
+// Variant requests the dependency path to be built for the matrix values
+// in values, keys of the Require or Options of its matrix, rather than for
+// those it inherits from the build of this module. For instance, a zlib
+// built with -fPIC:
+//
+//	variant "madler/zlib", {"pic": "on"}
+func (p *ModuleF) Variant(path string, values map[string]string) {
+	if p.variants == nil {
+		p.variants = make(map[string]map[string]string)
+	}
+	p.variants[path] = values
+}
+
 // -----------------------------------------------------------------------------
 
 // ModuleDeps represents the dependencies of a module.
 type ModuleDeps struct {
-	deps []module.Version
+	deps       []module.Version
+	tools      []module.Version
+	matrix     Combination
+	matrixRead bool // the matrix was read, see UsesMatrix
+}
+
+// NewModuleDeps creates a ModuleDeps for the module being built for the
+// matrix combination matrix, so that dependencies can be declared for
+// some combinations only.
+func NewModuleDeps(matrix Combination) *ModuleDeps {
+	return &ModuleDeps{matrix: matrix}
+}
+
+// Matrix returns the value of the required matrix key the module is
+// built for, or "" if the matrix has no such key.
+// In DSL: deps.matrix("os")
+func (p *ModuleDeps) Matrix(key string) string {
+	p.matrixRead = true
+	return p.matrix.Require[key]
+}
+
+// Option returns the value of the matrix option key the module is built
+// for, or "" if the matrix has no such option. For instance:
+//
+//	if deps.option("ssl") == "on" {
+//		deps.require "openssl/openssl", "3.3.0"
+//	}
+//
+// In DSL: deps.option("ssl")
+func (p *ModuleDeps) Option(key string) string {
+	p.matrixRead = true
+	return p.matrix.Options[key]
+}
+
+// MatrixValues returns every matrix key and option the module is built
+// for with its value.
+// In DSL: deps.matrixValues
+func (p *ModuleDeps) MatrixValues() map[string]string {
+	p.matrixRead = true
+	return p.matrix.Values()
+}
+
+// UsesMatrix reports whether the matrix combination was read, in which
+// case the dependencies declared are those of this combination only, even
+// if there are none.
+func (p *ModuleDeps) UsesMatrix() bool {
+	return p.matrixRead
 }
 
 // Deps returns the collected module dependencies.
@@ -153,12 +515,27 @@ func (p *ModuleDeps) Deps() []module.Version {
 	return slices.Clone(p.deps)
 }
 
+// Tools returns the collected tool dependencies.
+func (p *ModuleDeps) Tools() []module.Version {
+	return slices.Clone(p.tools)
+}
+
 // Require declares that the module being built depends on the specified
 // module (by its path and version).
 func (p *ModuleDeps) Require(path, ver string) {
 	p.deps = append(p.deps, module.Version{Path: path, Version: ver})
 }
 
+// Tool declares that the module being built runs the programs of the
+// specified module (by its path and version), such as re2c or nasm. A tool
+// is always built for the host, even in a cross build, and the bin
+// directory of its output is added to the PATH of the build. It is not
+// linked: it is neither in Project.Deps of the module nor of the modules
+// depending on it.
+func (p *ModuleDeps) Tool(path, ver string) {
+	p.tools = append(p.tools, module.Version{Path: path, Version: ver})
+}
+
 // OnRequire event is used to retrieve all direct dependencies of a
 // project (module). proj is the project being built, deps is used to
 // declare dependencies.
@@ -170,8 +547,9 @@ func (p *ModuleF) OnRequire(f func(proj *Project, deps *ModuleDeps)) {
 
 // BuildResult represents the result of building a project.
 type BuildResult struct {
-	errs     []error
-	metadata string // build output metadata, for C/C++ it's the result of pkg-config.
+	errs      []error
+	metadata  string     // build output metadata, for C/C++ it's the result of pkg-config.
+	pkgConfig *PkgConfig // structured metadata, if set
 }
 
 // AddErr records a build error.
@@ -184,8 +562,12 @@ func (b *BuildResult) Errs() []error {
 	return b.errs
 }
 
-// Metadata returns the build output metadata.
+// Metadata returns the build output metadata: the string set by
+// SetMetadata or, if none, the flags of the PkgConfig set by SetPkgConfig.
 func (b *BuildResult) Metadata() string {
+	if b.metadata == "" && b.pkgConfig != nil {
+		return b.pkgConfig.String()
+	}
 	return b.metadata
 }
 
@@ -194,6 +576,27 @@ func (b *BuildResult) SetMetadata(metadata string) {
 	b.metadata = metadata
 }
 
+// PkgConfig returns the structured build output metadata: the one set by
+// SetPkgConfig or, if none, the one parsed from the string set by
+// SetMetadata (see ParsePkgConfig).
+func (b *BuildResult) PkgConfig() PkgConfig {
+	if b.pkgConfig != nil {
+		return *b.pkgConfig
+	}
+	return ParsePkgConfig(b.metadata)
+}
+
+// SetPkgConfig sets the structured build output metadata, typically read
+// from the .pc files the build installed with pkgconfig.Load.
+// In DSL:
+//
+//	installDir, _ := ctx.outputDir()
+//	pc, err := pkgconfig.load(installDir)
+//	out.setPkgConfig pc
+func (b *BuildResult) SetPkgConfig(pc PkgConfig) {
+	b.pkgConfig = &pc
+}
+
 // OnBuild event is used to instruct the Formula to compile a project.
 func (p *ModuleF) OnBuild(f func(ctx *Context, proj *Project, out *BuildResult)) {
 	p.fOnBuild = f
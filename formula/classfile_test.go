@@ -5,8 +5,14 @@
 package formula
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+
+	"github.com/qiniu/x/gsh"
 )
 
 // testFormula embeds ModuleF and provides a MainEntry that exercises every
@@ -22,6 +28,10 @@ func (f *testFormula) MainEntry() {
 	f.Id("foo/bar")
 	f.FromVer("1.0.0")
 	f.Matrix(Matrix{Require: map[string][]string{"os": {"linux"}}})
+	f.Source("https://example.com/bar-{version}.tar.gz", "abc123")
+	f.Patch("fix-build.patch")
+	f.Patch("cve.patch", "more.patch")
+	f.Variant("madler/zlib", map[string]string{"pic": "on"})
 	f.OnRequire(func(*Project, *ModuleDeps) {})
 	f.OnBuild(func(*Context, *Project, *BuildResult) {})
 	f.OnTest(func(*Context, *Project, *TestResult) {})
@@ -47,6 +57,15 @@ func TestGopt_ModuleF_Main(t *testing.T) {
 	if !reflect.DeepEqual(f.matrix, wantMatrix) {
 		t.Errorf("Matrix: matrix = %+v, want %+v", f.matrix, wantMatrix)
 	}
+	if f.srcURL != "https://example.com/bar-{version}.tar.gz" || f.srcSHA256 != "abc123" {
+		t.Errorf("Source: srcURL = %q, srcSHA256 = %q", f.srcURL, f.srcSHA256)
+	}
+	if want := []string{"fix-build.patch", "cve.patch", "more.patch"}; !reflect.DeepEqual(f.patches, want) {
+		t.Errorf("Patch: patches = %q, want %q", f.patches, want)
+	}
+	if want := map[string]map[string]string{"madler/zlib": {"pic": "on"}}; !reflect.DeepEqual(f.variants, want) {
+		t.Errorf("Variant: variants = %v, want %v", f.variants, want)
+	}
 	if f.fOnRequire == nil {
 		t.Error("OnRequire: fOnRequire is nil")
 	}
@@ -66,3 +85,96 @@ func TestModuleF_app(t *testing.T) {
 		t.Errorf("app() = %p, want %p (embedded App)", got, &m.App)
 	}
 }
+
+func TestModuleF_ExecEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh and env")
+	}
+	t.Setenv("LLAR_EXEC_UNSET", "process")
+	t.Setenv("LLAR_EXEC_KEEP", "process")
+	env := NewEnv(os.Environ())
+	env.Set("LLAR_EXEC_BUILD", "build")
+	env.Unset("LLAR_EXEC_UNSET")
+
+	var f ModuleF
+	gsh.InitApp(&f.App)
+	f.env = env
+	if got := f.XGo_Env("LLAR_EXEC_BUILD"); got != "build" {
+		t.Errorf("XGo_Env = %q, want %q", got, "build")
+	}
+
+	out, err := f.Capout(func() {
+		f.Exec__2("sh", "-c", `echo "$LLAR_EXEC_BUILD $LLAR_EXEC_KEEP [$LLAR_EXEC_UNSET]"`)
+	})
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if want := "build process []\n"; out != want {
+		t.Errorf("exec output = %q, want %q", out, want)
+	}
+
+	out, err = f.Capout(func() {
+		f.Exec__1("LLAR_EXEC_LINE=$LLAR_EXEC_BUILD env")
+	})
+	if err != nil {
+		t.Fatalf("exec of a command line failed: %v", err)
+	}
+	for _, want := range []string{"LLAR_EXEC_LINE=build\n", "LLAR_EXEC_BUILD=build\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("env output lacks %q", want)
+		}
+	}
+	// Programs are looked up in the PATH of the build.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "llar-exec-test"), []byte("#!/bin/sh\necho $LLAR_EXEC_BUILD\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	env.Prepend("PATH", bin)
+	out, err = f.Capout(func() {
+		f.XGo_Exec("llar-exec-test")
+	})
+	if err != nil || out != "build\n" {
+		t.Errorf("exec of a program on the PATH of the build = %q, %v", out, err)
+	}
+	if os.Getenv("LLAR_EXEC_BUILD") != "" {
+		t.Error("the build environment leaked into the process")
+	}
+
+	// Without a build, commands see the process environment.
+	f.env = nil
+	out, _ = f.Capout(func() {
+		f.XGo_Exec("sh", "-c", `echo "$LLAR_EXEC_UNSET"`)
+	})
+	if out != "process\n" {
+		t.Errorf("exec output without build env = %q, want %q", out, "process\n")
+	}
+}
+
+func TestModuleF_ExecDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "configure"), []byte("#!/bin/sh\necho configured\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var f ModuleF
+	gsh.InitApp(&f.App)
+	f.dir = dir
+	out, err := f.Capout(func() {
+		f.Exec__1("./configure")
+	})
+	if err != nil || out != "configured\n" {
+		t.Errorf("exec in the source dir = %q, %v", out, err)
+	}
+
+	f.Exec__2("sh", "-c", "exit 3")
+	if f.LastErr() == nil || f.ExitCode() != 3 {
+		t.Errorf("lastErr, exitCode = %v, %d, want an error and 3", f.LastErr(), f.ExitCode())
+	}
+	f.Exec__1("A=1")
+	if f.ExitCode() != 127 {
+		t.Errorf("exitCode of a command line without a command = %d, want 127", f.ExitCode())
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formula
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Combination is a combination of a Matrix: a value for each key of its
+// Require and of its Options.
+type Combination struct {
+	Require map[string]string
+	Options map[string]string
+}
+
+// String returns the canonical encoding of c, which ParseCombination
+// reverses: the key=value pairs of Require sorted by key and separated by
+// ",", then, if there are options, "|" and the pairs of Options, e.g.
+// "arch=arm64,os=linux|shared=on". Bytes of keys and values other than
+// letters, digits and "-._~+" are percent-encoded, so the encoding is
+// also a valid file name.
+func (c Combination) String() string {
+	encode := func(kvs map[string]string) string {
+		pairs := make([]string, 0, len(kvs))
+		for _, k := range slices.Sorted(maps.Keys(kvs)) {
+			pairs = append(pairs, escapeMatrix(k)+"="+escapeMatrix(kvs[k]))
+		}
+		return strings.Join(pairs, ",")
+	}
+	s := encode(c.Require)
+	if len(c.Options) > 0 {
+		s += "|" + encode(c.Options)
+	}
+	return s
+}
+
+// Legacy returns the encoding of c used before String, which
+// Matrix.Combinations still returns: the values of Require sorted by key
+// and joined with "-", then, if there are options, "|" and the values of
+// Options joined likewise, e.g. "arm64-linux|on".
+func (c Combination) Legacy() string {
+	join := func(kvs map[string]string) string {
+		values := make([]string, 0, len(kvs))
+		for _, k := range slices.Sorted(maps.Keys(kvs)) {
+			values = append(values, kvs[k])
+		}
+		return strings.Join(values, "-")
+	}
+	s := join(c.Require)
+	if len(c.Options) > 0 {
+		if s != "" {
+			s += "|"
+		}
+		s += join(c.Options)
+	}
+	return s
+}
+
+// Values returns the values of c, options included, by key.
+func (c Combination) Values() map[string]string {
+	values := make(map[string]string, len(c.Require)+len(c.Options))
+	maps.Copy(values, c.Require)
+	maps.Copy(values, c.Options)
+	return values
+}
+
+// ParseCombination parses the canonical encoding of a combination, as
+// returned by Combination.String.
+func ParseCombination(s string) (Combination, error) {
+	decode := func(part string) (map[string]string, error) {
+		if part == "" {
+			return nil, nil
+		}
+		kvs := make(map[string]string)
+		for _, pair := range strings.Split(part, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || k == "" {
+				return nil, fmt.Errorf("invalid matrix combination %q: want key=value, got %q", s, pair)
+			}
+			key, err := url.PathUnescape(k)
+			if err != nil {
+				return nil, fmt.Errorf("invalid matrix combination %q: %w", s, err)
+			}
+			value, err := url.PathUnescape(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid matrix combination %q: %w", s, err)
+			}
+			if _, ok := kvs[key]; ok {
+				return nil, fmt.Errorf("invalid matrix combination %q: duplicate key %q", s, key)
+			}
+			kvs[key] = value
+		}
+		return kvs, nil
+	}
+	reqPart, optPart, _ := strings.Cut(s, "|")
+	var c Combination
+	var err error
+	if c.Require, err = decode(reqPart); err != nil {
+		return Combination{}, err
+	}
+	if c.Options, err = decode(optPart); err != nil {
+		return Combination{}, err
+	}
+	return c, nil
+}
+
+func escapeMatrix(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~+", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formula
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+
+// Env is an isolated set of environment variables for the commands spawned
+// by a build. Changing an Env never touches the process environment, so
+// builds running side by side (or llar embedded in a long-running process)
+// cannot leak variables into each other.
+//
+// An Env is not safe for concurrent use.
+type Env struct {
+	vars map[string]string
+}
+
+// NewEnv returns an Env initialized from environ, a list of "key=value"
+// pairs such as the result of os.Environ.
+func NewEnv(environ []string) *Env {
+	e := &Env{vars: make(map[string]string, len(environ))}
+	for _, kv := range environ {
+		if k, v, ok := strings.Cut(kv, "="); ok && k != "" {
+			e.Set(k, v)
+		}
+	}
+	return e
+}
+
+// key returns the name under which key is stored. Variable names are
+// case-insensitive on Windows, so an existing spelling (e.g. "Path") is
+// reused for "PATH".
+func (e *Env) key(key string) string {
+	if runtime.GOOS != "windows" {
+		return key
+	}
+	for k := range e.vars {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+// Lookup returns the value of key and whether it is set.
+func (e *Env) Lookup(key string) (string, bool) {
+	v, ok := e.vars[e.key(key)]
+	return v, ok
+}
+
+// Get returns the value of key, or "" if it is not set.
+func (e *Env) Get(key string) string {
+	v, _ := e.Lookup(key)
+	return v
+}
+
+// Set sets key to value.
+func (e *Env) Set(key, value string) {
+	if e.vars == nil {
+		e.vars = make(map[string]string)
+	}
+	e.vars[e.key(key)] = value
+}
+
+// Unset removes key.
+func (e *Env) Unset(key string) {
+	delete(e.vars, e.key(key))
+}
+
+// Prepend adds value to the front of the path list stored in key
+// (e.g. PATH, PKG_CONFIG_PATH), using the OS path list separator.
+func (e *Env) Prepend(key, value string) {
+	if cur := e.Get(key); cur != "" {
+		value += string(os.PathListSeparator) + cur
+	}
+	e.Set(key, value)
+}
+
+// Append adds value to the end of the path list stored in key, using the
+// OS path list separator.
+func (e *Env) Append(key, value string) {
+	if cur := e.Get(key); cur != "" {
+		value = cur + string(os.PathListSeparator) + value
+	}
+	e.Set(key, value)
+}
+
+// AppendFlag adds flag to the end of the space-separated flag list stored
+// in key (e.g. CPPFLAGS, LDFLAGS).
+func (e *Env) AppendFlag(key, flag string) {
+	if cur := e.Get(key); cur != "" {
+		flag = cur + " " + flag
+	}
+	e.Set(key, flag)
+}
+
+// Environ returns the variables as sorted "key=value" pairs, suitable for
+// exec.Cmd.Env.
+func (e *Env) Environ() []string {
+	environ := make([]string, 0, len(e.vars))
+	for k, v := range e.vars {
+		environ = append(environ, k+"="+v)
+	}
+	slices.Sort(environ)
+	return environ
+}
+
+// Clone returns an independent copy of e.
+func (e *Env) Clone() *Env {
+	return &Env{vars: maps.Clone(e.vars)}
+}
+
+// -----------------------------------------------------------------------------
+
+var (
+	boundMu   sync.Mutex
+	boundEnvs = make(map[string]*Env) // source dir -> ctx.env of its build
+)
+
+// BindEnv makes env the environment of the helpers (x/cmake, x/autotools)
+// created for sources under dir, until the returned function is called.
+// The builder binds the ctx.env of each build to its source dir, so that
+// the helpers a formula creates see the variables it sets and the tools on
+// its PATH without being handed the environment.
+func BindEnv(dir string, env *Env) (unbind func()) {
+	dir = filepath.Clean(dir)
+	boundMu.Lock()
+	boundEnvs[dir] = env
+	boundMu.Unlock()
+	return func() {
+		boundMu.Lock()
+		if boundEnvs[dir] == env {
+			delete(boundEnvs, dir)
+		}
+		boundMu.Unlock()
+	}
+}
+
+// BoundEnv returns the Env bound with BindEnv to the innermost directory
+// containing path, or nil if there is none.
+func BoundEnv(path string) *Env {
+	path = filepath.Clean(path)
+	boundMu.Lock()
+	defer boundMu.Unlock()
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if env, ok := boundEnvs[dir]; ok {
+			return env
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return nil
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
@@ -0,0 +1,97 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formula
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNewEnv(t *testing.T) {
+	e := NewEnv([]string{"A=1", "B=x=y", "=ignored", "NOEQ"})
+	if got := e.Get("A"); got != "1" {
+		t.Errorf("Get(A) = %q, want %q", got, "1")
+	}
+	if got := e.Get("B"); got != "x=y" {
+		t.Errorf("Get(B) = %q, want %q", got, "x=y")
+	}
+	if _, ok := e.Lookup("NOEQ"); ok {
+		t.Error("Lookup(NOEQ) ok = true, want false")
+	}
+	if want := []string{"A=1", "B=x=y"}; !slices.Equal(e.Environ(), want) {
+		t.Errorf("Environ() = %v, want %v", e.Environ(), want)
+	}
+}
+
+func TestEnv_SetUnset(t *testing.T) {
+	var e Env
+	e.Set("CC", "clang")
+	if got := e.Get("CC"); got != "clang" {
+		t.Errorf("Get(CC) = %q, want %q", got, "clang")
+	}
+	e.Unset("CC")
+	if _, ok := e.Lookup("CC"); ok {
+		t.Error("Lookup(CC) after Unset ok = true, want false")
+	}
+}
+
+func TestEnv_PrependAppend(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	e := NewEnv(nil)
+
+	e.Prepend("PATH", "/b")
+	e.Prepend("PATH", "/a")
+	e.Append("PATH", "/c")
+	if got, want := e.Get("PATH"), "/a"+sep+"/b"+sep+"/c"; got != want {
+		t.Errorf("PATH = %q, want %q", got, want)
+	}
+
+	e.AppendFlag("CFLAGS", "-O2")
+	e.AppendFlag("CFLAGS", "-g")
+	if got := e.Get("CFLAGS"); got != "-O2 -g" {
+		t.Errorf("CFLAGS = %q, want %q", got, "-O2 -g")
+	}
+}
+
+func TestEnv_Clone(t *testing.T) {
+	e := NewEnv([]string{"A=1"})
+	c := e.Clone()
+	c.Set("A", "2")
+	if got := e.Get("A"); got != "1" {
+		t.Errorf("original A = %q after changing clone, want %q", got, "1")
+	}
+}
+
+func TestEnv_DoesNotTouchProcess(t *testing.T) {
+	t.Setenv("LLAR_ENV_TEST", "process")
+	e := NewEnv(os.Environ())
+	e.Set("LLAR_ENV_TEST", "build")
+	if got := os.Getenv("LLAR_ENV_TEST"); got != "process" {
+		t.Errorf("process LLAR_ENV_TEST = %q, want %q", got, "process")
+	}
+}
+
+func TestBindEnv(t *testing.T) {
+	src := t.TempDir()
+	env := NewEnv(nil)
+	unbind := BindEnv(src, env)
+
+	if got := BoundEnv(src); got != env {
+		t.Errorf("BoundEnv(src) = %p, want %p", got, env)
+	}
+	if got := BoundEnv(filepath.Join(src, "tests", "_build")); got != env {
+		t.Errorf("BoundEnv(src/tests/_build) = %p, want %p", got, env)
+	}
+	if got := BoundEnv(src + "-other"); got != nil {
+		t.Errorf("BoundEnv(sibling) = %p, want nil", got)
+	}
+
+	unbind()
+	if got := BoundEnv(src); got != nil {
+		t.Errorf("BoundEnv(src) after unbind = %p, want nil", got)
+	}
+}
@@ -5,6 +5,7 @@
 package formula
 
 import (
+	"slices"
 	"testing"
 )
 
@@ -166,3 +167,204 @@ func TestMatrix_Combinations(t *testing.T) {
 		})
 	}
 }
+
+func TestMatrix_Select(t *testing.T) {
+	m := Matrix{
+		Require: map[string][]string{
+			"os":   {"linux", "darwin"},
+			"arch": {"amd64", "arm64"},
+			"lang": {"c"},
+		},
+		Options: map[string][]string{
+			"shared": {"on", "off"},
+			"zlib":   {"zlibON", "zlibOFF"},
+		},
+		DefaultOptions: map[string][]string{
+			"shared": {"off"},
+		},
+	}
+	tests := []struct {
+		name    string
+		require map[string]string
+		options map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "defaults",
+			require: map[string]string{"os": "linux", "arch": "arm64"},
+			want:    "arch=arm64,lang=c,os=linux|shared=off,zlib=zlibON",
+		},
+		{
+			name:    "options",
+			require: map[string]string{"os": "darwin", "arch": "amd64", "lang": "c"},
+			options: map[string]string{"shared": "on", "zlib": "zlibOFF"},
+			want:    "arch=amd64,lang=c,os=darwin|shared=on,zlib=zlibOFF",
+		},
+		{
+			name:    "missing key",
+			require: map[string]string{"os": "linux"},
+			wantErr: `missing value for matrix key "arch": want one of amd64, arm64`,
+		},
+		{
+			name:    "unknown key",
+			require: map[string]string{"os": "linux", "arch": "amd64", "libc": "musl"},
+			wantErr: `unknown matrix key "libc"`,
+		},
+		{
+			name:    "invalid value",
+			require: map[string]string{"os": "windows", "arch": "amd64"},
+			wantErr: `invalid value "windows" for matrix key "os": want one of linux, darwin`,
+		},
+		{
+			name:    "unknown option",
+			require: map[string]string{"os": "linux", "arch": "amd64"},
+			options: map[string]string{"os": "linux"},
+			wantErr: `unknown matrix option "os"`,
+		},
+	}
+	var all []string
+	for _, c := range m.Expand() {
+		all = append(all, c.String())
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Select(tt.require, tt.options)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Matrix.Select() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.String() != tt.want {
+				t.Errorf("Matrix.Select() = %q, %v, want %q", got, err, tt.want)
+			}
+			if !slices.Contains(all, got.String()) {
+				t.Errorf("Matrix.Select() = %q, not in Expand()", got)
+			}
+		})
+	}
+
+	only := Matrix{Options: map[string][]string{"ssl": {"sslON", "sslOFF"}}}
+	if got, err := only.Select(nil, nil); err != nil || got.String() != "|ssl=sslON" {
+		t.Errorf("Matrix.Select() of options only = %q, %v, want %q", got, err, "|ssl=sslON")
+	}
+}
+
+func TestMatrix_Inherit(t *testing.T) {
+	m := Matrix{
+		Require:        map[string][]string{"os": {"linux"}, "arch": {"amd64", "arm64"}},
+		Options:        map[string][]string{"pic": {"on", "off"}, "shared": {"on", "off"}},
+		DefaultOptions: map[string][]string{"pic": {"off"}, "shared": {"off"}},
+	}
+	from := Combination{
+		Require: map[string]string{"os": "linux", "arch": "arm64", "libc": "musl"},
+		Options: map[string]string{"shared": "on", "pic": "yes", "ssl": "on"},
+	}
+	got, err := m.Inherit(from, nil)
+	if want := "arch=arm64,os=linux|pic=off,shared=on"; err != nil || got.String() != want {
+		t.Errorf("Matrix.Inherit() = %q, %v, want %q", got, err, want)
+	}
+	got, err = m.Inherit(from, map[string]string{"arch": "amd64", "pic": "on"})
+	if want := "arch=amd64,os=linux|pic=on,shared=on"; err != nil || got.String() != want {
+		t.Errorf("Matrix.Inherit() with values = %q, %v, want %q", got, err, want)
+	}
+	if _, err := m.Inherit(from, map[string]string{"lto": "on"}); err == nil {
+		t.Error("Matrix.Inherit() with an unknown key succeeded")
+	}
+	from.Require["os"] = "darwin"
+	if _, err := m.Inherit(from, nil); err == nil {
+		t.Error("Matrix.Inherit() of an unsupported os succeeded")
+	}
+}
+
+func TestMatrix_Expand(t *testing.T) {
+	m := Matrix{
+		Require: map[string][]string{
+			"os":   {"linux", "darwin"},
+			"arch": {"x86_64", "arm64"},
+		},
+		Options: map[string][]string{
+			"zlib": {"zlibON", "zlibOFF"},
+		},
+	}
+	combos := m.Combinations()
+	expanded := m.Expand()
+	if len(expanded) != len(combos) {
+		t.Fatalf("len(Matrix.Expand()) = %d, want %d", len(expanded), len(combos))
+	}
+	// Expand follows the order of Combinations.
+	for i, c := range expanded {
+		short := c.Require["arch"] + "-" + c.Require["os"] + "|" + c.Options["zlib"]
+		if short != combos[i] {
+			t.Errorf("Matrix.Expand()[%d] = %v, want %q", i, c, combos[i])
+		}
+	}
+	if got := (&Matrix{}).Expand(); got != nil {
+		t.Errorf("Matrix.Expand() of empty matrix = %v, want nil", got)
+	}
+	onlyOpts := (&Matrix{Options: map[string][]string{"ssl": {"on", "off"}}}).Expand()
+	if len(onlyOpts) != 2 || onlyOpts[1].String() != "|ssl=off" {
+		t.Errorf("Matrix.Expand() of options only = %v", onlyOpts)
+	}
+}
+
+func TestCombination_String(t *testing.T) {
+	tests := []struct {
+		c    Combination
+		want string
+	}{
+		{Combination{Require: map[string]string{"os": "linux", "arch": "x86_64"}}, "arch=x86_64,os=linux"},
+		{Combination{Require: map[string]string{"os": "linux"}, Options: map[string]string{"shared": "on", "pic": "on"}}, "os=linux|pic=on,shared=on"},
+		{Combination{Options: map[string]string{"ssl": "on"}}, "|ssl=on"},
+		{Combination{Require: map[string]string{"abi": "arm-v7|hf", "a,b=c": "x/y z%"}}, "a%2Cb%3Dc=x%2Fy%20z%25,abi=arm-v7%7Chf"},
+		{Combination{}, ""},
+	}
+	for _, tt := range tests {
+		got := tt.c.String()
+		if got != tt.want {
+			t.Errorf("Combination.String() = %q, want %q", got, tt.want)
+		}
+		back, err := ParseCombination(got)
+		if err != nil {
+			t.Errorf("ParseCombination(%q) error = %v", got, err)
+			continue
+		}
+		if back.String() != got || len(back.Values()) != len(tt.c.Values()) {
+			t.Errorf("ParseCombination(%q) = %+v, want %+v", got, back, tt.c)
+		}
+	}
+}
+
+func TestCombination_Legacy(t *testing.T) {
+	for _, m := range []*Matrix{
+		{Require: map[string][]string{"os": {"linux", "darwin"}, "arch": {"amd64", "arm64"}}, Options: map[string][]string{"zlib": {"zlibON", "zlibOFF"}, "ssl": {"on"}}},
+		{Require: map[string][]string{"os": {"linux"}}},
+		{Options: map[string][]string{"ssl": {"on", "off"}}},
+	} {
+		want := m.Combinations()
+		for i, c := range m.Expand() {
+			if got := c.Legacy(); got != want[i] {
+				t.Errorf("%v.Legacy() = %q, want %q", c, got, want[i])
+			}
+		}
+	}
+	if got := (Combination{}).Legacy(); got != "" {
+		t.Errorf("Combination{}.Legacy() = %q, want \"\"", got)
+	}
+}
+
+func TestParseCombination(t *testing.T) {
+	c, err := ParseCombination("arch=arm64,os=linux|shared=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Require["arch"] != "arm64" || c.Require["os"] != "linux" || c.Options["shared"] != "on" {
+		t.Errorf("ParseCombination() = %+v", c)
+	}
+	for _, s := range []string{"amd64-linux", "os=linux,os=darwin", "=linux", "os=%zz"} {
+		if _, err := ParseCombination(s); err == nil {
+			t.Errorf("ParseCombination(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formula
+
+import (
+	"slices"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+
+// PkgConfig is the metadata of a build output in the terms of pkg-config:
+// what a consumer needs to compile and link against it. The build cache
+// stores it along with the output.
+type PkgConfig struct {
+	// Cflags are the compiler flags, such as -I, but the definitions.
+	Cflags []string `json:"cflags,omitempty"`
+	// Defines are the preprocessor definitions, as NAME or NAME=VALUE
+	// (the -D flags without -D).
+	Defines []string `json:"defines,omitempty"`
+	// Libs are the linker flags, such as -L and -l.
+	Libs []string `json:"libs,omitempty"`
+	// StaticLibs are the linker flags only needed to link statically,
+	// the Libs.private of pkg-config.
+	StaticLibs []string `json:"static_libs,omitempty"`
+	// Requires lists the pkg-config packages required, such as "zlib"
+	// or "libpng >= 1.6".
+	Requires []string `json:"requires,omitempty"`
+}
+
+// ParsePkgConfig parses the plain-string metadata of a build output, a
+// list of flags such as "-I/opt/zlib/include -DZ_SOLO -lz", into a
+// PkgConfig: -I, -isystem and -include flags are Cflags, -D flags Defines,
+// and the other flags Libs. Flags are separated by spaces and may be
+// quoted as in a shell.
+func ParsePkgConfig(flags string) PkgConfig {
+	var p PkgConfig
+	args := splitFlags(flags)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case strings.HasPrefix(arg, "-D"):
+			p.addDefine(arg, args, &i)
+		case arg == "-isystem" || arg == "-include" || arg == "-I":
+			p.Cflags = append(p.Cflags, arg)
+			if i+1 < len(args) {
+				i++
+				p.Cflags = append(p.Cflags, args[i])
+			}
+		case strings.HasPrefix(arg, "-I") || strings.HasPrefix(arg, "-isystem"):
+			p.Cflags = append(p.Cflags, arg)
+		default:
+			p.Libs = append(p.Libs, arg)
+		}
+	}
+	return p
+}
+
+// AddCflags adds compiler flags, such as a Cflags line of a .pc file:
+// -D flags to Defines, the others to Cflags.
+func (p *PkgConfig) AddCflags(flags string) {
+	args := splitFlags(flags)
+	for i := 0; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "-D") {
+			p.addDefine(args[i], args, &i)
+		} else {
+			p.Cflags = append(p.Cflags, args[i])
+		}
+	}
+}
+
+// AddLibs adds linker flags to Libs.
+func (p *PkgConfig) AddLibs(flags string) {
+	p.Libs = append(p.Libs, splitFlags(flags)...)
+}
+
+// AddStaticLibs adds linker flags to StaticLibs.
+func (p *PkgConfig) AddStaticLibs(flags string) {
+	p.StaticLibs = append(p.StaticLibs, splitFlags(flags)...)
+}
+
+// addDefine adds the definition of the -D flag args[*i], which is in the
+// next argument if the flag is only -D.
+func (p *PkgConfig) addDefine(arg string, args []string, i *int) {
+	def := arg[len("-D"):]
+	if def == "" {
+		if *i+1 >= len(args) {
+			return
+		}
+		*i++
+		def = args[*i]
+	}
+	p.Defines = append(p.Defines, def)
+}
+
+// IsEmpty reports whether p holds no metadata.
+func (p PkgConfig) IsEmpty() bool {
+	return len(p.Cflags) == 0 && len(p.Defines) == 0 && len(p.Libs) == 0 && len(p.StaticLibs) == 0 && len(p.Requires) == 0
+}
+
+// MergePkgConfig merges the metadata of several packages, such as a library
+// and all its transitive dependencies, in topological order: a package
+// before the packages it depends on.
+//
+// Like pkg-config given several packages, it keeps the first of repeated
+// Cflags, Defines, Requires and -L flags, but the last of repeated
+// libraries, so that a library is still linked after those using it. The
+// StaticLibs of all packages come after their Libs, without the flags
+// already in Libs.
+func MergePkgConfig(pcs ...PkgConfig) PkgConfig {
+	var merged PkgConfig
+	for _, p := range pcs {
+		merged.Cflags = append(merged.Cflags, p.Cflags...)
+		merged.Defines = append(merged.Defines, p.Defines...)
+		merged.Libs = append(merged.Libs, p.Libs...)
+		merged.StaticLibs = append(merged.StaticLibs, p.StaticLibs...)
+		merged.Requires = append(merged.Requires, p.Requires...)
+	}
+	merged.Cflags = uniqFlags(merged.Cflags, false)
+	merged.Defines = uniqFlags(merged.Defines, false)
+	merged.Libs = uniqFlags(merged.Libs, true)
+	merged.StaticLibs = uniqFlags(merged.StaticLibs, true)
+	merged.StaticLibs = slices.DeleteFunc(merged.StaticLibs, func(flag string) bool {
+		return slices.Contains(merged.Libs, flag)
+	})
+	merged.Requires = uniqFlags(merged.Requires, false)
+	return merged
+}
+
+// uniqFlags removes the repetitions of flags in place. With libs, it keeps
+// the last of repeated flags other than -L, otherwise the first. A flag
+// taking a separate argument, such as -isystem or -framework, is repeated
+// only along with its argument.
+func uniqFlags(flags []string, libs bool) []string {
+	var units [][]string
+	for i := 0; i < len(flags); i++ {
+		if pairFlags[flags[i]] && i+1 < len(flags) {
+			units = append(units, flags[i:i+2])
+			i++
+		} else {
+			units = append(units, flags[i:i+1])
+		}
+	}
+	last := make(map[string]int, len(units))
+	for i, u := range units {
+		last[strings.Join(u, " ")] = i
+	}
+	seen := make(map[string]bool, len(units))
+	var kept []string
+	for i, u := range units {
+		key := strings.Join(u, " ")
+		if libs && !strings.HasPrefix(key, "-L") {
+			if last[key] != i {
+				continue
+			}
+		} else if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, u...)
+	}
+	return kept
+}
+
+// pairFlags are the flags taking their argument separately.
+var pairFlags = map[string]bool{
+	"-I": true, "-isystem": true, "-include": true, "-L": true, "-framework": true,
+}
+
+// CflagsString returns the compiler flags of p, its definitions included,
+// as a string of flags, like pkg-config --cflags.
+func (p PkgConfig) CflagsString() string {
+	flags := make([]string, 0, len(p.Cflags)+len(p.Defines))
+	flags = append(flags, p.Cflags...)
+	for _, def := range p.Defines {
+		flags = append(flags, "-D"+def)
+	}
+	return joinFlags(flags)
+}
+
+// LibsString returns the linker flags of p as a string of flags, like
+// pkg-config --libs, or with static, like pkg-config --libs --static.
+func (p PkgConfig) LibsString(static bool) string {
+	if !static {
+		return joinFlags(p.Libs)
+	}
+	return joinFlags(append(p.Libs[:len(p.Libs):len(p.Libs)], p.StaticLibs...))
+}
+
+// String returns the compiler and linker flags of p as a single string,
+// the plain-string form of the metadata, which ParsePkgConfig reverses
+// but for StaticLibs and Requires.
+func (p PkgConfig) String() string {
+	cflags, libs := p.CflagsString(), p.LibsString(false)
+	if cflags == "" || libs == "" {
+		return cflags + libs
+	}
+	return cflags + " " + libs
+}
+
+// splitFlags splits a string of flags separated by spaces. Like in a
+// shell and in .pc files, a backslash escapes the next character and
+// quotes protect the spaces of a flag.
+func splitFlags(s string) []string {
+	var args []string
+	var b strings.Builder
+	inArg := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			} else {
+				b.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args
+}
+
+// joinFlags joins flags with spaces, escaping the characters splitFlags
+// would otherwise interpret.
+func joinFlags(flags []string) string {
+	var b strings.Builder
+	for i, flag := range flags {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		for j := 0; j < len(flag); j++ {
+			if strings.IndexByte(" \t\n\r'\"\\", flag[j]) >= 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(flag[j])
+		}
+	}
+	return b.String()
+}
+
+// -----------------------------------------------------------------------------
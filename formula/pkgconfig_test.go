@@ -0,0 +1,145 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePkgConfig(t *testing.T) {
+	tests := []struct {
+		flags string
+		want  PkgConfig
+	}{
+		{"", PkgConfig{}},
+		{"-lz", PkgConfig{Libs: []string{"-lz"}}},
+		{
+			"-I/opt/z/include -DZ_SOLO -D ZLIB_CONST=1 -L/opt/z/lib -lz",
+			PkgConfig{
+				Cflags:  []string{"-I/opt/z/include"},
+				Defines: []string{"Z_SOLO", "ZLIB_CONST=1"},
+				Libs:    []string{"-L/opt/z/lib", "-lz"},
+			},
+		},
+		{
+			"-isystem /opt/include -include config.h -pthread",
+			PkgConfig{
+				Cflags: []string{"-isystem", "/opt/include", "-include", "config.h"},
+				Libs:   []string{"-pthread"},
+			},
+		},
+		{
+			`-I"/opt/my lib/include" -I/opt/other\ dir -DNAME='"x y"'`,
+			PkgConfig{
+				Cflags:  []string{"-I/opt/my lib/include", "-I/opt/other dir"},
+				Defines: []string{`NAME="x y"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := ParsePkgConfig(tt.flags); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePkgConfig(%q) = %+v, want %+v", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestPkgConfig_String(t *testing.T) {
+	pc := PkgConfig{
+		Cflags:     []string{"-I/opt/my lib/include"},
+		Defines:    []string{`NAME="x"`},
+		Libs:       []string{"-L/opt/lib", "-lx"},
+		StaticLibs: []string{"-lm"},
+	}
+	if got, want := pc.CflagsString(), `-I/opt/my\ lib/include -DNAME=\"x\"`; got != want {
+		t.Errorf("CflagsString() = %q, want %q", got, want)
+	}
+	if got, want := pc.LibsString(true), "-L/opt/lib -lx -lm"; got != want {
+		t.Errorf("LibsString(true) = %q, want %q", got, want)
+	}
+	if got, want := pc.LibsString(false), "-L/opt/lib -lx"; got != want {
+		t.Errorf("LibsString(false) = %q, want %q", got, want)
+	}
+
+	// ParsePkgConfig reverses String but for StaticLibs and Requires.
+	pc.StaticLibs = nil
+	if got := ParsePkgConfig(pc.String()); !reflect.DeepEqual(got, pc) {
+		t.Errorf("ParsePkgConfig(%q) = %+v, want %+v", pc.String(), got, pc)
+	}
+	if got := (PkgConfig{Libs: []string{"-lz"}}).String(); got != "-lz" {
+		t.Errorf("String() = %q, want %q", got, "-lz")
+	}
+}
+
+func TestPkgConfig_Add(t *testing.T) {
+	var pc PkgConfig
+	if !pc.IsEmpty() {
+		t.Error("zero PkgConfig is not empty")
+	}
+	pc.AddCflags("-I/a -DA -pthread")
+	pc.AddLibs("-L/a -la")
+	pc.AddStaticLibs("-lm")
+	want := PkgConfig{
+		Cflags:     []string{"-I/a", "-pthread"},
+		Defines:    []string{"A"},
+		Libs:       []string{"-L/a", "-la"},
+		StaticLibs: []string{"-lm"},
+	}
+	if !reflect.DeepEqual(pc, want) {
+		t.Errorf("PkgConfig = %+v, want %+v", pc, want)
+	}
+}
+
+func TestBuildResult_PkgConfig(t *testing.T) {
+	var result BuildResult
+	result.SetMetadata("-I/z/include -lz")
+	if got, want := result.PkgConfig(), (PkgConfig{Cflags: []string{"-I/z/include"}, Libs: []string{"-lz"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("PkgConfig() from metadata = %+v, want %+v", got, want)
+	}
+
+	result = BuildResult{}
+	pc := PkgConfig{Defines: []string{"Z"}, Libs: []string{"-lz"}, Requires: []string{"zlib"}}
+	result.SetPkgConfig(pc)
+	if got := result.PkgConfig(); !reflect.DeepEqual(got, pc) {
+		t.Errorf("PkgConfig() = %+v, want %+v", got, pc)
+	}
+	if got, want := result.Metadata(), "-DZ -lz"; got != want {
+		t.Errorf("Metadata() = %q, want %q", got, want)
+	}
+	result.SetMetadata("-lother")
+	if got := result.Metadata(); got != "-lother" {
+		t.Errorf("Metadata() = %q, want the string set %q", got, "-lother")
+	}
+}
+
+func TestMergePkgConfig(t *testing.T) {
+	png := PkgConfig{
+		Cflags:     []string{"-I/png/include", "-isystem", "/usr/include"},
+		Defines:    []string{"PNG"},
+		Libs:       []string{"-L/png/lib", "-lpng", "-lm"},
+		StaticLibs: []string{"-lz", "-lpthread"},
+		Requires:   []string{"zlib"},
+	}
+	zlib := PkgConfig{
+		Cflags:     []string{"-I/zlib/include", "-isystem", "/usr/include"},
+		Defines:    []string{"PNG"},
+		Libs:       []string{"-L/zlib/lib", "-lz", "-lm"},
+		StaticLibs: []string{"-lpthread"},
+		Requires:   []string{"zlib"},
+	}
+	want := PkgConfig{
+		Cflags:     []string{"-I/png/include", "-isystem", "/usr/include", "-I/zlib/include"},
+		Defines:    []string{"PNG"},
+		Libs:       []string{"-L/png/lib", "-lpng", "-L/zlib/lib", "-lz", "-lm"},
+		StaticLibs: []string{"-lpthread"},
+		Requires:   []string{"zlib"},
+	}
+	if got := MergePkgConfig(png, zlib); !reflect.DeepEqual(got, want) {
+		t.Errorf("MergePkgConfig = %+v, want %+v", got, want)
+	}
+	if got := MergePkgConfig(); !got.IsEmpty() {
+		t.Errorf("MergePkgConfig() = %+v, want empty", got)
+	}
+}
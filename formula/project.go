@@ -6,6 +6,7 @@ package formula
 
 import (
 	"io/fs"
+	"os"
 
 	"github.com/goplus/llar/mod/module"
 )
@@ -14,7 +15,10 @@ import (
 
 // Project represents a project (module) being built.
 type Project struct {
-	Deps     []module.Version
+	Deps []module.Version
+	// Tools lists the tool dependencies of the project (see
+	// ModuleDeps.Tool), built for the host.
+	Tools    []module.Version
 	SourceFS fs.ReadFileFS
 }
 
@@ -28,19 +32,32 @@ type Context struct {
 	SourceDir string
 
 	buildResults map[module.Version]BuildResult
+	env          *Env
 
 	// filled by build
 	installDir   string
 	matrixStr    string
+	matrix       Combination
+	current      string // what CurrentMatrix returns
 	getOutputDir func(matrixStr string, mod module.Version) (string, error)
 }
 
-// NewContext creates a Context with build-internal fields.
+// NewContext creates a Context with build-internal fields. matrixStr is
+// the canonical encoding of the matrix combination being built (see
+// Combination.String); if it is not one, the context has no matrix
+// values.
 func NewContext(sourceDir, installDir, matrixStr string, getOutputDir func(string, module.Version) (string, error)) *Context {
+	current := matrixStr
+	matrix, err := ParseCombination(matrixStr)
+	if err == nil {
+		current = matrix.Legacy()
+	}
 	return &Context{
 		SourceDir:    sourceDir,
 		installDir:   installDir,
 		matrixStr:    matrixStr,
+		matrix:       matrix,
+		current:      current,
 		getOutputDir: getOutputDir,
 	}
 }
@@ -57,9 +74,44 @@ func (c *Context) OutputDir__1(mod module.Version) (string, error) {
 	return c.getOutputDir(c.matrixStr, mod)
 }
 
-// CurrentMatrix returns the active build matrix for this context.
+// Env returns the environment of this build. It starts as a snapshot of
+// the process environment taken on first use; changes made through it are
+// private to this build and are seen by the commands the formula runs with
+// exec and by the x/cmake and x/autotools helpers it creates.
+// In DSL: ctx.env
+func (c *Context) Env() *Env {
+	if c.env == nil {
+		c.env = NewEnv(os.Environ())
+	}
+	return c.env
+}
+
+// CurrentMatrix returns the active build matrix for this context in the
+// form formulas have always seen, e.g. "amd64-linux" (see
+// Combination.Legacy). Use Matrix and Option to read single values.
 func (c *Context) CurrentMatrix() string {
-	return c.matrixStr
+	return c.current
+}
+
+// Matrix returns the value of the required matrix key of this build, or ""
+// if the matrix has no such key.
+// In DSL: ctx.matrix("os")
+func (c *Context) Matrix(key string) string {
+	return c.matrix.Require[key]
+}
+
+// Option returns the value of the matrix option key of this build, or ""
+// if the matrix has no such option.
+// In DSL: ctx.option("shared")
+func (c *Context) Option(key string) string {
+	return c.matrix.Options[key]
+}
+
+// MatrixValues returns every matrix key and option of this build with its
+// value.
+// In DSL: ctx.matrixValues
+func (c *Context) MatrixValues() map[string]string {
+	return c.matrix.Values()
 }
 
 // BuildResult returns the stored build result for the module, if any.
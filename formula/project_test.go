@@ -6,6 +6,7 @@ package formula
 
 import (
 	"errors"
+	"maps"
 	"reflect"
 	"testing"
 	"testing/fstest"
@@ -28,6 +29,36 @@ func TestModuleDeps_Require(t *testing.T) {
 	}
 }
 
+func TestModuleDeps_Tool(t *testing.T) {
+	deps := &ModuleDeps{}
+
+	deps.Require("madler/zlib", "1.3.1")
+	deps.Tool("skvadrik/re2c", "4.0")
+
+	if want := []module.Version{{Path: "skvadrik/re2c", Version: "4.0"}}; !reflect.DeepEqual(deps.Tools(), want) {
+		t.Fatalf("ModuleDeps.Tools() = %#v, want %#v", deps.Tools(), want)
+	}
+	if want := []module.Version{{Path: "madler/zlib", Version: "1.3.1"}}; !reflect.DeepEqual(deps.Deps(), want) {
+		t.Fatalf("ModuleDeps.Deps() = %#v, want %#v", deps.Deps(), want)
+	}
+}
+
+func TestModuleDeps_Matrix(t *testing.T) {
+	deps := NewModuleDeps(Combination{
+		Require: map[string]string{"os": "linux"},
+		Options: map[string]string{"ssl": "on"},
+	})
+	if deps.Matrix("os") != "linux" || deps.Option("ssl") != "on" || deps.Option("os") != "" {
+		t.Errorf("ModuleDeps matrix = %q, %q, %q", deps.Matrix("os"), deps.Option("ssl"), deps.Option("os"))
+	}
+	if want := map[string]string{"os": "linux", "ssl": "on"}; !maps.Equal(deps.MatrixValues(), want) {
+		t.Errorf("ModuleDeps.MatrixValues() = %v, want %v", deps.MatrixValues(), want)
+	}
+	if (&ModuleDeps{}).Option("ssl") != "" {
+		t.Error("ModuleDeps without matrix has an option")
+	}
+}
+
 func TestBuildResult_ErrsAndMetadata(t *testing.T) {
 	result := &BuildResult{}
 	errA := errors.New("first")
@@ -74,7 +105,6 @@ func TestProject_ReadFile(t *testing.T) {
 }
 
 func TestContext_CurrentMatrix(t *testing.T) {
-	ctx := &Context{}
 	matrix := Matrix{
 		Require: map[string][]string{
 			"os": {"linux"},
@@ -84,11 +114,40 @@ func TestContext_CurrentMatrix(t *testing.T) {
 		},
 	}
 
+	// Formulas see the legacy form of the canonical combination built.
 	want := matrix.Combinations()[0]
-	ctx.matrixStr = want
+	ctx := NewContext("", "", matrix.Expand()[0].String(), nil)
 	if got := ctx.CurrentMatrix(); got != want {
 		t.Fatalf("Context.CurrentMatrix() = %q, want %q", got, want)
 	}
+
+	// A matrix that is not canonical is passed through.
+	ctx = NewContext("", "", "amd64-linux", nil)
+	if got := ctx.CurrentMatrix(); got != "amd64-linux" {
+		t.Fatalf("Context.CurrentMatrix() = %q, want %q", got, "amd64-linux")
+	}
+}
+
+func TestContext_Matrix(t *testing.T) {
+	ctx := NewContext("/src", "/install", "arch=arm64,os=linux|shared=on", nil)
+	if got := ctx.Matrix("os"); got != "linux" {
+		t.Errorf("Context.Matrix(os) = %q, want %q", got, "linux")
+	}
+	if got := ctx.Option("shared"); got != "on" {
+		t.Errorf("Context.Option(shared) = %q, want %q", got, "on")
+	}
+	if got := ctx.Matrix("shared"); got != "" {
+		t.Errorf("Context.Matrix(shared) = %q, want an option to be no required key", got)
+	}
+	want := map[string]string{"arch": "arm64", "os": "linux", "shared": "on"}
+	if got := ctx.MatrixValues(); !maps.Equal(got, want) {
+		t.Errorf("Context.MatrixValues() = %v, want %v", got, want)
+	}
+
+	// A matrix string in another form has no values.
+	if got := NewContext("/src", "/install", "amd64-linux", nil).MatrixValues(); len(got) != 0 {
+		t.Errorf("Context.MatrixValues() = %v, want none", got)
+	}
 }
 
 // TestNewContext covers the public constructor and asserts that each
@@ -175,3 +234,21 @@ func TestContext_BuildResult(t *testing.T) {
 		t.Fatalf("Context.BuildResult() metadata = %q, want %q", got.Metadata(), "metadata")
 	}
 }
+
+func TestContext_Env(t *testing.T) {
+	t.Setenv("LLAR_CTX_ENV_TEST", "process")
+	ctx := NewContext("/src", "/install", "amd64-linux", nil)
+
+	env := ctx.Env()
+	if got := env.Get("LLAR_CTX_ENV_TEST"); got != "process" {
+		t.Errorf("Env().Get = %q, want snapshot of process env %q", got, "process")
+	}
+	if ctx.Env() != env {
+		t.Error("Env() returned a different Env on second call")
+	}
+
+	env.Set("LLAR_CTX_ENV_TEST", "build")
+	if other := NewContext("/src", "/install", "amd64-linux", nil).Env(); other.Get("LLAR_CTX_ENV_TEST") != "process" {
+		t.Error("Env of one Context leaked into another")
+	}
+}
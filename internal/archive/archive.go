@@ -0,0 +1,269 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package archive downloads release archives declared by formulas, verifies
+// their checksums and unpacks them.
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goplus/llar/internal/lockedfile"
+)
+
+// Archive cache directory layout:
+//
+//	cacheDir/
+//	  <sha256>/
+//	    <name>            # the verified archive, named after its URL
+//	    tree/             # shared unpacked copy, never modified
+//	    tree.done         # marks tree/ as complete
+//	  <sha256>.lock       # serializes downloads and unpacking
+//
+// Archives are addressed by their checksum, so the same archive served
+// from several URLs is only stored once.
+const (
+	treeDir = "tree"
+)
+
+// Cache is an on-disk cache of verified source archives. A Cache is safe
+// for concurrent use by multiple goroutines and processes.
+type Cache struct {
+	dir    string
+	client *http.Client
+}
+
+// NewCache returns a Cache rooted at dir.
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir, client: http.DefaultClient}
+}
+
+// DefaultCacheDir returns the default archive cache directory:
+// <UserCacheDir>/.llar/archives.
+func DefaultCacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDir, ".llar", "archives"), nil
+}
+
+// Dir returns the root directory of the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Fetch returns the path of the archive at rawURL, downloading it on first
+// use. The archive must have the hex-encoded SHA-256 checksum sum; a
+// mismatching download is discarded and reported as an error.
+func (c *Cache) Fetch(ctx context.Context, rawURL, sum string) (string, error) {
+	name, err := archiveName(rawURL)
+	if err != nil {
+		return "", err
+	}
+	sum = strings.ToLower(sum)
+	if !isSHA256(sum) {
+		return "", fmt.Errorf("invalid sha256 checksum %q for %s", sum, rawURL)
+	}
+	file := filepath.Join(c.dir, sum, name)
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+
+	unlock, err := c.lock(sum)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Another process may have downloaded it while we waited for the lock.
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+	if err := c.download(ctx, rawURL, sum, file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// Extract unpacks the archive at rawURL into destDir (see Unpack),
+// downloading and verifying it first if needed.
+func (c *Cache) Extract(ctx context.Context, rawURL, sum, destDir string) error {
+	file, err := c.Fetch(ctx, rawURL, sum)
+	if err != nil {
+		return err
+	}
+	return Unpack(file, destDir)
+}
+
+// Tree returns a directory holding the unpacked archive at rawURL. The
+// directory is shared by all users of the cache and must not be modified;
+// use Extract for a writable copy.
+func (c *Cache) Tree(ctx context.Context, rawURL, sum string) (string, error) {
+	sum = strings.ToLower(sum)
+	dir := filepath.Join(c.dir, sum, treeDir)
+	done := dir + ".done"
+	if _, err := os.Stat(done); err == nil {
+		return dir, nil
+	}
+	file, err := c.Fetch(ctx, rawURL, sum)
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := c.lock(sum)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(done); err == nil {
+		return dir, nil
+	}
+	// Clean up a tree left behind by an interrupted attempt.
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := Unpack(file, dir); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(done, nil, 0o644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// download fetches rawURL into file, verifying its checksum. The caller
+// must hold the lock of sum.
+func (c *Cache) download(ctx context.Context, rawURL, sum, file string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download %s: %w", rawURL, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("checksum mismatch for %s:\n\twant sha256 %s\n\tgot  sha256 %s", rawURL, sum, got)
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// lock acquires the lock of the archive with checksum sum.
+func (c *Cache) lock(sum string) (unlock func(), err error) {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return nil, err
+	}
+	return lockedfile.MutexAt(filepath.Join(c.dir, sum+".lock")).Lock()
+}
+
+// archiveName returns the file name of the archive at rawURL.
+func archiveName(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	if format(name) == unknown {
+		return "", fmt.Errorf("unsupported archive format: %s", rawURL)
+	}
+	return name, nil
+}
+
+// isSHA256 reports whether s is a lower-case hex-encoded SHA-256 checksum.
+func isSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// -----------------------------------------------------------------------------
+
+// treeFS is a read-only view of an unpacked archive served from a Cache.
+// The archive is only downloaded when the view is first used.
+type treeFS struct {
+	dir func() (string, error)
+}
+
+// FS returns a lazy, read-only view of the unpacked archive at rawURL.
+// The returned fs.FS also implements fs.ReadFileFS and fs.ReadDirFS.
+func (c *Cache) FS(rawURL, sum string) fs.FS {
+	return &treeFS{
+		dir: sync.OnceValues(func() (string, error) {
+			return c.Tree(context.Background(), rawURL, sum)
+		}),
+	}
+}
+
+func (t *treeFS) fsys() (fs.FS, error) {
+	dir, err := t.dir()
+	if err != nil {
+		return nil, err
+	}
+	return os.DirFS(dir), nil
+}
+
+// Open opens the named file.
+func (t *treeFS) Open(name string) (fs.File, error) {
+	fsys, err := t.fsys()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return fsys.Open(name)
+}
+
+// ReadFile reads the named file.
+func (t *treeFS) ReadFile(name string) ([]byte, error) {
+	fsys, err := t.fsys()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// ReadDir reads the named directory.
+func (t *treeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys, err := t.fsys()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return fs.ReadDir(fsys, name)
+}
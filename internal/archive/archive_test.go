@@ -0,0 +1,368 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testFiles is the content of the test archives, all wrapped in the
+// top-level directory zlib-1.3.1/ like a real release.
+var testFiles = map[string]string{
+	"zlib-1.3.1/README":     "zlib",
+	"zlib-1.3.1/configure":  "#!/bin/sh\n",
+	"zlib-1.3.1/src/zlib.h": "header",
+}
+
+// makeTar returns testFiles (plus extra) as a tar stream.
+func makeTar(t *testing.T, extra ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range testFiles {
+		mode := int64(0o644)
+		if strings.HasSuffix(name, "configure") {
+			mode = 0o755
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, hdr := range extra {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, extra ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(makeTar(t, extra...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range testFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compress pipes data through the named compression command.
+func compress(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s not installed", name)
+	}
+	cmd := exec.Command(name, "--stdout")
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return out
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// serve serves files (URL path -> content) and counts the requests.
+func serve(t *testing.T, files map[string][]byte) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func checkTree(t *testing.T, dir string) {
+	t.Helper()
+	for name, want := range testFiles {
+		rel := strings.TrimPrefix(name, "zlib-1.3.1/")
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			t.Errorf("missing %s: %v", rel, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", rel, data, want)
+		}
+	}
+}
+
+func TestCache_Extract(t *testing.T) {
+	tarball := makeTar(t)
+	tests := []struct {
+		name string
+		data func(t *testing.T) []byte
+	}{
+		{"zlib-1.3.1.tar.gz", func(t *testing.T) []byte { return makeTarGz(t) }},
+		{"zlib-1.3.1.tar.bz2", func(t *testing.T) []byte { return compress(t, "bzip2", tarball) }},
+		{"zlib-1.3.1.tar.xz", func(t *testing.T) []byte { return compress(t, "xz", tarball) }},
+		{"zlib-1.3.1.zip", makeZip},
+		{"zlib-1.3.1.tar", func(*testing.T) []byte { return tarball }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data(t)
+			srv, _ := serve(t, map[string][]byte{"/" + tt.name: data})
+			c := NewCache(t.TempDir())
+
+			dest := t.TempDir()
+			if err := c.Extract(context.Background(), srv.URL+"/"+tt.name, sha256Hex(data), dest); err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			checkTree(t, dest)
+		})
+	}
+}
+
+func TestCache_ExtractKeepsExecutableBit(t *testing.T) {
+	data := makeTarGz(t)
+	srv, _ := serve(t, map[string][]byte{"/zlib.tar.gz": data})
+	dest := t.TempDir()
+	if err := NewCache(t.TempDir()).Extract(context.Background(), srv.URL+"/zlib.tar.gz", sha256Hex(data), dest); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "configure"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o100 == 0 {
+		t.Errorf("configure mode = %v, want executable", info.Mode())
+	}
+}
+
+func TestCache_ChecksumMismatch(t *testing.T) {
+	data := makeTarGz(t)
+	srv, _ := serve(t, map[string][]byte{"/zlib.tar.gz": data})
+	c := NewCache(t.TempDir())
+
+	wrong := sha256Hex([]byte("something else"))
+	_, err := c.Fetch(context.Background(), srv.URL+"/zlib.tar.gz", wrong)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") || !strings.Contains(err.Error(), sha256Hex(data)) {
+		t.Fatalf("Fetch() error = %v, want checksum mismatch reporting the actual sum", err)
+	}
+	// Nothing is kept from a rejected download.
+	if _, err := os.Stat(filepath.Join(c.Dir(), wrong, "zlib.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("rejected archive was cached, stat error = %v", err)
+	}
+}
+
+func TestCache_FetchOnce(t *testing.T) {
+	data := makeTarGz(t)
+	srv, hits := serve(t, map[string][]byte{"/zlib.tar.gz": data})
+	c := NewCache(t.TempDir())
+	ctx := context.Background()
+	url, sum := srv.URL+"/zlib.tar.gz", sha256Hex(data)
+
+	for range 3 {
+		if err := c.Extract(ctx, url, sum, t.TempDir()); err != nil {
+			t.Fatalf("Extract() error = %v", err)
+		}
+	}
+	tree, err := c.Tree(ctx, url, sum)
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+	checkTree(t, tree)
+	if n := hits.Load(); n != 1 {
+		t.Errorf("archive downloaded %d times, want 1", n)
+	}
+}
+
+func TestCache_FetchErrors(t *testing.T) {
+	srv, _ := serve(t, nil)
+	c := NewCache(t.TempDir())
+	ctx := context.Background()
+	sum := sha256Hex(nil)
+
+	if _, err := c.Fetch(ctx, srv.URL+"/missing.tar.gz", sum); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Fetch(missing) error = %v, want 404", err)
+	}
+	if _, err := c.Fetch(ctx, srv.URL+"/zlib.rar", sum); err == nil || !strings.Contains(err.Error(), "unsupported archive format") {
+		t.Errorf("Fetch(.rar) error = %v, want unsupported format", err)
+	}
+	if _, err := c.Fetch(ctx, srv.URL+"/zlib.tar.gz", "abc"); err == nil || !strings.Contains(err.Error(), "invalid sha256") {
+		t.Errorf("Fetch(bad sum) error = %v, want invalid sha256", err)
+	}
+}
+
+func TestCache_FS(t *testing.T) {
+	data := makeTarGz(t)
+	srv, hits := serve(t, map[string][]byte{"/zlib.tar.gz": data})
+	fsys := NewCache(t.TempDir()).FS(srv.URL+"/zlib.tar.gz", sha256Hex(data))
+
+	// Nothing is downloaded until the view is used.
+	if n := hits.Load(); n != 0 {
+		t.Fatalf("FS() downloaded the archive eagerly")
+	}
+	got, err := fs.ReadFile(fsys, "src/zlib.h")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "header" {
+		t.Errorf("src/zlib.h = %q, want %q", got, "header")
+	}
+}
+
+func TestUnpack_RejectsEscapingPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		hdr  *tar.Header
+	}{
+		{"parent dir", &tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0o644}},
+		{"absolute symlink", &tar.Header{Name: "zlib-1.3.1/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		{"escaping symlink", &tar.Header{Name: "zlib-1.3.1/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "evil.tar.gz")
+			if err := os.WriteFile(file, makeTarGz(t, tt.hdr), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := Unpack(file, t.TempDir()); err == nil {
+				t.Error("Unpack() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestUnpack_RejectsSymlinkChains(t *testing.T) {
+	link := func(name, target string) *tar.Header {
+		return &tar.Header{Name: "zlib-1.3.1/" + name, Typeflag: tar.TypeSymlink, Linkname: target}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: "zlib-1.3.1/" + name, Typeflag: tar.TypeReg, Mode: 0o644}
+	}
+	tests := []struct {
+		name string
+		hdrs []*tar.Header
+	}{
+		// Each link stays inside lexically, but d/l/m/n is three levels
+		// above d.
+		{"chain", []*tar.Header{link("d/l", ".."), link("d/l/m", ".."), link("d/l/m/n", ".."), file("d/l/m/n/evil")}},
+		{"through link", []*tar.Header{link("d/l", ".."), link("x", "d/l/../.."), file("x/evil")}},
+		{"dir through link", []*tar.Header{link("l", "src"), {Name: "zlib-1.3.1/l/sub", Typeflag: tar.TypeDir, Mode: 0o755}}},
+		{"overwrite link", []*tar.Header{link("l", "configure"), file("l")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outer := t.TempDir()
+			dest := filepath.Join(outer, "a", "b", "c", "dest")
+			archive := filepath.Join(t.TempDir(), "evil.tar.gz")
+			if err := os.WriteFile(archive, makeTarGz(t, tt.hdrs...), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := Unpack(archive, dest); err == nil {
+				t.Error("Unpack() error = nil, want error")
+			}
+			filepath.WalkDir(outer, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && d.Name() == "evil" && !strings.HasPrefix(path, dest+string(filepath.Separator)) {
+					t.Errorf("%s written outside the destination directory", path)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestUnpack_SymlinkToSymlink(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "libs.tar.gz")
+	if err := os.WriteFile(file, makeTarGz(t,
+		&tar.Header{Name: "zlib-1.3.1/zlib.h", Typeflag: tar.TypeSymlink, Linkname: "src/zlib.h"},
+		&tar.Header{Name: "zlib-1.3.1/z.h", Typeflag: tar.TypeSymlink, Linkname: "zlib.h"},
+	), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := Unpack(file, dest); err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "z.h")); err != nil || string(got) != "header" {
+		t.Errorf("z.h = %q, %v, want %q", got, err, "header")
+	}
+}
+
+func TestUnpack_KeepsMultipleTopLevelEntries(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b/c.txt"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 1, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte("x"))
+	}
+	tw.Close()
+	file := filepath.Join(t.TempDir(), "flat.tar")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	if err := Unpack(file, dest); err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	for _, name := range []string{"a.txt", "b/c.txt"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("missing %s: %v", name, err)
+		}
+	}
+	entries, _ := os.ReadDir(dest)
+	if len(entries) != 2 {
+		t.Errorf("dest has %d entries, want 2 (no leftover temp dir)", len(entries))
+	}
+}
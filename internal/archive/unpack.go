@@ -0,0 +1,314 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// archiveFormat is the container and compression format of an archive.
+type archiveFormat int
+
+const (
+	unknown archiveFormat = iota
+	tarPlain
+	tarGzip
+	tarBzip2
+	tarXz
+	zipArchive
+)
+
+// format returns the format of the archive called name, by extension.
+func format(name string) archiveFormat {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return tarGzip
+	case strings.HasSuffix(name, ".tar.bz2"), strings.HasSuffix(name, ".tbz2"), strings.HasSuffix(name, ".tbz"):
+		return tarBzip2
+	case strings.HasSuffix(name, ".tar.xz"), strings.HasSuffix(name, ".txz"):
+		return tarXz
+	case strings.HasSuffix(name, ".tar"):
+		return tarPlain
+	case strings.HasSuffix(name, ".zip"):
+		return zipArchive
+	}
+	return unknown
+}
+
+// Unpack unpacks the archive file into destDir, which is created if needed.
+// The format is chosen by the file extension: .tar.gz (.tgz), .tar.bz2
+// (.tbz2), .tar.xz (.txz), .tar or .zip; .tar.xz needs the xz command.
+//
+// Release archives usually wrap everything in a single top-level directory
+// (e.g. zlib-1.3.1/); that directory is stripped so destDir becomes the
+// project root, like a repository checkout. Entries escaping destDir are
+// rejected.
+func Unpack(file, destDir string) error {
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(destDir, ".unpack-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	switch format(file) {
+	case zipArchive:
+		err = unzip(file, tmp)
+	case unknown:
+		err = errors.New("unsupported archive format")
+	default:
+		err = untarFile(file, tmp)
+	}
+	if err != nil {
+		return fmt.Errorf("unpack %s: %w", filepath.Base(file), err)
+	}
+
+	root := tmp
+	if entries, err := os.ReadDir(tmp); err != nil {
+		return err
+	} else if len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(tmp, entries[0].Name())
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Rename(filepath.Join(root, e.Name()), filepath.Join(destDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untarFile unpacks the (possibly compressed) tar archive file into dir.
+func untarFile(file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch format(file) {
+	case tarGzip:
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	case tarBzip2:
+		r = bzip2.NewReader(f)
+	case tarXz:
+		// The standard library has no xz decoder.
+		cmd := exec.Command("xz", "--decompress", "--stdout")
+		cmd.Stdin = f
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("xz is required for .tar.xz archives: %w", err)
+		}
+		err = untar(out, dir)
+		if err != nil {
+			io.Copy(io.Discard, out)
+		}
+		if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+			err = fmt.Errorf("xz: %w", waitErr)
+		}
+		return err
+	}
+	return untar(r, dir)
+}
+
+// untar unpacks the tar stream r into dir.
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := safeJoin(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := symlink(dir, hdr.Name, hdr.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			old, err := safeJoin(dir, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Link(old, target); err != nil {
+				return err
+			}
+		default:
+			// Skip pax headers, devices and the like: source archives
+			// have no use for them.
+		}
+	}
+}
+
+// unzip unpacks the zip archive file into dir.
+func unzip(file, dir string) error {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		target, err := safeJoin(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case mode&fs.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			link, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := symlink(dir, f.Name, string(link)); err != nil {
+				return err
+			}
+		default:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			perm := mode.Perm()
+			if perm == 0 {
+				perm = 0o644
+			}
+			err = writeFile(target, rc, perm)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile writes the content of r to the new file target.
+func writeFile(target string, r io.Reader, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm|0o200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// symlink creates the archive entry name in dir as a symbolic link to
+// link, which must stay inside dir.
+func symlink(dir, name, link string) error {
+	if filepath.IsAbs(link) {
+		return fmt.Errorf("%s: symbolic link to absolute path %s", name, link)
+	}
+	target, err := safeJoin(dir, name)
+	if err != nil {
+		return err
+	}
+	// Resolve link component by component rather than lexically: through
+	// a link created earlier, "l/.." may be the parent of dir.
+	var elems []string
+	parts := strings.Split(path.Dir(name)+"/"+filepath.ToSlash(link), "/")
+	for i, elem := range parts {
+		switch elem {
+		case "", ".":
+		case "..":
+			if len(elems) == 0 {
+				return fmt.Errorf("%s: symbolic link %s escapes the destination directory", name, link)
+			}
+			elems = elems[:len(elems)-1]
+		default:
+			elems = append(elems, elem)
+			if i < len(parts)-1 && isSymlink(filepath.Join(dir, filepath.Join(elems...))) {
+				return fmt.Errorf("%s: symbolic link %s goes through another symbolic link", name, link)
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	return os.Symlink(link, target)
+}
+
+// safeJoin joins the slash-separated archive path name to dir, rejecting
+// names that would escape dir: lexically, or through a symbolic link an
+// earlier entry created, since creating and writing files follows them.
+// No component of the result below dir may be a symbolic link.
+func safeJoin(dir, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(name, "./")))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.VolumeName(rel) != "" {
+		return "", fmt.Errorf("%s: path escapes the destination directory", name)
+	}
+	target := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		target = filepath.Join(target, elem)
+		if isSymlink(target) {
+			return "", fmt.Errorf("%s: path goes through symbolic link %s", name, elem)
+		}
+	}
+	return target, nil
+}
+
+// isSymlink reports whether the file name is a symbolic link.
+func isSymlink(name string) bool {
+	info, err := os.Lstat(name)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
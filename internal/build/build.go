@@ -5,14 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	classfile "github.com/goplus/llar/formula"
+	"github.com/goplus/llar/internal/archive"
+	"github.com/goplus/llar/internal/cmakeconfig"
 	"github.com/goplus/llar/internal/formula/repo"
 	"github.com/goplus/llar/internal/modules"
+	"github.com/goplus/llar/internal/patch"
 	"github.com/goplus/llar/internal/vcs"
 	"github.com/goplus/llar/mod/module"
 )
@@ -21,13 +27,24 @@ type Builder struct {
 	store        repo.Store
 	matrix       string
 	runTest      bool
+	jobs         int
 	workspaceDir string
 	newRepo      func(repoPath string) (vcs.Repo, error) // defaults to vcs.NewRepo
+	archives     *archive.Cache                          // source archives declared by formulas
 }
 
 type Result struct {
+	Module    module.Version // the module@version this result belongs to
+	Matrix    string         // the matrix combination it was built for
 	Metadata  string
+	PkgConfig classfile.PkgConfig // Metadata in a structured form
+	// Closure merges PkgConfig with those of all transitive dependencies,
+	// the module first and each dependency before its own dependencies:
+	// everything needed to compile and link statically against the module.
+	Closure   classfile.PkgConfig
 	OutputDir string
+	Key       string // content-addressed build key, see buildInputs
+	Linked    bool   // linked into the main module, directly or not; false for tools
 }
 
 type Options struct {
@@ -42,6 +59,10 @@ type Options struct {
 	// their OnTest hooks triggered.
 	RunTest      bool
 	WorkspaceDir string
+	// Jobs is the maximum number of modules built at the same time.
+	// A module is only started once all of its dependencies in the build
+	// list have finished. Values below 1 mean 1 (sequential build).
+	Jobs int
 }
 
 func defaultWorkspaceDir() (string, error) {
@@ -67,12 +88,18 @@ func NewBuilder(opts Options) (*Builder, error) {
 			return nil, err
 		}
 	}
+	archiveDir, err := archive.DefaultCacheDir()
+	if err != nil {
+		return nil, err
+	}
 	return &Builder{
 		store:        opts.Store,
 		matrix:       opts.MatrixStr,
 		runTest:      opts.RunTest,
+		jobs:         max(opts.Jobs, 1),
 		workspaceDir: workspaceDir,
 		newRepo:      vcs.NewRepo,
+		archives:     archive.NewCache(archiveDir),
 	}, nil
 }
 
@@ -81,7 +108,8 @@ func NewBuilder(opts Options) (*Builder, error) {
 // the main module (root) comes last.
 //
 // This method lives in the build module (rather than modules) because build
-// ordering may change in the future, e.g. to support parallel builds.
+// ordering is a build concern: Build uses it both as the sequential order
+// and as the deterministic order of its results when building in parallel.
 //
 // Example:
 //
@@ -108,6 +136,11 @@ func (b *Builder) constructBuildList(targets []*modules.Module) []*modules.Modul
 				visit(d)
 			}
 		}
+		for _, tool := range m.Tools {
+			if d, ok := byPath[tool.Path]; ok {
+				visit(d)
+			}
+		}
 		order = append(order, m)
 	}
 
@@ -118,78 +151,14 @@ func (b *Builder) constructBuildList(targets []*modules.Module) []*modules.Modul
 	return order
 }
 
-// resolveModTransitiveDeps collects all transitive dependencies of mod from
-// the MVS build list and returns them in build order (DFS post-order: leaves first).
-//
-// modules.Module.Deps only stores direct dependencies so that the build module
-// can reorder freely. This method reconstructs the full transitive set by
-// walking the dependency graph through targets (which use MVS-selected versions).
-//
-// Case 1 - Simple:
-//
-//	Graph:  A -> B -> C -> D
-//	Input:  targets=[A@1.0.0, B@1.2.0, C@1.2.0, D@1.0.0], mod=C@1.2.0
-//	Output: [D@1.0.0]
+// Build builds targets (an MVS build list with the main module first) and
+// returns one Result per module and matrix in plan order.
 //
-// Case 2 - Diamond (MVS version selection):
-//
-//	Graph:  A -> B -> C, A -> D -> C   (MVS selects C@2.0.0)
-//	Input:  targets=[A@1.0.0, B@1.2.0, C@2.0.0, D@1.0.0], mod=B@1.2.0
-//	Output: [C@2.0.0]
-//
-// Case 3 - Diamond with transitive dep:
-//
-//	Graph:  A -> B -> C, A -> D -> C -> E   (MVS selects C@2.0.0)
-//	Input:  targets=[A@1.0.0, B@1.2.0, C@2.0.0, D@1.0.0, E@1.0.0], mod=B@1.2.0
-//	Output: [E@1.0.0, C@2.0.0]
-//
-// Case 4 - Multiple direct deps (alphabet order):
-//
-//	Graph:  A -> B -> C, A -> B -> D
-//	Input:  targets=[A@1.0.0, B@1.2.0, C@1.1.0, D@1.0.0], mod=B@1.2.0
-//	Output: [C@1.1.0, D@1.0.0]
-//
-// Case 5 - Dep ordering by topology:
-//
-//	Graph:  A -> B -> C -> D, A -> B -> D
-//	Input:  targets=[A@1.0.0, B@1.2.0, C@1.1.0, D@1.2.0], mod=B@1.2.0
-//	Output: [D@1.2.0, C@1.1.0]  (D before C because B depends on both D and C directly, while C depends on D transitively)
-func (b *Builder) resolveModTransitiveDeps(targets []*modules.Module, mod *modules.Module) []module.Version {
-	byPath := make(map[string]*modules.Module, len(targets))
-	for _, m := range targets {
-		byPath[m.Path] = m
-	}
-
-	var order []module.Version
-	visited := make(map[string]bool)
-	visited[mod.Path] = true
-
-	var visit func(m *modules.Module)
-	visit = func(m *modules.Module) {
-		if visited[m.Path] {
-			return
-		}
-		visited[m.Path] = true
-		for _, dep := range m.Deps {
-			if d, ok := byPath[dep.Path]; ok {
-				visit(d)
-			}
-		}
-		order = append(order, module.Version{Path: m.Path, Version: m.Version})
-	}
-
-	for _, dep := range mod.Deps {
-		if d, ok := byPath[dep.Path]; ok {
-			visit(d)
-		}
-	}
-
-	return order
-}
-
+// Up to Options.Jobs modules are built at the same time; a module starts as
+// soon as all of its dependencies have been built. The first failure
+// cancels the context passed to the remaining builds, no further module is
+// started, and the failure is returned.
 func (b *Builder) Build(ctx context.Context, targets []*modules.Module) ([]Result, error) {
-	builtResults := make(map[module.Version]classfile.BuildResult)
-
 	// Identify the root target. By MVS convention (see constructBuildList
 	// and modules.Load), targets[0] is the main module requested by the
 	// caller; runTest semantics (fresh build + OnTest invocation) only
@@ -205,8 +174,18 @@ func (b *Builder) Build(ctx context.Context, targets []*modules.Module) ([]Resul
 		rootID = module.Version{Path: targets[0].Path, Version: targets[0].Version}
 	}
 
-	build := func(mod *modules.Module) (Result, error) {
-		isRoot := mod.Path == rootID.Path && mod.Version == rootID.Version
+	nodes, err := b.plan(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	build := func(ctx context.Context, node *buildNode, depResults map[*buildNode]Result) (Result, error) {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		mod := node.Module
+		modID := module.Version{Path: mod.Path, Version: mod.Version}
+		isRoot := modID == rootID
 		testThisMod := b.runTest && isRoot && mod.OnTest != nil
 
 		unlock, err := b.store.LockModule(mod.Path)
@@ -215,85 +194,226 @@ func (b *Builder) Build(ctx context.Context, targets []*modules.Module) ([]Resul
 		}
 		defer unlock()
 
-		// Consult the build cache. A hit means we already have the
-		// module's build metadata and its installDir is populated from a
-		// previous successful build.
+		// Collect the build inputs: the formula, its patches, the matrix
+		// and the keys of all transitive dependencies (built before mod).
+		// A source archive is identified by its checksum, a local source
+		// directory by the hash of its files, a version pinned by a lock
+		// file by its locked commit and any other version by the commit
+		// it resolves to now.
+		formulaHash, err := hashFile(mod.FS, mod.File)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to hash formula of %s@%s: %w", mod.Path, mod.Version, err)
+		}
+		inputs := &buildInputs{Formula: formulaHash, Matrix: node.matrix}
+		patches, err := readPatches(mod)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to read patches of %s@%s: %w", mod.Path, mod.Version, err)
+		}
+		for _, p := range patches {
+			inputs.Patches = append(inputs.Patches, p.name+":"+hashBytes(p.data))
+		}
+		// A replacement takes the place of the source archive too.
+		var archiveURL, sourceDir string
+		ref := mod.Version
+		if mod.Replace != nil {
+			inputs.Replace = mod.Replace.String()
+			sourceDir = mod.Replace.Dir()
+			ref = mod.Replace.New.Version
+		} else {
+			archiveURL = mod.ArchiveURL(mod.Version)
+		}
+		switch {
+		case archiveURL != "":
+			inputs.Source = "sha256:" + strings.ToLower(mod.SourceSHA256)
+		case sourceDir != "":
+			sum, err := hashDir(sourceDir)
+			if err != nil {
+				return Result{}, fmt.Errorf("failed to hash source of %s@%s: %w", mod.Path, mod.Version, err)
+			}
+			inputs.Source = "dir:" + sum
+		case mod.SourceCommit != "":
+			inputs.Source = mod.SourceCommit
+		}
+		var repo vcs.Repo
+		var resolveErr error
+		if archiveURL == "" && sourceDir == "" {
+			if repo, err = b.newRepo(mod.Source); err != nil {
+				return Result{}, err
+			}
+			if mod.SourceCommit == "" {
+				// A cheap ls-remote, so that a tag moved upstream
+				// triggers a rebuild.
+				inputs.Source, resolveErr = repo.Resolve(ctx, ref)
+			}
+		}
+		transitiveDeps := node.transitiveDeps()
+		for _, dep := range transitiveDeps {
+			if inputs.Deps == nil {
+				inputs.Deps = make(map[string]string, len(transitiveDeps))
+			}
+			inputs.Deps[dep.Path+"@"+dep.Version] = depResults[dep].Key
+		}
+		for _, tool := range node.tools {
+			if inputs.Tools == nil {
+				inputs.Tools = make(map[string]string, len(node.tools))
+			}
+			inputs.Tools[tool.Path+"@"+tool.Version] = depResults[tool].Key
+		}
+
+		// Consult the build cache. A hit means the entry was built from
+		// exactly these inputs and its installDir is populated from that
+		// build. If the version ref could not be resolved, for example
+		// offline, the commit recorded by the previous build is reused.
+		// An entry saved under the legacy matrix encoding only counts as
+		// the previous build.
 		cache, err := b.loadCache(mod.Path)
 		if err != nil {
 			cache = nil
 		}
-		var cachedEntry *buildEntry
+		var cachedEntry, staleEntry *buildEntry
 		if cache != nil {
-			if entry, ok := cache.get(mod.Version, b.matrix); ok {
-				cachedEntry = entry
+			entry, ok := cache.get(mod.Version, node.matrix)
+			if !ok {
+				entry, ok = b.takeLegacy(cache, mod.Path, mod.Version, node.matrix)
+			}
+			if ok {
+				if entry.Inputs != nil && inputs.Source == "" && entry.Inputs.Replace == inputs.Replace {
+					inputs.Source = entry.Inputs.Source
+				}
+				if entry.Key != "" && entry.Key == inputs.key() {
+					cachedEntry = entry
+				} else {
+					staleEntry = entry
+				}
 			}
 		}
 
 		// Fast path: cache hit and no OnTest to run. Skip source clone
-		// and OnBuild entirely.
+		// and OnBuild entirely. The CMake config is still written, for
+		// outputs cached before it was generated.
 		if cachedEntry != nil && !testThisMod {
-			dir, _ := b.installDir(mod.Path, mod.Version)
-			return Result{Metadata: cachedEntry.Metadata, OutputDir: dir}, nil
+			dir, _ := b.outputDir(mod.Path, mod.Version, node.matrix)
+			pkgConfig := cachedEntry.pkgConfig()
+			if err := writeCMakeConfig(node, dir, pkgConfig, depResults); err != nil {
+				return Result{}, fmt.Errorf("failed to write CMake config of %s@%s: %w", mod.Path, mod.Version, err)
+			}
+			return Result{Module: modID, Matrix: node.matrix, Metadata: cachedEntry.Metadata, PkgConfig: pkgConfig, Closure: closure(pkgConfig, transitiveDeps, depResults), OutputDir: dir, Key: cachedEntry.Key, Linked: node.linked}, nil
 		}
 
 		// At this point we need to run OnBuild, OnTest, or both. All of
 		// them expect a source checkout and a prepared build context, so
 		// set those up uniformly regardless of cache state.
 
-		// TODO(MeteorsLiu): Source cache dir (belongs in the vcs layer)
+		// OnBuild writes into its source dir, so every build gets a private
+		// copy. The repo fills it from the shared source cache, which
+		// fetches each module@version from the network only once; a
+		// source archive is likewise downloaded once and unpacked here.
 		tmpSourceDir, err := os.MkdirTemp("", fmt.Sprintf("source-%s-%s*", strings.ReplaceAll(mod.Path, "/", "-"), mod.Version))
 		if err != nil {
 			return Result{}, err
 		}
 		defer os.RemoveAll(tmpSourceDir)
 
-		// Before we start to build, clone source to tmpSourceDir
-		// And switch current dir to it.
-		// TODO(MeteorsLiu): Support different code host
-		repo, err := b.newRepo(fmt.Sprintf("github.com/%s", mod.Path))
-		if err != nil {
-			return Result{}, err
+		// Before we start to build, check out source to tmpSourceDir.
+		switch {
+		case archiveURL != "":
+			if err := b.archives.Extract(ctx, archiveURL, mod.SourceSHA256, tmpSourceDir); err != nil {
+				return Result{}, fmt.Errorf("failed to fetch source of %s@%s: %w", mod.Path, mod.Version, err)
+			}
+		case sourceDir != "":
+			if err := os.CopyFS(tmpSourceDir, os.DirFS(sourceDir)); err != nil {
+				return Result{}, fmt.Errorf("failed to copy source of %s@%s: %w", mod.Path, mod.Version, err)
+			}
+		default:
+			// Check out the commit the cache key records, pinned by a
+			// lock file or just resolved, so that a tag moved upstream
+			// builds the sources it now points to rather than those of a
+			// previous fetch. Offline, a cached build is tested against
+			// the sources the tag had.
+			switch {
+			case mod.SourceCommit != "":
+				ref = mod.SourceCommit
+			case resolveErr == nil:
+				ref = inputs.Source
+			case cachedEntry == nil:
+				return Result{}, fmt.Errorf("failed to resolve %s@%s: %w", mod.Path, mod.Version, resolveErr)
+			}
+			if err := repo.Sync(ctx, ref, "", tmpSourceDir); err != nil {
+				return Result{}, err
+			}
 		}
-		if err := repo.Sync(ctx, mod.Version, "", tmpSourceDir); err != nil {
-			return Result{}, err
+		for _, p := range patches {
+			if err := patch.Apply(tmpSourceDir, p.name, p.data); err != nil {
+				return Result{}, fmt.Errorf("failed to patch %s@%s: %w", mod.Path, mod.Version, err)
+			}
 		}
 
-		installDir, err := b.installDir(mod.Path, mod.Version)
+		installDir, err := b.outputDir(mod.Path, mod.Version, node.matrix)
 		if err != nil {
 			return Result{}, err
 		}
+		if cachedEntry == nil {
+			// Start from an empty installDir so that files left by a
+			// stale or failed build cannot leak into this one.
+			if err := os.RemoveAll(installDir); err != nil {
+				return Result{}, err
+			}
+		}
 		if err := os.MkdirAll(installDir, 0o755); err != nil {
 			return Result{}, err
 		}
 
-		getOutputDir := func(_ string, m module.Version) (string, error) {
-			return b.installDir(m.Path, m.Version)
+		// A dependency is built for the matrix planned for it, which may
+		// differ from the one of mod.
+		getOutputDir := func(matrixStr string, m module.Version) (string, error) {
+			for _, dep := range slices.Concat(transitiveDeps, node.tools) {
+				if dep.Path == m.Path && dep.Version == m.Version {
+					matrixStr = dep.matrix
+					break
+				}
+			}
+			return b.outputDir(m.Path, m.Version, matrixStr)
 		}
-		buildContext := classfile.NewContext(tmpSourceDir, installDir, b.matrix, getOutputDir)
+		buildContext := classfile.NewContext(tmpSourceDir, installDir, node.matrix, getOutputDir)
 
-		// Inject results of already-built dependencies
-		for modVer, result := range builtResults {
-			buildContext.AddBuildResult(modVer, result)
+		// Inject results of the dependencies, all built before mod
+		for _, dep := range transitiveDeps {
+			var br classfile.BuildResult
+			result := depResults[dep]
+			br.SetMetadata(result.Metadata)
+			if !result.PkgConfig.IsEmpty() {
+				br.SetPkgConfig(result.PkgConfig)
+			}
+			buildContext.AddBuildResult(module.Version{Path: dep.Path, Version: dep.Version}, br)
 		}
-
-		project := &classfile.Project{Deps: b.resolveModTransitiveDeps(targets, mod), SourceFS: mod.FS.(fs.ReadFileFS)}
-
-		// Ready! Go!
-		if err := os.Chdir(tmpSourceDir); err != nil {
-			return Result{}, err
+		// Tools run from the PATH, the first declared first.
+		for _, tool := range slices.Backward(node.tools) {
+			buildContext.Env().Prepend("PATH", filepath.Join(depResults[tool].OutputDir, "bin"))
 		}
 
+		project := &classfile.Project{Deps: versionsOf(transitiveDeps), Tools: versionsOf(node.tools), SourceFS: mod.FS.(fs.ReadFileFS)}
+		// Commands the formula runs with exec run in its sources and, like
+		// the cmake and autotools helpers it creates for them, see the same
+		// environment.
+		mod.SetEnv(buildContext.Env())
+		mod.SetDir(tmpSourceDir)
+		defer classfile.BindEnv(tmpSourceDir, buildContext.Env())()
+
 		// Run OnBuild only on cache miss; reuse cached metadata otherwise.
 		var metadata string
+		var pkgConfig classfile.PkgConfig
 		if cachedEntry != nil {
-			metadata = cachedEntry.Metadata
+			metadata, pkgConfig = cachedEntry.Metadata, cachedEntry.pkgConfig()
 		} else {
 			var out classfile.BuildResult
 			mod.OnBuild(buildContext, project, &out)
 			if len(out.Errs()) > 0 {
 				return Result{}, errors.Join(out.Errs()...)
 			}
-			metadata = out.Metadata()
+			metadata, pkgConfig = out.Metadata(), out.PkgConfig()
+		}
+		if err := writeCMakeConfig(node, installDir, pkgConfig, depResults); err != nil {
+			return Result{}, fmt.Errorf("failed to write CMake config of %s@%s: %w", mod.Path, mod.Version, err)
 		}
 
 		// Run OnTest (root only) against the just-built or cached
@@ -309,52 +429,203 @@ func (b *Builder) Build(ctx context.Context, targets []*modules.Module) ([]Resul
 
 		// Save cache only on cache miss. A cache hit means the entry is
 		// already present and current; OnTest does not modify metadata.
+		key := inputs.key()
 		if cachedEntry == nil {
 			if cache == nil {
 				cache = &buildCache{}
 			}
-			cache.set(mod.Version, b.matrix, &buildEntry{
+			entry := &buildEntry{
 				Metadata:  metadata,
 				BuildTime: time.Now(),
-			})
+				Key:       key,
+				Inputs:    inputs,
+			}
+			if !pkgConfig.IsEmpty() {
+				entry.PkgConfig = &pkgConfig
+			}
+			if staleEntry != nil {
+				entry.Rebuild = inputs.changes(staleEntry.Inputs)
+			}
+			cache.set(mod.Version, node.matrix, entry)
 			if err := b.saveCache(mod.Path, cache); err != nil {
 				return Result{}, err
 			}
 		}
 
-		return Result{Metadata: metadata, OutputDir: installDir}, nil
+		return Result{Module: modID, Matrix: node.matrix, Metadata: metadata, PkgConfig: pkgConfig, Closure: closure(pkgConfig, transitiveDeps, depResults), OutputDir: installDir, Key: key, Linked: node.linked}, nil
 	}
 
-	var results []Result
+	return b.schedule(ctx, nodes, build)
+}
 
-	// Save current environment and restore it after OnBuild,
-	// that's because OnBuild may break environment
-	// TODO(MeteorsLiu): Switch to sandbox to run OnBuild
-	savedEnv := os.Environ()
-	defer func() {
-		os.Clearenv()
-		for _, env := range savedEnv {
-			k, v, _ := strings.Cut(env, "=")
-			os.Setenv(k, v)
+// writeCMakeConfig writes the CMake package configuration of node into
+// installDir, unless its build installed one or it has no metadata. The
+// imported target links to the targets of the dependencies configured so
+// too, and carries the flags of the other dependencies.
+//
+// Package names are not unique (see cmakeconfig.Name). No configuration is
+// written for a module sharing its name with one of its transitive
+// dependencies, and a dependency that shares its name, or whose own
+// dependencies share theirs, with another module of the closure is
+// carried as flags rather than found by name.
+func writeCMakeConfig(node *buildNode, installDir string, pc classfile.PkgConfig, depResults map[*buildNode]Result) error {
+	name := cmakeconfig.Name(node.Path)
+	paths := make(map[string]map[string]bool) // package name -> module paths
+	for _, d := range append(node.transitiveDeps(), node) {
+		n := cmakeconfig.Name(d.Path)
+		if paths[n] == nil {
+			paths[n] = make(map[string]bool)
+		}
+		paths[n][d.Path] = true
+	}
+	unique := func(n *buildNode) bool {
+		for _, d := range append(n.transitiveDeps(), n) {
+			if len(paths[cmakeconfig.Name(d.Path)]) > 1 {
+				return false
+			}
+		}
+		return true
+	}
+	if len(paths[name]) > 1 {
+		return nil
+	}
+	if has, err := cmakeconfig.Has(installDir, name); err != nil || (has && !cmakeconfig.Generated(installDir, name)) {
+		return err
+	}
+	c := cmakeconfig.Config{Name: name, Module: module.Version{Path: node.Path, Version: node.Version}, Dir: installDir}
+	pcs := []classfile.PkgConfig{pc}
+	for _, dep := range node.deps {
+		result := depResults[dep]
+		if depName := cmakeconfig.Name(dep.Path); unique(dep) && cmakeconfig.Generated(result.OutputDir, depName) {
+			c.Deps = append(c.Deps, cmakeconfig.Dep{Name: depName, Dir: result.OutputDir})
+		} else {
+			pcs = append(pcs, result.Closure)
 		}
-	}()
+	}
+	c.PkgConfig = classfile.MergePkgConfig(pcs...)
+	if c.PkgConfig.IsEmpty() && len(c.Deps) == 0 {
+		return nil
+	}
+	return cmakeconfig.Write(c)
+}
+
+// closure merges the metadata pc of a module with the metadata of its
+// transitive dependencies deps, in build order, for Result.Closure.
+func closure(pc classfile.PkgConfig, deps []*buildNode, depResults map[*buildNode]Result) classfile.PkgConfig {
+	pcs := []classfile.PkgConfig{pc}
+	// Reversed, the build order has every module before its dependencies.
+	for _, dep := range slices.Backward(deps) {
+		pcs = append(pcs, depResults[dep].PkgConfig)
+	}
+	return classfile.MergePkgConfig(pcs...)
+}
+
+// sourcePatch is a patch file declared by a formula.
+type sourcePatch struct {
+	name string // as declared, relative to the formula file
+	data []byte
+}
 
-	// TODO(MeteorsLiu): Parallel build
-	for _, target := range b.constructBuildList(targets) {
-		result, err := build(target)
+// readPatches reads the patches declared by the formula of mod from the
+// formula module FS, in the order they are to be applied.
+func readPatches(mod *modules.Module) ([]sourcePatch, error) {
+	patches := make([]sourcePatch, 0, len(mod.Patches))
+	for _, name := range mod.Patches {
+		data, err := fs.ReadFile(mod.FS, path.Join(path.Dir(mod.File), name))
 		if err != nil {
 			return nil, err
 		}
+		patches = append(patches, sourcePatch{name: name, data: data})
+	}
+	return patches, nil
+}
+
+// schedule runs build for every node in order (a plan result) on at most
+// b.jobs goroutines and returns the results in order.
+//
+// A node becomes ready once all of its dependencies that precede it in
+// order have been built; ready nodes are started lowest index first, so
+// with a single job the nodes are built exactly in order. Each build
+// receives the results of all nodes finished before it started.
+//
+// The first error cancels ctx for the builds still running, stops
+// scheduling new ones, and is returned once the running builds return.
+func (b *Builder) schedule(ctx context.Context, order []*buildNode, build func(context.Context, *buildNode, map[*buildNode]Result) (Result, error)) ([]Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := max(b.jobs, 1)
+	index := make(map[*buildNode]int, len(order))
+	for i, n := range order {
+		index[n] = i
+	}
+	pending := make([]int, len(order))      // unbuilt deps of order[i]
+	dependents := make([][]int, len(order)) // nodes waiting for order[i]
+	var ready []int
+	for i, n := range order {
+		for _, dep := range slices.Concat(n.deps, n.tools) {
+			if j, ok := index[dep]; ok && j < i {
+				pending[i]++
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	type outcome struct {
+		i      int
+		result Result
+		err    error
+	}
+	done := make(chan outcome)
+
+	results := make([]Result, len(order))
+	builtResults := make(map[*buildNode]Result)
+	var firstErr error
+	running := 0
+
+	for {
+		for firstErr == nil && running < jobs && len(ready) > 0 {
+			i := ready[0]
+			ready = ready[1:]
+			depResults := maps.Clone(builtResults)
+			running++
+			go func() {
+				result, err := build(ctx, order[i], depResults)
+				done <- outcome{i: i, result: result, err: err}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		o := <-done
+		running--
+		if o.err != nil {
+			if firstErr == nil {
+				firstErr = o.err
+				cancel()
+			}
+			continue
+		}
 
 		// Track result for downstream dependencies
-		modVer := module.Version{Path: target.Path, Version: target.Version}
-		br := classfile.BuildResult{}
-		if result.Metadata != "" {
-			br.SetMetadata(result.Metadata)
+		builtResults[order[o.i]] = o.result
+		results[o.i] = o.result
+
+		for _, j := range dependents[o.i] {
+			pending[j]--
+			if pending[j] == 0 {
+				k, _ := slices.BinarySearch(ready, j)
+				ready = slices.Insert(ready, k, j)
+			}
 		}
-		builtResults[modVer] = br
+	}
 
-		results = append(results, result)
+	if firstErr != nil {
+		return nil, firstErr
 	}
 	return results, nil
 }
@@ -1,18 +1,31 @@
 package build
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	classfile "github.com/goplus/llar/formula"
+	"github.com/goplus/llar/internal/archive"
+	"github.com/goplus/llar/internal/cmakeconfig"
 	"github.com/goplus/llar/internal/formula/repo"
 	"github.com/goplus/llar/internal/modules"
 	"github.com/goplus/llar/internal/vcs"
@@ -102,7 +115,24 @@ func TestConstructBuildList(t *testing.T) {
 	})
 }
 
-func TestResolveModTransitiveDeps(t *testing.T) {
+// transitiveDepsOf plans targets and returns the transitive deps of the
+// node of m.
+func transitiveDepsOf(t *testing.T, b *Builder, targets []*modules.Module, m *modules.Module) []module.Version {
+	t.Helper()
+	nodes, err := b.plan(targets)
+	if err != nil {
+		t.Fatalf("plan() error = %v", err)
+	}
+	for _, n := range nodes {
+		if n.Module == m {
+			return versionsOf(n.transitiveDeps())
+		}
+	}
+	t.Fatalf("%s@%s not planned", m.Path, m.Version)
+	return nil
+}
+
+func TestTransitiveDeps(t *testing.T) {
 	b := &Builder{}
 
 	t.Run("case1: simple", func(t *testing.T) {
@@ -113,7 +143,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D)
 		targets := []*modules.Module{A, B, C, D}
 
-		got := b.resolveModTransitiveDeps(targets, C)
+		got := transitiveDepsOf(t, b, targets, C)
 		if want := "D@1.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
 		}
@@ -127,7 +157,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D)
 		targets := []*modules.Module{A, B, C, D}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		if want := "C@2.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
 		}
@@ -142,7 +172,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D, E)
 		targets := []*modules.Module{A, B, C, D, E}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		if want := "E@1.0.0 C@2.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
 		}
@@ -156,7 +186,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D)
 		targets := []*modules.Module{A, B, C, D}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		if want := "C@1.1.0 D@1.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
 		}
@@ -170,7 +200,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D)
 		targets := []*modules.Module{A, B, C, D}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		// D before C because C depends on D
 		if want := "D@1.2.0 C@1.1.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
@@ -182,7 +212,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", D)
 		targets := []*modules.Module{A, D}
 
-		got := b.resolveModTransitiveDeps(targets, D)
+		got := transitiveDepsOf(t, b, targets, D)
 		if len(got) != 0 {
 			t.Errorf("got %q, want empty", versions(got))
 		}
@@ -197,7 +227,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D, E)
 		targets := []*modules.Module{A, B, C, D, E}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		if want := "E@1.0.0 D@1.0.0 C@1.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
 		}
@@ -212,7 +242,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		targets := []*modules.Module{A, B, C, D}
 
 		// resolve for A: B and C both need D
-		got := b.resolveModTransitiveDeps(targets, A)
+		got := transitiveDepsOf(t, b, targets, A)
 		// D first (shared leaf), then B, then C
 		if want := "D@2.0.0 B@1.0.0 C@1.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
@@ -230,7 +260,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D, F, G)
 		targets := []*modules.Module{A, B, C, D, F, G}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		// F and G are leaves, then C and D (both depend on F,G), order follows DFS
 		if want := "F@1.0.0 G@1.0.0 C@1.0.0 D@1.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
@@ -247,7 +277,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D)
 		targets := []*modules.Module{A, B, C, D}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		// B is excluded (mod itself), D -> B is a back-edge (B already visited)
 		// so: visit(C) -> visit(D) -> visit(B) no-op -> append D -> append C
 		if want := "D@1.0.0 C@1.0.0"; versions(got) != want {
@@ -264,7 +294,7 @@ func TestResolveModTransitiveDeps(t *testing.T) {
 		A := mod("A", "1.0.0", B, C, D, E)
 		targets := []*modules.Module{A, B, C, D, E}
 
-		got := b.resolveModTransitiveDeps(targets, B)
+		got := transitiveDepsOf(t, b, targets, B)
 		if want := "C@1.0.0 D@1.0.0 E@1.0.0"; versions(got) != want {
 			t.Errorf("got %q, want %q", versions(got), want)
 		}
@@ -290,12 +320,20 @@ func init() {
 // setupTestStore copies testdata/formulas to a temp dir and returns a Store.
 // The mock VCS Sync is a no-op since data is already in place.
 func setupTestStore(t *testing.T) repo.Store {
+	t.Helper()
+	store, _ := setupTestStoreDir(t)
+	return store
+}
+
+// setupTestStoreDir is setupTestStore but also returns the store directory,
+// for tests that edit formulas between builds.
+func setupTestStoreDir(t *testing.T) (repo.Store, string) {
 	t.Helper()
 	storeDir := t.TempDir()
 	if err := os.CopyFS(storeDir, os.DirFS(testFormulaDir)); err != nil {
 		t.Fatalf("failed to copy testdata: %v", err)
 	}
-	return repo.New(storeDir, newMockRepo(storeDir))
+	return repo.New(storeDir, newMockRepo(storeDir)), storeDir
 }
 
 // setupBuilder creates a Builder wired with a test Store and mock source repos.
@@ -309,6 +347,7 @@ func setupBuilder(t *testing.T, store repo.Store, matrix string) *Builder {
 			modPath := strings.TrimPrefix(repoPath, "github.com/")
 			return newMockRepo(filepath.Join(testSourceDir, modPath)), nil
 		},
+		archives: archive.NewCache(t.TempDir()),
 	}
 }
 
@@ -327,6 +366,31 @@ func loadAndBuild(t *testing.T, b *Builder, store repo.Store, main module.Versio
 	return results, mods
 }
 
+// seedCache builds main once so that its cache entry carries the real build
+// key, then replaces the cached metadata with metadata, the structured form
+// included. A later build that returns metadata proves the entry was reused
+// rather than rebuilt.
+func seedCache(t *testing.T, b *Builder, store repo.Store, main module.Version, metadata string) {
+	t.Helper()
+	runTest := b.runTest
+	b.runTest = false
+	loadAndBuild(t, b, store, main)
+	b.runTest = runTest
+
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatalf("loadCache() failed: %v", err)
+	}
+	entry, ok := cache.get(main.Version, b.matrix)
+	if !ok {
+		t.Fatalf("no cache entry for %s@%s", main.Path, main.Version)
+	}
+	entry.Metadata, entry.PkgConfig = metadata, nil
+	if err := b.saveCache(main.Path, cache); err != nil {
+		t.Fatalf("saveCache() failed: %v", err)
+	}
+}
+
 // findResult returns the Result for a given module path.
 // Results are in constructBuildList order, so we match via build order.
 func findResult(results []Result, b *Builder, mods []*modules.Module, path string) (Result, bool) {
@@ -463,16 +527,9 @@ func TestBuild_PrePopulatedCache(t *testing.T) {
 	b := setupBuilder(t, store, "amd64-linux")
 
 	// Pre-populate cache with a different metadata value
-	cache := &buildCache{}
-	cache.set("1.0.0", "amd64-linux", &buildEntry{
-		Metadata:  "-lPRECACHED",
-		BuildTime: time.Now(),
-	})
-	if err := b.saveCache("test/liba", cache); err != nil {
-		t.Fatalf("saveCache() failed: %v", err)
-	}
-
 	main := module.Version{Path: "test/liba", Version: "1.0.0"}
+	seedCache(t, b, store, main, "-lPRECACHED")
+
 	results, _ := loadAndBuild(t, b, store, main)
 
 	// Should return pre-cached metadata, not the formula-defined "-lA"
@@ -516,6 +573,399 @@ func TestBuild_CacheWrittenCorrectly(t *testing.T) {
 	}
 }
 
+func TestBuild_CacheRecordsInputs(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	main := module.Version{Path: "test/depresult", Version: "1.0.0"}
+	results, mods := loadAndBuild(t, b, store, main)
+	liba, _ := findResult(results, b, mods, "test/liba")
+
+	cache, err := b.loadCache("test/depresult")
+	if err != nil {
+		t.Fatalf("loadCache() failed: %v", err)
+	}
+	entry, ok := cache.get("1.0.0", "amd64-linux")
+	if !ok {
+		t.Fatal("cache entry not found for test/depresult")
+	}
+	in := entry.Inputs
+	if in == nil {
+		t.Fatal("cache entry does not record its inputs")
+	}
+	if in.Formula == "" || in.Matrix != "amd64-linux" || in.Source != "commit-1.0.0" {
+		t.Errorf("inputs = %+v, want formula hash, matrix amd64-linux, source commit-1.0.0", in)
+	}
+	if got := in.Deps["test/liba@1.0.0"]; got == "" || got != liba.Key {
+		t.Errorf("inputs dep key = %q, want liba key %q", got, liba.Key)
+	}
+	if entry.Key != in.key() {
+		t.Errorf("entry key = %q, want hash of inputs %q", entry.Key, in.key())
+	}
+}
+
+func TestBuild_PkgConfig(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+	main := module.Version{Path: "test/pcfile", Version: "1.0.0"}
+
+	results, _ := loadAndBuild(t, b, store, main)
+	installDir := results[0].OutputDir
+	want := classfile.PkgConfig{
+		Cflags:     []string{"-I" + installDir + "/include"},
+		Defines:    []string{"PCFILE_API"},
+		Libs:       []string{"-L" + installDir + "/lib", "-lpcfile"},
+		StaticLibs: []string{"-lm"},
+		Requires:   []string{"zlib >= 1.2"},
+	}
+	if got := results[0].PkgConfig; !reflect.DeepEqual(got, want) {
+		t.Errorf("PkgConfig = %+v, want %+v", got, want)
+	}
+	if got, want := results[0].Metadata, want.String(); got != want {
+		t.Errorf("Metadata = %q, want %q", got, want)
+	}
+
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatalf("loadCache() failed: %v", err)
+	}
+	entry, ok := cache.get(main.Version, "amd64-linux")
+	if !ok || entry.PkgConfig == nil || !reflect.DeepEqual(*entry.PkgConfig, want) {
+		t.Fatalf("cache entry = %+v, want PkgConfig %+v", entry, want)
+	}
+
+	// A cache hit returns the structured metadata saved.
+	results, _ = loadAndBuild(t, b, store, main)
+	if got := results[0].PkgConfig; !reflect.DeepEqual(got, want) {
+		t.Errorf("cached PkgConfig = %+v, want %+v", got, want)
+	}
+
+	// Entries saved without it fall back to parsing the metadata.
+	seedCache(t, b, store, main, "-I/old/include -lold")
+	results, _ = loadAndBuild(t, b, store, main)
+	want = classfile.PkgConfig{Cflags: []string{"-I/old/include"}, Libs: []string{"-lold"}}
+	if got := results[0].PkgConfig; !reflect.DeepEqual(got, want) {
+		t.Errorf("PkgConfig of an old entry = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuild_Closure(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	for _, tt := range []struct {
+		main, want string
+	}{
+		{"test/diamond", "-lDiamond -lB -lA"},
+		{"test/libc", "-lC -lB -lA"},
+		{"test/liba", "-lA"},
+	} {
+		// The second build is served from the cache.
+		for range 2 {
+			results, mods := loadAndBuild(t, b, store, module.Version{Path: tt.main, Version: "1.0.0"})
+			r, _ := findResult(results, b, mods, tt.main)
+			if got := r.Closure.LibsString(true); got != tt.want {
+				t.Errorf("%s closure = %q, want %q", tt.main, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestBuild_CMakeConfig(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+	results, mods := loadAndBuild(t, b, store, module.Version{Path: "test/depresult", Version: "1.0.0"})
+	liba, _ := findResult(results, b, mods, "test/liba")
+	depresult, _ := findResult(results, b, mods, "test/depresult")
+
+	for _, r := range []Result{liba, depresult} {
+		if !cmakeconfig.Generated(r.OutputDir, cmakeconfig.Name(r.Module.Path)) {
+			t.Fatalf("no CMake config generated for %s", r.Module.Path)
+		}
+	}
+	targets, err := os.ReadFile(filepath.Join(depresult.OutputDir, "lib", "cmake", "depresult", "depresultTargets.cmake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `INTERFACE_LINK_LIBRARIES "A;DR;liba::liba"`; !strings.Contains(string(targets), want) {
+		t.Errorf("depresultTargets.cmake lacks %q:\n%s", want, targets)
+	}
+	config, err := os.ReadFile(filepath.Join(depresult.OutputDir, "lib", "cmake", "depresult", "depresultConfig.cmake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "find_dependency(liba CONFIG"; !strings.Contains(string(config), want) {
+		t.Errorf("depresultConfig.cmake lacks %q:\n%s", want, config)
+	}
+}
+
+// TestBuild_CMakeConfigCacheHit verifies that a cached build gets the
+// CMake config it was built without, referring to its dependencies
+// relative to its own prefix.
+func TestBuild_CMakeConfigCacheHit(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+	main := module.Version{Path: "test/depresult", Version: "1.0.0"}
+	results, mods := loadAndBuild(t, b, store, main)
+	for _, r := range results {
+		if err := os.RemoveAll(filepath.Join(r.OutputDir, "lib", "cmake")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, mods = loadAndBuild(t, b, store, main)
+	liba, _ := findResult(results, b, mods, "test/liba")
+	depresult, _ := findResult(results, b, mods, "test/depresult")
+	for _, r := range []Result{liba, depresult} {
+		if !cmakeconfig.Generated(r.OutputDir, cmakeconfig.Name(r.Module.Path)) {
+			t.Fatalf("no CMake config generated for the cached build of %s", r.Module.Path)
+		}
+	}
+	config, err := os.ReadFile(filepath.Join(depresult.OutputDir, "lib", "cmake", "depresult", "depresultConfig.cmake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `find_dependency(liba CONFIG HINTS "${_IMPORT_PREFIX}" "${_IMPORT_PREFIX}/../` + filepath.Base(liba.OutputDir) + `")`
+	if !strings.Contains(string(config), want) {
+		t.Errorf("depresultConfig.cmake lacks %q:\n%s", want, config)
+	}
+	if strings.Contains(string(config), liba.OutputDir) {
+		t.Errorf("depresultConfig.cmake refers to the absolute output dir of liba:\n%s", config)
+	}
+}
+
+// TestWriteCMakeConfig_NameCollision verifies that modules sharing a
+// package name are never found by it.
+func TestWriteCMakeConfig_NameCollision(t *testing.T) {
+	node := func(path string, deps ...*buildNode) *buildNode {
+		return &buildNode{Module: &modules.Module{Path: path, Version: "1.0.0"}, deps: deps}
+	}
+	azlib, bzlib := node("a/zlib"), node("b/zlib")
+	png := node("x/png", azlib, bzlib)
+	self := node("c/zlib", azlib)
+
+	depResults := make(map[*buildNode]Result)
+	for _, n := range []*buildNode{azlib, bzlib} {
+		dir := t.TempDir()
+		pc := classfile.PkgConfig{Libs: []string{"-l" + strings.TrimSuffix(n.Path, "/zlib")}}
+		if err := writeCMakeConfig(n, dir, pc, depResults); err != nil {
+			t.Fatal(err)
+		}
+		if !cmakeconfig.Generated(dir, "zlib") {
+			t.Fatalf("no CMake config generated for %s", n.Path)
+		}
+		depResults[n] = Result{OutputDir: dir, Closure: pc}
+	}
+
+	pngDir := t.TempDir()
+	if err := writeCMakeConfig(png, pngDir, classfile.PkgConfig{Libs: []string{"-lpng"}}, depResults); err != nil {
+		t.Fatal(err)
+	}
+	config, err := os.ReadFile(filepath.Join(cmakeconfig.Dir(pngDir, "png"), "pngConfig.cmake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "find_dependency") {
+		t.Errorf("pngConfig.cmake finds a zlib by name:\n%s", config)
+	}
+	targets, err := os.ReadFile(filepath.Join(cmakeconfig.Dir(pngDir, "png"), "pngTargets.cmake"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `INTERFACE_LINK_LIBRARIES "png;a;b"`; !strings.Contains(string(targets), want) {
+		t.Errorf("pngTargets.cmake lacks %q:\n%s", want, targets)
+	}
+
+	selfDir := t.TempDir()
+	if err := writeCMakeConfig(self, selfDir, classfile.PkgConfig{Libs: []string{"-lc"}}, depResults); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := cmakeconfig.Has(selfDir, "zlib"); has {
+		t.Error("CMake config generated for c/zlib, named like its dependency a/zlib")
+	}
+}
+
+// syncRecorder is a mockRepo that records the refs it checks out.
+type syncRecorder struct {
+	*mockRepo
+	refs *[]string
+}
+
+func (r syncRecorder) Resolve(ctx context.Context, ref string) (string, error) {
+	*r.refs = append(*r.refs, "resolve "+ref)
+	return r.mockRepo.Resolve(ctx, ref)
+}
+
+func (r syncRecorder) Sync(ctx context.Context, ref, path, destDir string) error {
+	*r.refs = append(*r.refs, "sync "+ref)
+	return r.mockRepo.Sync(ctx, ref, path, destDir)
+}
+
+func TestBuild_LockedSourceCommit(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+	var refs []string
+	b.newRepo = func(repoPath string) (vcs.Repo, error) {
+		modPath := strings.TrimPrefix(repoPath, "github.com/")
+		return syncRecorder{newMockRepo(filepath.Join(testSourceDir, modPath)), &refs}, nil
+	}
+
+	ctx := context.Background()
+	main := module.Version{Path: "test/liba", Version: "1.0.0"}
+	mods, err := modules.Load(ctx, main, modules.Options{FormulaStore: store})
+	if err != nil {
+		t.Fatalf("modules.Load failed: %v", err)
+	}
+	mods[0].SourceCommit = "locked-commit"
+	if _, err := b.Build(ctx, mods); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	// The locked commit is checked out as is, without resolving the tag.
+	if want := []string{"sync locked-commit"}; !slices.Equal(refs, want) {
+		t.Errorf("repo calls = %q, want %q", refs, want)
+	}
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatalf("loadCache() failed: %v", err)
+	}
+	if entry, ok := cache.get(main.Version, "amd64-linux"); !ok || entry.Inputs == nil || entry.Inputs.Source != "locked-commit" {
+		t.Errorf("cache entry = %+v, want source locked-commit", entry)
+	}
+}
+
+// movingRepo is a mockRepo whose refs all resolve to *commit, or fail if
+// it is empty. It records the refs synced in *synced.
+type movingRepo struct {
+	*mockRepo
+	commit *string
+	synced *[]string
+}
+
+func (r movingRepo) Sync(ctx context.Context, ref, path, destDir string) error {
+	*r.synced = append(*r.synced, ref)
+	return r.mockRepo.Sync(ctx, ref, path, destDir)
+}
+
+func (r movingRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	if *r.commit == "" {
+		return "", errors.New("offline")
+	}
+	return *r.commit, nil
+}
+
+func TestBuild_SourceMovedUpstream(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+	commit := "c1"
+	var synced []string
+	b.newRepo = func(repoPath string) (vcs.Repo, error) {
+		modPath := strings.TrimPrefix(repoPath, "github.com/")
+		return movingRepo{newMockRepo(filepath.Join(testSourceDir, modPath)), &commit, &synced}, nil
+	}
+	main := module.Version{Path: "test/liba", Version: "1.0.0"}
+	entryOf := func() *buildEntry {
+		t.Helper()
+		cache, err := b.loadCache(main.Path)
+		if err != nil {
+			t.Fatalf("loadCache() failed: %v", err)
+		}
+		entry, ok := cache.get(main.Version, "amd64-linux")
+		if !ok || entry.Inputs == nil {
+			t.Fatalf("cache entry = %+v, want recorded inputs", entry)
+		}
+		return entry
+	}
+
+	loadAndBuild(t, b, store, main)
+	first := entryOf()
+
+	// The tag moves upstream: the new commit triggers a rebuild.
+	commit = "c2"
+	loadAndBuild(t, b, store, main)
+	moved := entryOf()
+	if moved.Inputs.Source != "c2" || moved.Key == first.Key || !slices.Equal(moved.Rebuild, []string{"source"}) {
+		t.Errorf("cache entry = %+v, want source c2 rebuilt for source", moved)
+	}
+	// Each build checks out the commit its key records, not the tag.
+	if !slices.Equal(synced, []string{"c1", "c2"}) {
+		t.Errorf("synced refs = %v, want [c1 c2]", synced)
+	}
+
+	// Offline, the commit of the previous build is reused.
+	commit = ""
+	loadAndBuild(t, b, store, main)
+	if offline := entryOf(); offline.Key != moved.Key || !offline.BuildTime.Equal(moved.BuildTime) {
+		t.Errorf("cache entry = %+v, want the c2 build reused", offline)
+	}
+}
+
+func TestBuild_CacheInvalidatedByFormulaChange(t *testing.T) {
+	store, storeDir := setupTestStoreDir(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	main := module.Version{Path: "test/depresult", Version: "1.0.0"}
+	seedCache(t, b, store, module.Version{Path: "test/liba", Version: "1.0.0"}, "-lSTALE")
+	first, mods := loadAndBuild(t, b, store, main)
+	if r, _ := findResult(first, b, mods, "test/depresult"); r.Metadata != "-lSTALE -lDR" {
+		t.Fatalf("depresult metadata = %q, want %q", r.Metadata, "-lSTALE -lDR")
+	}
+
+	// Editing liba's formula must rebuild liba and, through its new key,
+	// everything that depends on it.
+	formulaFile := filepath.Join(storeDir, "test", "liba", "1.0.0", "Liba_llar.gox")
+	data, err := os.ReadFile(formulaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(formulaFile, append(data, "\n// changed\n"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, mods := loadAndBuild(t, b, store, main)
+	if r, _ := findResult(results, b, mods, "test/liba"); r.Metadata != "-lA" {
+		t.Errorf("liba metadata = %q, want rebuilt %q", r.Metadata, "-lA")
+	}
+	if r, _ := findResult(results, b, mods, "test/depresult"); r.Metadata != "-lA -lDR" {
+		t.Errorf("depresult metadata = %q, want rebuilt %q", r.Metadata, "-lA -lDR")
+	}
+
+	for path, want := range map[string]string{
+		"test/liba":      "formula",
+		"test/depresult": "dep test/liba@1.0.0",
+	} {
+		cache, err := b.loadCache(path)
+		if err != nil {
+			t.Fatalf("loadCache(%s) failed: %v", path, err)
+		}
+		entry, _ := cache.get("1.0.0", "amd64-linux")
+		if !slices.Equal(entry.Rebuild, []string{want}) {
+			t.Errorf("%s rebuild reason = %q, want [%q]", path, entry.Rebuild, want)
+		}
+	}
+}
+
+func TestBuild_CacheEntryWithoutKeyIsRebuilt(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	// Entries written before build keys existed carry no inputs.
+	cache := &buildCache{}
+	cache.set("1.0.0", "amd64-linux", &buildEntry{
+		Metadata:  "-lOLD",
+		BuildTime: time.Now(),
+	})
+	if err := b.saveCache("test/liba", cache); err != nil {
+		t.Fatalf("saveCache() failed: %v", err)
+	}
+
+	main := module.Version{Path: "test/liba", Version: "1.0.0"}
+	results, _ := loadAndBuild(t, b, store, main)
+	if results[0].Metadata != "-lA" {
+		t.Errorf("metadata = %q, want rebuilt %q", results[0].Metadata, "-lA")
+	}
+}
+
 func TestBuild_CacheAccumulatesMultipleVersions(t *testing.T) {
 	store := setupTestStore(t)
 	b := setupBuilder(t, store, "amd64-linux")
@@ -547,6 +997,265 @@ func TestBuild_CacheAccumulatesMultipleVersions(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// Source archive tests
+// ---------------------------------------------------------------------------
+
+// serveArchive serves a .tar.gz release of test/tarball@1.0.0 holding
+// VERSION, writes a formula declaring it as source into storeDir and
+// returns the number of downloads served so far.
+func serveArchive(t *testing.T, storeDir string, sum func(data []byte) string) *atomic.Int32 {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "tarball-1.0.0/VERSION", Mode: 0o644, Size: 5, Typeflag: tar.TypeReg})
+	tw.Write([]byte("1.0.0"))
+	tw.Close()
+	zw.Close()
+	data := buf.Bytes()
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/tarball-1.0.0.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := filepath.Join(storeDir, "test", "tarball")
+	if err := os.MkdirAll(filepath.Join(dir, "1.0.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	formula := fmt.Sprintf(`import "os"
+
+id "test/tarball"
+
+fromVer "1.0.0"
+
+source %q, %q
+
+onBuild (ctx, proj, out) => {
+	data, err := os.readFile(ctx.SourceDir + "/VERSION")
+	if err != nil {
+		out.addErr err
+		return
+	}
+	out.setMetadata string(data)
+}
+`, srv.URL+"/tarball-{version}.tar.gz", sum(data))
+	if err := os.WriteFile(filepath.Join(dir, "1.0.0", "Tarball_llar.gox"), []byte(formula), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "versions.json"), []byte(`{"path": "test/tarball", "deps": {}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &hits
+}
+
+func TestBuild_SourceArchive(t *testing.T) {
+	store, storeDir := setupTestStoreDir(t)
+	hits := serveArchive(t, storeDir, func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	})
+	b := setupBuilder(t, store, "amd64-linux")
+	b.newRepo = func(repoPath string) (vcs.Repo, error) {
+		t.Errorf("newRepo(%q) called for a module with a source archive", repoPath)
+		return &errorRepo{}, nil
+	}
+
+	main := module.Version{Path: "test/tarball", Version: "1.0.0"}
+	results, _ := loadAndBuild(t, b, store, main)
+	if results[0].Metadata != "1.0.0" {
+		t.Errorf("metadata = %q, want VERSION from the archive %q", results[0].Metadata, "1.0.0")
+	}
+
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := cache.get("1.0.0", "amd64-linux")
+	if !strings.HasPrefix(entry.Inputs.Source, "sha256:") {
+		t.Errorf("inputs source = %q, want the archive checksum", entry.Inputs.Source)
+	}
+
+	// A rebuild after a cache wipe unpacks the cached archive again
+	// without downloading it.
+	if err := os.RemoveAll(b.workspaceDir); err != nil {
+		t.Fatal(err)
+	}
+	results, _ = loadAndBuild(t, b, store, main)
+	if results[0].Metadata != "1.0.0" {
+		t.Errorf("rebuilt metadata = %q, want %q", results[0].Metadata, "1.0.0")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("archive downloaded %d times, want 1", n)
+	}
+}
+
+func TestBuild_SourceArchiveChecksumMismatch(t *testing.T) {
+	store, storeDir := setupTestStoreDir(t)
+	serveArchive(t, storeDir, func([]byte) string { return strings.Repeat("0", 64) })
+	b := setupBuilder(t, store, "amd64-linux")
+
+	main := module.Version{Path: "test/tarball", Version: "1.0.0"}
+	mods, err := modules.Load(context.Background(), main, modules.Options{FormulaStore: store})
+	if err != nil {
+		t.Fatalf("modules.Load failed: %v", err)
+	}
+	_, err = b.Build(context.Background(), mods)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Build() error = %v, want checksum mismatch", err)
+	}
+	if cache, err := b.loadCache(main.Path); err == nil {
+		if _, ok := cache.get("1.0.0", "amd64-linux"); ok {
+			t.Error("failed build was cached")
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Patch tests
+// ---------------------------------------------------------------------------
+
+func TestBuild_Patches(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	main := module.Version{Path: "test/patched", Version: "1.0.0"}
+	results, _ := loadAndBuild(t, b, store, main)
+	if results[0].Metadata != "Hello,\nworld\n" {
+		t.Errorf("metadata = %q, want the patched greeting", results[0].Metadata)
+	}
+
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := cache.get("1.0.0", "amd64-linux")
+	if len(entry.Inputs.Patches) != 1 || !strings.HasPrefix(entry.Inputs.Patches[0], "fix-greeting.patch:") {
+		t.Errorf("inputs patches = %q, want [fix-greeting.patch:<sha256>]", entry.Inputs.Patches)
+	}
+}
+
+func TestBuild_ReplaceDir(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+	b.newRepo = func(repoPath string) (vcs.Repo, error) {
+		t.Errorf("newRepo(%q) called for a module replaced by a directory", repoPath)
+		return nil, errors.New("unexpected")
+	}
+
+	sourceDir := t.TempDir()
+	greeting := filepath.Join(sourceDir, "greeting.txt")
+	if err := os.WriteFile(greeting, []byte("Bonjour\nHello,\nwrold\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	main := module.Version{Path: "test/patched", Version: "1.0.0"}
+	opts := modules.Options{
+		FormulaStore: store,
+		Replace:      []modules.Replace{{Old: module.Version{Path: main.Path}, New: module.Version{Path: sourceDir}}},
+	}
+	build := func() Result {
+		t.Helper()
+		mods, err := modules.Load(ctx, main, opts)
+		if err != nil {
+			t.Fatalf("modules.Load failed: %v", err)
+		}
+		results, err := b.Build(ctx, mods)
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		return results[0]
+	}
+
+	// The formula's patch applies to the replacement source.
+	if got := build().Metadata; got != "Bonjour\nHello,\nworld\n" {
+		t.Errorf("metadata = %q, want the patched replacement greeting", got)
+	}
+
+	// Editing the directory invalidates the build.
+	if err := os.WriteFile(greeting, []byte("Hola\nHello,\nwrold\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := build().Metadata; got != "Hola\nHello,\nworld\n" {
+		t.Errorf("metadata = %q after editing the replacement", got)
+	}
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := cache.get("1.0.0", "amd64-linux")
+	if !strings.HasPrefix(entry.Inputs.Source, "dir:") || entry.Inputs.Replace != "test/patched@1.0.0="+sourceDir {
+		t.Errorf("inputs = %+v, want a directory source and its replacement", entry.Inputs)
+	}
+	if !slices.Equal(entry.Rebuild, []string{"source"}) {
+		t.Errorf("rebuild = %q, want [source]", entry.Rebuild)
+	}
+}
+
+func TestBuild_CacheInvalidatedByPatchChange(t *testing.T) {
+	store, storeDir := setupTestStoreDir(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	main := module.Version{Path: "test/patched", Version: "1.0.0"}
+	loadAndBuild(t, b, store, main)
+
+	patchFile := filepath.Join(storeDir, "test", "patched", "1.0.0", "fix-greeting.patch")
+	data, err := os.ReadFile(patchFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), "+world", "+World", 1))
+	if err := os.WriteFile(patchFile, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, _ := loadAndBuild(t, b, store, main)
+	if results[0].Metadata != "Hello,\nWorld\n" {
+		t.Errorf("metadata = %q, want rebuilt with the edited patch", results[0].Metadata)
+	}
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := cache.get("1.0.0", "amd64-linux")
+	if !slices.Equal(entry.Rebuild, []string{"patches"}) {
+		t.Errorf("rebuild reason = %q, want [patches]", entry.Rebuild)
+	}
+}
+
+func TestBuild_PatchFailure(t *testing.T) {
+	store, storeDir := setupTestStoreDir(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	patchFile := filepath.Join(storeDir, "test", "patched", "1.0.0", "fix-greeting.patch")
+	bad := "--- a/greeting.txt\n+++ b/greeting.txt\n@@ -1,2 +1,2 @@\n Goodbye,\n-wrold\n+world\n"
+	if err := os.WriteFile(patchFile, []byte(bad), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	main := module.Version{Path: "test/patched", Version: "1.0.0"}
+	mods, err := modules.Load(context.Background(), main, modules.Options{FormulaStore: store})
+	if err != nil {
+		t.Fatalf("modules.Load failed: %v", err)
+	}
+	_, err = b.Build(context.Background(), mods)
+	if err == nil {
+		t.Fatal("Build() error = nil, want patch failure")
+	}
+	for _, want := range []string{"test/patched@1.0.0", "fix-greeting.patch", "greeting.txt", "hunk #1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Build() error = %q, want it to mention %q", err, want)
+		}
+	}
+}
+
 // ---------------------------------------------------------------------------
 // OnTest (RunTest) behaviour tests
 // ---------------------------------------------------------------------------
@@ -628,16 +1337,9 @@ func TestBuild_RunTest_ReusesCacheWhenHit(t *testing.T) {
 	// Pre-populate cache with a sentinel metadata value. If the cache is
 	// consulted and reused (as the new behavior requires), Build() will
 	// return this value without re-running OnBuild.
-	cache := &buildCache{}
-	cache.set("1.0.0", "amd64-linux", &buildEntry{
-		Metadata:  "-lCACHED",
-		BuildTime: time.Now(),
-	})
-	if err := b.saveCache("test/liba", cache); err != nil {
-		t.Fatalf("saveCache() failed: %v", err)
-	}
-
 	main := module.Version{Path: "test/liba", Version: "1.0.0"}
+	seedCache(t, b, store, main, "-lCACHED")
+
 	ctx := context.Background()
 	mods, err := modules.Load(ctx, main, modules.Options{FormulaStore: store})
 	if err != nil {
@@ -788,14 +1490,7 @@ func TestBuild_RunTest_DepCacheStillUsed(t *testing.T) {
 
 	// Pre-populate liba's cache with a sentinel metadata; if liba is rebuilt
 	// it would produce "-lA" instead, so the sentinel is a unique cache signal.
-	cache := &buildCache{}
-	cache.set("1.0.0", "amd64-linux", &buildEntry{
-		Metadata:  "-lA-CACHED",
-		BuildTime: time.Now(),
-	})
-	if err := b.saveCache("test/liba", cache); err != nil {
-		t.Fatalf("saveCache() failed: %v", err)
-	}
+	seedCache(t, b, store, module.Version{Path: "test/liba", Version: "1.0.0"}, "-lA-CACHED")
 
 	main := module.Version{Path: "test/depresult", Version: "1.0.0"}
 	ctx := context.Background()
@@ -927,10 +1622,10 @@ func TestConstructBuildList_DepNotInTargets(t *testing.T) {
 }
 
 // ---------------------------------------------------------------------------
-// resolveModTransitiveDeps additional tests
+// transitiveDeps additional tests
 // ---------------------------------------------------------------------------
 
-func TestResolveModTransitiveDeps_ModNotInTargets(t *testing.T) {
+func TestTransitiveDeps_ModNotInTargets(t *testing.T) {
 	b := &Builder{}
 
 	// mod's deps reference a module not in targets
@@ -940,13 +1635,118 @@ func TestResolveModTransitiveDeps_ModNotInTargets(t *testing.T) {
 	A := mod("A", "1.0.0", B, C)
 	targets := []*modules.Module{A, B, C} // D is NOT in targets
 
-	got := b.resolveModTransitiveDeps(targets, B)
+	got := transitiveDepsOf(t, b, targets, B)
 	// C is reachable, D is not in targets so skipped
 	if want := "C@1.0.0"; versions(got) != want {
 		t.Errorf("got %q, want %q", versions(got), want)
 	}
 }
 
+// ---------------------------------------------------------------------------
+// schedule tests
+// ---------------------------------------------------------------------------
+
+// wideGraph returns a build order with n independent leaves and a root
+// depending on all of them.
+func wideGraph(b *Builder, n int) []*buildNode {
+	var leaves []*modules.Module
+	for i := range n {
+		leaves = append(leaves, mod(fmt.Sprintf("leaf%d", i), "1.0.0"))
+	}
+	root := mod("root", "1.0.0", leaves...)
+	nodes, _ := b.plan(append([]*modules.Module{root}, leaves...))
+	return nodes
+}
+
+func TestSchedule_SequentialFollowsBuildOrder(t *testing.T) {
+	b := &Builder{jobs: 1}
+	E := mod("E", "1.0.0")
+	C := mod("C", "2.0.0", E)
+	B := mod("B", "1.2.0", C)
+	D := mod("D", "1.0.0", C)
+	A := mod("A", "1.0.0", B, C, D, E)
+	order, err := b.plan([]*modules.Module{A, B, C, D, E})
+	if err != nil {
+		t.Fatalf("plan() error = %v", err)
+	}
+
+	var started []string
+	results, err := b.schedule(context.Background(), order, func(_ context.Context, m *buildNode, _ map[*buildNode]Result) (Result, error) {
+		started = append(started, m.Path)
+		return Result{Metadata: "-l" + m.Path}, nil
+	})
+	if err != nil {
+		t.Fatalf("schedule() error = %v", err)
+	}
+	if got, want := strings.Join(started, " "), "E C B D A"; got != want {
+		t.Errorf("start order = %q, want %q", got, want)
+	}
+	for i, m := range order {
+		if results[i].Metadata != "-l"+m.Path {
+			t.Errorf("results[%d] = %q, want %q", i, results[i].Metadata, "-l"+m.Path)
+		}
+	}
+}
+
+func TestSchedule_ParallelLeaves(t *testing.T) {
+	const leaves = 4
+	b := &Builder{jobs: leaves}
+	order := wideGraph(b, leaves)
+
+	// Every leaf blocks until all leaves are running, which only
+	// completes if they are really built at the same time.
+	var wg sync.WaitGroup
+	wg.Add(leaves)
+	results, err := b.schedule(context.Background(), order, func(_ context.Context, m *buildNode, deps map[*buildNode]Result) (Result, error) {
+		if m.Path == "root" {
+			if len(deps) != leaves {
+				return Result{}, fmt.Errorf("root started with %d dep results, want %d", len(deps), leaves)
+			}
+		} else {
+			wg.Done()
+			wg.Wait()
+		}
+		return Result{Metadata: m.Path}, nil
+	})
+	if err != nil {
+		t.Fatalf("schedule() error = %v", err)
+	}
+	for i, m := range order {
+		if results[i].Metadata != m.Path {
+			t.Errorf("results[%d] = %q, want %q (deterministic order)", i, results[i].Metadata, m.Path)
+		}
+	}
+}
+
+func TestSchedule_FailureCancelsSiblings(t *testing.T) {
+	b := &Builder{jobs: 2}
+	order := wideGraph(b, 4)
+
+	wantErr := errors.New("leaf0 failed")
+	var mu sync.Mutex
+	var started []string
+	_, err := b.schedule(context.Background(), order, func(ctx context.Context, m *buildNode, _ map[*buildNode]Result) (Result, error) {
+		mu.Lock()
+		started = append(started, m.Path)
+		mu.Unlock()
+		switch m.Path {
+		case "leaf0":
+			return Result{}, wantErr
+		case "leaf1":
+			// A running sibling observes the cancellation.
+			<-ctx.Done()
+			return Result{}, ctx.Err()
+		}
+		return Result{}, nil
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("schedule() error = %v, want %v", err, wantErr)
+	}
+	if got := strings.Join(started, " "); got != "leaf0 leaf1" && got != "leaf1 leaf0" {
+		t.Errorf("started = %q, want only leaf0 and leaf1", got)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // Mock types for error testing
 // ---------------------------------------------------------------------------
@@ -964,6 +1764,10 @@ func (e *errorRepo) Latest(ctx context.Context) (string, error) {
 	return "abc123", nil
 }
 
+func (e *errorRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	return "commit-" + ref, nil
+}
+
 func (e *errorRepo) At(ref, localDir string) fs.FS {
 	return os.DirFS(".")
 }
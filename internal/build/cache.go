@@ -1,12 +1,17 @@
 package build
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
+	classfile "github.com/goplus/llar/formula"
 	"github.com/goplus/llar/mod/module"
 )
 
@@ -14,17 +19,163 @@ import (
 //
 //	workspaceDir/
 //	  <escaped>/                      # module-level dir (cacheDir)
-//	    .cache.json                   # build cache: maps "version-matrix" → buildEntry
+//	    .cache.json                   # build cache: maps "version-matrix" → buildEntry (with its key and inputs)
 //	  <escaped>@<version>-<matrix>/   # build output dir (installDir)
 //	    include/
 //	    lib/
 //	    ...
+//
+// <matrix> is the canonical encoding of the matrix combination built (see
+// formula.Combination), e.g. "arch=amd64,os=linux|shared=off". Entries and
+// output dirs left under the legacy encoding, e.g. "amd64-linux|off", are
+// removed by the next build of the same combination (see takeLegacy).
 const cacheFile = ".cache.json"
 
 // buildEntry contains metadata about a single successful build.
+//
+// An entry is only reused while its Key matches the key computed from the
+// current inputs; otherwise the module is rebuilt and Rebuild lists the
+// inputs that changed since the previous build.
 type buildEntry struct {
-	Metadata  string    `json:"metadata"`
-	BuildTime time.Time `json:"build_time"`
+	Metadata  string               `json:"metadata"`
+	PkgConfig *classfile.PkgConfig `json:"pkgconfig,omitempty"`
+	BuildTime time.Time            `json:"build_time"`
+	Key       string               `json:"key,omitempty"`
+	Inputs    *buildInputs         `json:"inputs,omitempty"`
+	Rebuild   []string             `json:"rebuild,omitempty"`
+}
+
+// pkgConfig returns the structured metadata of the build, parsed from
+// Metadata for entries saved without it.
+func (e *buildEntry) pkgConfig() classfile.PkgConfig {
+	if e.PkgConfig != nil {
+		return *e.PkgConfig
+	}
+	return classfile.ParsePkgConfig(e.Metadata)
+}
+
+// buildInputs are everything a build's output is derived from. Their hash
+// is the content-addressed key of the build.
+type buildInputs struct {
+	Formula string `json:"formula"` // sha256 of the formula file
+	Source  string `json:"source"`  // commit the version resolved to
+	Matrix  string `json:"matrix"`
+	// Replace is the replacement of the source, if any, in the form of
+	// modules.ParseReplace. Source is then the commit of the replacement
+	// repository or, for a local directory, "dir:" and the sha256 of its
+	// files.
+	Replace string `json:"replace,omitempty"`
+	// Patches lists the patches applied to the source, in order, as
+	// "name:sha256".
+	Patches []string `json:"patches,omitempty"`
+	// Deps maps each transitive dependency ("path@version") to its key,
+	// so rebuilding a dependency invalidates every module built on it.
+	Deps map[string]string `json:"deps,omitempty"`
+	// Tools maps each tool ("path@version") to its key, like Deps.
+	Tools map[string]string `json:"tools,omitempty"`
+}
+
+// key returns the content-addressed build key: the sha256 of the inputs.
+func (in *buildInputs) key() string {
+	// encoding/json sorts map keys, so the encoding is canonical.
+	data, err := json.Marshal(in)
+	if err != nil {
+		panic(err) // only strings and string maps, cannot fail
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// changes describes which inputs differ from old, in a stable order.
+// A nil old (an entry written before inputs were recorded) reports
+// every input as changed.
+func (in *buildInputs) changes(old *buildInputs) []string {
+	if old == nil {
+		return []string{"inputs not recorded"}
+	}
+	var changed []string
+	if in.Formula != old.Formula {
+		changed = append(changed, "formula")
+	}
+	if in.Source != old.Source || in.Replace != old.Replace {
+		changed = append(changed, "source")
+	}
+	if in.Matrix != old.Matrix {
+		changed = append(changed, "matrix")
+	}
+	if !slices.Equal(in.Patches, old.Patches) {
+		changed = append(changed, "patches")
+	}
+	var deps []string
+	for dep, key := range in.Deps {
+		if old.Deps[dep] != key {
+			deps = append(deps, "dep "+dep)
+		}
+	}
+	for dep := range old.Deps {
+		if _, ok := in.Deps[dep]; !ok {
+			deps = append(deps, "dep "+dep+" removed")
+		}
+	}
+	for tool, key := range in.Tools {
+		if old.Tools[tool] != key {
+			deps = append(deps, "tool "+tool)
+		}
+	}
+	for tool := range old.Tools {
+		if _, ok := in.Tools[tool]; !ok {
+			deps = append(deps, "tool "+tool+" removed")
+		}
+	}
+	slices.Sort(deps)
+	return append(changed, deps...)
+}
+
+// hashFile returns the hex sha256 of the file name in fsys.
+func hashFile(fsys fs.FS, name string) (string, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(data), nil
+}
+
+// hashDir returns the hex sha256 of the regular files under dir, their
+// names and whether they are executable. Git metadata is skipped.
+func hashDir(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(h, "%s %o %s\n", filepath.ToSlash(rel), info.Mode().Perm()&0o111, hashBytes(data))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashBytes returns the hex sha256 of data.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 // buildCache maps "version-matrixString" keys to their build entries.
@@ -48,6 +199,30 @@ func (c *buildCache) set(version, matrix string, entry *buildEntry) {
 	c.Cache[cacheKey(version, matrix)] = entry
 }
 
+// takeLegacy removes the entry that a build of matrix saved under its
+// legacy encoding (see formula.Combination.Legacy) from cache, removes the
+// output dir that belongs to it, and returns the entry. The caller saves
+// cache with the entry of its new build.
+func (b *Builder) takeLegacy(cache *buildCache, modPath, version, matrix string) (*buildEntry, bool) {
+	c, err := classfile.ParseCombination(matrix)
+	if err != nil {
+		return nil, false
+	}
+	legacy := c.Legacy()
+	if legacy == matrix {
+		return nil, false
+	}
+	entry, ok := cache.get(version, legacy)
+	if !ok {
+		return nil, false
+	}
+	delete(cache.Cache, cacheKey(version, legacy))
+	if dir, err := b.outputDir(modPath, version, legacy); err == nil {
+		os.RemoveAll(dir)
+	}
+	return entry, true
+}
+
 // cacheDir returns the module-level directory for cache storage: workspaceDir/<escapedPath>.
 func (b *Builder) cacheDir(modPath string) (string, error) {
 	escaped, err := module.EscapePath(modPath)
@@ -57,13 +232,18 @@ func (b *Builder) cacheDir(modPath string) (string, error) {
 	return filepath.Join(b.workspaceDir, escaped), nil
 }
 
-// installDir returns the build output directory: workspaceDir/<escapedPath>@<version>-<matrix>.
+// installDir returns the build output directory for b.matrix: workspaceDir/<escapedPath>@<version>-<matrix>.
 func (b *Builder) installDir(modPath, version string) (string, error) {
+	return b.outputDir(modPath, version, b.matrix)
+}
+
+// outputDir returns the build output directory for matrix: workspaceDir/<escapedPath>@<version>-<matrix>.
+func (b *Builder) outputDir(modPath, version, matrix string) (string, error) {
 	escaped, err := module.EscapePath(modPath)
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(b.workspaceDir, fmt.Sprintf("%s@%s-%s", escaped, version, b.matrix)), nil
+	return filepath.Join(b.workspaceDir, fmt.Sprintf("%s@%s-%s", escaped, version, matrix)), nil
 }
 
 // loadCache reads the cache file for a module from the workspace directory.
@@ -96,6 +96,58 @@ func TestBuildCache_Overwrite(t *testing.T) {
 	}
 }
 
+func TestBuildInputs_Key(t *testing.T) {
+	base := buildInputs{
+		Formula: "f",
+		Source:  "s",
+		Matrix:  "amd64-linux",
+		Deps:    map[string]string{"a/a@1.0.0": "ka", "b/b@1.0.0": "kb"},
+	}
+	same := base
+	same.Deps = map[string]string{"b/b@1.0.0": "kb", "a/a@1.0.0": "ka"}
+	if base.key() != same.key() {
+		t.Error("key depends on map insertion order")
+	}
+
+	for name, mutate := range map[string]func(in *buildInputs){
+		"formula": func(in *buildInputs) { in.Formula = "f2" },
+		"source":  func(in *buildInputs) { in.Source = "s2" },
+		"matrix":  func(in *buildInputs) { in.Matrix = "arm64-linux" },
+		"dep key": func(in *buildInputs) { in.Deps = map[string]string{"a/a@1.0.0": "ka2", "b/b@1.0.0": "kb"} },
+		"no deps": func(in *buildInputs) { in.Deps = nil },
+		"tool":    func(in *buildInputs) { in.Tools = map[string]string{"a/a@1.0.0": "ka"} },
+	} {
+		in := base
+		mutate(&in)
+		if in.key() == base.key() {
+			t.Errorf("changing %s did not change the key", name)
+		}
+	}
+}
+
+func TestBuildInputs_Changes(t *testing.T) {
+	old := &buildInputs{
+		Formula: "f",
+		Source:  "s",
+		Matrix:  "m",
+		Deps:    map[string]string{"a/a@1.0.0": "ka", "gone/x@1.0.0": "kx"},
+	}
+	in := &buildInputs{
+		Formula: "f2",
+		Source:  "s",
+		Matrix:  "m",
+		Deps:    map[string]string{"a/a@1.0.0": "ka2", "new/y@1.0.0": "ky"},
+		Tools:   map[string]string{"t/t@1.0.0": "kt"},
+	}
+	want := []string{"formula", "dep a/a@1.0.0", "dep gone/x@1.0.0 removed", "dep new/y@1.0.0", "tool t/t@1.0.0"}
+	if got := in.changes(old); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("changes() = %q, want %q", got, want)
+	}
+	if got := in.changes(nil); len(got) != 1 {
+		t.Errorf("changes(nil) = %q, want a single reason", got)
+	}
+}
+
 func TestBuilder_InstallDir(t *testing.T) {
 	b := &Builder{workspaceDir: "/tmp/ws", matrix: "amd64-linux"}
 
@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
 
@@ -105,6 +106,27 @@ func TestE2E_DiamondDeps(t *testing.T) {
 	}
 }
 
+// TestE2E_DiamondDepsParallel builds the diamond graph with several jobs
+// and checks that results keep the sequential build order.
+func TestE2E_DiamondDepsParallel(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+	b.jobs = 4
+
+	main := module.Version{Path: "test/diamond", Version: "1.0.0"}
+	results, mods := loadAndBuild(t, b, store, main)
+
+	order := b.constructBuildList(mods)
+	if len(results) != len(order) {
+		t.Fatalf("got %d results, want %d", len(results), len(order))
+	}
+	for i, m := range order {
+		if results[i].Module.Path != m.Path {
+			t.Errorf("results[%d] is %s, want %s", i, results[i].Module.Path, m.Path)
+		}
+	}
+}
+
 // TestE2E_MatrixVariation verifies that building the same module with
 // different matrix strings produces separate cached results and install dirs.
 func TestE2E_MatrixVariation(t *testing.T) {
@@ -136,6 +158,90 @@ func TestE2E_MatrixVariation(t *testing.T) {
 	}
 }
 
+// TestE2E_MatrixValues verifies that a formula reads the values of the
+// matrix combination it is built for through ctx.matrix, ctx.option and
+// ctx.matrixValues.
+func TestE2E_MatrixValues(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "arch=arm64,os=linux|shared=on")
+
+	main := module.Version{Path: "test/matrixvals", Version: "1.0.0"}
+	results, _ := loadAndBuild(t, b, store, main)
+
+	if results[0].Metadata != "arm64 on linux" {
+		t.Errorf("metadata = %q, want %q", results[0].Metadata, "arm64 on linux")
+	}
+}
+
+// TestE2E_Variant verifies that a dependency is built for the variant
+// requested by its dependent. test/usevariant requires test/matrixvals
+// with shared=on and reads its metadata and output dir.
+func TestE2E_Variant(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "arch=arm64,os=linux")
+
+	main := module.Version{Path: "test/usevariant", Version: "1.0.0"}
+	results, _ := loadAndBuild(t, b, store, main)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	const depMatrix = "arch=arm64,os=linux|shared=on"
+	dep := results[0]
+	if dep.Module.Path != "test/matrixvals" || dep.Matrix != depMatrix {
+		t.Fatalf("results[0] = %s for %q, want test/matrixvals for %q", dep.Module, dep.Matrix, depMatrix)
+	}
+	depDir, _ := b.outputDir("test/matrixvals", "1.0.0", depMatrix)
+	if dep.OutputDir != depDir {
+		t.Errorf("dep OutputDir = %q, want %q", dep.OutputDir, depDir)
+	}
+	if want := "arm64 on linux " + depDir; results[1].Metadata != want {
+		t.Errorf("metadata = %q, want %q", results[1].Metadata, want)
+	}
+	if results[1].Matrix != b.matrix {
+		t.Errorf("root Matrix = %q, want %q", results[1].Matrix, b.matrix)
+	}
+}
+
+// TestE2E_Tool verifies that a tool is built for the host, put on the
+// PATH and not linked. test/usetool uses test/hello as a tool, runs it with
+// exec and reports its output dir, PATH and output.
+func TestE2E_Tool(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test/hello is a shell script")
+	}
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	main := module.Version{Path: "test/usetool", Version: "1.0.0"}
+	results, _ := loadAndBuild(t, b, store, main)
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	tool := results[0]
+	if tool.Module.Path != "test/hello" || tool.Matrix != hostMatrix() {
+		t.Fatalf("results[0] = %s for %q, want test/hello for %q", tool.Module, tool.Matrix, hostMatrix())
+	}
+	if tool.Linked || !results[1].Linked {
+		t.Errorf("Linked = %v for the tool, %v for the main module", tool.Linked, results[1].Linked)
+	}
+	env, output, _ := strings.Cut(results[1].Metadata, "\n")
+	if want := "hello from the build\n"; output != want {
+		t.Errorf("output of the tool = %q, want %q", output, want)
+	}
+	dir, path, _ := strings.Cut(env, " ")
+	if dir != tool.OutputDir {
+		t.Errorf("ctx.outputDir(tool) = %q, want %q", dir, tool.OutputDir)
+	}
+	if want := filepath.Join(tool.OutputDir, "bin") + string(os.PathListSeparator); !strings.HasPrefix(path, want) {
+		t.Errorf("PATH = %q, want prefix %q", path, want)
+	}
+	if os.Getenv("PATH") == path {
+		t.Error("the PATH of the build leaked into the process")
+	}
+}
+
 // TestE2E_CacheAcrossRebuilds verifies that a second build of the same
 // module returns cached results without re-executing the formula.
 func TestE2E_CacheAcrossRebuilds(t *testing.T) {
@@ -258,6 +364,63 @@ func TestE2E_OnTest_SucceedsAndRuns(t *testing.T) {
 	}
 }
 
+// TestE2E_CurrentMatrixLegacy verifies that formulas built for a canonical
+// matrix combination still see the legacy form from ctx.currentMatrix.
+func TestE2E_CurrentMatrixLegacy(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "arch=amd64,os=linux")
+	b.runTest = true
+
+	main := module.Version{Path: "test/testhook", Version: "1.0.0"}
+	results, _ := loadAndBuild(t, b, store, main)
+
+	data, err := os.ReadFile(filepath.Join(results[0].OutputDir, "ontest.stamp"))
+	if err != nil {
+		t.Fatalf("onTest stamp missing; OnTest did not run: %v", err)
+	}
+	if string(data) != "amd64-linux" {
+		t.Errorf("ctx.currentMatrix() = %q, want %q", data, "amd64-linux")
+	}
+}
+
+// TestE2E_LegacyWorkspace verifies that a build for a canonical matrix
+// combination removes the cache entry and output dir that a build of the
+// same combination left under the legacy encoding.
+func TestE2E_LegacyWorkspace(t *testing.T) {
+	store := setupTestStore(t)
+	b := setupBuilder(t, store, "amd64-linux")
+
+	main := module.Version{Path: "test/liba", Version: "1.0.0"}
+	legacy, _ := loadAndBuild(t, b, store, main)
+	if _, err := os.Stat(legacy[0].OutputDir); err != nil {
+		t.Fatalf("legacy output dir missing: %v", err)
+	}
+
+	b.matrix = "arch=amd64,os=linux"
+	results, _ := loadAndBuild(t, b, store, main)
+	if results[0].OutputDir == legacy[0].OutputDir {
+		t.Fatalf("output dir = %s, want a canonical one", results[0].OutputDir)
+	}
+	if _, err := os.Stat(legacy[0].OutputDir); !os.IsNotExist(err) {
+		t.Errorf("legacy output dir still exists: %v", err)
+	}
+
+	cache, err := b.loadCache(main.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get(main.Version, "amd64-linux"); ok {
+		t.Error("legacy cache entry was kept")
+	}
+	entry, ok := cache.get(main.Version, b.matrix)
+	if !ok {
+		t.Fatal("no cache entry for the canonical matrix")
+	}
+	if !slices.Contains(entry.Rebuild, "matrix") {
+		t.Errorf("entry.Rebuild = %v, want the matrix change", entry.Rebuild)
+	}
+}
+
 // TestE2E_OnTest_FailureSurfaces verifies that errors added to out inside
 // a formula's onTest block flow through Build() and arrive with the
 // expected "onTest failed for <path>@<version>" wrapping.
@@ -21,6 +21,11 @@ func (m *mockRepo) Latest(ctx context.Context) (string, error) {
 	return "abc123", nil
 }
 
+// Resolve pretends every ref points to a commit named after it.
+func (m *mockRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	return "commit-" + ref, nil
+}
+
 func (m *mockRepo) At(ref, localDir string) fs.FS {
 	return os.DirFS(m.testdataDir)
 }
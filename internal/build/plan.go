@@ -0,0 +1,229 @@
+package build
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+
+	classfile "github.com/goplus/llar/formula"
+	"github.com/goplus/llar/internal/modules"
+	"github.com/goplus/llar/mod/module"
+)
+
+// buildNode is a module of the build list to be built for one matrix
+// combination. A module is built once per distinct matrix its dependents
+// need, see plan.
+type buildNode struct {
+	*modules.Module
+	matrix string
+	deps   []*buildNode // direct dependencies, in the order of Module.Deps
+	tools  []*buildNode // tools, in the order of Module.Tools
+	linked bool         // linked into the main module, directly or not
+}
+
+// plan returns the nodes to build for targets (an MVS build list with the
+// main module first), dependencies first.
+//
+// The main module is built for b.matrix. Every dependency is built for the
+// matrix of the module requiring it, adapted to its own matrix by
+// depMatrix and overridden by the variant the requiring formula declares
+// for it, if any. A module required for several matrices gets a node for
+// each, ordered as first required, and nodes of the same module are kept
+// together at the position constructBuildList gives the module.
+//
+// Tools are built for the host matrix (see hostMatrix) instead, along
+// with their own dependencies.
+//
+// modules.Load lists the whole build list as deps of the main module, so
+// the main module only requires, for its own matrix, the modules no other
+// module linked into it requires or for which it declares a variant; it
+// builds against the variants required by its dependencies for the others.
+func (b *Builder) plan(targets []*modules.Module) ([]*buildNode, error) {
+	order := b.constructBuildList(targets)
+	if len(order) == 0 {
+		return nil, nil
+	}
+	inOrder := make(map[string]*modules.Module, len(order))
+	for _, m := range order {
+		inOrder[m.Path] = m
+	}
+
+	root := &buildNode{Module: order[len(order)-1], matrix: b.matrix, linked: true}
+	variants := map[string][]*buildNode{root.Path: {root}}
+	require := func(n *buildNode, d *modules.Module, tool bool) error {
+		from := n.matrix
+		if tool {
+			from = hostMatrix()
+		}
+		matrix, err := depMatrix(from, variantOf(n.Module, d.Path), matrixOf(d))
+		if err != nil {
+			return fmt.Errorf("%s@%s requires %s: %w", n.Path, n.Version, d.Path, err)
+		}
+		i := slices.IndexFunc(variants[d.Path], func(v *buildNode) bool { return v.matrix == matrix })
+		if i < 0 {
+			i = len(variants[d.Path])
+			variants[d.Path] = append(variants[d.Path], &buildNode{Module: d, matrix: matrix})
+		}
+		if tool {
+			n.tools = append(n.tools, variants[d.Path][i])
+		} else {
+			n.deps = append(n.deps, variants[d.Path][i])
+			variants[d.Path][i].linked = variants[d.Path][i].linked || n.linked
+		}
+		return nil
+	}
+	requireAll := func(n *buildNode, reqs []*modules.Module, tool bool) error {
+		for _, req := range reqs {
+			if d, ok := inOrder[req.Path]; ok && d != root.Module {
+				if err := require(n, d, tool); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	rootLinks := make(map[string]bool, len(root.Deps))
+	for _, dep := range root.Deps {
+		rootLinks[dep.Path] = true
+	}
+	if err := requireAll(root, root.Tools, true); err != nil {
+		return nil, err
+	}
+	// Reversed, the build order has every module after the modules
+	// requiring it, so the matrices a module is needed for are all known
+	// when it is reached.
+	for i := len(order) - 2; i >= 0; i-- {
+		m := order[i]
+		if rootLinks[m.Path] && (variantOf(root.Module, m.Path) != nil || !slices.ContainsFunc(variants[m.Path], func(n *buildNode) bool { return n.linked })) {
+			if err := require(root, m, false); err != nil {
+				return nil, err
+			}
+		}
+		for _, n := range variants[m.Path] {
+			if err := requireAll(n, m.Deps, false); err != nil {
+				return nil, err
+			}
+			if err := requireAll(n, m.Tools, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Required in reverse build order above, keep them in build order.
+	slices.Reverse(root.deps)
+
+	var nodes []*buildNode
+	for _, m := range order {
+		nodes = append(nodes, variants[m.Path]...)
+	}
+	return nodes, nil
+}
+
+// transitiveDeps returns all transitive dependencies of n in build order
+// (DFS post-order: leaves first), one per module path. Should a module be
+// reachable for several matrices, a direct dependency takes precedence,
+// then the first one reached.
+func (n *buildNode) transitiveDeps() []*buildNode {
+	direct := make(map[string]*buildNode, len(n.deps))
+	for _, d := range n.deps {
+		if _, ok := direct[d.Path]; !ok {
+			direct[d.Path] = d
+		}
+	}
+
+	var order []*buildNode
+	visited := map[string]bool{n.Path: true}
+
+	var visit func(d *buildNode)
+	visit = func(d *buildNode) {
+		if visited[d.Path] {
+			return
+		}
+		visited[d.Path] = true
+		if dd, ok := direct[d.Path]; ok {
+			d = dd
+		}
+		for _, dep := range d.deps {
+			visit(dep)
+		}
+		order = append(order, d)
+	}
+
+	for _, d := range n.deps {
+		visit(d)
+	}
+	return order
+}
+
+// depMatrix returns the matrix a dependency declaring the matrix dep is
+// built for when required by a module built for matrix, with the variant
+// values requested by the requiring formula, keys of either the Require
+// or the Options of dep.
+//
+// The dependency inherits matrix as described by formula.Matrix.Inherit.
+// A dependency declaring no matrix is
+// built for matrix as is, unless a variant is requested, and then for the
+// os and arch of matrix. A matrix that is not a canonical combination
+// (see formula.Combination) is passed on unchanged to dependencies
+// without variant.
+func depMatrix(matrix string, values map[string]string, dep classfile.Matrix) (string, error) {
+	implicit := len(dep.Require) == 0 && len(dep.Options) == 0
+	if len(values) == 0 && implicit {
+		return matrix, nil
+	}
+	c, err := classfile.ParseCombination(matrix)
+	if err != nil {
+		if len(values) == 0 {
+			return matrix, nil
+		}
+		return "", err
+	}
+	if implicit {
+		dep.Require = make(map[string][]string)
+		for _, k := range []string{"os", "arch"} {
+			v, ok := values[k]
+			if !ok {
+				v, ok = c.Require[k]
+			}
+			if ok {
+				dep.Require[k] = []string{v}
+			}
+		}
+	}
+	selected, err := dep.Inherit(c, values)
+	if err != nil {
+		return "", err
+	}
+	return selected.String(), nil
+}
+
+// matrixOf returns the matrix declared by the formula of m.
+func matrixOf(m *modules.Module) classfile.Matrix {
+	if m.Formula == nil {
+		return classfile.Matrix{}
+	}
+	return m.Matrix
+}
+
+// variantOf returns the variant of the dependency path declared by the
+// formula of m, or nil.
+func variantOf(m *modules.Module, path string) map[string]string {
+	if m.Formula == nil {
+		return nil
+	}
+	return m.Variants[path]
+}
+
+// hostMatrix returns the matrix of the host, which tools are built for.
+func hostMatrix() string {
+	return classfile.Combination{Require: map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH}}.String()
+}
+
+// versionsOf returns the module versions of nodes.
+func versionsOf(nodes []*buildNode) []module.Version {
+	vers := make([]module.Version, len(nodes))
+	for i, n := range nodes {
+		vers[i] = module.Version{Path: n.Path, Version: n.Version}
+	}
+	return vers
+}
@@ -0,0 +1,172 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	classfile "github.com/goplus/llar/formula"
+	"github.com/goplus/llar/internal/formula"
+	"github.com/goplus/llar/internal/modules"
+)
+
+// nodes returns the "Path@Version[matrix]" strings for []*buildNode.
+func nodes(ns []*buildNode) string {
+	var s []string
+	for _, n := range ns {
+		s = append(s, fmt.Sprintf("%s@%s[%s]", n.Path, n.Version, n.matrix))
+	}
+	return strings.Join(s, " ")
+}
+
+// withFormula sets the matrix and variants declared by the formula of m.
+func withFormula(m *modules.Module, matrix classfile.Matrix, variants map[string]map[string]string) *modules.Module {
+	m.Formula = &formula.Formula{Matrix: matrix, Variants: variants}
+	return m
+}
+
+var picMatrix = classfile.Matrix{
+	Require:        map[string][]string{"os": {"linux"}, "arch": {"amd64", "arm64"}},
+	Options:        map[string][]string{"pic": {"on", "off"}},
+	DefaultOptions: map[string][]string{"pic": {"off"}},
+}
+
+func TestPlan(t *testing.T) {
+	b := &Builder{matrix: "arch=arm64,os=linux"}
+
+	t.Run("no variants", func(t *testing.T) {
+		// A -> B -> C, A -> C
+		C := mod("C", "1.0.0")
+		B := mod("B", "1.0.0", C)
+		A := mod("A", "1.0.0", B, C)
+
+		got, err := b.plan([]*modules.Module{A, B, C})
+		if err != nil {
+			t.Fatalf("plan() error = %v", err)
+		}
+		m := b.matrix
+		if want := "C@1.0.0[" + m + "] B@1.0.0[" + m + "] A@1.0.0[" + m + "]"; nodes(got) != want {
+			t.Errorf("plan() = %q, want %q", nodes(got), want)
+		}
+	})
+
+	t.Run("variant", func(t *testing.T) {
+		// A -> B -> C{pic=on}, A -> D -> C
+		C := withFormula(mod("C", "1.0.0"), picMatrix, nil)
+		B := withFormula(mod("B", "1.0.0", C), classfile.Matrix{}, map[string]map[string]string{"C": {"pic": "on"}})
+		D := mod("D", "1.0.0", C)
+		A := mod("A", "1.0.0", B, C, D)
+
+		got, err := b.plan([]*modules.Module{A, B, C, D})
+		if err != nil {
+			t.Fatalf("plan() error = %v", err)
+		}
+		want := "C@1.0.0[arch=arm64,os=linux|pic=off] C@1.0.0[arch=arm64,os=linux|pic=on] " +
+			"B@1.0.0[arch=arm64,os=linux] D@1.0.0[arch=arm64,os=linux] A@1.0.0[arch=arm64,os=linux]"
+		if nodes(got) != want {
+			t.Errorf("plan() = %q, want %q", nodes(got), want)
+		}
+		// A links against both B and D, so it gets the C of B, reached first.
+		if deps := versions(versionsOf(got[4].transitiveDeps())); deps != "C@1.0.0 B@1.0.0 D@1.0.0" {
+			t.Errorf("A deps = %q", deps)
+		}
+		if c := got[4].transitiveDeps()[0]; c != got[1] {
+			t.Errorf("A builds against C[%s], want C[%s]", c.matrix, got[1].matrix)
+		}
+		if c := got[3].deps[0]; c != got[0] {
+			t.Errorf("D builds against C[%s], want C[%s]", c.matrix, got[0].matrix)
+		}
+	})
+
+	t.Run("root variant", func(t *testing.T) {
+		// A -> B -> C, A -> C{pic=on}
+		C := withFormula(mod("C", "1.0.0"), picMatrix, nil)
+		B := mod("B", "1.0.0", C)
+		A := withFormula(mod("A", "1.0.0", B, C), classfile.Matrix{}, map[string]map[string]string{"C": {"pic": "on"}})
+
+		got, err := b.plan([]*modules.Module{A, B, C})
+		if err != nil {
+			t.Fatalf("plan() error = %v", err)
+		}
+		root := got[len(got)-1]
+		if deps := nodes(root.deps); deps != "C@1.0.0[arch=arm64,os=linux|pic=on] B@1.0.0[arch=arm64,os=linux]" {
+			t.Errorf("A deps = %q", deps)
+		}
+		if c := root.transitiveDeps()[0]; c.matrix != "arch=arm64,os=linux|pic=on" {
+			t.Errorf("A builds against C[%s], want its variant", c.matrix)
+		}
+	})
+
+	t.Run("tools", func(t *testing.T) {
+		// A -> B, A tool T -> B, T -> U, in a cross build
+		B := mod("B", "1.0.0")
+		U := mod("U", "1.0.0")
+		T := mod("T", "1.0.0", B, U)
+		A := mod("A", "1.0.0", B)
+		A.Tools = []*modules.Module{T}
+
+		b := &Builder{matrix: "arch=mips,os=plan9"}
+		host := hostMatrix()
+		got, err := b.plan([]*modules.Module{A, B, T, U})
+		if err != nil {
+			t.Fatalf("plan() error = %v", err)
+		}
+		want := "B@1.0.0[" + host + "] B@1.0.0[arch=mips,os=plan9] U@1.0.0[" + host + "] T@1.0.0[" + host + "] A@1.0.0[arch=mips,os=plan9]"
+		if nodes(got) != want {
+			t.Errorf("plan() = %q, want %q", nodes(got), want)
+		}
+		root := got[len(got)-1]
+		if deps := nodes(root.transitiveDeps()); deps != "B@1.0.0[arch=mips,os=plan9]" {
+			t.Errorf("A deps = %q, want only B", deps)
+		}
+		if tools := nodes(root.tools); tools != "T@1.0.0["+host+"]" {
+			t.Errorf("A tools = %q", tools)
+		}
+	})
+
+	t.Run("invalid variant", func(t *testing.T) {
+		C := withFormula(mod("C", "1.0.0"), picMatrix, nil)
+		A := withFormula(mod("A", "1.0.0", C), classfile.Matrix{}, map[string]map[string]string{"C": {"lto": "on"}})
+
+		_, err := b.plan([]*modules.Module{A, C})
+		if err == nil || !strings.Contains(err.Error(), "A@1.0.0 requires C") {
+			t.Errorf("plan() error = %v, want one naming A and C", err)
+		}
+	})
+}
+
+func TestDepMatrix(t *testing.T) {
+	tests := []struct {
+		name    string
+		matrix  string
+		values  map[string]string
+		dep     classfile.Matrix
+		want    string
+		wantErr string
+	}{
+		{"no matrix", "arch=arm64,os=linux|shared=on", nil, classfile.Matrix{}, "arch=arm64,os=linux|shared=on", ""},
+		{"legacy string", "amd64-linux", nil, picMatrix, "amd64-linux", ""},
+		{"defaults", "arch=arm64,os=linux|shared=on", nil, picMatrix, "arch=arm64,os=linux|pic=off", ""},
+		{"inherited option", "arch=arm64,os=linux|pic=on", nil, picMatrix, "arch=arm64,os=linux|pic=on", ""},
+		{"variant", "arch=arm64,os=linux", map[string]string{"pic": "on"}, picMatrix, "arch=arm64,os=linux|pic=on", ""},
+		{"variant require", "arch=arm64,os=linux", map[string]string{"arch": "amd64"}, picMatrix, "arch=amd64,os=linux|pic=off", ""},
+		{"implicit matrix", "arch=arm64,os=linux|shared=on", map[string]string{"arch": "amd64"}, classfile.Matrix{}, "arch=amd64,os=linux", ""},
+		{"unknown option", "arch=arm64,os=linux", map[string]string{"lto": "on"}, picMatrix, "", `unknown matrix option "lto"`},
+		{"unsupported require", "arch=riscv64,os=linux", nil, picMatrix, "", `"riscv64"`},
+		{"variant of legacy string", "amd64-linux", map[string]string{"pic": "on"}, picMatrix, "", "invalid matrix combination"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := depMatrix(tt.matrix, tt.values, tt.dep)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("depMatrix() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("depMatrix() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
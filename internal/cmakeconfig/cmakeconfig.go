@@ -0,0 +1,298 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cmakeconfig writes CMake package configuration files for build
+// outputs that do not ship their own, so that CMake projects can use them
+// with find_package(<Name> CONFIG) and link against the imported target
+// <Name>::<Name>.
+package cmakeconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/goplus/llar/formula"
+	"github.com/goplus/llar/mod/module"
+)
+
+// header starts every file written, telling generated files apart from
+// those a build installed.
+const header = "# Generated by llar"
+
+// Dep is a dependency with a generated package configuration.
+type Dep struct {
+	Name string // package name, see Name
+	Dir  string // install directory
+}
+
+// Config describes the package configuration of a build output.
+type Config struct {
+	Name      string         // package name, see Name
+	Module    module.Version // the module built
+	Dir       string         // install directory, the prefix of the package
+	PkgConfig formula.PkgConfig
+	// Deps are the dependencies the imported target links to, through
+	// their own imported targets.
+	Deps []Dep
+}
+
+// Name returns the package name of a module, the last element of its path:
+// madler/zlib is found with find_package(zlib CONFIG) as zlib::zlib. The
+// name is not unique: a/zlib and b/zlib are both zlib, and the caller must
+// not let one package refer to both.
+func Name(modPath string) string {
+	return path.Base(modPath)
+}
+
+// Dir returns the directory of the files of the package name in dir.
+func Dir(dir, name string) string {
+	return filepath.Join(dir, "lib", "cmake", name)
+}
+
+// Has reports whether dir contains a package configuration file for name,
+// <name>Config.cmake or <name>-config.cmake, in any directory and case.
+func Has(dir, name string) (bool, error) {
+	want := []string{strings.ToLower(name) + "config.cmake", strings.ToLower(name) + "-config.cmake"}
+	found := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if base := strings.ToLower(d.Name()); base == want[0] || base == want[1] {
+				found = true
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return found, err
+}
+
+// Generated reports whether the package configuration of name in dir was
+// written by Write.
+func Generated(dir, name string) bool {
+	data, err := os.ReadFile(filepath.Join(Dir(dir, name), name+"Targets.cmake"))
+	return err == nil && bytes.HasPrefix(data, []byte(header))
+}
+
+// Write writes <Name>Config.cmake, <Name>ConfigVersion.cmake and
+// <Name>Targets.cmake into lib/cmake/<Name> of c.Dir.
+//
+// The targets file defines the INTERFACE IMPORTED target <Name>::<Name>
+// from c.PkgConfig: -I flags are include directories, -D definitions
+// compile definitions, -L flags link directories, -l flags and library
+// files link libraries, and other flags compile or link options; StaticLibs
+// are only linked ($<LINK_ONLY:...>). Paths below c.Dir are written
+// relative to the location of the files, so the install directory may be
+// moved. The target links to the targets of c.Deps, which the config file
+// finds with find_dependency, first in CMAKE_PREFIX_PATH, then in the
+// prefix of the package, where a merged prefix has them, and at the
+// location of their install directories relative to c.Dir, where the
+// workspace has them. No absolute path is written. Files whose content is
+// unchanged are not rewritten.
+func Write(c Config) error {
+	dir := Dir(c.Dir, c.Name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content string
+	}{
+		{c.Name + "Config.cmake", c.config()},
+		{c.Name + "ConfigVersion.cmake", c.version()},
+		{c.Name + "Targets.cmake", c.targets()},
+	}
+	for _, f := range files {
+		name := filepath.Join(dir, f.name)
+		if data, err := os.ReadFile(name); err == nil && string(data) == f.content {
+			continue
+		}
+		if err := os.WriteFile(name, []byte(f.content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importPrefix computes _IMPORT_PREFIX, c.Dir wherever it was moved, from
+// lib/cmake/<Name>.
+const importPrefix = `get_filename_component(_IMPORT_PREFIX "${CMAKE_CURRENT_LIST_DIR}/../../.." ABSOLUTE)` + "\n"
+
+func (c Config) header() string {
+	return fmt.Sprintf("%s for %s@%s. Do not edit.\n\n", header, c.Module.Path, c.Module.Version)
+}
+
+func (c Config) config() string {
+	var b strings.Builder
+	b.WriteString(c.header())
+	if len(c.Deps) > 0 {
+		b.WriteString(importPrefix)
+		b.WriteString("include(CMakeFindDependencyMacro)\n")
+		for _, d := range c.Deps {
+			hints := `"${_IMPORT_PREFIX}"`
+			if rel, err := filepath.Rel(c.Dir, d.Dir); err == nil {
+				hints += ` "${_IMPORT_PREFIX}/` + escape(filepath.ToSlash(rel)) + `"`
+			}
+			fmt.Fprintf(&b, "find_dependency(%s CONFIG HINTS %s)\n", d.Name, hints)
+		}
+		b.WriteString("unset(_IMPORT_PREFIX)\n\n")
+	}
+	fmt.Fprintf(&b, "include(\"${CMAKE_CURRENT_LIST_DIR}/%sTargets.cmake\")\n", c.Name)
+	return b.String()
+}
+
+// version accepts any requested version up to the version built, numeric
+// components compared as VERSION_LESS does.
+func (c Config) version() string {
+	var b strings.Builder
+	b.WriteString(c.header())
+	fmt.Fprintf(&b, "set(PACKAGE_VERSION %s)\n\n", quote(strings.TrimPrefix(c.Module.Version, "v")))
+	b.WriteString(`if(PACKAGE_VERSION VERSION_LESS PACKAGE_FIND_VERSION)
+  set(PACKAGE_VERSION_COMPATIBLE FALSE)
+else()
+  set(PACKAGE_VERSION_COMPATIBLE TRUE)
+  if(PACKAGE_FIND_VERSION STREQUAL PACKAGE_VERSION)
+    set(PACKAGE_VERSION_EXACT TRUE)
+  endif()
+endif()
+`)
+	return b.String()
+}
+
+func (c Config) targets() string {
+	var props properties
+	pc := c.PkgConfig
+	for i := 0; i < len(pc.Cflags); i++ {
+		flag, arg, next := splitArg(pc.Cflags, i, "-I", "-isystem", "-include")
+		switch flag {
+		case "-I":
+			props.add("INTERFACE_INCLUDE_DIRECTORIES", c.path(arg))
+		case "-isystem":
+			props.add("INTERFACE_INCLUDE_DIRECTORIES", c.path(arg))
+			props.add("INTERFACE_SYSTEM_INCLUDE_DIRECTORIES", c.path(arg))
+		case "-include":
+			props.add("INTERFACE_COMPILE_OPTIONS", "SHELL:-include "+c.path(arg))
+		default:
+			props.add("INTERFACE_COMPILE_OPTIONS", escape(pc.Cflags[i]))
+		}
+		i = next
+	}
+	for _, def := range pc.Defines {
+		props.add("INTERFACE_COMPILE_DEFINITIONS", escape(def))
+	}
+	c.addLibs(&props, pc.Libs, false)
+	for _, d := range c.Deps {
+		props.add("INTERFACE_LINK_LIBRARIES", d.Name+"::"+d.Name)
+	}
+	c.addLibs(&props, pc.StaticLibs, true)
+
+	target := c.Name + "::" + c.Name
+	var b strings.Builder
+	b.WriteString(c.header())
+	fmt.Fprintf(&b, "if(TARGET %s)\n  return()\nendif()\n\n", target)
+	b.WriteString(importPrefix)
+	fmt.Fprintf(&b, "\nadd_library(%s INTERFACE IMPORTED)\n", target)
+	if len(props.names) > 0 {
+		fmt.Fprintf(&b, "set_target_properties(%s PROPERTIES\n", target)
+		for _, name := range props.names {
+			fmt.Fprintf(&b, "  %s \"%s\"\n", name, strings.Join(props.values[name], ";"))
+		}
+		b.WriteString(")\n")
+	}
+	b.WriteString("\nunset(_IMPORT_PREFIX)\n")
+	return b.String()
+}
+
+// addLibs adds the linker flags libs to props, only for linking with
+// linkOnly.
+func (c Config) addLibs(props *properties, libs []string, linkOnly bool) {
+	lib := func(item string) {
+		if linkOnly {
+			item = "$<LINK_ONLY:" + item + ">"
+		}
+		props.add("INTERFACE_LINK_LIBRARIES", item)
+	}
+	for i := 0; i < len(libs); i++ {
+		flag, arg, next := splitArg(libs, i, "-L", "-l", "-framework")
+		switch {
+		case flag == "-L":
+			props.add("INTERFACE_LINK_DIRECTORIES", c.path(arg))
+		case flag == "-l":
+			lib(escape(arg))
+		case flag == "-framework":
+			props.add("INTERFACE_LINK_OPTIONS", "SHELL:-framework "+escape(arg))
+		case filepath.IsAbs(libs[i]):
+			lib(c.path(libs[i]))
+		default:
+			props.add("INTERFACE_LINK_OPTIONS", escape(libs[i]))
+		}
+		i = next
+	}
+}
+
+// splitArg returns the flag of flags among prefixes that flags[i] starts
+// with and its argument, attached or the next flag, and the index of the
+// last flag used.
+func splitArg(flags []string, i int, prefixes ...string) (flag, arg string, last int) {
+	for _, p := range prefixes {
+		switch {
+		case flags[i] == p && i+1 < len(flags):
+			return p, flags[i+1], i + 1
+		case len(flags[i]) > len(p) && strings.HasPrefix(flags[i], p) && p != "-include" && p != "-framework":
+			return p, flags[i][len(p):], i
+		}
+	}
+	return "", "", i
+}
+
+// path returns p for a CMake string, relative to _IMPORT_PREFIX if below
+// c.Dir.
+func (c Config) path(p string) string {
+	if rel, err := filepath.Rel(c.Dir, p); err == nil && filepath.IsAbs(p) && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		if rel == "." {
+			return "${_IMPORT_PREFIX}"
+		}
+		return "${_IMPORT_PREFIX}/" + escape(filepath.ToSlash(rel))
+	}
+	return escape(p)
+}
+
+// properties are target properties, in the order first added.
+type properties struct {
+	names  []string
+	values map[string][]string
+}
+
+func (p *properties) add(name, value string) {
+	if p.values == nil {
+		p.values = make(map[string][]string)
+	}
+	if _, ok := p.values[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.values[name] = append(p.values[name], value)
+}
+
+// escape escapes s for a quoted CMake argument holding a list: quotes,
+// backslashes and variable references are kept literally and semicolons
+// do not separate elements.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, `;`, `\;`).Replace(s)
+}
+
+// quote returns s as a quoted CMake argument.
+func quote(s string) string {
+	return `"` + escape(s) + `"`
+}
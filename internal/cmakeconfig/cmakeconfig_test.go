@@ -0,0 +1,113 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmakeconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/formula"
+	"github.com/goplus/llar/mod/module"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	zlibDir := filepath.Join(filepath.Dir(dir), "zlib") // as in the workspace
+	c := Config{
+		Name:   "libpng",
+		Module: module.Version{Path: "pnggroup/libpng", Version: "v1.6.47"},
+		Dir:    dir,
+		PkgConfig: formula.PkgConfig{
+			Cflags:     []string{"-I" + dir + "/include/libpng16", "-isystem", "/opt/include", "-pthread"},
+			Defines:    []string{"PNG_API=1", "NAME=\"a;b\""},
+			Libs:       []string{"-L" + dir + "/lib", "-lpng16", dir + "/lib/libextra.a", "-framework", "CoreFoundation"},
+			StaticLibs: []string{"-lm"},
+		},
+		Deps: []Dep{{Name: "zlib", Dir: zlibDir}},
+	}
+	if err := Write(c); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	cmakeDir := filepath.Join(dir, "lib", "cmake", "libpng")
+
+	config := readFile(t, filepath.Join(cmakeDir, "libpngConfig.cmake"))
+	for _, want := range []string{
+		"# Generated by llar for pnggroup/libpng@v1.6.47.",
+		`find_dependency(zlib CONFIG HINTS "${_IMPORT_PREFIX}" "${_IMPORT_PREFIX}/../zlib")`,
+		`include("${CMAKE_CURRENT_LIST_DIR}/libpngTargets.cmake")`,
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("libpngConfig.cmake lacks %q:\n%s", want, config)
+		}
+	}
+	if strings.Contains(config, zlibDir) {
+		t.Errorf("libpngConfig.cmake refers to the absolute install directory of zlib:\n%s", config)
+	}
+
+	version := readFile(t, filepath.Join(cmakeDir, "libpngConfigVersion.cmake"))
+	if !strings.Contains(version, `set(PACKAGE_VERSION "1.6.47")`) {
+		t.Errorf("libpngConfigVersion.cmake lacks the version:\n%s", version)
+	}
+
+	targets := readFile(t, filepath.Join(cmakeDir, "libpngTargets.cmake"))
+	for _, want := range []string{
+		`get_filename_component(_IMPORT_PREFIX "${CMAKE_CURRENT_LIST_DIR}/../../.." ABSOLUTE)`,
+		"add_library(libpng::libpng INTERFACE IMPORTED)",
+		`  INTERFACE_INCLUDE_DIRECTORIES "${_IMPORT_PREFIX}/include/libpng16;/opt/include"`,
+		`  INTERFACE_SYSTEM_INCLUDE_DIRECTORIES "/opt/include"`,
+		`  INTERFACE_COMPILE_OPTIONS "-pthread"`,
+		`  INTERFACE_COMPILE_DEFINITIONS "PNG_API=1;NAME=\"a\;b\""`,
+		`  INTERFACE_LINK_DIRECTORIES "${_IMPORT_PREFIX}/lib"`,
+		`  INTERFACE_LINK_LIBRARIES "png16;${_IMPORT_PREFIX}/lib/libextra.a;zlib::zlib;$<LINK_ONLY:m>"`,
+		`  INTERFACE_LINK_OPTIONS "SHELL:-framework CoreFoundation"`,
+	} {
+		if !strings.Contains(targets, want) {
+			t.Errorf("libpngTargets.cmake lacks %q:\n%s", want, targets)
+		}
+	}
+
+	if !Generated(dir, "libpng") {
+		t.Error("Generated = false for the files written")
+	}
+	if has, err := Has(dir, "libpng"); err != nil || !has {
+		t.Errorf("Has = %v, %v after Write", has, err)
+	}
+}
+
+func TestHas(t *testing.T) {
+	dir := t.TempDir()
+	if has, err := Has(dir, "zlib"); err != nil || has {
+		t.Errorf("Has of an empty dir = %v, %v", has, err)
+	}
+	if has, err := Has(filepath.Join(dir, "missing"), "zlib"); err != nil || has {
+		t.Errorf("Has of a missing dir = %v, %v", has, err)
+	}
+
+	// Shipped by the build, in another directory and case.
+	shipped := filepath.Join(dir, "share", "cmake", "ZLIB")
+	if err := os.MkdirAll(shipped, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(shipped, "ZLIB-config.cmake"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := Has(dir, "zlib"); err != nil || !has {
+		t.Errorf("Has with ZLIB-config.cmake = %v, %v", has, err)
+	}
+	if Generated(dir, "zlib") {
+		t.Error("Generated = true for a shipped config")
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formula
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/goplus/ixgo"
+	"github.com/goplus/ixgo/xgobuild"
+)
+
+// Compiled classfile cache layout:
+//
+//	cacheDir/
+//	  <key>.go      # the Go source a classfile compiles to
+//
+// The key is the SHA-256 of the compiler identity (see compilerID), the
+// file name of the classfile, which names its class, and its content.
+// Turning a classfile into Go source is the slowest step of loading it.
+
+// interpMu serializes the creation and initialization of interpreters:
+// ixgo registers the methods of the types of every interpreter in tables
+// shared by all of them. Compiling and type-checking a classfile, the bulk
+// of loading it, runs in parallel.
+var interpMu sync.Mutex
+
+// DefaultCacheDir returns the directory compiled classfiles are cached in:
+// <UserCacheDir>/.llar/compiled.
+func DefaultCacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDir, ".llar", "compiled"), nil
+}
+
+// LoadClass loads the XGo classfile at path in fsys (a formula or a
+// comparator) and returns a new instance of the class it declares, after
+// running its Main method, which executes the statements of the file.
+//
+// The class is named after the file name prefix before "_", e.g. "hello"
+// for "hello_llar.gox". LoadClass is safe for concurrent use: each call
+// gets its own interpreter.
+func LoadClass(fsys fs.ReadFileFS, path string) (reflect.Value, error) {
+	content, err := fsys.ReadFile(path)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	structName, _, ok := strings.Cut(filepath.Base(path), "_")
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("failed to load %s: file name is not valid", path)
+	}
+
+	// Every interpreter has its own reflectx context, so that creating one
+	// does not reset the methods of the others.
+	ctx := ixgo.NewContext(ixgo.SupportMultipleInterp)
+	source, cached, err := compile(ctx, path, content)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	pkgs, err := ctx.LoadFile("main.go", source)
+	if err != nil && cached {
+		// The cached source may predate a change of the formula package
+		// the compiler identity does not account for, e.g. in a
+		// development build: compile the file again.
+		ctx = ixgo.NewContext(ixgo.SupportMultipleInterp)
+		if source, err = build(ctx, path, content); err != nil {
+			return reflect.Value{}, err
+		}
+		pkgs, err = ctx.LoadFile("main.go", source)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	interpMu.Lock()
+	defer interpMu.Unlock()
+
+	interp, err := ctx.NewInterp(pkgs)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if err = interp.RunInit(); err != nil {
+		return reflect.Value{}, err
+	}
+	typ, ok := interp.GetType(structName)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("failed to load %s: struct name not found: %s", path, structName)
+	}
+	val := reflect.New(typ)
+	val.Interface().(interface{ Main() }).Main()
+	return val.Elem(), nil
+}
+
+// compile returns the Go source the classfile path with content compiles
+// to, and whether it was read from the cache in DefaultCacheDir.
+func compile(ctx *ixgo.Context, path string, content []byte) (source []byte, cached bool, err error) {
+	file := cacheFile(path, content)
+	if file != "" {
+		if source, err := os.ReadFile(file); err == nil {
+			return source, true, nil
+		}
+	}
+	source, err = build(ctx, path, content)
+	return source, false, err
+}
+
+// build compiles the classfile path with content to Go source and stores
+// the result in the cache. The cache is best effort: failing to write it
+// is not an error.
+func build(ctx *ixgo.Context, path string, content []byte) ([]byte, error) {
+	source, err := xgobuild.BuildFile(ctx, path, content)
+	if err != nil {
+		return nil, err
+	}
+	if file := cacheFile(path, content); file != "" {
+		writeFileAtomic(file, source)
+	}
+	return source, nil
+}
+
+// cacheFile returns the file caching the Go source of the classfile path
+// with content, or "" if there is no cache directory.
+func cacheFile(path string, content []byte) string {
+	dir, err := DefaultCacheDir()
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", compilerID(), filepath.Base(path))
+	h.Write(content)
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".go")
+}
+
+// compilerID identifies the code generating the Go source of classfiles:
+// the versions of this module and of the XGo toolchain it is built with.
+var compilerID = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(info.Main.Version)
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" || s.Key == "vcs.modified" {
+			fmt.Fprintf(&b, " %s=%s", s.Key, s.Value)
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/goplus/xgo" || dep.Path == "github.com/goplus/ixgo" {
+			fmt.Fprintf(&b, " %s@%s", dep.Path, dep.Version)
+		}
+	}
+	return b.String()
+})
+
+// writeFileAtomic writes data to file through a temporary file, so that
+// concurrent readers never see a partial file. Errors are ignored.
+func writeFileAtomic(file string, data []byte) {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(file)+".tmp*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package formula
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoadClass_Cache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	fsys := os.DirFS("testdata/formula").(fs.ReadFileFS)
+	content, err := fsys.ReadFile("hello_llar.gox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := cacheFile("hello_llar.gox", content)
+	if file == "" {
+		t.Fatal("no cache directory")
+	}
+
+	if _, err := LoadFS(fsys, "hello_llar.gox"); err != nil {
+		t.Fatalf("LoadFS failed: %v", err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("compiled formula not cached: %v", err)
+	}
+	if other := cacheFile("other_llar.gox", content); other == file {
+		t.Error("cache key does not depend on the file name")
+	}
+
+	// A cached source that no longer loads is compiled again.
+	if err := os.WriteFile(file, []byte("package main\n\nfunc main() { undefined() }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadFS(fsys, "hello_llar.gox")
+	if err != nil {
+		t.Fatalf("LoadFS with a stale cache failed: %v", err)
+	}
+	if f.ModPath != "DaveGamble/cJSON" {
+		t.Errorf("ModPath = %q, want %q", f.ModPath, "DaveGamble/cJSON")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(file), "*.tmp*")); len(matches) > 0 {
+		t.Errorf("temporary files left in the cache: %v", matches)
+	}
+}
+
+func TestLoadFS_Concurrent(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	fsys := os.DirFS("testdata/formula").(fs.ReadFileFS)
+
+	var wg sync.WaitGroup
+	formulas := make([]*Formula, 8)
+	errs := make([]error, len(formulas))
+	for i := range formulas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			formulas[i], errs[i] = LoadFS(fsys, "hello_llar.gox")
+		}()
+	}
+	wg.Wait()
+	for i, f := range formulas {
+		if errs[i] != nil {
+			t.Fatalf("LoadFS failed: %v", errs[i])
+		}
+		if f.ModPath != "DaveGamble/cJSON" || f.OnBuild == nil {
+			t.Errorf("formula %d = %q, OnBuild %v", i, f.ModPath, f.OnBuild != nil)
+		}
+	}
+}
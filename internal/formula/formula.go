@@ -5,15 +5,11 @@
 package formula
 
 import (
-	"fmt"
 	"io"
 	"io/fs"
-	"path/filepath"
 	"reflect"
 	"strings"
 
-	"github.com/goplus/ixgo"
-	"github.com/goplus/ixgo/xgobuild"
 	"github.com/goplus/llar/formula"
 
 	_ "github.com/goplus/llar/internal/ixgo"
@@ -24,6 +20,10 @@ import (
 type Formula struct {
 	structElem reflect.Value
 
+	// File is the path of the formula file within the filesystem it was
+	// loaded from.
+	File string
+
 	// NOTE(MeteorsLiu): these signatures MUST match with
 	// 	the method declaration of ModuleF in formula/classfile.go
 	ModPath   string
@@ -31,6 +31,24 @@ type Formula struct {
 	OnRequire func(proj *formula.Project, deps *formula.ModuleDeps)
 	OnBuild   func(ctx *formula.Context, proj *formula.Project, out *formula.BuildResult)
 	OnTest    func(ctx *formula.Context, proj *formula.Project, out *formula.TestResult)
+
+	// Matrix is the build matrix declared by matrix; it is empty if the
+	// formula declares none.
+	Matrix formula.Matrix
+
+	// Variants maps the path of a dependency to the matrix values it is
+	// to be built for, as declared by variant.
+	Variants map[string]map[string]string
+
+	// SourceURL and SourceSHA256 describe the source archive declared by
+	// source; SourceURL is empty if the source is the module's repository.
+	// Use ArchiveURL to get the URL for a given version.
+	SourceURL    string
+	SourceSHA256 string
+
+	// Patches lists the patch files declared by patch, relative to the
+	// directory of File, in the order they are applied.
+	Patches []string
 }
 
 // loadFS is the internal implementation for loading a formula from a filesystem.
@@ -71,76 +89,48 @@ type Formula struct {
 //
 // The struct name is derived from the filename prefix before "_" (e.g., "hello" from "hello_llar.gox").
 // Calling Main() triggers Gopt_ModuleF_Main which invokes MainEntry() to populate the struct fields.
+// The generated code is cached on disk, see LoadClass.
 func loadFS(fs fs.ReadFileFS, path string) (*Formula, error) {
-	// Create a new ixgo interpreter context
-	ctx := ixgo.NewContext(0)
-
-	// Read the raw DSL content from the .gox file
-	content, err := fs.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	// Transform the DSL (.gox) into generated Go source code
-	// This uses the registered classfile extensions (_llar.gox -> ModuleF)
-	source, err := xgobuild.BuildFile(ctx, path, content)
-	if err != nil {
-		return nil, err
-	}
-
-	// Load the generated source as a Go package (treated as main.go)
-	pkgs, err := ctx.LoadFile("main.go", source)
-	if err != nil {
-		return nil, err
-	}
-
-	// Create a new interpreter for the loaded package
-	interp, err := ctx.NewInterp(pkgs)
-	if err != nil {
-		return nil, err
-	}
-
-	// Run package-level init functions
-	if err = interp.RunInit(); err != nil {
-		return nil, err
-	}
-
-	// Extract struct name from filename: "hello_llar.gox" -> "hello"
-	// The classfile mechanism generates a struct with this name
-	structName, _, ok := strings.Cut(filepath.Base(path), "_")
-	if !ok {
-		return nil, fmt.Errorf("failed to load formula: file name is not valid: %s", path)
-	}
-
-	// Get the generated struct type from the interpreter
-	typ, ok := interp.GetType(structName)
-	if !ok {
-		return nil, fmt.Errorf("failed to load formula: struct name not found: %s", structName)
-	}
-
-	// Create a new instance of the generated struct (e.g., &hello{})
-	val := reflect.New(typ)
-	class := val.Elem()
-
-	// Call Main() which triggers: formula.Gopt_ModuleF_Main(this)
-	// This in turn calls MainEntry() to execute the DSL code and populate fields:
+	// Compile the formula and call Main() on a new instance of its class,
+	// which triggers formula.Gopt_ModuleF_Main(this). This in turn calls
+	// MainEntry() to execute the DSL code and populate fields:
 	// - modPath: set by this.Id(...)
 	// - modFromVer: set by this.FromVer(...)
 	// - fOnRequire: set by this.OnRequire(...)
 	// - fOnBuild: set by this.OnBuild(...)
-	val.Interface().(interface{ Main() }).Main()
+	// - matrix: set by this.Matrix(...)
+	// - variants: set by this.Variant(...)
+	// - srcURL, srcSHA256: set by this.Source(...)
+	// - patches: set by this.Patch(...)
+	class, err := LoadClass(fs, path)
+	if err != nil {
+		return nil, err
+	}
 
 	// Extract the populated fields from the struct and return the Formula
 	return &Formula{
 		structElem: class,
+		File:       path,
 		ModPath:    valueOf(class, "modPath").(string),
 		FromVer:    valueOf(class, "modFromVer").(string),
 		OnBuild:    valueOf(class, "fOnBuild").(func(*formula.Context, *formula.Project, *formula.BuildResult)),
 		OnTest:     valueOf(class, "fOnTest").(func(*formula.Context, *formula.Project, *formula.TestResult)),
 		OnRequire:  valueOf(class, "fOnRequire").(func(*formula.Project, *formula.ModuleDeps)),
+		Matrix:     valueOf(class, "matrix").(formula.Matrix),
+		Variants:   valueOf(class, "variants").(map[string]map[string]string),
+
+		SourceURL:    valueOf(class, "srcURL").(string),
+		SourceSHA256: valueOf(class, "srcSHA256").(string),
+		Patches:      valueOf(class, "patches").([]string),
 	}, nil
 }
 
+// ArchiveURL returns the URL of the source archive of version, or "" if
+// the formula does not declare one.
+func (f *Formula) ArchiveURL(version string) string {
+	return strings.ReplaceAll(f.SourceURL, "{version}", version)
+}
+
 // LoadFS loads a formula from a filesystem interface.
 // This allows loading formulas from remote repositories or mock filesystems.
 // The path should be relative to the filesystem root.
@@ -162,3 +152,19 @@ func (f *Formula) SetStderr(w io.Writer) {
 		setValue(f.structElem, "ferr", w)
 	}
 }
+
+// SetEnv sets the environment the commands run by the formula with exec
+// see, the ctx.env of the build about to run its callbacks.
+func (f *Formula) SetEnv(env *formula.Env) {
+	if f.structElem.IsValid() {
+		setValue(f.structElem, "env", env)
+	}
+}
+
+// SetDir sets the directory the commands run by the formula with exec run
+// in, the ctx.SourceDir of the build about to run its callbacks.
+func (f *Formula) SetDir(dir string) {
+	if f.structElem.IsValid() {
+		setValue(f.structElem, "dir", dir)
+	}
+}
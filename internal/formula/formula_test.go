@@ -7,6 +7,7 @@ package formula
 import (
 	"io/fs"
 	"os"
+	"reflect"
 	"testing"
 
 	formulapkg "github.com/goplus/llar/formula"
@@ -26,6 +27,17 @@ func TestLoadFS(t *testing.T) {
 		if f.FromVer != "v1.0.0" {
 			t.Errorf("Unexpected FromVer: want %s got %s", "v1.0.0", f.FromVer)
 		}
+		wantMatrix := formulapkg.Matrix{
+			Require:        map[string][]string{"os": {"linux", "darwin"}, "arch": {"amd64", "arm64"}},
+			Options:        map[string][]string{"shared": {"on", "off"}},
+			DefaultOptions: map[string][]string{"shared": {"off"}},
+		}
+		if !reflect.DeepEqual(f.Matrix, wantMatrix) {
+			t.Errorf("Unexpected Matrix: want %+v got %+v", wantMatrix, f.Matrix)
+		}
+		if want := map[string]map[string]string{"madler/zlib": {"pic": "on"}}; !reflect.DeepEqual(f.Variants, want) {
+			t.Errorf("Unexpected Variants: want %v got %v", want, f.Variants)
+		}
 		if f.OnBuild == nil {
 			t.Error("OnBuild is nil")
 		}
@@ -32,3 +32,11 @@ func (s *overlayStore) LockModule(modPath string) (func(), error) {
 	// Always delegate lock ownership to the shared backing store.
 	return s.remote.LockModule(modPath)
 }
+
+func (s *overlayStore) Revision(ctx context.Context, modPath string) (string, error) {
+	// Local formulas are read from disk, not from a repository commit.
+	if _, ok := s.locals[modPath]; ok {
+		return "", nil
+	}
+	return s.remote.Revision(ctx, modPath)
+}
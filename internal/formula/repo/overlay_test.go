@@ -82,6 +82,28 @@ func TestOverlayStore_ModuleFS_Remote(t *testing.T) {
 	}
 }
 
+func TestOverlayStore_Revision(t *testing.T) {
+	tmpDir := t.TempDir()
+	vcsRepo := &mockRepo{latest: "abc123"}
+	store := NewOverlayStore(New(tmpDir, vcsRepo), map[string]string{
+		"local/mod": tmpDir,
+	})
+	ctx := context.Background()
+
+	for range 2 {
+		rev, err := store.Revision(ctx, "remote/mod")
+		if err != nil || rev != "abc123" {
+			t.Fatalf("Revision(remote/mod) = %q, %v, want %q", rev, err, "abc123")
+		}
+	}
+	if vcsRepo.latestN != 1 {
+		t.Errorf("Latest called %d times, want 1", vcsRepo.latestN)
+	}
+	if rev, err := store.Revision(ctx, "local/mod"); err != nil || rev != "" {
+		t.Errorf("Revision(local/mod) = %q, %v, want empty", rev, err)
+	}
+}
+
 func TestOverlayStore_LockModule(t *testing.T) {
 	tmpDir := t.TempDir()
 	remote := New(tmpDir, &mockRepo{})
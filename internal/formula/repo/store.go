@@ -9,6 +9,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/goplus/llar/internal/lockedfile"
 	"github.com/goplus/llar/internal/vcs"
@@ -23,12 +24,21 @@ type Store interface {
 	// LockModule acquires an exclusive lock for the given module path.
 	// Returns an unlock function that must be called to release the lock.
 	LockModule(modPath string) (unlock func(), err error)
+
+	// Revision returns the commit of the formula repository that the
+	// formulas of the specified module are served from, or "" if they do
+	// not come from a repository.
+	Revision(ctx context.Context, modPath string) (string, error)
 }
 
 // remoteStore manages a formula repository, handling storage layout and synchronization.
 type remoteStore struct {
 	dir     string
 	vcsRepo vcs.Repo
+
+	revOnce sync.Once
+	rev     string
+	revErr  error
 }
 
 // New creates a new Store with the given directory and vcs.Repo.
@@ -83,6 +93,15 @@ func (s *remoteStore) LockModule(modPath string) (unlock func(), err error) {
 	return lockedfile.MutexAt(lockFile).Lock()
 }
 
+// Revision returns the latest commit of the formula repository, which is
+// the commit ModuleFS synchronizes to. It is looked up once per Store.
+func (s *remoteStore) Revision(ctx context.Context, modPath string) (string, error) {
+	s.revOnce.Do(func() {
+		s.rev, s.revErr = s.vcsRepo.Latest(ctx)
+	})
+	return s.rev, s.revErr
+}
+
 // DefaultDir returns the default root directory where all formula repositories are stored.
 // It creates the directory with 0700 permissions if it doesn't exist.
 // The directory is located at <UserCacheDir>/.llar/formulas.
@@ -19,7 +19,9 @@ import (
 
 // mockRepo is a mock implementation of vcs.Repo for testing
 type mockRepo struct {
-	syncFn func(ctx context.Context, ref, path, localDir string) error
+	syncFn  func(ctx context.Context, ref, path, localDir string) error
+	latest  string
+	latestN int // number of Latest calls
 }
 
 func (m *mockRepo) Tags(ctx context.Context) ([]string, error) {
@@ -27,7 +29,12 @@ func (m *mockRepo) Tags(ctx context.Context) ([]string, error) {
 }
 
 func (m *mockRepo) Latest(ctx context.Context) (string, error) {
-	return "", nil
+	m.latestN++
+	return m.latest, nil
+}
+
+func (m *mockRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	return ref, nil
 }
 
 func (m *mockRepo) At(ref, localDir string) fs.FS {
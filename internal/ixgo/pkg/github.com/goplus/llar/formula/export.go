@@ -18,24 +18,39 @@ func init() {
 		Deps: map[string]string{
 			"github.com/goplus/llar/mod/module": "module",
 			"github.com/qiniu/x/gsh":            "gsh",
+			"fmt":                               "fmt",
 			"io/fs":                             "fs",
+			"maps":                              "maps",
+			"net/url":                           "url",
+			"os":                                "os",
+			"runtime":                           "runtime",
 			"slices":                            "slices",
 			"sort":                              "sort",
+			"strings":                           "strings",
 		},
 		Interfaces: map[string]reflect.Type{},
 		NamedTypes: map[string]reflect.Type{
 			"BuildResult": reflect.TypeOf((*q.BuildResult)(nil)).Elem(),
+			"Combination": reflect.TypeOf((*q.Combination)(nil)).Elem(),
 			"Context":     reflect.TypeOf((*q.Context)(nil)).Elem(),
+			"Env":         reflect.TypeOf((*q.Env)(nil)).Elem(),
 			"Matrix":      reflect.TypeOf((*q.Matrix)(nil)).Elem(),
 			"ModuleDeps":  reflect.TypeOf((*q.ModuleDeps)(nil)).Elem(),
 			"ModuleF":     reflect.TypeOf((*q.ModuleF)(nil)).Elem(),
+			"PkgConfig":   reflect.TypeOf((*q.PkgConfig)(nil)).Elem(),
 			"Project":     reflect.TypeOf((*q.Project)(nil)).Elem(),
 			"TestResult":  reflect.TypeOf((*q.TestResult)(nil)).Elem(),
 		},
 		AliasTypes: map[string]reflect.Type{},
 		Vars:       map[string]reflect.Value{},
 		Funcs: map[string]reflect.Value{
+			"BindEnv":           reflect.ValueOf(q.BindEnv),
+			"BoundEnv":          reflect.ValueOf(q.BoundEnv),
 			"Gopt_ModuleF_Main": reflect.ValueOf(q.Gopt_ModuleF_Main),
+			"MergePkgConfig":    reflect.ValueOf(q.MergePkgConfig),
+			"NewEnv":            reflect.ValueOf(q.NewEnv),
+			"ParseCombination":  reflect.ValueOf(q.ParseCombination),
+			"ParsePkgConfig":    reflect.ValueOf(q.ParsePkgConfig),
 		},
 		TypedConsts: map[string]ixgo.TypedConst{},
 		UntypedConsts: map[string]ixgo.UntypedConst{
@@ -15,10 +15,11 @@ func init() {
 		Name: "autotools",
 		Path: "github.com/goplus/llar/x/autotools",
 		Deps: map[string]string{
-			"os":            "os",
-			"os/exec":       "exec",
-			"path/filepath": "filepath",
-			"runtime":       "runtime",
+			"github.com/goplus/llar/formula": "formula",
+			"os":                             "os",
+			"os/exec":                        "exec",
+			"path/filepath":                  "filepath",
+			"runtime":                        "runtime",
 		},
 		Interfaces: map[string]reflect.Type{},
 		NamedTypes: map[string]reflect.Type{
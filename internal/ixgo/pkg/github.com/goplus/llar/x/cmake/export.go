@@ -15,11 +15,12 @@ func init() {
 		Name: "cmake",
 		Path: "github.com/goplus/llar/x/cmake",
 		Deps: map[string]string{
-			"os":            "os",
-			"os/exec":       "exec",
-			"path/filepath": "filepath",
-			"runtime":       "runtime",
-			"sort":          "sort",
+			"github.com/goplus/llar/formula": "formula",
+			"os":                             "os",
+			"os/exec":                        "exec",
+			"path/filepath":                  "filepath",
+			"runtime":                        "runtime",
+			"sort":                           "sort",
 		},
 		Interfaces: map[string]reflect.Type{},
 		NamedTypes: map[string]reflect.Type{
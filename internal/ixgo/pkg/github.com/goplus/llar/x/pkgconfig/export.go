@@ -0,0 +1,41 @@
+// export by github.com/goplus/ixgo/cmd/qexp
+
+package pkgconfig
+
+import (
+	q "github.com/goplus/llar/x/pkgconfig"
+
+	"reflect"
+
+	"github.com/goplus/ixgo"
+)
+
+func init() {
+	ixgo.RegisterPackage(&ixgo.Package{
+		Name: "pkgconfig",
+		Path: "github.com/goplus/llar/x/pkgconfig",
+		Deps: map[string]string{
+			"bufio":                          "bufio",
+			"bytes":                          "bytes",
+			"fmt":                            "fmt",
+			"github.com/goplus/llar/formula": "formula",
+			"io/fs":                          "fs",
+			"os":                             "os",
+			"path/filepath":                  "filepath",
+			"strings":                        "strings",
+		},
+		Interfaces: map[string]reflect.Type{},
+		NamedTypes: map[string]reflect.Type{
+			"File": reflect.TypeOf((*q.File)(nil)).Elem(),
+		},
+		AliasTypes: map[string]reflect.Type{},
+		Vars:       map[string]reflect.Value{},
+		Funcs: map[string]reflect.Value{
+			"Load":      reflect.ValueOf(q.Load),
+			"Parse":     reflect.ValueOf(q.Parse),
+			"ParseFile": reflect.ValueOf(q.ParseFile),
+		},
+		TypedConsts:   map[string]ixgo.TypedConst{},
+		UntypedConsts: map[string]ixgo.UntypedConst{},
+	})
+}
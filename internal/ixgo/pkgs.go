@@ -10,6 +10,7 @@ package ixgo
 //go:generate qexp -outdir pkg github.com/goplus/llar/x/gnu
 //go:generate qexp -outdir pkg github.com/goplus/llar/x/autotools
 //go:generate qexp -outdir pkg github.com/goplus/llar/x/cmake
+//go:generate qexp -outdir pkg github.com/goplus/llar/x/pkgconfig
 //go:generate qexp -outdir pkg github.com/goplus/llar/mod/module
 //go:generate qexp -outdir pkg github.com/qiniu/x/gsh
 import (
@@ -184,6 +185,7 @@ import (
 	_ "github.com/goplus/llar/internal/ixgo/pkg/github.com/goplus/llar/x/autotools"
 	_ "github.com/goplus/llar/internal/ixgo/pkg/github.com/goplus/llar/x/cmake"
 	_ "github.com/goplus/llar/internal/ixgo/pkg/github.com/goplus/llar/x/gnu"
+	_ "github.com/goplus/llar/internal/ixgo/pkg/github.com/goplus/llar/x/pkgconfig"
 
 	_ "github.com/goplus/llar/internal/ixgo/pkg/github.com/qiniu/x/gsh"
 	_ "github.com/goplus/llar/internal/ixgo/pkg/golang.org/x/mod/semver"
@@ -1,16 +1,11 @@
 package modules
 
 import (
-	"fmt"
 	"io/fs"
-	"path/filepath"
 	"reflect"
-	"strings"
 	"unsafe"
 
-	"github.com/goplus/ixgo"
-	"github.com/goplus/ixgo/xgobuild"
-	_ "github.com/goplus/llar/internal/ixgo"
+	"github.com/goplus/llar/internal/formula"
 	"github.com/goplus/llar/mod/module"
 )
 
@@ -22,41 +17,10 @@ import (
 //   - zero if v1 == v2
 //   - a positive value if v1 > v2
 func loadComparatorFS(fs fs.ReadFileFS, path string) (comparator func(v1, v2 module.Version) int, err error) {
-	ctx := ixgo.NewContext(0)
-
-	content, err := fs.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	source, err := xgobuild.BuildFile(ctx, path, content)
+	class, err := formula.LoadClass(fs, path)
 	if err != nil {
 		return nil, err
 	}
-	pkgs, err := ctx.LoadFile("main.go", source)
-	if err != nil {
-		return nil, err
-	}
-	interp, err := ctx.NewInterp(pkgs)
-	if err != nil {
-		return nil, err
-	}
-	if err = interp.RunInit(); err != nil {
-		return nil, err
-	}
-	structName, _, ok := strings.Cut(filepath.Base(path), "_")
-	if !ok {
-		return nil, fmt.Errorf("failed to load: file name is not valid: %s", path)
-	}
-	typ, ok := interp.GetType(structName)
-	if !ok {
-		return nil, fmt.Errorf("failed to load: struct name not found: %s", structName)
-	}
-	val := reflect.New(typ)
-	class := val.Elem()
-
-	val.Interface().(interface{ Main() }).Main()
-
 	return valueOf(class, "fCompareVer").(func(v1, v2 module.Version) int), nil
 }
 
@@ -1,14 +1,18 @@
 package modules
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"runtime"
 	"slices"
 	"strings"
 	"sync"
 
+	"github.com/goplus/llar/internal/archive"
 	"github.com/goplus/llar/internal/formula"
 	"github.com/goplus/llar/internal/formula/repo"
 	"github.com/goplus/llar/internal/mvs"
@@ -48,20 +52,83 @@ type Module struct {
 	Path    string
 	Version string
 
+	// Source is the repository holding the module's source code, either
+	// "host/owner/repo" or a git URL, as accepted by vcs.NewRepo. It is
+	// declared by the "source" field of versions.json and defaults to
+	// "github.com/<Path>". A repository replacement (see Replace) takes
+	// its place.
+	Source string
+
+	// Replace is the replacement of the module's source, or nil. A
+	// replaced module is built from the replacement directory, or from
+	// Source at Replace.New.Version, instead of its source archive or
+	// its own repository at Version.
+	Replace *Replace
+
+	// SourceCommit is the commit Version resolved to when it is pinned
+	// by a lock file (see Options.LockFile), or "" if Version is to be
+	// resolved at build time. It is always "" for source archives and
+	// for sources replaced by a local directory.
+	SourceCommit string
+
 	// Deps holds direct dependencies only (not transitive).
-	// For the main module, Deps contains all modules in the build list.
+	// For the main module, Deps contains all modules in the build list
+	// but those only required, directly or not, as tools.
 	// For non-main modules, Deps contains only the declared dependencies
 	// from versions.json. The build module can reconstruct the full
 	// dependency graph from these adjacency edges.
 	Deps []*Module
+
+	// Tools holds the direct tool dependencies (see
+	// formula.ModuleDeps.Tool). Like Deps, they take part in version
+	// selection, but the build module builds them for the host and does
+	// not link them.
+	Tools []*Module
 }
 
 // Options contains options for Load.
 type Options struct {
 	// FormulaStore is the store for downloading and caching formulas.
 	FormulaStore repo.Store
+
+	// LockFile is the path of a lock file (see Lock). If it exists, an
+	// empty main version is taken from it and every module version it
+	// lists is pinned to its locked source commit, which onRequire also
+	// reads the source at; after resolution the file is updated to
+	// record the build list, with a warning to Stderr if that changes
+	// the locked resolution. Empty disables locking.
+	LockFile string
+
+	// Locked makes Load fail, instead of updating LockFile, if the
+	// resolution differs from it. LockFile must exist.
+	Locked bool
+
+	// Replace replaces the source of modules, for example with a fork or
+	// a local checkout. Like the replace directives of go.mod, they apply
+	// to every module of the build list, not only the main one.
+	Replace []Replace
+
+	// Exclude lists module versions MVS must not select: requirements
+	// on them are ignored, so the next higher required version of the
+	// module, if any, is selected instead.
+	Exclude []module.Version
+
+	// Stderr receives warnings, such as the changes made to LockFile.
+	// Nil discards them.
+	Stderr io.Writer
+
+	// Matrix is the canonical encoding (see formula.Combination) of the
+	// matrix combination the main module is built for, so that the build
+	// list only holds the dependencies declared for it. onRequire of
+	// every module sees it as inherited by the matrix the module declares
+	// (see formula.Matrix.Inherit), options left out taking their
+	// defaults. Empty means no matrix values.
+	Matrix string
 }
 
+// newRepo opens the repository of a module source; tests replace it.
+var newRepo = vcs.NewRepo
+
 func latestVersion(ctx context.Context, modPath string, repo vcs.Repo, comparator func(v1, v2 module.Version) int) (version string, err error) {
 	tags, err := repo.Tags(ctx)
 	if err != nil {
@@ -79,12 +146,62 @@ func latestVersion(ctx context.Context, modPath string, repo vcs.Repo, comparato
 // formulaContext groups helper functions used throughout the Load process,
 // sharing a module cache and a filesystem provider across all steps.
 type formulaContext struct {
-	moduleCache sync.Map
-	moduleFS    func(ctx context.Context, modPath string) (fs.FS, error)
+	moduleCache  sync.Map
+	versionCache sync.Map // module path -> []string
+	moduleFS     func(ctx context.Context, modPath string) (fs.FS, error)
+	replace      replacements
+	exclude      map[module.Version]bool
+	lock         *Lock // the lock file onRequire reads sources at, or nil
+	matrix       classfile.Combination
+	matrices     sync.Map // module path -> *requiredMatrices
+	resolved     sync.Map // resolveKey -> requirements
+	requirements sync.Map // module.Version -> requirements
+}
+
+// requirements are the requirements of a module version, by kind. MVS
+// sees them together.
+type requirements struct {
+	deps, tools []module.Version
+	matrices    int // number of matrix combinations they were resolved for
+}
+
+// requiredMatrices are the matrix combinations a module is required for,
+// in the order found.
+type requiredMatrices struct {
+	mu   sync.Mutex
+	list []classfile.Combination
+}
+
+// resolveKey identifies the requirements of a module version resolved for
+// a matrix combination.
+type resolveKey struct {
+	mod    module.Version
+	matrix string
+}
+
+// all returns the requirements of every kind.
+func (r requirements) all() []module.Version {
+	return append(slices.Clip(r.deps), r.tools...)
 }
 
-func newFormulaContext(moduleFS func(ctx context.Context, modPath string) (fs.FS, error)) *formulaContext {
-	return &formulaContext{moduleFS: moduleFS}
+func newFormulaContext(opts Options) (*formulaContext, error) {
+	replace, err := newReplacements(opts.Replace)
+	if err != nil {
+		return nil, err
+	}
+	c := &formulaContext{moduleFS: opts.FormulaStore.ModuleFS, replace: replace}
+	if opts.Matrix != "" {
+		if c.matrix, err = classfile.ParseCombination(opts.Matrix); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Exclude) > 0 {
+		c.exclude = make(map[module.Version]bool, len(opts.Exclude))
+		for _, m := range opts.Exclude {
+			c.exclude[m] = true
+		}
+	}
+	return c, nil
 }
 
 // compareModuleVersion compares two versions of the same module path
@@ -118,17 +235,222 @@ func (c *formulaContext) moduleOf(ctx context.Context, modPath string) (*formula
 	return actual.(*formulaModule), nil
 }
 
-// loadDeps loads the declared dependencies for a specific module version.
-func (c *formulaContext) loadDeps(ctx context.Context, mod module.Version) (deps []module.Version, err error) {
-	thisMod, err := c.moduleOf(ctx, mod.Path)
+// versionsOf returns the available versions of modPath in ascending order:
+// the tags of its source repository that a formula applies to.
+func (c *formulaContext) versionsOf(ctx context.Context, modPath string) ([]string, error) {
+	if versions, ok := c.versionCache.Load(modPath); ok {
+		return versions.([]string), nil
+	}
+	mod, err := c.moduleOf(ctx, modPath)
 	if err != nil {
 		return nil, err
 	}
-	f, err := thisMod.at(mod.Version)
+	cmp, err := mod.comparator()
+	if err != nil {
+		return nil, err
+	}
+	oldest, err := mod.minFromVer(cmp)
+	if err != nil {
+		return nil, err
+	}
+	source, err := mod.source()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := newRepo(source)
 	if err != nil {
 		return nil, err
 	}
-	return resolveDeps(mod, thisMod.fsys.(fs.ReadFileFS), f)
+	tags, err := repo.Tags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of %s: %w", modPath, err)
+	}
+	version := func(v string) module.Version { return module.Version{Path: modPath, Version: v} }
+	versions := slices.DeleteFunc(slices.Clone(tags), func(v string) bool {
+		return cmp(version(v), version(oldest)) < 0
+	})
+	slices.SortFunc(versions, func(a, b string) int {
+		return cmp(version(a), version(b))
+	})
+	c.versionCache.Store(modPath, versions)
+	return versions, nil
+}
+
+// loadDeps loads the declared dependencies and tools for a specific
+// module version and records them for requirementsOf: those of every
+// matrix combination the module is required for so far (see
+// requireMatrix), or, if none, the one of matrixOf.
+func (c *formulaContext) loadDeps(ctx context.Context, mod module.Version) (requirements, error) {
+	thisMod, err := c.moduleOf(ctx, mod.Path)
+	if err != nil {
+		return requirements{}, err
+	}
+	f, err := thisMod.at(mod.Version)
+	if err != nil {
+		return requirements{}, err
+	}
+	matrices := c.matricesOf(mod.Path)
+	if len(matrices) == 0 {
+		matrix, err := c.matrixOf(f)
+		if err != nil {
+			return requirements{}, fmt.Errorf("%s@%s: %w", mod.Path, mod.Version, err)
+		}
+		c.requireMatrix(mod.Path, matrix)
+		matrices = []classfile.Combination{matrix}
+	}
+	reqs := requirements{matrices: len(matrices)}
+	for _, matrix := range matrices {
+		key := resolveKey{mod: mod, matrix: matrix.String()}
+		resolved, ok := c.resolved.Load(key)
+		if !ok {
+			rep := c.replace.lookup(mod)
+			deps, tools, err := resolveDeps(mod, thisMod.fsys.(fs.ReadFileFS), f, rep, c.lock.commit(mod, rep), matrix)
+			if err != nil {
+				return requirements{}, err
+			}
+			if err := c.requireMatrices(ctx, f, matrix, deps, tools); err != nil {
+				return requirements{}, fmt.Errorf("%s@%s: %w", mod.Path, mod.Version, err)
+			}
+			resolved, _ = c.resolved.LoadOrStore(key, requirements{deps: deps, tools: tools})
+		}
+		r := resolved.(requirements)
+		reqs.deps = appendNew(reqs.deps, r.deps...)
+		reqs.tools = appendNew(reqs.tools, r.tools...)
+	}
+	c.requirements.Store(mod, reqs)
+	return reqs, nil
+}
+
+// appendNew appends to list the versions of vers it does not hold.
+func appendNew(list []module.Version, vers ...module.Version) []module.Version {
+	for _, v := range vers {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// matrixOf returns the matrix combination a module with formula f sees in
+// onRequire when it is not required by another module: the one of the
+// main module as inherited by the matrix f declares, if any, which checks
+// it against that matrix.
+func (c *formulaContext) matrixOf(f *formula.Formula) (classfile.Combination, error) {
+	if len(c.matrix.Require) == 0 && len(c.matrix.Options) == 0 {
+		return classfile.Combination{}, nil
+	}
+	return inheritMatrix(f.Matrix, c.matrix, nil)
+}
+
+// requireMatrices records the matrix combinations of the dependencies and
+// tools a module with formula f declares when required for from, as the
+// build plans them: a dependency inherits from, overridden by the variant
+// f declares for it, and a tool the matrix of the host.
+func (c *formulaContext) requireMatrices(ctx context.Context, f *formula.Formula, from classfile.Combination, deps, tools []module.Version) error {
+	if len(c.matrix.Require) == 0 && len(c.matrix.Options) == 0 {
+		return nil
+	}
+	require := func(dep module.Version, from classfile.Combination, values map[string]string) error {
+		depMod, err := c.moduleOf(ctx, dep.Path)
+		if err != nil {
+			return nil // reported when loading dep, if selected
+		}
+		depFormula, err := depMod.at(dep.Version)
+		if err != nil {
+			return nil
+		}
+		matrix, err := inheritMatrix(depFormula.Matrix, from, values)
+		if err != nil {
+			return fmt.Errorf("requires %s: %w", dep.Path, err)
+		}
+		c.requireMatrix(dep.Path, matrix)
+		return nil
+	}
+	for _, dep := range deps {
+		if err := require(dep, from, f.Variants[dep.Path]); err != nil {
+			return err
+		}
+	}
+	for _, tool := range tools {
+		if err := require(tool, hostMatrix(), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireMatrix records that the module modPath is required for the matrix
+// combination matrix.
+func (c *formulaContext) requireMatrix(modPath string, matrix classfile.Combination) {
+	v, _ := c.matrices.LoadOrStore(modPath, &requiredMatrices{})
+	m := v.(*requiredMatrices)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := matrix.String()
+	if !slices.ContainsFunc(m.list, func(c classfile.Combination) bool { return c.String() == key }) {
+		m.list = append(m.list, matrix)
+	}
+}
+
+// matricesOf returns the matrix combinations the module modPath is
+// required for so far.
+func (c *formulaContext) matricesOf(modPath string) []classfile.Combination {
+	v, ok := c.matrices.Load(modPath)
+	if !ok {
+		return nil
+	}
+	m := v.(*requiredMatrices)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.list)
+}
+
+// matricesGrew reports whether a module version loaded was since required
+// for matrix combinations its requirements were not resolved for.
+func (c *formulaContext) matricesGrew() bool {
+	grew := false
+	c.requirements.Range(func(k, v any) bool {
+		grew = v.(requirements).matrices < len(c.matricesOf(k.(module.Version).Path))
+		return !grew
+	})
+	return grew
+}
+
+// inheritMatrix returns the matrix combination a module declaring matrix m
+// is built for when required for from, with values overriding (see
+// formula.Matrix.Inherit). A module declaring no matrix is built for from,
+// or, with values, for the os and arch of from and values, as in the build
+// plan.
+func inheritMatrix(m classfile.Matrix, from classfile.Combination, values map[string]string) (classfile.Combination, error) {
+	if len(m.Require) == 0 && len(m.Options) == 0 {
+		if len(values) == 0 {
+			return from, nil
+		}
+		m.Require = make(map[string][]string)
+		for _, k := range []string{"os", "arch"} {
+			v, ok := values[k]
+			if !ok {
+				v, ok = from.Require[k]
+			}
+			if ok {
+				m.Require[k] = []string{v}
+			}
+		}
+	}
+	return m.Inherit(from, values)
+}
+
+// hostMatrix returns the matrix combination of the host, which tools are
+// built for.
+func hostMatrix() classfile.Combination {
+	return classfile.Combination{Require: map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH}}
+}
+
+// requirementsOf returns the requirements of mod recorded by loadDeps.
+func (c *formulaContext) requirementsOf(mod module.Version) requirements {
+	reqs, _ := c.requirements.Load(mod)
+	r, _ := reqs.(requirements)
+	return r
 }
 
 // convertToModules converts a list of module.Version into loaded Module structs.
@@ -144,11 +466,20 @@ func (c *formulaContext) convertToModules(ctx context.Context, modList []module.
 		if err != nil {
 			return nil, err
 		}
+		source, err := thisMod.source()
+		if err != nil {
+			return nil, err
+		}
 		module := &Module{
 			Formula: f,
 			FS:      thisMod.fsys,
 			Path:    mod.Path,
 			Version: mod.Version,
+			Source:  source,
+			Replace: c.replace.lookup(mod),
+		}
+		if r := module.Replace; r != nil && r.Dir() == "" {
+			module.Source = r.New.Path
 		}
 		modules = append(modules, module)
 	}
@@ -156,44 +487,239 @@ func (c *formulaContext) convertToModules(ctx context.Context, modList []module.
 	return modules, nil
 }
 
+// Graph is the requirement graph of a main module as explored by MVS.
+// Its only root is Main; RequiredBy reports the requirements of every
+// module version visited, whether or not that version was selected.
+type Graph struct {
+	*mvs.Graph
+
+	// Main is the main module, with its version resolved.
+	Main module.Version
+
+	// BuildList holds the versions selected by MVS: Main first, then
+	// the remaining modules sorted by path.
+	BuildList []module.Version
+}
+
 // Load loads all packages required by the main module and resolves
 // their dependencies using the MVS algorithm. It returns modules for all
 // packages in the computed build list.
 func Load(ctx context.Context, main module.Version, opts Options) ([]*Module, error) {
-	if err := validateModulePath(main.Path); err != nil {
+	main, lock, err := lockedMain(main, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	context, err := newFormulaContext(opts)
+	if err != nil {
 		return nil, err
 	}
+	context.lock = lock
 
-	context := newFormulaContext(opts.FormulaStore.ModuleFS)
+	graph, err := context.loadGraph(ctx, main)
+	if err != nil {
+		return nil, err
+	}
+	main = graph.Main
 
-	mainMod, err := context.moduleOf(ctx, main.Path)
+	modules, err := context.convertToModules(ctx, graph.BuildList)
 	if err != nil {
 		return nil, err
 	}
-	if main.Version == "" {
-		cmp, err := mainMod.comparator()
-		if err != nil {
+
+	// fill the deps
+	for _, mod := range modules {
+		// only direct deps, this is because we don't know how to build
+		// so only keep necessary information to allow build compute the dependencies more flexibly
+		// However, the Deps in `project` must contain all dependencies,
+		// we will resolve all transitive dependencies in the build module.
+		mv := module.Version{mod.Path, mod.Version}
+		reqs, _ := graph.RequiredBy(mv)
+		kinds := context.requirementsOf(mv)
+		ofKind := func(list []module.Version) []module.Version {
+			return slices.DeleteFunc(slices.Clone(reqs), func(r module.Version) bool {
+				return !slices.ContainsFunc(list, func(m module.Version) bool { return m.Path == r.Path })
+			})
+		}
+		if mod.Deps, err = context.convertToModules(ctx, ofKind(kinds.deps)); err != nil {
 			return nil, err
 		}
-		// TODO(MeteorsLiu): Support different code host sites
-		latestRepo, err := vcs.NewRepo(fmt.Sprintf("github.com/%s", main.Path))
-		if err != nil {
+		if mod.Tools, err = context.convertToModules(ctx, ofKind(kinds.tools)); err != nil {
 			return nil, err
 		}
-		latest, err := latestVersion(ctx, main.Path, latestRepo, cmp)
-		if err != nil {
+	}
+	modules[0].Deps = mainDeps(modules)
+
+	if opts.LockFile != "" {
+		if err := applyLock(ctx, modules, lock, opts); err != nil {
 			return nil, err
 		}
-		main.Version = latest
 	}
-	mainFormula, err := mainMod.at(main.Version)
+	return modules, nil
+}
+
+// LoadMain resolves the version of main the way Load does and loads its
+// formula, without resolving its dependencies: the module returned has no
+// Deps. It lets a caller choose the Options.Matrix of Load from the matrix
+// of the main formula.
+func LoadMain(ctx context.Context, main module.Version, opts Options) (*Module, error) {
+	main, _, err := lockedMain(main, opts)
 	if err != nil {
 		return nil, err
 	}
-	mainDeps, err := resolveDeps(main, mainMod.fsys.(fs.ReadFileFS), mainFormula)
+	c, err := newFormulaContext(opts)
 	if err != nil {
 		return nil, err
 	}
+	main, _, _, err = c.resolveMain(ctx, main)
+	if err != nil {
+		return nil, err
+	}
+	mods, err := c.convertToModules(ctx, []module.Version{main})
+	if err != nil {
+		return nil, err
+	}
+	return mods[0], nil
+}
+
+// lockedMain returns main, with the version of the lock file of opts if
+// it has none, and the lock file, or nil if there is none or it is for
+// another module.
+func lockedMain(main module.Version, opts Options) (module.Version, *Lock, error) {
+	lock, err := readLockOption(opts)
+	if err != nil {
+		return module.Version{}, nil, err
+	}
+	if lock != nil {
+		if locked := lock.Modules[0]; locked.Path != main.Path {
+			if opts.Locked {
+				return module.Version{}, nil, fmt.Errorf("lock file %s is for %s, not %s", opts.LockFile, locked.Path, main.Path)
+			}
+			lock = nil
+		} else if main.Version == "" {
+			main.Version = locked.Version
+		}
+	}
+	return main, lock, nil
+}
+
+// mainDeps returns the modules of the build list, main first, the main
+// module depends on: all of them but those it only reaches through tool
+// requirements, which are built for the host.
+func mainDeps(modules []*Module) []*Module {
+	byPath := make(map[string]*Module, len(modules))
+	for _, m := range modules {
+		byPath[m.Path] = m
+	}
+	reach := func(withTools bool) map[string]bool {
+		seen := make(map[string]bool)
+		var visit func(m *Module)
+		visit = func(m *Module) {
+			if seen[m.Path] {
+				return
+			}
+			seen[m.Path] = true
+			reqs := m.Deps
+			if withTools {
+				reqs = append(slices.Clip(reqs), m.Tools...)
+			}
+			for _, req := range reqs {
+				if r, ok := byPath[req.Path]; ok {
+					visit(r)
+				}
+			}
+		}
+		visit(modules[0])
+		return seen
+	}
+	linked, reached := reach(false), reach(true)
+	return slices.DeleteFunc(slices.Clone(modules[1:]), func(m *Module) bool {
+		return reached[m.Path] && !linked[m.Path]
+	})
+}
+
+// LoadGraph loads the requirement graph of the main module, the same way
+// Load does, without preparing the modules for a build. An empty
+// main.Version selects the latest version.
+func LoadGraph(ctx context.Context, main module.Version, opts Options) (*Graph, error) {
+	c, err := newFormulaContext(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.loadGraph(ctx, main)
+}
+
+// loadGraph resolves the version of main if needed and runs MVS from it,
+// recording every requirement it loads in the returned Graph.
+func (c *formulaContext) loadGraph(ctx context.Context, main module.Version) (*Graph, error) {
+	for {
+		graph, err := c.loadGraphOnce(ctx, main)
+		if err != nil || !c.matricesGrew() {
+			return graph, err
+		}
+		// A module was found to be required for another matrix
+		// combination after its requirements were loaded: load them
+		// again, for every combination known.
+		c.requirements.Range(func(k, _ any) bool {
+			c.requirements.Delete(k)
+			return true
+		})
+	}
+}
+
+// loadGraphOnce runs MVS from main once, loading the requirements of every
+// module for the matrix combinations it is known to be required for.
+func (c *formulaContext) loadGraphOnce(ctx context.Context, main module.Version) (*Graph, error) {
+	reqs, main, err := c.newReqs(ctx, main)
+	if err != nil {
+		return nil, err
+	}
+
+	// The graph records the requirements MVS sees, without the excluded
+	// versions.
+	var graphMu sync.Mutex
+	graph := mvs.NewGraph(reqs.cmpVersion, []module.Version{main})
+	graph.Require(main, reqs.prune(reqs.roots))
+	load := reqs.onLoad
+	reqs.onLoad = func(mod module.Version) ([]module.Version, error) {
+		deps, err := load(mod)
+		if err != nil {
+			return nil, err
+		}
+		graphMu.Lock()
+		if _, ok := graph.RequiredBy(mod); !ok {
+			graph.Require(mod, reqs.prune(deps))
+		}
+		graphMu.Unlock()
+		return deps, nil
+	}
+
+	buildList, err := mvs.BuildList([]module.Version{main}, reqs)
+	if err != nil {
+		return nil, err
+	}
+	if err := reqs.checkDropped(buildList); err != nil {
+		return nil, err
+	}
+	return &Graph{Graph: graph, Main: main, BuildList: buildList}, nil
+}
+
+// newReqs resolves the version of main if needed and returns the
+// requirements MVS runs on from main, along with the resolved main.
+func (c *formulaContext) newReqs(ctx context.Context, main module.Version) (*mvsReqs, module.Version, error) {
+	main, _, mainFormula, err := c.resolveMain(ctx, main)
+	if err != nil {
+		return nil, module.Version{}, err
+	}
+	matrix, err := c.matrixOf(mainFormula)
+	if err != nil {
+		return nil, module.Version{}, fmt.Errorf("%s@%s: %w", main.Path, main.Version, err)
+	}
+	c.requireMatrix(main.Path, matrix)
+	mainReqs, err := c.loadDeps(ctx, main)
+	if err != nil {
+		return nil, module.Version{}, err
+	}
 	cmp := func(p, v1, v2 string) int {
 		// none is an internal version for MVS, which means the smallest
 		if v1 == "none" && v2 != "none" {
@@ -203,148 +729,191 @@ func Load(ctx context.Context, main module.Version, opts Options) ([]*Module, er
 		} else if v1 == "none" && v2 == "none" {
 			return 0
 		}
-		return context.compareModuleVersion(ctx, p, v1, v2)
+		return c.compareModuleVersion(ctx, p, v1, v2)
 	}
 
-	var depCache sync.Map
-	var graphMu sync.Mutex
-	graph := mvs.NewGraph(cmp, mainDeps)
+	reqs := &mvsReqs{
+		roots: mainReqs.all(),
+		isMain: func(v module.Version) bool {
+			return v.Path == main.Path && v.Version == main.Version
+		},
+		cmp:     cmp,
+		exclude: c.exclude,
+		versions: func(modPath string) ([]string, error) {
+			return c.versionsOf(ctx, modPath)
+		},
+	}
 
-	onLoad := func(mod module.Version) ([]module.Version, error) {
+	var depCache sync.Map
+	reqs.onLoad = func(mod module.Version) ([]module.Version, error) {
 		if deps, ok := depCache.Load(mod); ok {
 			return deps.([]module.Version), nil
 		}
-		deps, err := context.loadDeps(ctx, mod)
+		reqs, err := c.loadDeps(ctx, mod)
 		if err != nil {
 			return nil, err
 		}
-		graphMu.Lock()
-		graph.Require(mod, deps)
-		graphMu.Unlock()
-
+		deps := reqs.all()
 		depCache.Store(mod, deps)
 		return deps, nil
 	}
 
-	reqs := &mvsReqs{
-		roots: mainDeps,
-		isMain: func(v module.Version) bool {
-			return v.Path == main.Path && v.Version == main.Version
-		},
-		cmp:    cmp,
-		onLoad: onLoad,
+	return reqs, main, nil
+}
+
+// resolveMain resolves the version of main to the latest one if needed
+// and returns it with its formula module and formula.
+func (c *formulaContext) resolveMain(ctx context.Context, main module.Version) (module.Version, *formulaModule, *formula.Formula, error) {
+	if err := validateModulePath(main.Path); err != nil {
+		return module.Version{}, nil, nil, err
 	}
 
-	buildList, err := mvs.BuildList([]module.Version{main}, reqs)
+	mainMod, err := c.moduleOf(ctx, main.Path)
 	if err != nil {
-		return nil, err
+		return module.Version{}, nil, nil, err
 	}
-
-	modules, err := context.convertToModules(ctx, buildList)
+	if main.Version == "" {
+		cmp, err := mainMod.comparator()
+		if err != nil {
+			return module.Version{}, nil, nil, err
+		}
+		source, err := mainMod.source()
+		if err != nil {
+			return module.Version{}, nil, nil, err
+		}
+		latestRepo, err := newRepo(source)
+		if err != nil {
+			return module.Version{}, nil, nil, err
+		}
+		latest, err := latestVersion(ctx, main.Path, latestRepo, cmp)
+		if err != nil {
+			return module.Version{}, nil, nil, err
+		}
+		main.Version = latest
+	}
+	if c.exclude[main] {
+		return module.Version{}, nil, nil, fmt.Errorf("main module %s@%s is excluded", main.Path, main.Version)
+	}
+	mainFormula, err := mainMod.at(main.Version)
 	if err != nil {
-		return nil, err
+		return module.Version{}, nil, nil, err
 	}
+	return main, mainMod, mainFormula, nil
+}
 
-	// fill the deps
-	for _, mod := range modules {
-		var deps []*Module
-
-		if mod.Path == main.Path && mod.Version == main.Version {
-			deps = modules[1:]
-		} else {
-			// only direct deps, this is because we don't know how to build
-			// so only keep necessary information to allow build compute the dependencies more flexibly
-			// However, the Deps in `project` must contain all dependencies,
-			// we will resolve all transitive dependencies in the build module.
-			reqs, _ := graph.RequiredBy(module.Version{mod.Path, mod.Version})
-			deps, err = context.convertToModules(ctx, reqs)
-			if err != nil {
-				return nil, err
-			}
+// sourceFSOf returns a lazy view of the source of mod: its replacement rep,
+// if not nil, the source archive declared by its formula, or its
+// repository at mod.Version. A repository is read at commit instead, if
+// not empty.
+func sourceFSOf(mod module.Version, frla *formula.Formula, v *versions.Versions, rep *Replace, commit string) (fs.ReadFileFS, error) {
+	if rep != nil {
+		if dir := rep.Dir(); dir != "" {
+			return os.DirFS(dir).(fs.ReadFileFS), nil
 		}
-		mod.Deps = deps
+		repo, err := newRepo(rep.New.Path)
+		if err != nil {
+			return nil, err
+		}
+		return repo.At(cmp.Or(commit, rep.New.Version), "").(fs.ReadFileFS), nil
 	}
-
-	return modules, nil
+	if url := frla.ArchiveURL(mod.Version); url != "" {
+		dir, err := archive.DefaultCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		return archive.NewCache(dir).FS(url, frla.SourceSHA256).(fs.ReadFileFS), nil
+	}
+	repo, err := newRepo(sourceRepo(mod.Path, v))
+	if err != nil {
+		return nil, err
+	}
+	return repo.At(cmp.Or(commit, mod.Version), "").(fs.ReadFileFS), nil
 }
 
-// resolveDeps resolves the dependencies for a formula.
-// It first tries to get dependencies from the OnRequire callback,
-// then falls back to parsing versions.json if no dependencies are found.
-func resolveDeps(mod module.Version, modFS fs.ReadFileFS, frla *formula.Formula) ([]module.Version, error) {
+// resolveDeps resolves the dependencies and the tools for a formula.
+// It first tries to get them from the OnRequire callback, then falls back
+// to parsing versions.json, which only lists dependencies, if none are
+// found, unless onRequire read the matrix combination. The source
+// onRequire reads is that of the replacement rep, if not nil, at the
+// locked commit, if not empty, and the matrix combination it sees is
+// matrix.
+func resolveDeps(mod module.Version, modFS fs.ReadFileFS, frla *formula.Formula, rep *Replace, commit string, matrix classfile.Combination) (vers, tools []module.Version, err error) {
 	if err := validateModulePath(mod.Path); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var deps classfile.ModuleDeps
+	deps := classfile.NewModuleDeps(matrix)
 
-	// TODO(MeteorsLiu): Support different code host sites.
-	repo, err := vcs.NewRepo(fmt.Sprintf("github.com/%s", mod.Path))
+	content, err := modFS.ReadFile("versions.json")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	depTable, err := versions.Parse("", content)
+	if err != nil {
+		return nil, nil, err
 	}
+
 	// onRequire is optional
 	if frla.OnRequire != nil {
-		// TODO(MeteorsLiu): Design source cache dir
-		// In the most common case, onRequire only read one file like CMakelist.txt, etc.
-		// So missing cache here is acceptable.
-		tmpSourceDir, err := os.MkdirTemp("", fmt.Sprintf("source-%s-%s*", strings.ReplaceAll(mod.Path, "/", "-"), mod.Version))
+		// The source is served from the shared source (or archive) cache
+		// and only fetched when onRequire actually reads a file; onBuild
+		// reuses the same fetch later.
+		sourceFS, err := sourceFSOf(mod, frla, depTable, rep, commit)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		defer os.RemoveAll(tmpSourceDir)
-
-		repoFS := repo.At(mod.Version, tmpSourceDir)
 		proj := &classfile.Project{
-			SourceFS: repoFS.(fs.ReadFileFS),
+			SourceFS: sourceFS,
 		}
-		frla.OnRequire(proj, &deps)
+		frla.OnRequire(proj, deps)
 	}
 
-	content, err := modFS.ReadFile("versions.json")
-	if err != nil {
-		return nil, err
-	}
-	depTable, err := versions.Parse("", content)
-	if err != nil {
-		return nil, err
-	}
 	versionedDeps := depTable.Dependencies[mod.Version]
 
-	var vers []module.Version
-
 	// Reconcile onRequire deps with versions.json: fill in missing versions
 	// from the pinned table; unknown deps are safe to skip since MVS resolves
 	// them recursively through other paths in the dependency graph.
-	for _, dep := range deps.Deps() {
-		if err := validateModulePath(dep.Path); err != nil {
-			return nil, err
-		}
-		if dep.Version == "" {
-			// if a version of a dep input by onRequire is empty, try our best to resolve it.
-			idx := slices.IndexFunc(versionedDeps, func(depInTable module.Version) bool {
-				return depInTable.Path == dep.Path
-			})
-			if idx < 0 {
-				// It seems safe to drop deps here, because we resolve deps recursively and finally we will find that dep.
-				continue
+	reconcile := func(declared []module.Version) ([]module.Version, error) {
+		var vers []module.Version
+		for _, dep := range declared {
+			if err := validateModulePath(dep.Path); err != nil {
+				return nil, err
+			}
+			if dep.Version == "" {
+				// if a version of a dep input by onRequire is empty, try our best to resolve it.
+				idx := slices.IndexFunc(versionedDeps, func(depInTable module.Version) bool {
+					return depInTable.Path == dep.Path
+				})
+				if idx < 0 {
+					// It seems safe to drop deps here, because we resolve deps recursively and finally we will find that dep.
+					continue
+				}
+				dep.Version = versionedDeps[idx].Version
 			}
-			dep.Version = versionedDeps[idx].Version
-		}
 
-		vers = append(vers, module.Version{
-			Path:    dep.Path,
-			Version: dep.Version,
-		})
+			vers = append(vers, module.Version{
+				Path:    dep.Path,
+				Version: dep.Version,
+			})
+		}
+		return vers, nil
+	}
+	if vers, err = reconcile(deps.Deps()); err != nil {
+		return nil, nil, err
+	}
+	if tools, err = reconcile(deps.Tools()); err != nil {
+		return nil, nil, err
 	}
 
-	if len(vers) > 0 {
-		return vers, nil
+	// onRequire reading the matrix declares the dependencies of the
+	// combination it sees: declaring none is an answer, not a lack of one.
+	if len(vers) > 0 || len(tools) > 0 || deps.UsesMatrix() {
+		return vers, tools, nil
 	}
 
 	for _, dep := range versionedDeps {
 		if err := validateModulePath(dep.Path); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if dep.Version != "" {
 			vers = append(vers, module.Version{
@@ -354,5 +923,5 @@ func resolveDeps(mod module.Version, modFS fs.ReadFileFS, frla *formula.Formula)
 		}
 	}
 
-	return vers, nil
+	return vers, nil, nil
 }
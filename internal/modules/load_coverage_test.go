@@ -11,6 +11,7 @@ import (
 	"strings"
 	"testing"
 
+	classfile "github.com/goplus/llar/formula"
 	"github.com/goplus/llar/internal/formula"
 	"github.com/goplus/llar/internal/vcs"
 	"github.com/goplus/llar/mod/module"
@@ -62,6 +63,9 @@ func (m *mockLatestRepo) At(ref, localDir string) fs.FS          { return os.Dir
 func (m *mockLatestRepo) Sync(ctx context.Context, ref, path, localDir string) error {
 	return nil
 }
+func (m *mockLatestRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	return ref, nil
+}
 
 func TestLatestVersion_SelectsMaxByComparator(t *testing.T) {
 	repo := &mockLatestRepo{
@@ -122,7 +126,7 @@ func TestResolveDeps_InvalidModulePath(t *testing.T) {
 	mod := module.Version{Path: "", Version: "1.0.0"}
 	frla := &formula.Formula{ModPath: "", FromVer: "1.0.0"}
 
-	_, err := resolveDeps(mod, modFS, frla)
+	_, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err == nil {
 		t.Fatal("expected error for invalid module path")
 	}
@@ -214,7 +218,7 @@ func TestResolveDeps_InvalidDependencyPathFromVersions(t *testing.T) {
 	mod := module.Version{Path: "towner/main", Version: "1.0.0"}
 	frla := &formula.Formula{ModPath: "towner/main", FromVer: "1.0.0"}
 
-	_, err := resolveDeps(mod, modFS, frla)
+	_, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err == nil {
 		t.Fatal("expected error for invalid dependency path")
 	}
@@ -228,7 +232,7 @@ func TestResolveDeps_MissingVersionsFile(t *testing.T) {
 	mod := module.Version{Path: "towner/badcmp", Version: "1.0.0"}
 	frla := &formula.Formula{ModPath: "towner/badcmp", FromVer: "1.0.0"}
 
-	_, err := resolveDeps(mod, modFS, frla)
+	_, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err == nil {
 		t.Fatal("expected error for missing versions.json")
 	}
@@ -264,7 +268,9 @@ func TestLoad_EmptyVersion_LatestVersionTagsError(t *testing.T) {
 	}
 }
 
-func TestResolveDeps_OnRequireMkdirTempError(t *testing.T) {
+// TestResolveDeps_OnRequireNeedsNoTempDir verifies that onRequire reads
+// sources through the shared source cache instead of a temp dir.
+func TestResolveDeps_OnRequireNeedsNoTempDir(t *testing.T) {
 	tmpFile := filepath.Join(t.TempDir(), "tmp-file")
 	if err := os.WriteFile(tmpFile, []byte("not-a-dir"), 0644); err != nil {
 		t.Fatalf("write tmp file: %v", err)
@@ -277,9 +283,8 @@ func TestResolveDeps_OnRequireMkdirTempError(t *testing.T) {
 	modFS := os.DirFS("testdata/load/towner/withreq").(fs.ReadFileFS)
 	mod := module.Version{Path: "towner/withreq", Version: "1.0.0"}
 
-	_, err := resolveDeps(mod, modFS, frla)
-	if err == nil {
-		t.Fatal("expected MkdirTemp error")
+	if _, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{}); err != nil {
+		t.Fatalf("resolveDeps() error = %v", err)
 	}
 }
 
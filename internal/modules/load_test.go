@@ -27,6 +27,9 @@ func (m *mockVCSRepo) At(ref, localDir string) fs.FS              { return os.Di
 func (m *mockVCSRepo) Sync(ctx context.Context, ref, path, localDir string) error {
 	return nil
 }
+func (m *mockVCSRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	return ref, nil
+}
 
 // setupTestStore creates a repo.Store backed by a copy of testdataDir.
 func setupTestStore(t *testing.T, testdataDir string) repo.Store {
@@ -82,7 +85,7 @@ func TestResolveDeps_NoOnRequire_DepsFromVersionsJson(t *testing.T) {
 	}
 	mod := module.Version{Path: "towner/mainmod", Version: "1.0.0"}
 
-	deps, err := resolveDeps(mod, modFS, frla)
+	deps, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err != nil {
 		t.Fatalf("resolveDeps failed: %v", err)
 	}
@@ -102,7 +105,7 @@ func TestResolveDeps_NoOnRequire_NoDeps(t *testing.T) {
 	}
 	mod := module.Version{Path: "towner/leafmod", Version: "1.0.0"}
 
-	deps, err := resolveDeps(mod, modFS, frla)
+	deps, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err != nil {
 		t.Fatalf("resolveDeps failed: %v", err)
 	}
@@ -120,7 +123,7 @@ func TestResolveDeps_VersionNotInDepsTable(t *testing.T) {
 	// Version 9.9.9 doesn't exist in versions.json deps table
 	mod := module.Version{Path: "towner/mainmod", Version: "9.9.9"}
 
-	deps, err := resolveDeps(mod, modFS, frla)
+	deps, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err != nil {
 		t.Fatalf("resolveDeps failed: %v", err)
 	}
@@ -134,7 +137,7 @@ func TestResolveDeps_WithOnRequire_EchoOnly_FallbackToVersionsJson(t *testing.T)
 	modFS := os.DirFS("testdata/load/towner/withreq").(fs.ReadFileFS)
 	mod := module.Version{Path: "towner/withreq", Version: "1.0.0"}
 
-	deps, err := resolveDeps(mod, modFS, frla)
+	deps, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err != nil {
 		t.Fatalf("resolveDeps failed: %v", err)
 	}
@@ -152,7 +155,7 @@ func TestResolveDeps_WithOnRequire_AddsDeps(t *testing.T) {
 	modFS := os.DirFS("testdata/load/towner/withdeps").(fs.ReadFileFS)
 	mod := module.Version{Path: "towner/withdeps", Version: "1.0.0"}
 
-	deps, err := resolveDeps(mod, modFS, frla)
+	deps, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err != nil {
 		t.Fatalf("resolveDeps failed: %v", err)
 	}
@@ -171,7 +174,7 @@ func TestResolveDeps_WithOnRequire_EmptyVersionFallback(t *testing.T) {
 	modFS := os.DirFS("testdata/load/towner/reqnover").(fs.ReadFileFS)
 	mod := module.Version{Path: "towner/reqnover", Version: "1.0.0"}
 
-	deps, err := resolveDeps(mod, modFS, frla)
+	deps, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err != nil {
 		t.Fatalf("resolveDeps failed: %v", err)
 	}
@@ -196,7 +199,7 @@ func TestResolveDeps_WithOnRequire_UnknownDepDropped(t *testing.T) {
 	modFS := os.DirFS("testdata/load/towner/reqdrop").(fs.ReadFileFS)
 	mod := module.Version{Path: "towner/reqdrop", Version: "1.0.0"}
 
-	deps, err := resolveDeps(mod, modFS, frla)
+	deps, _, err := resolveDeps(mod, modFS, frla, nil, "", classfile.Combination{})
 	if err != nil {
 		t.Fatalf("resolveDeps failed: %v", err)
 	}
@@ -551,6 +554,167 @@ func TestLoad_DeepDiamondDeps_ResolvedSubgraph(t *testing.T) {
 	}
 }
 
+func TestLoad_Tools(t *testing.T) {
+	store := setupTestStore(t, "testdata/load")
+	// withtool depends on leafmod@1.0.0 and uses the tool toolmod@1.0.0,
+	// which depends on leafmod@2.0.0 and standalone@1.0.0.
+	main := module.Version{Path: "towner/withtool", Version: "1.0.0"}
+
+	modules, err := Load(context.Background(), main, Options{FormulaStore: store})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	paths := func(mods []*Module) []string {
+		var s []string
+		for _, m := range mods {
+			s = append(s, m.Path+"@"+m.Version)
+		}
+		return s
+	}
+
+	// Tools take part in version selection.
+	wantList := []string{"towner/withtool@1.0.0", "towner/leafmod@2.0.0", "towner/standalone@1.0.0", "towner/toolmod@1.0.0"}
+	if got := paths(modules); !slices.Equal(got, wantList) {
+		t.Errorf("build list = %v, want %v", got, wantList)
+	}
+	// But the main module does not depend on what only the tool needs.
+	if got, want := paths(modules[0].Deps), []string{"towner/leafmod@2.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("main Deps = %v, want %v", got, want)
+	}
+	if got, want := paths(modules[0].Tools), []string{"towner/toolmod@1.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("main Tools = %v, want %v", got, want)
+	}
+	tool := findModule(modules, "towner/toolmod")
+	if got, want := paths(tool.Deps), []string{"towner/leafmod@2.0.0", "towner/standalone@1.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("toolmod Deps = %v, want %v", got, want)
+	}
+}
+
+func TestLoad_Matrix(t *testing.T) {
+	store := setupTestStore(t, "testdata/load")
+	// withopt depends on leafmod@1.0.0, and on standalone@1.0.0 when built
+	// with the option ssl=on.
+	main := module.Version{Path: "towner/withopt", Version: "1.0.0"}
+
+	tests := []struct {
+		matrix string
+		want   []string
+	}{
+		{"", []string{"towner/withopt@1.0.0", "towner/leafmod@1.0.0"}},
+		{"arch=amd64,os=linux", []string{"towner/withopt@1.0.0", "towner/leafmod@1.0.0"}},
+		{"arch=amd64,os=linux|ssl=off", []string{"towner/withopt@1.0.0", "towner/leafmod@1.0.0"}},
+		{"arch=amd64,os=linux|ssl=on", []string{"towner/withopt@1.0.0", "towner/leafmod@1.0.0", "towner/standalone@1.0.0"}},
+	}
+	for _, tt := range tests {
+		modules, err := Load(context.Background(), main, Options{FormulaStore: store, Matrix: tt.matrix})
+		if err != nil {
+			t.Fatalf("Load(%q) failed: %v", tt.matrix, err)
+		}
+		var got []string
+		for _, m := range modules {
+			got = append(got, m.Path+"@"+m.Version)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Load(%q) build list = %v, want %v", tt.matrix, got, tt.want)
+		}
+	}
+
+	if _, err := Load(context.Background(), main, Options{FormulaStore: store, Matrix: "arch=mips,os=linux"}); err == nil {
+		t.Error("Load for a combination outside the matrix succeeded")
+	}
+}
+
+func TestLoad_MatrixConditionalOnly(t *testing.T) {
+	store := setupTestStore(t, "testdata/load")
+	// sslonly depends on standalone@1.0.0 only with the option ssl=on,
+	// although versions.json lists it.
+	main := module.Version{Path: "towner/sslonly", Version: "1.0.0"}
+
+	for matrix, want := range map[string][]string{
+		"arch=amd64,os=linux|ssl=off": {"towner/sslonly@1.0.0"},
+		"arch=amd64,os=linux|ssl=on":  {"towner/sslonly@1.0.0", "towner/standalone@1.0.0"},
+	} {
+		modules, err := Load(context.Background(), main, Options{FormulaStore: store, Matrix: matrix})
+		if err != nil {
+			t.Fatalf("Load(%q) failed: %v", matrix, err)
+		}
+		if got := buildListOf(modules); !slices.Equal(got, want) {
+			t.Errorf("Load(%q) build list = %v, want %v", matrix, got, want)
+		}
+	}
+}
+
+func TestLoad_MatrixOfRequirement(t *testing.T) {
+	store := setupTestStore(t, "testdata/load")
+	// Built for os=crossos, usecross requires sslonly for the variant
+	// ssl=on, which requires standalone, and uses the tool crosstool,
+	// which would require leafmod if built for os=crossos rather than for
+	// the host.
+	main := module.Version{Path: "towner/usecross", Version: "1.0.0"}
+
+	modules, err := Load(context.Background(), main, Options{FormulaStore: store, Matrix: "arch=amd64,os=crossos"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := []string{"towner/usecross@1.0.0", "towner/crosstool@1.0.0", "towner/sslonly@1.0.0", "towner/standalone@1.0.0"}
+	if got := buildListOf(modules); !slices.Equal(got, want) {
+		t.Errorf("build list = %v, want %v", got, want)
+	}
+}
+
+func TestLoadMain(t *testing.T) {
+	store := setupTestStore(t, "testdata/load")
+	m, err := LoadMain(context.Background(), module.Version{Path: "towner/withopt", Version: "1.0.0"}, Options{FormulaStore: store})
+	if err != nil {
+		t.Fatalf("LoadMain failed: %v", err)
+	}
+	if m.Version != "1.0.0" || m.Formula == nil || len(m.Deps) != 0 {
+		t.Errorf("LoadMain = %s@%s (formula %v, deps %v), want the formula of towner/withopt@1.0.0 without deps", m.Path, m.Version, m.Formula != nil, m.Deps)
+	}
+	if got := m.Matrix.Options["ssl"]; !slices.Equal(got, []string{"off", "on"}) {
+		t.Errorf("LoadMain matrix options ssl = %v, want [off on]", got)
+	}
+}
+
+func TestLoadGraph_Diamond(t *testing.T) {
+	store := setupTestStore(t, "testdata/load")
+	main := module.Version{Path: "towner/diamond", Version: "1.0.0"}
+
+	g, err := LoadGraph(context.Background(), main, Options{FormulaStore: store})
+	if err != nil {
+		t.Fatalf("LoadGraph failed: %v", err)
+	}
+	if g.Main != main {
+		t.Errorf("Main = %v, want %v", g.Main, main)
+	}
+	wantList := []module.Version{
+		main,
+		{Path: "towner/altdep", Version: "1.0.0"},
+		{Path: "towner/depmod", Version: "1.0.0"},
+		{Path: "towner/leafmod", Version: "2.0.0"},
+	}
+	if !slices.Equal(g.BuildList, wantList) {
+		t.Errorf("BuildList = %v, want %v", g.BuildList, wantList)
+	}
+
+	// The main module is the root, and the losing requirement on
+	// leafmod@1.0.0 is still recorded.
+	if reqs, _ := g.RequiredBy(main); len(reqs) != 2 {
+		t.Errorf("RequiredBy(main) = %v, want depmod and altdep", reqs)
+	}
+	path := g.FindPath(func(m module.Version) bool {
+		return m == module.Version{Path: "towner/leafmod", Version: "1.0.0"}
+	})
+	wantPath := []module.Version{
+		main,
+		{Path: "towner/depmod", Version: "1.0.0"},
+		{Path: "towner/leafmod", Version: "1.0.0"},
+	}
+	if !slices.Equal(path, wantPath) {
+		t.Errorf("FindPath(leafmod@1.0.0) = %v, want %v", path, wantPath)
+	}
+}
+
 func TestLoad_ErrorDepFormulaNotFound(t *testing.T) {
 	store := setupTestStore(t, "testdata/load")
 	ctx := context.Background()
@@ -577,6 +741,9 @@ func (m *failingSyncRepo) Sync(ctx context.Context, ref, path, localDir string)
 	}
 	return nil
 }
+func (m *failingSyncRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	return ref, nil
+}
 
 func TestLoad_ErrorModuleOfFails(t *testing.T) {
 	// Use a custom VCS repo that fails Sync for "towner/baddep"
@@ -0,0 +1,229 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/goplus/llar/mod/module"
+)
+
+// LockFileName is the conventional name of a lock file.
+const LockFileName = "llar.lock"
+
+// Lock records one exact resolution of a main module, so that it can be
+// reproduced later regardless of how the formula repository and the
+// upstream tags have moved since.
+type Lock struct {
+	// Modules lists the MVS build list: the main module first, then the
+	// other modules sorted by path.
+	Modules []LockedModule `json:"modules"`
+}
+
+// LockedModule is a module version of a Lock.
+type LockedModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+
+	// FormulaCommit is the commit of the formula repository the formula
+	// was read from; it is empty for local formulas.
+	FormulaCommit string `json:"formula_commit,omitempty"`
+
+	// FormulaSHA256 is the hex-encoded sha256 of the formula file.
+	FormulaSHA256 string `json:"formula_sha256"`
+
+	// Replace is the replacement of the source (see Replace), if any.
+	Replace string `json:"replace,omitempty"`
+
+	// Source is the commit Version resolved to, "sha256:<sum>" for a
+	// source archive, or "dir:<path>" for a source replaced by a local
+	// directory, which cannot be pinned.
+	Source string `json:"source"`
+}
+
+// ReadLock reads the lock file at name.
+func ReadLock(name string) (*Lock, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("invalid lock file %s: %w", name, err)
+	}
+	if len(lock.Modules) == 0 {
+		return nil, fmt.Errorf("invalid lock file %s: no modules", name)
+	}
+	return &lock, nil
+}
+
+func (l *Lock) encode() ([]byte, error) {
+	data, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// find returns the locked module path@version, or nil.
+func (l *Lock) find(path, version string) *LockedModule {
+	if l == nil {
+		return nil
+	}
+	for i := range l.Modules {
+		if m := &l.Modules[i]; m.Path == path && m.Version == version {
+			return m
+		}
+	}
+	return nil
+}
+
+// commit returns the source commit module version mod is locked to with
+// the replacement rep, or "" if it is not locked to a commit.
+func (l *Lock) commit(mod module.Version, rep *Replace) string {
+	var replace string
+	if rep != nil {
+		replace = rep.String()
+	}
+	m := l.find(mod.Path, mod.Version)
+	if m == nil || m.Replace != replace || strings.HasPrefix(m.Source, "sha256:") || strings.HasPrefix(m.Source, "dir:") {
+		return ""
+	}
+	return m.Source
+}
+
+// diff describes how l differs from old, one line per module version.
+// Formula commits are not compared: the formula repository moves whenever
+// any formula changes, and the formula hash already pins the content.
+func (l *Lock) diff(old *Lock) []string {
+	var lines []string
+	for _, m := range l.Modules {
+		o := old.find(m.Path, m.Version)
+		switch {
+		case o == nil:
+			lines = append(lines, fmt.Sprintf("+ %s@%s", m.Path, m.Version))
+		case o.FormulaSHA256 != m.FormulaSHA256:
+			lines = append(lines, fmt.Sprintf("%s@%s: formula sha256 %s, locked %s", m.Path, m.Version, m.FormulaSHA256, o.FormulaSHA256))
+		case o.Replace != m.Replace:
+			lines = append(lines, fmt.Sprintf("%s@%s: replacement %q, locked %q", m.Path, m.Version, m.Replace, o.Replace))
+		case o.Source != m.Source:
+			lines = append(lines, fmt.Sprintf("%s@%s: source %s, locked %s", m.Path, m.Version, m.Source, o.Source))
+		}
+	}
+	for _, o := range old.Modules {
+		if l.find(o.Path, o.Version) == nil {
+			lines = append(lines, fmt.Sprintf("- %s@%s", o.Path, o.Version))
+		}
+	}
+	return lines
+}
+
+// applyLock records the resolution of modules (a Load build list) in the
+// lock file opts.LockFile, whose previous content is old (nil if there was
+// none), and pins the source of every module to the locked commit.
+//
+// A module version already in old keeps its locked source commit; the
+// versions of other modules are resolved now. A resolution that differs
+// from old, for example because the formulas have moved, is written with
+// a warning to opts.Stderr. With opts.Locked, nothing is resolved or
+// written: any difference from old is an error.
+func applyLock(ctx context.Context, modules []*Module, old *Lock, opts Options) error {
+	lock := &Lock{Modules: make([]LockedModule, 0, len(modules))}
+	for _, mod := range modules {
+		data, err := fs.ReadFile(mod.FS, mod.File)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		formulaCommit, err := opts.FormulaStore.Revision(ctx, mod.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get formula commit of %s: %w", mod.Path, err)
+		}
+		locked := LockedModule{
+			Path:          mod.Path,
+			Version:       mod.Version,
+			FormulaCommit: formulaCommit,
+			FormulaSHA256: hex.EncodeToString(sum[:]),
+		}
+		ref := mod.Version
+		if mod.Replace != nil {
+			locked.Replace = mod.Replace.String()
+			ref = mod.Replace.New.Version
+		}
+		o := old.find(mod.Path, mod.Version)
+		switch {
+		case mod.Replace != nil && mod.Replace.Dir() != "":
+			locked.Source = "dir:" + mod.Replace.Dir()
+		case mod.Replace == nil && mod.ArchiveURL(mod.Version) != "":
+			locked.Source = "sha256:" + strings.ToLower(mod.SourceSHA256)
+		case o != nil && o.Replace == locked.Replace:
+			locked.Source = o.Source
+		case !opts.Locked:
+			repo, err := newRepo(mod.Source)
+			if err != nil {
+				return err
+			}
+			if locked.Source, err = repo.Resolve(ctx, ref); err != nil {
+				return fmt.Errorf("failed to resolve %s@%s: %w", mod.Path, mod.Version, err)
+			}
+		}
+		lock.Modules = append(lock.Modules, locked)
+	}
+
+	if opts.Locked {
+		if diff := lock.diff(old); len(diff) > 0 {
+			return fmt.Errorf("resolution differs from lock file %s:\n\t%s", opts.LockFile, strings.Join(diff, "\n\t"))
+		}
+	} else {
+		if old != nil && opts.Stderr != nil {
+			if diff := lock.diff(old); len(diff) > 0 {
+				fmt.Fprintf(opts.Stderr, "warning: resolution differs from lock file %s, updating it:\n\t%s\n", opts.LockFile, strings.Join(diff, "\n\t"))
+			}
+		}
+		if err := writeLockIfChanged(opts.LockFile, lock); err != nil {
+			return err
+		}
+	}
+
+	for i, mod := range modules {
+		if source := lock.Modules[i].Source; !strings.HasPrefix(source, "sha256:") && !strings.HasPrefix(source, "dir:") {
+			mod.SourceCommit = source
+		}
+	}
+	return nil
+}
+
+// writeLockIfChanged writes lock to name unless the file already holds it.
+func writeLockIfChanged(name string, lock *Lock) error {
+	data, err := lock.encode()
+	if err != nil {
+		return err
+	}
+	if old, err := os.ReadFile(name); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	return os.WriteFile(name, data, 0o644)
+}
+
+// readLockOption reads the lock file named by opts, returning nil if locking
+// is disabled or, outside of locked mode, the file does not exist yet.
+func readLockOption(opts Options) (*Lock, error) {
+	if opts.LockFile == "" {
+		if opts.Locked {
+			return nil, errors.New("locked mode requires a lock file")
+		}
+		return nil, nil
+	}
+	lock, err := ReadLock(opts.LockFile)
+	if errors.Is(err, fs.ErrNotExist) && !opts.Locked {
+		return nil, nil
+	}
+	return lock, err
+}
@@ -0,0 +1,236 @@
+package modules
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/internal/formula/repo"
+	"github.com/goplus/llar/internal/vcs"
+	"github.com/goplus/llar/mod/module"
+)
+
+// tagRepo is a vcs.Repo whose tags resolve to "<prefix>-<tag>".
+type tagRepo struct {
+	mockVCSRepo
+	prefix   *string
+	resolved *int
+}
+
+func (r *tagRepo) Resolve(ctx context.Context, ref string) (string, error) {
+	*r.resolved++
+	return *r.prefix + "-" + ref, nil
+}
+
+// withTagRepos makes Load resolve every source tag through a tagRepo and
+// returns the prefix of the resolved commits and the number of Resolve
+// calls made so far.
+func withTagRepos(t *testing.T) (prefix *string, resolved *int) {
+	t.Helper()
+	prefix, resolved = new(string), new(int)
+	*prefix = "commit"
+	orig := newRepo
+	newRepo = func(string) (vcs.Repo, error) {
+		return &tagRepo{prefix: prefix, resolved: resolved}, nil
+	}
+	t.Cleanup(func() { newRepo = orig })
+	return prefix, resolved
+}
+
+// setupLockTest copies testdata/load into a temp store and returns the
+// store, its directory and the path of a (not yet existing) lock file.
+func setupLockTest(t *testing.T) (repo.Store, string, string) {
+	t.Helper()
+	storeDir := t.TempDir()
+	if err := os.CopyFS(storeDir, os.DirFS("testdata/load")); err != nil {
+		t.Fatal(err)
+	}
+	return repo.New(storeDir, &mockVCSRepo{}), storeDir, filepath.Join(t.TempDir(), LockFileName)
+}
+
+func TestLoad_WritesLockFile(t *testing.T) {
+	_, resolved := withTagRepos(t)
+	store, _, lockFile := setupLockTest(t)
+
+	main := module.Version{Path: "towner/diamond", Version: "1.0.0"}
+	mods, err := Load(context.Background(), main, Options{FormulaStore: store, LockFile: lockFile})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	lock, err := ReadLock(lockFile)
+	if err != nil {
+		t.Fatalf("ReadLock failed: %v", err)
+	}
+	if len(lock.Modules) != len(mods) {
+		t.Fatalf("lock has %d modules, want %d", len(lock.Modules), len(mods))
+	}
+	for i, m := range lock.Modules {
+		mod := mods[i]
+		if m.Path != mod.Path || m.Version != mod.Version {
+			t.Errorf("lock.Modules[%d] = %s@%s, want %s@%s", i, m.Path, m.Version, mod.Path, mod.Version)
+		}
+		if want := "commit-" + mod.Version; m.Source != want || mod.SourceCommit != want {
+			t.Errorf("%s source = %q, SourceCommit = %q, want %q", m.Path, m.Source, mod.SourceCommit, want)
+		}
+		if len(m.FormulaSHA256) != 64 {
+			t.Errorf("%s formula_sha256 = %q", m.Path, m.FormulaSHA256)
+		}
+	}
+	if *resolved != len(mods) {
+		t.Errorf("resolved %d tags, want %d", *resolved, len(mods))
+	}
+}
+
+func TestLoad_HonorsLockFile(t *testing.T) {
+	prefix, resolved := withTagRepos(t)
+	store, _, lockFile := setupLockTest(t)
+	ctx := context.Background()
+
+	main := module.Version{Path: "towner/diamond", Version: "1.0.0"}
+	if _, err := Load(ctx, main, Options{FormulaStore: store, LockFile: lockFile}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// Every tag moves upstream; the locked commits are kept, and the main
+	// version comes from the lock file.
+	*prefix, *resolved = "moved", 0
+	mods, err := Load(ctx, module.Version{Path: main.Path}, Options{FormulaStore: store, LockFile: lockFile})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if mods[0].Version != "1.0.0" {
+		t.Errorf("main version = %q, want locked %q", mods[0].Version, "1.0.0")
+	}
+	for _, mod := range mods {
+		if want := "commit-" + mod.Version; mod.SourceCommit != want {
+			t.Errorf("%s SourceCommit = %q, want locked %q", mod.Path, mod.SourceCommit, want)
+		}
+	}
+	if *resolved != 0 {
+		t.Errorf("resolved %d tags, want 0", *resolved)
+	}
+}
+
+func TestLoad_Locked(t *testing.T) {
+	withTagRepos(t)
+	store, storeDir, lockFile := setupLockTest(t)
+	ctx := context.Background()
+	main := module.Version{Path: "towner/diamond", Version: "1.0.0"}
+	locked := Options{FormulaStore: store, LockFile: lockFile, Locked: true}
+
+	if _, err := Load(ctx, main, locked); err == nil {
+		t.Fatal("Load(--locked) without a lock file succeeded")
+	}
+	if _, err := Load(ctx, main, Options{FormulaStore: store, LockFile: lockFile}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := Load(ctx, main, locked); err != nil {
+		t.Fatalf("Load(--locked) with a matching lock file failed: %v", err)
+	}
+
+	// A changed formula no longer matches the lock file.
+	formulaFile := filepath.Join(storeDir, "towner", "leafmod", "2.0.0", "Leafmod_llar.gox")
+	data, err := os.ReadFile(formulaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(formulaFile, append(data, "\n// changed\n"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(lockFile)
+	_, err = Load(ctx, main, locked)
+	if err == nil || !strings.Contains(err.Error(), "resolution differs from lock file") || !strings.Contains(err.Error(), "towner/leafmod@2.0.0: formula sha256") {
+		t.Fatalf("Load(--locked) error = %v, want formula mismatch of leafmod", err)
+	}
+	if after, _ := os.ReadFile(lockFile); string(after) != string(before) {
+		t.Error("lock file was rewritten in locked mode")
+	}
+
+	// Outside of locked mode, the lock file is updated with a warning.
+	var stderr strings.Builder
+	if _, err := Load(ctx, main, Options{FormulaStore: store, LockFile: lockFile, Stderr: &stderr}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "resolution differs from lock file") || !strings.Contains(stderr.String(), "towner/leafmod@2.0.0: formula sha256") {
+		t.Errorf("warning = %q, want formula mismatch of leafmod", stderr.String())
+	}
+	if after, _ := os.ReadFile(lockFile); string(after) == string(before) {
+		t.Error("lock file was not updated")
+	}
+	if _, err := Load(ctx, main, locked); err != nil {
+		t.Fatalf("Load(--locked) with the updated lock file failed: %v", err)
+	}
+
+	// A lock file for another module is rejected.
+	other := module.Version{Path: "towner/depmod", Version: "1.0.0"}
+	if _, err := Load(ctx, other, locked); err == nil || !strings.Contains(err.Error(), "is for towner/diamond") {
+		t.Errorf("Load(--locked) of another module error = %v", err)
+	}
+}
+
+// refRepo is a tagRepo whose source at a ref is the directory dirs[ref].
+type refRepo struct {
+	tagRepo
+	dirs map[string]string
+}
+
+func (r *refRepo) At(ref, localDir string) fs.FS { return os.DirFS(r.dirs[ref]) }
+
+func TestLoad_OnRequireReadsLockedCommit(t *testing.T) {
+	store, leafDir := setupReplaceTest(t)
+	lockFile := filepath.Join(t.TempDir(), LockFileName)
+	depDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(depDir, "CMakeLists.txt"), []byte("find_package(depmod REQUIRED)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prefix, resolved := withTagRepos(t)
+	dirs := map[string]string{"1.0.0": leafDir, "commit-1.0.0": leafDir}
+	newRepo = func(string) (vcs.Repo, error) {
+		return &refRepo{tagRepo: tagRepo{prefix: prefix, resolved: resolved}, dirs: dirs}, nil
+	}
+	ctx := context.Background()
+	main := module.Version{Path: "towner/cmakereq", Version: "1.0.0"}
+	want := []string{"towner/cmakereq@1.0.0", "towner/leafmod@1.0.0"}
+
+	mods, err := Load(ctx, main, Options{FormulaStore: store, LockFile: lockFile})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := buildListOf(mods); !slices.Equal(got, want) {
+		t.Fatalf("build list = %v, want %v", got, want)
+	}
+
+	// The tag moves to a source finding depmod; onRequire still reads the
+	// locked commit.
+	dirs["1.0.0"] = depDir
+	mods, err = Load(ctx, main, Options{FormulaStore: store, LockFile: lockFile, Locked: true})
+	if err != nil {
+		t.Fatalf("Load(--locked) failed: %v", err)
+	}
+	if got := buildListOf(mods); !slices.Equal(got, want) {
+		t.Errorf("build list = %v, want locked %v", got, want)
+	}
+}
+
+func TestLock_Diff(t *testing.T) {
+	old := &Lock{Modules: []LockedModule{
+		{Path: "a", Version: "1.0.0", FormulaSHA256: "f", Source: "s"},
+		{Path: "b", Version: "1.0.0", FormulaSHA256: "f", Source: "s"},
+		{Path: "c", Version: "1.0.0", FormulaSHA256: "f", Source: "s"},
+	}}
+	cur := &Lock{Modules: []LockedModule{
+		{Path: "a", Version: "1.0.0", FormulaSHA256: "f", Source: "s", FormulaCommit: "new"},
+		{Path: "b", Version: "1.1.0", FormulaSHA256: "f", Source: "s"},
+		{Path: "c", Version: "1.0.0", FormulaSHA256: "f", Source: "t"},
+	}}
+	got := strings.Join(cur.diff(old), "\n")
+	want := "+ b@1.1.0\nc@1.0.0: source t, locked s\n- b@1.0.0"
+	if got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -1,33 +1,79 @@
 package modules
 
 import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
 	"github.com/goplus/llar/internal/mvs"
 	"github.com/goplus/llar/mod/module"
 )
 
-var _ mvs.Reqs = (*mvsReqs)(nil)
+var (
+	_ mvs.UpgradeReqs   = (*mvsReqs)(nil)
+	_ mvs.DowngradeReqs = (*mvsReqs)(nil)
+)
 
 // mvsReqs implements mvs.Reqs for module,
-// with any exclusions or replacements applied internally.
+// with any exclusions applied internally. Replacements only change where
+// the source of a module is read from, so they are applied when loading
+// requirements (see onLoad).
 type mvsReqs struct {
-	roots  []module.Version
-	isMain func(module.Version) bool
-	cmp    func(p string, v1, v2 string) int
-	onLoad func(module.Version) ([]module.Version, error)
+	roots   []module.Version
+	isMain  func(module.Version) bool
+	cmp     func(p string, v1, v2 string) int
+	onLoad  func(module.Version) ([]module.Version, error)
+	exclude map[module.Version]bool
+
+	// versions returns the available versions of a module path, in
+	// ascending order. Without it, nothing is upgraded or downgraded.
+	versions func(path string) ([]string, error)
+
+	mu      sync.Mutex
+	dropped map[string]bool // paths of the excluded versions required
 }
 
 func (r *mvsReqs) Required(mod module.Version) ([]module.Version, error) {
 	if r.isMain(mod) {
 		// Use the build list as it existed when r was constructed, not the current
 		// global build list.
-		return r.roots, nil
+		return r.prune(r.roots), nil
 	}
 
 	if mod.Version == "none" {
 		return nil, nil
 	}
 
-	return r.onLoad(mod)
+	deps, err := r.onLoad(mod)
+	if err != nil {
+		return nil, err
+	}
+	return r.prune(deps), nil
+}
+
+// prune returns reqs without the requirements on excluded versions, like
+// the go command ignores them. It remembers what it dropped so that the
+// caller can report a module none of whose required versions is left.
+func (r *mvsReqs) prune(reqs []module.Version) []module.Version {
+	if len(r.exclude) == 0 {
+		return reqs
+	}
+	kept := make([]module.Version, 0, len(reqs))
+	for _, m := range reqs {
+		if !r.exclude[m] {
+			kept = append(kept, m)
+			continue
+		}
+		r.mu.Lock()
+		if r.dropped == nil {
+			r.dropped = make(map[string]bool)
+		}
+		r.dropped[m.Path] = true
+		r.mu.Unlock()
+	}
+	return kept
 }
 
 // Max returns the maximum of v1 and v2 according to custom comparator.
@@ -43,11 +89,65 @@ func (r *mvsReqs) Max(p string, v1, v2 string) string {
 	return v1
 }
 
-// Upgrade is a no-op, here to implement mvs.Reqs.
-func (*mvsReqs) Upgrade(m module.Version) (module.Version, error) {
+// checkDropped reports the module paths that were only required at
+// excluded versions, and so are missing from the build list. Unlike a Go
+// package, a dependency cannot just be left out of a build.
+func (r *mvsReqs) checkDropped(buildList []module.Version) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var missing []string
+	for _, path := range slices.Sorted(maps.Keys(r.dropped)) {
+		if !slices.ContainsFunc(buildList, func(m module.Version) bool { return m.Path == path }) {
+			missing = append(missing, path)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("every required version of %s is excluded", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Upgrade returns the latest available version of m.Path that is not
+// excluded, or m if it is not older than that.
+func (r *mvsReqs) Upgrade(m module.Version) (module.Version, error) {
+	if r.versions == nil {
+		return m, nil
+	}
+	versions, err := r.versions(m.Path)
+	if err != nil {
+		return module.Version{}, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := module.Version{Path: m.Path, Version: versions[i]}
+		if r.exclude[v] {
+			continue
+		}
+		if r.cmpVersion(m.Path, v.Version, m.Version) > 0 {
+			return v, nil
+		}
+		break
+	}
 	return m, nil
 }
 
+// Previous returns the available version of m.Path immediately prior to
+// m.Version that is not excluded, or "none" if there is none.
+func (r *mvsReqs) Previous(m module.Version) (module.Version, error) {
+	if r.versions != nil {
+		versions, err := r.versions(m.Path)
+		if err != nil {
+			return module.Version{}, err
+		}
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := module.Version{Path: m.Path, Version: versions[i]}
+			if !r.exclude[v] && r.cmpVersion(m.Path, v.Version, m.Version) < 0 {
+				return v, nil
+			}
+		}
+	}
+	return module.Version{Path: m.Path, Version: "none"}, nil
+}
+
 // cmpVersion implements the comparison for versions in the module loader.
 //
 // As a special case, the version "" is considered higher than all other versions.
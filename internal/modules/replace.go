@@ -0,0 +1,152 @@
+package modules
+
+import (
+	"fmt"
+	stdbuild "go/build"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goplus/llar/mod/module"
+)
+
+// Replace replaces the source of a module, like a replace directive of
+// go.mod. The formula of the replaced module still resolves its
+// dependencies and builds it; only the source it reads comes from New.
+type Replace struct {
+	// Old is the module replaced. An empty Old.Version replaces every
+	// version; a replacement of a specific version takes precedence.
+	Old module.Version
+
+	// New is the replacement. If New.Path is a local directory (an
+	// absolute path or one starting with ./ or ../), the directory holds
+	// the source and New.Version must be empty. Otherwise New.Path is a
+	// repository, "host/owner/repo" or a git URL as accepted by
+	// vcs.NewRepo, and New.Version the version (a tag or a commit) to
+	// check out, which defaults to the version replaced.
+	New module.Version
+}
+
+// Dir returns the local directory replacing the source, or "" if the
+// source is replaced by a repository.
+func (r *Replace) Dir() string {
+	if isLocalPath(r.New.Path) {
+		return r.New.Path
+	}
+	return ""
+}
+
+// String returns r in the form accepted by ParseReplace.
+func (r *Replace) String() string {
+	return versionString(r.Old) + "=" + versionString(r.New)
+}
+
+func versionString(m module.Version) string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return m.Path + "@" + m.Version
+}
+
+func isLocalPath(path string) bool {
+	return stdbuild.IsLocalImport(path) || filepath.IsAbs(path)
+}
+
+// ParseReplace parses a replacement of the form "old[@v]=new[@v]", as
+// accepted by "go mod edit -replace", where new is a repository or a local
+// directory.
+func ParseReplace(s string) (Replace, error) {
+	oldStr, newStr, ok := strings.Cut(s, "=")
+	if !ok {
+		return Replace{}, fmt.Errorf("invalid replacement %q: want old[@v]=new[@v]", s)
+	}
+	var r Replace
+	r.Old.Path, r.Old.Version = splitVersion(oldStr)
+	if err := validateModulePath(r.Old.Path); err != nil {
+		return Replace{}, fmt.Errorf("invalid replacement %q: %w", s, err)
+	}
+	if isLocalPath(newStr) {
+		r.New.Path = newStr
+	} else {
+		r.New.Path, r.New.Version = splitVersion(newStr)
+	}
+	if r.New.Path == "" {
+		return Replace{}, fmt.Errorf("invalid replacement %q: empty replacement", s)
+	}
+	return r, nil
+}
+
+// ParseExclude parses an excluded module version of the form "path@v".
+func ParseExclude(s string) (module.Version, error) {
+	var m module.Version
+	m.Path, m.Version = splitVersion(s)
+	if m.Version == "" {
+		return module.Version{}, fmt.Errorf("invalid exclusion %q: want path@version", s)
+	}
+	if err := validateModulePath(m.Path); err != nil {
+		return module.Version{}, fmt.Errorf("invalid exclusion %q: %w", s, err)
+	}
+	return m, nil
+}
+
+// splitVersion splits s at its last "@" if what follows looks like a
+// version rather than a part of a git URL such as "git@host:path".
+func splitVersion(s string) (path, version string) {
+	i := strings.LastIndex(s, "@")
+	if i < 0 || strings.ContainsAny(s[i+1:], "/:") {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+// replacements looks up the replacement of module versions.
+type replacements []Replace
+
+// newReplacements checks the replacements in list and makes their local
+// directories absolute.
+func newReplacements(list []Replace) (replacements, error) {
+	rs := slices.Clone(list)
+	for i := range rs {
+		r := &rs[i]
+		if r.Dir() == "" {
+			continue
+		}
+		if r.New.Version != "" {
+			return nil, fmt.Errorf("invalid replacement %s: a directory replacement has no version", r.String())
+		}
+		dir, err := filepath.Abs(r.New.Path)
+		if err != nil {
+			return nil, err
+		}
+		r.New.Path = dir
+	}
+	return rs, nil
+}
+
+// lookup returns the replacement of mod, or nil. The version of a
+// repository replacement is filled in.
+func (rs replacements) lookup(mod module.Version) *Replace {
+	var found *Replace
+	for i := range rs {
+		r := &rs[i]
+		if r.Old.Path != mod.Path {
+			continue
+		}
+		if r.Old.Version == mod.Version {
+			found = r
+			break
+		}
+		if r.Old.Version == "" && found == nil {
+			found = r
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	r := *found
+	r.Old.Version = mod.Version
+	if r.Dir() == "" && r.New.Version == "" {
+		r.New.Version = mod.Version
+	}
+	return &r
+}
@@ -0,0 +1,223 @@
+package modules
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/internal/formula/repo"
+	"github.com/goplus/llar/internal/vcs"
+	"github.com/goplus/llar/mod/module"
+)
+
+func TestParseReplace(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Replace
+		wantErr bool
+	}{
+		{"madler/zlib=github.com/ourorg/zlib", Replace{module.Version{"madler/zlib", ""}, module.Version{"github.com/ourorg/zlib", ""}}, false},
+		{"madler/zlib@v1.3.1=github.com/ourorg/zlib@v1.3.1-fix", Replace{module.Version{"madler/zlib", "v1.3.1"}, module.Version{"github.com/ourorg/zlib", "v1.3.1-fix"}}, false},
+		{"madler/zlib=git@github.com:ourorg/zlib.git", Replace{module.Version{"madler/zlib", ""}, module.Version{"git@github.com:ourorg/zlib.git", ""}}, false},
+		{"madler/zlib=https://gitlab.com/ourorg/zlib.git@abc123", Replace{module.Version{"madler/zlib", ""}, module.Version{"https://gitlab.com/ourorg/zlib.git", "abc123"}}, false},
+		{"madler/zlib=../zlib", Replace{module.Version{"madler/zlib", ""}, module.Version{"../zlib", ""}}, false},
+		{"madler/zlib=/src/zlib@dev", Replace{module.Version{"madler/zlib", ""}, module.Version{"/src/zlib@dev", ""}}, false},
+		{"madler/zlib", Replace{}, true},
+		{"madler/zlib=", Replace{}, true},
+		{"./zlib=ourorg/zlib", Replace{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReplace(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseReplace(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReplace(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if err == nil && !strings.Contains(tt.in, "/src/") {
+			if s := got.String(); s != tt.in {
+				t.Errorf("ParseReplace(%q).String() = %q", tt.in, s)
+			}
+		}
+	}
+}
+
+func TestParseExclude(t *testing.T) {
+	got, err := ParseExclude("madler/zlib@v1.2.11")
+	if err != nil || got != (module.Version{Path: "madler/zlib", Version: "v1.2.11"}) {
+		t.Errorf("ParseExclude() = %v, %v", got, err)
+	}
+	for _, in := range []string{"madler/zlib", "madler/zlib@", "@v1.0.0"} {
+		if _, err := ParseExclude(in); err == nil {
+			t.Errorf("ParseExclude(%q) succeeded", in)
+		}
+	}
+}
+
+func TestReplacements_Lookup(t *testing.T) {
+	rs, err := newReplacements([]Replace{
+		{Old: module.Version{Path: "a"}, New: module.Version{Path: "fork/a"}},
+		{Old: module.Version{Path: "a", Version: "2.0.0"}, New: module.Version{Path: "./a"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := rs.lookup(module.Version{Path: "a", Version: "1.0.0"}); r == nil || r.New != (module.Version{Path: "fork/a", Version: "1.0.0"}) {
+		t.Errorf("lookup(a@1.0.0) = %+v, want fork/a@1.0.0", r)
+	}
+	abs, _ := filepath.Abs("a")
+	if r := rs.lookup(module.Version{Path: "a", Version: "2.0.0"}); r == nil || r.Dir() != abs {
+		t.Errorf("lookup(a@2.0.0) = %+v, want directory %s", r, abs)
+	}
+	if r := rs.lookup(module.Version{Path: "b", Version: "1.0.0"}); r != nil {
+		t.Errorf("lookup(b@1.0.0) = %+v, want nil", r)
+	}
+
+	_, err = newReplacements([]Replace{{Old: module.Version{Path: "a"}, New: module.Version{Path: "./a", Version: "1.0.0"}}})
+	if err == nil {
+		t.Error("newReplacements accepted a directory replacement with a version")
+	}
+}
+
+// setupReplaceTest returns a store in which towner/cmakereq@1.0.0 requires
+// depmod and leafmod according to versions.json, while its onRequire reads
+// them from the find_package calls of CMakeLists.txt, and a source
+// directory whose CMakeLists.txt only finds leafmod.
+func setupReplaceTest(t *testing.T) (repo.Store, string) {
+	t.Helper()
+	storeDir := t.TempDir()
+	if err := os.CopyFS(storeDir, os.DirFS("testdata/load")); err != nil {
+		t.Fatal(err)
+	}
+	versionsJSON := `{
+	"path": "towner/cmakereq",
+	"deps": {
+		"1.0.0": [
+			{"path": "towner/depmod", "version": "1.0.0"},
+			{"path": "towner/leafmod", "version": "1.0.0"}
+		]
+	}
+}`
+	if err := os.WriteFile(filepath.Join(storeDir, "towner", "cmakereq", "versions.json"), []byte(versionsJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sourceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sourceDir, "CMakeLists.txt"), []byte("find_package(leafmod REQUIRED)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return repo.New(storeDir, &mockVCSRepo{}), sourceDir
+}
+
+func buildListOf(mods []*Module) []string {
+	var list []string
+	for _, m := range mods {
+		list = append(list, m.Path+"@"+m.Version)
+	}
+	return list
+}
+
+func TestLoad_ReplaceDir(t *testing.T) {
+	store, sourceDir := setupReplaceTest(t)
+	ctx := context.Background()
+	main := module.Version{Path: "towner/cmakereq", Version: "1.0.0"}
+
+	mods, err := Load(ctx, main, Options{FormulaStore: store})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got, want := buildListOf(mods), []string{"towner/cmakereq@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@1.0.0"}; !slices.Equal(got, want) {
+		t.Fatalf("build list = %q, want %q", got, want)
+	}
+
+	replace := Replace{Old: module.Version{Path: main.Path}, New: module.Version{Path: sourceDir}}
+	mods, err = Load(ctx, main, Options{FormulaStore: store, Replace: []Replace{replace}})
+	if err != nil {
+		t.Fatalf("Load with replacement failed: %v", err)
+	}
+	if got, want := buildListOf(mods), []string{"towner/cmakereq@1.0.0", "towner/leafmod@1.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("build list = %q, want %q", got, want)
+	}
+	if r := mods[0].Replace; r == nil || r.Dir() != sourceDir {
+		t.Errorf("Replace = %+v, want directory %s", r, sourceDir)
+	}
+	if mods[1].Replace != nil {
+		t.Errorf("%s is replaced: %+v", mods[1].Path, mods[1].Replace)
+	}
+}
+
+// forkRepo serves the source of dir for every ref and records the refs.
+type forkRepo struct {
+	mockVCSRepo
+	dir  string
+	refs *[]string
+}
+
+func (r *forkRepo) At(ref, localDir string) fs.FS {
+	*r.refs = append(*r.refs, ref)
+	return os.DirFS(r.dir)
+}
+
+func TestLoad_ReplaceRepo(t *testing.T) {
+	store, sourceDir := setupReplaceTest(t)
+	var repos, refs []string
+	orig := newRepo
+	newRepo = func(source string) (vcs.Repo, error) {
+		repos = append(repos, source)
+		return &forkRepo{dir: sourceDir, refs: &refs}, nil
+	}
+	defer func() { newRepo = orig }()
+
+	main := module.Version{Path: "towner/cmakereq", Version: "1.0.0"}
+	replace, _ := ParseReplace("towner/cmakereq@1.0.0=github.com/fork/cmakereq@1.0.0-fix")
+	mods, err := Load(context.Background(), main, Options{FormulaStore: store, Replace: []Replace{replace}})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got, want := buildListOf(mods), []string{"towner/cmakereq@1.0.0", "towner/leafmod@1.0.0"}; !slices.Equal(got, want) {
+		t.Errorf("build list = %q, want %q", got, want)
+	}
+	if !slices.Equal(repos, []string{"github.com/fork/cmakereq"}) || !slices.Equal(refs, []string{"1.0.0-fix"}) {
+		t.Errorf("read source from %q at %q, want the fork at 1.0.0-fix", repos, refs)
+	}
+	if mods[0].Source != "github.com/fork/cmakereq" || mods[0].Replace.New.Version != "1.0.0-fix" {
+		t.Errorf("Source = %q, Replace = %+v", mods[0].Source, mods[0].Replace)
+	}
+}
+
+func TestLoad_Exclude(t *testing.T) {
+	store := setupTestStore(t, "testdata/load")
+	ctx := context.Background()
+	main := module.Version{Path: "towner/diamond", Version: "1.0.0"}
+	leafmod2 := module.Version{Path: "towner/leafmod", Version: "2.0.0"}
+
+	// altdep requires leafmod@2.0.0; excluding it leaves depmod's 1.0.0.
+	g, err := LoadGraph(ctx, main, Options{FormulaStore: store, Exclude: []module.Version{leafmod2}})
+	if err != nil {
+		t.Fatalf("LoadGraph failed: %v", err)
+	}
+	want := []module.Version{
+		main,
+		{Path: "towner/altdep", Version: "1.0.0"},
+		{Path: "towner/depmod", Version: "1.0.0"},
+		{Path: "towner/leafmod", Version: "1.0.0"},
+	}
+	if !slices.Equal(g.BuildList, want) {
+		t.Errorf("build list = %v, want %v", g.BuildList, want)
+	}
+	if reqs, _ := g.RequiredBy(module.Version{Path: "towner/altdep", Version: "1.0.0"}); len(reqs) != 0 {
+		t.Errorf("graph keeps excluded requirements of altdep: %v", reqs)
+	}
+
+	exclude := []module.Version{leafmod2, {Path: "towner/leafmod", Version: "1.0.0"}}
+	if _, err := Load(ctx, main, Options{FormulaStore: store, Exclude: exclude}); err == nil || !strings.Contains(err.Error(), "every required version of towner/leafmod is excluded") {
+		t.Errorf("Load excluding every leafmod error = %v", err)
+	}
+	if _, err := Load(ctx, main, Options{FormulaStore: store, Exclude: []module.Version{main}}); err == nil || !strings.Contains(err.Error(), "main module towner/diamond@1.0.0 is excluded") {
+		t.Errorf("Load excluding main error = %v", err)
+	}
+}
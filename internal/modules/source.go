@@ -14,16 +14,12 @@ import (
 	"github.com/goplus/ixgo/xgobuild"
 	"github.com/goplus/llar/internal/formula"
 	"github.com/goplus/llar/mod/module"
+	"github.com/goplus/llar/mod/versions"
 	"github.com/goplus/llar/x/gnu"
 	"github.com/goplus/xgo/ast"
 	"github.com/goplus/xgo/parser"
 )
 
-// loadMu serializes ixgo interpreter loading (formula.LoadFS, loadComparatorFS).
-// The ixgo interpreter has internal race conditions during concurrent loading,
-// so all load operations must be serialized.
-var loadMu sync.Mutex
-
 const defaultFormulaSuffix = "_llar.gox"
 const defaultComparatorSuffix = "_cmp.gox"
 
@@ -34,6 +30,7 @@ type formulaModule struct {
 	fsys       fs.FS
 	modPath    string
 	comparator func() (func(v1, v2 module.Version) int, error)
+	versions   func() (*versions.Versions, error)
 
 	mu       sync.Mutex
 	formulas map[string]*formula.Formula
@@ -51,9 +48,35 @@ func newFormulaModule(fsys fs.FS, modPath string) *formulaModule {
 	m.comparator = sync.OnceValues(func() (func(v1, v2 module.Version) int, error) {
 		return loadOrDefaultComparator(m.fsys)
 	})
+	m.versions = sync.OnceValues(func() (*versions.Versions, error) {
+		content, err := fs.ReadFile(m.fsys, "versions.json")
+		if err != nil {
+			return nil, err
+		}
+		return versions.Parse("", content)
+	})
 	return m
 }
 
+// source returns the repository holding the module's source code, as
+// declared by its versions.json.
+func (m *formulaModule) source() (string, error) {
+	v, err := m.versions()
+	if err != nil {
+		return "", err
+	}
+	return sourceRepo(m.modPath, v), nil
+}
+
+// sourceRepo returns the repository holding the source code of modPath:
+// the source declared in v, or "github.com/<modPath>" by default.
+func sourceRepo(modPath string, v *versions.Versions) string {
+	if v.Source != "" {
+		return v.Source
+	}
+	return "github.com/" + modPath
+}
+
 // loadOrDefaultComparator searches for a _cmp.gox comparator file in fsys.
 // If found, it loads and returns the custom comparator.
 // If no comparator file exists, it falls back to GNU version comparison.
@@ -65,8 +88,6 @@ func loadOrDefaultComparator(fsys fs.FS) (func(v1, v2 module.Version) int, error
 			return gnu.Compare(v1.Version, v2.Version)
 		}, nil
 	}
-	loadMu.Lock()
-	defer loadMu.Unlock()
 	return loadComparatorFS(fsys.(fs.ReadFileFS), matches[0])
 }
 
@@ -91,10 +112,7 @@ func (m *formulaModule) at(version string) (*formula.Formula, error) {
 	if f, ok := m.formulas[fromVer]; ok {
 		return f, nil
 	}
-	loadMu.Lock()
 	f, err := formula.LoadFS(m.fsys.(fs.ReadFileFS), formulaPath)
-	loadMu.Unlock()
-
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +120,35 @@ func (m *formulaModule) at(version string) (*formula.Formula, error) {
 	return f, nil
 }
 
+// minFromVer returns the lowest fromVer of the module's formulas: the
+// oldest version the module has a formula for.
+func (m *formulaModule) minFromVer(compare func(v1, v2 module.Version) int) (string, error) {
+	var minFromVer string
+	err := fs.WalkDir(m.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !strings.HasSuffix(path, defaultFormulaSuffix) {
+			return nil
+		}
+		fromVer, err := fromVerOf(m.fsys.(fs.ReadFileFS), path)
+		if err != nil {
+			return err
+		}
+		if minFromVer == "" || compare(module.Version{Path: m.modPath, Version: fromVer}, module.Version{Path: m.modPath, Version: minFromVer}) < 0 {
+			minFromVer = fromVer
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if minFromVer == "" {
+		return "", fmt.Errorf("no formula found for %s", m.modPath)
+	}
+	return minFromVer, nil
+}
+
 // findMaxFromVer finds the formula file with the highest fromVer that is <= the target version.
 func (m *formulaModule) findMaxFromVer(mod module.Version, compare func(v1, v2 module.Version) int) (maxFromVer, formulaPath string, err error) {
 	err = fs.WalkDir(m.fsys, ".", func(path string, d fs.DirEntry, err error) error {
@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"os"
 	"testing"
+	"testing/fstest"
 
 	"github.com/goplus/ixgo/xgobuild"
 	"github.com/goplus/llar/internal/formula/repo"
@@ -91,6 +92,33 @@ func TestFormulaModule_ComparatorDefaultFallback(t *testing.T) {
 	}
 }
 
+func TestFormulaModule_Source(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions string
+		want     string
+	}{
+		{"default", `{"path": "madler/zlib", "deps": {}}`, "github.com/madler/zlib"},
+		{"declared", `{"path": "madler/zlib", "source": "https://gitlab.com/forks/zlib.git", "deps": {}}`, "https://gitlab.com/forks/zlib.git"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"versions.json": {Data: []byte(tt.versions)}}
+			got, err := newFormulaModule(fsys, "madler/zlib").source()
+			if err != nil {
+				t.Fatalf("source() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("source() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newFormulaModule(fstest.MapFS{}, "madler/zlib").source(); err == nil {
+		t.Error("source() without versions.json error = nil, want error")
+	}
+}
+
 func TestFormulaModule_At(t *testing.T) {
 	fsys := os.DirFS("testdata/DaveGamble/cJSON")
 	mod := newFormulaModule(fsys, "DaveGamble/cJSON")
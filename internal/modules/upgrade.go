@@ -0,0 +1,251 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/goplus/llar/internal/mvs"
+	"github.com/goplus/llar/mod/module"
+)
+
+// Update is an upgrade or a downgrade of the dependencies of a main module.
+type Update struct {
+	// Main is the main module, with its version resolved.
+	Main module.Version
+
+	// Old and New are the build lists before and after the update: Main
+	// first, then the remaining modules sorted by path.
+	Old, New []module.Version
+
+	// Requirements and Tools are a minimal list of requirements of Main
+	// that selects New, sorted by path and split into the dependencies
+	// Main links against, to pin in versions.json for Main.Version, and
+	// the tools, only reached through tool requirements. versions.json
+	// only lists the tools that onRequire of Main looks up there: without
+	// onRequire, whatever it lists is a dependency. The paths Main
+	// required before are kept even if they are implied by others.
+	Requirements []module.Version
+	Tools        []module.Version
+
+	// Versions is the versions.json of Main with the requirements of
+	// Main.Version replaced as described above, in the layout of
+	// versions.Format.
+	Versions []byte
+
+	// Overridden lists the Requirements and Tools that writing Versions
+	// would not select, because onRequire of Main declares them itself,
+	// with another version or not at all, or because Main has no
+	// onRequire to declare tools.
+	Overridden []module.Version
+}
+
+// Upgrade computes the update of the dependencies of main that upgrades
+// the given module versions, as "go get" does. A module without version is
+// upgraded to its latest version. Without any module, every dependency is
+// upgraded to its latest version. The available versions of a module are
+// the tags of its source repository that one of its formulas applies to.
+func Upgrade(ctx context.Context, main module.Version, opts Options, upgrade ...module.Version) (*Update, error) {
+	return update(ctx, main, opts, func(reqs *mvsReqs, main module.Version) ([]module.Version, error) {
+		if len(upgrade) == 0 {
+			return mvs.UpgradeAll(main, reqs)
+		}
+		list := make([]module.Version, len(upgrade))
+		for i, u := range upgrade {
+			if u.Version == "" {
+				latest, err := reqs.Upgrade(module.Version{Path: u.Path, Version: "none"})
+				if err != nil {
+					return nil, err
+				}
+				if latest.Version == "none" {
+					return nil, fmt.Errorf("no version of %s is available", u.Path)
+				}
+				u = latest
+			}
+			list[i] = u
+		}
+		return mvs.Upgrade(main, reqs, list...)
+	})
+}
+
+// Downgrade computes the update of the dependencies of main that
+// downgrades the given module versions, also downgrading the modules
+// requiring newer versions of them. The version "none" removes a module.
+func Downgrade(ctx context.Context, main module.Version, opts Options, downgrade ...module.Version) (*Update, error) {
+	for _, d := range downgrade {
+		if d.Version == "" {
+			return nil, fmt.Errorf("downgrade of %s has no version", d.Path)
+		}
+	}
+	return update(ctx, main, opts, func(reqs *mvsReqs, main module.Version) ([]module.Version, error) {
+		return mvs.Downgrade(main, reqs, downgrade...)
+	})
+}
+
+// update loads the requirements of main and runs compute on them to get
+// the new build list.
+func update(ctx context.Context, main module.Version, opts Options, compute func(reqs *mvsReqs, main module.Version) ([]module.Version, error)) (*Update, error) {
+	c, err := newFormulaContext(opts)
+	if err != nil {
+		return nil, err
+	}
+	reqs, main, err := c.newReqs(ctx, main)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := mvs.BuildList([]module.Version{main}, reqs)
+	if err != nil {
+		return nil, err
+	}
+	newList, err := compute(reqs, main)
+	if err != nil {
+		return nil, err
+	}
+	if err := reqs.checkDropped(newList); err != nil {
+		return nil, err
+	}
+
+	// Like "go get", keep requiring what main required directly, as long
+	// as it is still in the build list, and add whatever else is needed
+	// to select newList.
+	var base []string
+	for _, m := range reqs.prune(reqs.roots) {
+		if slices.ContainsFunc(newList, func(n module.Version) bool { return n.Path == m.Path }) {
+			base = append(base, m.Path)
+		}
+	}
+	reqs.roots = newList[1:]
+	min, err := mvs.Req(main, base, reqs)
+	if err != nil {
+		return nil, err
+	}
+	u := &Update{Main: main, Old: old, New: newList}
+	linked, err := c.linkedPaths(ctx, newList)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range min {
+		if linked[m.Path] {
+			u.Requirements = append(u.Requirements, m)
+		} else {
+			u.Tools = append(u.Tools, m)
+		}
+	}
+	if err := c.writeVersions(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// linkedPaths returns the paths of the modules of list, a build list, its
+// main module (list[0]) links against: those reached through dependencies,
+// not only through tool requirements.
+func (c *formulaContext) linkedPaths(ctx context.Context, list []module.Version) (map[string]bool, error) {
+	versions := make(map[string]string, len(list))
+	for _, m := range list {
+		versions[m.Path] = m.Version
+	}
+	linked := make(map[string]bool)
+	queue := []module.Version{list[0]}
+	for len(queue) > 0 {
+		reqs, err := c.loadDeps(ctx, queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, dep := range reqs.deps {
+			if version, ok := versions[dep.Path]; ok && !linked[dep.Path] {
+				linked[dep.Path] = true
+				queue = append(queue, module.Version{Path: dep.Path, Version: version})
+			}
+		}
+	}
+	return linked, nil
+}
+
+// writeVersions sets u.Versions and u.Overridden, running onRequire of
+// u.Main against the new versions.json for every matrix combination it
+// is required for.
+func (c *formulaContext) writeVersions(ctx context.Context, u *Update) error {
+	mod, err := c.moduleOf(ctx, u.Main.Path)
+	if err != nil {
+		return err
+	}
+	v, err := mod.versions()
+	if err != nil {
+		return err
+	}
+	compare, err := mod.comparator()
+	if err != nil {
+		return err
+	}
+	f, err := mod.at(u.Main.Version)
+	if err != nil {
+		return err
+	}
+	rep := c.replace.lookup(u.Main)
+
+	// resolve returns what u.Main requires with list pinned in versions.json.
+	resolve := func(list []module.Version) (deps, tools []module.Version, err error) {
+		nv := *v
+		nv.Dependencies = maps.Clone(v.Dependencies)
+		if nv.Dependencies == nil {
+			nv.Dependencies = make(map[string][]module.Version)
+		}
+		nv.Dependencies[u.Main.Version] = list
+		u.Versions = nv.Format(func(v1, v2 string) int {
+			return compare(module.Version{Path: u.Main.Path, Version: v1}, module.Version{Path: u.Main.Path, Version: v2})
+		})
+		fsys := versionsOverlay{ReadFileFS: mod.fsys.(fs.ReadFileFS), data: u.Versions}
+		for _, matrix := range c.matricesOf(u.Main.Path) {
+			d, t, err := resolveDeps(u.Main, fsys, f, rep, c.lock.commit(u.Main, rep), matrix)
+			if err != nil {
+				return nil, nil, err
+			}
+			deps, tools = append(deps, d...), append(tools, t...)
+		}
+		return deps, tools, nil
+	}
+
+	list := slices.SortedFunc(slices.Values(append(slices.Clip(u.Requirements), u.Tools...)), func(a, b module.Version) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	deps, tools, err := resolve(list)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(u.Tools, func(m module.Version) bool { return slices.Contains(deps, m) }) {
+		// Tools listed in versions.json would be linked.
+		if deps, tools, err = resolve(u.Requirements); err != nil {
+			return err
+		}
+	}
+	for _, m := range u.Requirements {
+		if !slices.Contains(deps, m) {
+			u.Overridden = append(u.Overridden, m)
+		}
+	}
+	for _, m := range u.Tools {
+		if !slices.Contains(tools, m) {
+			u.Overridden = append(u.Overridden, m)
+		}
+	}
+	return nil
+}
+
+// versionsOverlay is a module directory whose versions.json is data.
+type versionsOverlay struct {
+	fs.ReadFileFS
+	data []byte
+}
+
+func (o versionsOverlay) ReadFile(name string) ([]byte, error) {
+	if name == "versions.json" {
+		return o.data, nil
+	}
+	return o.ReadFileFS.ReadFile(name)
+}
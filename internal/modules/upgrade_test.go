@@ -0,0 +1,211 @@
+package modules
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/internal/vcs"
+	"github.com/goplus/llar/mod/module"
+)
+
+// tagsRepo is a vcs.Repo with a fixed list of tags.
+type tagsRepo struct {
+	mockVCSRepo
+	tags []string
+}
+
+func (r *tagsRepo) Tags(ctx context.Context) ([]string, error) { return r.tags, nil }
+
+// withTags makes the source repository "github.com/<path>" of every module
+// path in tags list the given tags.
+func withTags(t *testing.T, tags map[string][]string) {
+	t.Helper()
+	orig := newRepo
+	newRepo = func(source string) (vcs.Repo, error) {
+		return &tagsRepo{tags: tags[strings.TrimPrefix(source, "github.com/")]}, nil
+	}
+	t.Cleanup(func() { newRepo = orig })
+}
+
+// diamondTags are the tags of towner/diamond and its dependencies.
+// leafmod@0.9.0 predates the formulas of leafmod, so it is unavailable.
+var diamondTags = map[string][]string{
+	"towner/diamond": {"1.0.0"},
+	"towner/depmod":  {"1.0.0"},
+	"towner/altdep":  {"1.0.0"},
+	"towner/leafmod": {"2.1.0", "0.9.0", "1.0.0", "2.0.0"},
+}
+
+func mv(s string) module.Version {
+	path, version, _ := strings.Cut(s, "@")
+	return module.Version{Path: path, Version: version}
+}
+
+func mvList(list ...string) []module.Version {
+	vs := make([]module.Version, len(list))
+	for i, s := range list {
+		vs[i] = mv(s)
+	}
+	return vs
+}
+
+func TestUpgrade(t *testing.T) {
+	withTags(t, diamondTags)
+	store := setupTestStore(t, "testdata/load")
+	ctx := context.Background()
+	main := mv("towner/diamond@1.0.0")
+	opts := Options{FormulaStore: store}
+
+	tests := []struct {
+		name     string
+		upgrade  []module.Version
+		exclude  []module.Version
+		wantNew  []module.Version
+		wantReqs []module.Version
+	}{
+		{
+			name:     "all",
+			wantNew:  mvList("towner/diamond@1.0.0", "towner/altdep@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@2.1.0"),
+			wantReqs: mvList("towner/altdep@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@2.1.0"),
+		},
+		{
+			name:     "latest",
+			upgrade:  mvList("towner/leafmod"),
+			wantNew:  mvList("towner/diamond@1.0.0", "towner/altdep@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@2.1.0"),
+			wantReqs: mvList("towner/altdep@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@2.1.0"),
+		},
+		{
+			name:     "latest not excluded",
+			upgrade:  mvList("towner/leafmod"),
+			exclude:  mvList("towner/leafmod@2.1.0"),
+			wantNew:  mvList("towner/diamond@1.0.0", "towner/altdep@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@2.0.0"),
+			wantReqs: mvList("towner/altdep@1.0.0", "towner/depmod@1.0.0"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts.Exclude = tt.exclude
+			u, err := Upgrade(ctx, main, opts, tt.upgrade...)
+			if err != nil {
+				t.Fatalf("Upgrade failed: %v", err)
+			}
+			wantOld := mvList("towner/diamond@1.0.0", "towner/altdep@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@2.0.0")
+			if !slices.Equal(u.Old, wantOld) {
+				t.Errorf("Old = %v, want %v", u.Old, wantOld)
+			}
+			if !slices.Equal(u.New, tt.wantNew) {
+				t.Errorf("New = %v, want %v", u.New, tt.wantNew)
+			}
+			if !slices.Equal(u.Requirements, tt.wantReqs) {
+				t.Errorf("Requirements = %v, want %v", u.Requirements, tt.wantReqs)
+			}
+		})
+	}
+
+	if _, err := Upgrade(ctx, main, Options{FormulaStore: store}, mv("towner/standalone")); err == nil || !strings.Contains(err.Error(), "no version of towner/standalone is available") {
+		t.Errorf("Upgrade of a module without tags error = %v", err)
+	}
+}
+
+func TestDowngrade(t *testing.T) {
+	withTags(t, diamondTags)
+	store := setupTestStore(t, "testdata/load")
+	ctx := context.Background()
+	main := mv("towner/diamond@1.0.0")
+
+	// altdep@1.0.0 requires leafmod@2.0.0 and has no previous version, so
+	// it is removed.
+	u, err := Downgrade(ctx, main, Options{FormulaStore: store}, mv("towner/leafmod@1.0.0"))
+	if err != nil {
+		t.Fatalf("Downgrade failed: %v", err)
+	}
+	if want := mvList("towner/diamond@1.0.0", "towner/depmod@1.0.0", "towner/leafmod@1.0.0"); !slices.Equal(u.New, want) {
+		t.Errorf("New = %v, want %v", u.New, want)
+	}
+	if want := mvList("towner/depmod@1.0.0"); !slices.Equal(u.Requirements, want) {
+		t.Errorf("Requirements = %v, want %v", u.Requirements, want)
+	}
+
+	if _, err := Downgrade(ctx, main, Options{FormulaStore: store}, mv("towner/leafmod")); err == nil {
+		t.Error("Downgrade without a version succeeded")
+	}
+}
+
+func TestMvsReqs_Previous(t *testing.T) {
+	withTags(t, diamondTags)
+	c, err := newFormulaContext(Options{FormulaStore: setupTestStore(t, "testdata/load")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs, _, err := c.newReqs(context.Background(), mv("towner/diamond@1.0.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs.exclude = map[module.Version]bool{mv("towner/leafmod@2.0.0"): true}
+
+	tests := []struct{ m, want string }{
+		{"towner/leafmod@2.1.0", "towner/leafmod@1.0.0"},
+		{"towner/leafmod@1.0.0", "towner/leafmod@none"},
+		{"towner/leafmod@1.5.0", "towner/leafmod@1.0.0"},
+	}
+	for _, tt := range tests {
+		got, err := reqs.Previous(mv(tt.m))
+		if err != nil || got != mv(tt.want) {
+			t.Errorf("Previous(%s) = %v, %v, want %s", tt.m, got, err, tt.want)
+		}
+	}
+}
+
+func TestUpgrade_Tools(t *testing.T) {
+	withTags(t, map[string][]string{"towner/leafmod": {"1.0.0", "2.0.0"}})
+	store := setupTestStore(t, "testdata/load")
+
+	// withtool links leafmod and uses the tool toolmod, which requires
+	// standalone; onRequire looks up both versions in versions.json.
+	u, err := Upgrade(context.Background(), mv("towner/withtool@1.0.0"), Options{FormulaStore: store}, mv("towner/leafmod@2.0.0"))
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	if want := mvList("towner/leafmod@2.0.0"); !slices.Equal(u.Requirements, want) {
+		t.Errorf("Requirements = %v, want %v", u.Requirements, want)
+	}
+	if want := mvList("towner/toolmod@1.0.0"); !slices.Equal(u.Tools, want) {
+		t.Errorf("Tools = %v, want %v", u.Tools, want)
+	}
+	if len(u.Overridden) != 0 {
+		t.Errorf("Overridden = %v, want none", u.Overridden)
+	}
+	want := `{
+	"path": "towner/withtool",
+	"deps": {
+		"1.0.0": [
+			{"path": "towner/leafmod", "version": "2.0.0"},
+			{"path": "towner/toolmod", "version": "1.0.0"}
+		]
+	}
+}
+`
+	if string(u.Versions) != want {
+		t.Errorf("Versions =\n%s\nwant\n%s", u.Versions, want)
+	}
+}
+
+func TestUpgrade_Overridden(t *testing.T) {
+	withTags(t, map[string][]string{"towner/leafmod": {"1.0.0", "2.0.0"}})
+	store := setupTestStore(t, "testdata/load")
+
+	// onRequire of withdeps only requires depmod@1.0.0, so versions.json
+	// cannot pin leafmod.
+	u, err := Upgrade(context.Background(), mv("towner/withdeps@1.0.0"), Options{FormulaStore: store}, mv("towner/leafmod@2.0.0"))
+	if err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	if want := mvList("towner/depmod@1.0.0", "towner/leafmod@2.0.0"); !slices.Equal(u.Requirements, want) {
+		t.Errorf("Requirements = %v, want %v", u.Requirements, want)
+	}
+	if want := mvList("towner/leafmod@2.0.0"); !slices.Equal(u.Overridden, want) {
+		t.Errorf("Overridden = %v, want %v", u.Overridden, want)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package patch applies unified diffs, as produced by diff -u and git diff,
+// to a directory tree. It is a small, dependency-free subset of patch(1):
+// hunks must match exactly, but may have moved by any number of lines.
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const devNull = "/dev/null"
+
+// FileDiff is the change a patch makes to a single file.
+type FileDiff struct {
+	OldName string // "" for a new file
+	NewName string // "" for a deleted file
+	Hunks   []*Hunk
+}
+
+// Name returns the path of the file the diff applies to.
+func (d *FileDiff) Name() string {
+	if d.NewName != "" {
+		return d.NewName
+	}
+	return d.OldName
+}
+
+// Hunk is a single "@@ -l,s +l,s @@" section of a FileDiff.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+
+	// Lines holds the body of the hunk with the leading ' ', '-' or '+'
+	// and the trailing newline, which is missing if the line is followed
+	// by "\ No newline at end of file".
+	Lines []string
+}
+
+// Header returns the "@@ ... @@" line of the hunk.
+func (h *Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// old and new return the content the hunk expects and produces.
+func (h *Hunk) old() []string { return h.side('-') }
+func (h *Hunk) new() []string { return h.side('+') }
+
+func (h *Hunk) side(op byte) []string {
+	var lines []string
+	for _, l := range h.Lines {
+		if l[0] == ' ' || l[0] == op {
+			lines = append(lines, l[1:])
+		}
+	}
+	return lines
+}
+
+// Parse parses the unified diff data. Text outside of file diffs, such as
+// a commit message or git's extended headers, is ignored.
+func Parse(data []byte) ([]*FileDiff, error) {
+	lines := splitLines(string(data))
+	var diffs []*FileDiff
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		d := &FileDiff{
+			OldName: fileName(lines[i][len("--- "):]),
+			NewName: fileName(lines[i+1][len("+++ "):]),
+		}
+		i += 2
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			d.Hunks = append(d.Hunks, h)
+			i = next
+		}
+		if len(d.Hunks) == 0 {
+			return nil, fmt.Errorf("line %d: no hunks for %s", i+1, d.Name())
+		}
+		diffs = append(diffs, d)
+		i--
+	}
+	if len(diffs) == 0 {
+		return nil, errors.New("no file diffs found")
+	}
+	return diffs, nil
+}
+
+// parseHunk parses the hunk starting at lines[i] and returns it along with
+// the index of the line following it.
+func parseHunk(lines []string, i int) (*Hunk, int, error) {
+	h := new(Hunk)
+	header := strings.TrimRight(lines[i], "\n")
+	ranges, _, ok := strings.Cut(strings.TrimPrefix(header, "@@ "), " @@")
+	oldRange, newRange, ok2 := strings.Cut(ranges, " ")
+	if !ok || !ok2 || !strings.HasPrefix(oldRange, "-") || !strings.HasPrefix(newRange, "+") {
+		return nil, 0, fmt.Errorf("line %d: invalid hunk header %q", i+1, header)
+	}
+	var err1, err2 error
+	h.OldStart, h.OldLines, err1 = parseRange(oldRange[1:])
+	h.NewStart, h.NewLines, err2 = parseRange(newRange[1:])
+	if err1 != nil || err2 != nil {
+		return nil, 0, fmt.Errorf("line %d: invalid hunk header %q", i+1, header)
+	}
+
+	oldLeft, newLeft := h.OldLines, h.NewLines
+	for i++; i < len(lines) && (oldLeft > 0 || newLeft > 0); i++ {
+		l := lines[i]
+		switch {
+		case l == "\n" || l == "":
+			// Some editors strip the trailing space of empty context lines.
+			l = " " + l
+			oldLeft--
+			newLeft--
+		case l[0] == ' ':
+			oldLeft--
+			newLeft--
+		case l[0] == '-':
+			oldLeft--
+		case l[0] == '+':
+			newLeft--
+		case l[0] == '\\':
+			trimLastNewline(h.Lines)
+			continue
+		default:
+			return nil, 0, fmt.Errorf("line %d: unexpected line in hunk %s", i+1, h.Header())
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return nil, 0, fmt.Errorf("line %d: hunk %s is longer than its header says", i+1, h.Header())
+		}
+		h.Lines = append(h.Lines, l)
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, 0, fmt.Errorf("line %d: hunk %s is truncated", i+1, h.Header())
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], `\`) {
+		trimLastNewline(h.Lines)
+		i++
+	}
+	return h, i, nil
+}
+
+// parseRange parses "start[,count]" of a hunk header.
+func parseRange(s string) (start, count int, err error) {
+	startStr, countStr, ok := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(startStr); err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		return start, 1, nil
+	}
+	count, err = strconv.Atoi(countStr)
+	return start, count, err
+}
+
+// fileName returns the path named by a "---" or "+++" line without its
+// "a/" or "b/" prefix and timestamp, or "" for /dev/null.
+func fileName(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	if name, _, ok := strings.Cut(s, "\t"); ok {
+		s = name
+	}
+	s = strings.TrimSpace(s)
+	if s == devNull {
+		return ""
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+// -----------------------------------------------------------------------------
+
+// Error reports a hunk that does not apply.
+type Error struct {
+	Patch string // name of the patch file
+	File  string // file the hunk applies to
+	Hunk  int    // 1-based index of the hunk within File
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.Hunk == 0 {
+		return fmt.Sprintf("%s: %s: %v", e.Patch, e.File, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: hunk #%d %v", e.Patch, e.File, e.Hunk, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Apply applies the unified diff data, read from the file called name, to
+// the files under dir. Either every file diff applies and all files are
+// updated, or nothing is changed and an *Error (or a parse error) is
+// returned.
+func Apply(dir, name string, data []byte) error {
+	diffs, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	// Files are only written once every diff applies. A file may appear
+	// in several diffs; later ones see the result of earlier ones.
+	type result struct {
+		path    string
+		content []byte // nil removes the file
+		mode    fs.FileMode
+	}
+	var results []*result
+	pending := make(map[string]*result)
+	for _, d := range diffs {
+		path, err := safeJoin(dir, d.Name())
+		if err != nil {
+			return &Error{Patch: name, File: d.Name(), Err: err}
+		}
+		r, seen := pending[path]
+		if !seen {
+			r = &result{path: path, mode: 0o644}
+			if info, err := os.Lstat(path); err == nil {
+				r.mode = info.Mode().Perm()
+				if r.content, err = os.ReadFile(path); err != nil {
+					return &Error{Patch: name, File: d.Name(), Err: err}
+				}
+			}
+			pending[path] = r
+			results = append(results, r)
+		}
+		exists := r.content != nil
+		switch {
+		case d.OldName == "" && exists:
+			return &Error{Patch: name, File: d.Name(), Err: errors.New("file to be created already exists")}
+		case d.OldName != "" && !exists:
+			return &Error{Patch: name, File: d.Name(), Err: fs.ErrNotExist}
+		}
+
+		lines, err := applyHunks(splitLines(string(r.content)), d.Hunks)
+		if err != nil {
+			var he *hunkError
+			if errors.As(err, &he) {
+				return &Error{Patch: name, File: d.Name(), Hunk: he.index, Err: he}
+			}
+			return &Error{Patch: name, File: d.Name(), Err: err}
+		}
+		if d.NewName == "" {
+			if len(lines) > 0 {
+				return &Error{Patch: name, File: d.Name(), Err: errors.New("file to be deleted is not empty after patching")}
+			}
+			r.content = nil
+		} else {
+			r.content = []byte(strings.Join(lines, ""))
+		}
+	}
+
+	for _, r := range results {
+		if r.content == nil {
+			if err := os.Remove(r.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(r.path, r.content, r.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hunkError reports that the hunk at index (1-based) does not apply.
+type hunkError struct {
+	index int
+	hunk  *Hunk
+}
+
+func (e *hunkError) Error() string {
+	return fmt.Sprintf("(%s) does not apply", e.hunk.Header())
+}
+
+// applyHunks applies hunks in order to lines. Each hunk is looked for at
+// the line its header names, shifted by the distance the previous hunk was
+// found away from its own line, and then at increasing distances from
+// there. Hunks may not overlap.
+func applyHunks(lines []string, hunks []*Hunk) ([]string, error) {
+	out := make([]string, 0, len(lines))
+	pos, drift := 0, 0
+	for i, h := range hunks {
+		old := h.old()
+		line := h.OldStart - 1
+		if h.OldLines == 0 {
+			// Pure insertion: OldStart names the line after which the
+			// new lines go.
+			line++
+		}
+		at := find(lines, old, pos, line+drift)
+		if at < 0 {
+			return nil, &hunkError{index: i + 1, hunk: h}
+		}
+		out = append(out, lines[pos:at]...)
+		out = append(out, h.new()...)
+		pos = at + len(old)
+		drift = at - line
+	}
+	return append(out, lines[pos:]...), nil
+}
+
+// find returns the index of old in lines at or after from that is closest
+// to want, or -1 if there is none.
+func find(lines, old []string, from, want int) int {
+	last := len(lines) - len(old)
+	want = max(min(want, last), from)
+	for d := 0; want-d >= from || want+d <= last; d++ {
+		if at := want - d; at >= from && at <= last && matches(lines[at:], old) {
+			return at
+		}
+		if at := want + d; d > 0 && at <= last && matches(lines[at:], old) {
+			return at
+		}
+	}
+	return -1
+}
+
+func matches(lines, old []string) bool {
+	for i, l := range old {
+		if lines[i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines splits s into lines, each keeping its trailing newline.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// trimLastNewline removes the newline of the last line in lines, for a
+// "\ No newline at end of file" marker.
+func trimLastNewline(lines []string) {
+	if n := len(lines); n > 0 {
+		lines[n-1] = strings.TrimSuffix(lines[n-1], "\n")
+	}
+}
+
+// safeJoin joins the slash-separated path name to dir, rejecting names
+// that would escape dir.
+func safeJoin(dir, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("path escapes the source directory")
+	}
+	return filepath.Join(dir, rel), nil
+}
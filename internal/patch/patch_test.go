@@ -0,0 +1,186 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package patch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files (relative path -> content) under a fresh temp dir.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s): %v", path, err)
+	}
+	return string(data)
+}
+
+const mainC = "#include <stdio.h>\n\nint main(void) {\n\tputs(\"hello\");\n\treturn 0;\n}\n"
+
+const fixPatch = `Fix the greeting.
+
+--- main.c.orig	2026-01-01 00:00:00
++++ main.c	2026-01-01 00:00:00
+@@ -2,4 +2,4 @@
+
+ int main(void) {
+-	puts("hello");
++	puts("hello, world");
+ 	return 0;
+`
+
+func TestApply(t *testing.T) {
+	dir := writeTree(t, map[string]string{"main.c": mainC})
+	if err := Apply(dir, "fix.patch", []byte(fixPatch)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := strings.Replace(mainC, `"hello"`, `"hello, world"`, 1)
+	if got := readFile(t, filepath.Join(dir, "main.c")); got != want {
+		t.Errorf("main.c = %q, want %q", got, want)
+	}
+}
+
+func TestApply_Offset(t *testing.T) {
+	// Lines added upstream above the hunk move it down.
+	dir := writeTree(t, map[string]string{"main.c": "// header\n// comment\n" + mainC})
+	if err := Apply(dir, "fix.patch", []byte(fixPatch)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := readFile(t, filepath.Join(dir, "main.c")); !strings.Contains(got, `puts("hello, world")`) {
+		t.Errorf("main.c = %q, want patched greeting", got)
+	}
+}
+
+func TestApply_GitFormat(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"CMakeLists.txt": "project(demo)\nadd_library(demo demo.c)\n",
+		"obsolete.txt":   "old\n",
+	})
+	const gitPatch = `From 1234 Mon Sep 17 00:00:00 2001
+Subject: [PATCH] Build shared
+
+diff --git a/CMakeLists.txt b/CMakeLists.txt
+index 1111111..2222222 100644
+--- a/CMakeLists.txt
++++ b/CMakeLists.txt
+@@ -1,2 +1,3 @@
+ project(demo)
++option(BUILD_SHARED_LIBS "shared" ON)
+ add_library(demo demo.c)
+diff --git a/cmake/demo.pc.in b/cmake/demo.pc.in
+new file mode 100644
+--- /dev/null
++++ b/cmake/demo.pc.in
+@@ -0,0 +1 @@
++Name: demo
+\ No newline at end of file
+diff --git a/obsolete.txt b/obsolete.txt
+deleted file mode 100644
+--- a/obsolete.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-old
+`
+	if err := Apply(dir, "0001-build-shared.patch", []byte(gitPatch)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got, want := readFile(t, filepath.Join(dir, "CMakeLists.txt")), "project(demo)\noption(BUILD_SHARED_LIBS \"shared\" ON)\nadd_library(demo demo.c)\n"; got != want {
+		t.Errorf("CMakeLists.txt = %q, want %q", got, want)
+	}
+	if got := readFile(t, filepath.Join(dir, "cmake", "demo.pc.in")); got != "Name: demo" {
+		t.Errorf("demo.pc.in = %q, want %q without newline", got, "Name: demo")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "obsolete.txt")); !os.IsNotExist(err) {
+		t.Errorf("obsolete.txt not deleted, stat error = %v", err)
+	}
+}
+
+func TestApply_HunkFailure(t *testing.T) {
+	files := map[string]string{
+		"a.txt": "one\ntwo\nthree\n",
+		"b.txt": "alpha\nbeta\ngamma\ndelta\nepsilon\nzeta\neta\ntheta\n",
+	}
+	dir := writeTree(t, files)
+	const bad = `--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++2
+ three
+--- a/b.txt
++++ b/b.txt
+@@ -1,2 +1,2 @@
+-alpha
++ALPHA
+ beta
+@@ -6,3 +6,3 @@
+ zeta
+-ETA
++eta!
+ theta
+`
+	err := Apply(dir, "bad.patch", []byte(bad))
+	var pe *Error
+	if !errors.As(err, &pe) {
+		t.Fatalf("Apply() error = %v, want *Error", err)
+	}
+	if pe.Patch != "bad.patch" || pe.File != "b.txt" || pe.Hunk != 2 {
+		t.Errorf("error = %+v, want bad.patch b.txt hunk 2", pe)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "bad.patch: b.txt: hunk #2 (@@ -6,3 +6,3 @@) does not apply") {
+		t.Errorf("error message = %q", msg)
+	}
+	// Nothing is written when any hunk fails.
+	for name, want := range files {
+		if got := readFile(t, filepath.Join(dir, name)); got != want {
+			t.Errorf("%s changed to %q after failed patch", name, got)
+		}
+	}
+}
+
+func TestApply_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"no diff", "just text\n", "no file diffs found"},
+		{"truncated", "--- a/x\n+++ b/x\n@@ -1,3 +1,3 @@\n a\n", "truncated"},
+		{"bad header", "--- a/x\n+++ b/x\n@@ bogus @@\n", "invalid hunk header"},
+		{"missing file", "--- a/missing.c\n+++ b/missing.c\n@@ -1 +1 @@\n-a\n+b\n", "missing.c"},
+		{"escaping path", "--- a/../x\n+++ b/../x\n@@ -1 +1 @@\n-a\n+b\n", "escapes"},
+		{"create existing", "--- /dev/null\n+++ b/main.c\n@@ -0,0 +1 @@\n+x\n", "already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTree(t, map[string]string{"main.c": mainC})
+			err := Apply(dir, "p.patch", []byte(tt.patch))
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "p.patch: ") {
+				t.Errorf("Apply() error = %v, want p.patch: ...%s...", err, tt.want)
+			}
+		})
+	}
+}
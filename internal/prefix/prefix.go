@@ -0,0 +1,400 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package prefix manages a persistent install prefix shared by several
+// modules. Every installed module leaves a receipt recording exactly which
+// files it contributed, so that a later upgrade or uninstall can remove
+// those files and nothing else.
+package prefix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goplus/llar/internal/lockedfile"
+	"github.com/goplus/llar/mod/module"
+)
+
+// Prefix directory layout:
+//
+//	prefixDir/
+//	  include/ lib/ lib/pkgconfig/ bin/ ...   # merged module files
+//	  .llar/
+//	    .lock                                 # serializes installs
+//	    receipts/<escaped>.json               # one receipt per module path
+//	    install-*/                            # files staged by Install
+const (
+	metaDir     = ".llar"
+	receiptsDir = "receipts"
+	lockFile    = ".lock"
+)
+
+// Receipt records which files a module@version installed into a prefix.
+type Receipt struct {
+	Path        string    `json:"path"`
+	Version     string    `json:"version"`
+	Matrix      string    `json:"matrix"`
+	Key         string    `json:"key,omitempty"` // build key of the installed output
+	Files       []string  `json:"files"`         // slash-separated, relative to the prefix
+	InstallTime time.Time `json:"install_time"`
+}
+
+// Prefix is an install prefix on the local filesystem.
+type Prefix struct {
+	dir string
+}
+
+// New returns the Prefix rooted at dir, creating it if necessary.
+func New(dir string) (*Prefix, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(abs, metaDir, receiptsDir), 0o755); err != nil {
+		return nil, err
+	}
+	return &Prefix{dir: abs}, nil
+}
+
+// DefaultDir returns the default install prefix: <UserHomeDir>/.llar/prefix.
+func DefaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".llar", "prefix"), nil
+}
+
+// Dir returns the absolute prefix directory.
+func (p *Prefix) Dir() string {
+	return p.dir
+}
+
+// Lock acquires an exclusive lock on the prefix.
+// Returns an unlock function that must be called to release the lock.
+func (p *Prefix) Lock() (unlock func(), err error) {
+	return lockedfile.MutexAt(filepath.Join(p.dir, metaDir, lockFile)).Lock()
+}
+
+// receiptFile returns the receipt location for modPath.
+func (p *Prefix) receiptFile(modPath string) (string, error) {
+	escaped, err := module.EscapePath(modPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(p.dir, metaDir, receiptsDir, escaped+".json"), nil
+}
+
+// Receipt returns the receipt of the installed version of modPath.
+// The returned error satisfies errors.Is(err, fs.ErrNotExist) if modPath
+// is not installed.
+func (p *Prefix) Receipt(modPath string) (*Receipt, error) {
+	file, err := p.receiptFile(modPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var r Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid receipt %s: %w", file, err)
+	}
+	return &r, nil
+}
+
+// Receipts returns the receipts of all modules installed in the prefix,
+// sorted by module path.
+func (p *Prefix) Receipts() ([]*Receipt, error) {
+	root := filepath.Join(p.dir, metaDir, receiptsDir)
+	var receipts []*Receipt
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var r Receipt
+		if err := json.Unmarshal(data, &r); err != nil {
+			return fmt.Errorf("invalid receipt %s: %w", path, err)
+		}
+		receipts = append(receipts, &r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(receipts, func(a, b *Receipt) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return receipts, nil
+}
+
+func (p *Prefix) saveReceipt(r *Receipt) error {
+	file, err := p.receiptFile(r.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0o644)
+}
+
+// Install copies the build output in srcDir into the prefix as mod, built
+// for matrix with the build key key.
+//
+// If another version of mod.Path is already installed, its files are
+// replaced, so installing a newer version is an upgrade. Files already
+// owned by a different module, or present in the prefix without being
+// owned by any module, are reported as a conflict and nothing is changed.
+// Absolute references to srcDir inside pkg-config files are rewritten to
+// point into the prefix.
+//
+// The new files are staged first, so a failed copy leaves the installed
+// version alone. Until they are all in place, the receipt of mod.Path
+// records both the old and the new files, so that no file is left
+// unowned if Install is interrupted; installing again cleans up.
+//
+// The caller is expected to hold the lock returned by Lock.
+func (p *Prefix) Install(mod module.Version, matrix, key, srcDir string) (*Receipt, error) {
+	files, err := listFiles(srcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := p.owners()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		owner, ok := owners[f]
+		if ok && owner.Path != mod.Path {
+			return nil, fmt.Errorf("%s@%s: file %s is already installed by %s@%s", mod.Path, mod.Version, f, owner.Path, owner.Version)
+		}
+		if !ok {
+			if _, err := os.Lstat(filepath.Join(p.dir, filepath.FromSlash(f))); err == nil {
+				return nil, fmt.Errorf("%s@%s: file %s already exists in the prefix and is not installed by any module", mod.Path, mod.Version, f)
+			}
+		}
+	}
+
+	staging, err := os.MkdirTemp(filepath.Join(p.dir, metaDir), "install-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	for _, f := range files {
+		if err := p.copyFile(srcDir, f, staging); err != nil {
+			return nil, fmt.Errorf("%s@%s: install %s: %w", mod.Path, mod.Version, f, err)
+		}
+	}
+
+	old, err := p.Receipt(mod.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	// The pending receipt has no build key, so that an interrupted
+	// install is not taken for a complete one.
+	pending := &Receipt{Path: mod.Path, Version: mod.Version, Matrix: matrix, Files: files}
+	if old != nil {
+		pending.Files = slices.Compact(slices.Sorted(slices.Values(slices.Concat(old.Files, files))))
+	}
+	if err := p.saveReceipt(pending); err != nil {
+		return nil, err
+	}
+	// Old files go first, so that a file may become a directory.
+	if old != nil {
+		for _, f := range old.Files {
+			if _, found := slices.BinarySearch(files, f); found {
+				continue
+			}
+			target := filepath.Join(p.dir, filepath.FromSlash(f))
+			if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			p.pruneEmptyDirs(filepath.Dir(target))
+		}
+	}
+
+	for _, f := range files {
+		dst := filepath.Join(p.dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(filepath.Join(staging, filepath.FromSlash(f)), dst); err != nil {
+			return nil, fmt.Errorf("%s@%s: install %s: %w", mod.Path, mod.Version, f, err)
+		}
+	}
+
+	r := &Receipt{
+		Path:        mod.Path,
+		Version:     mod.Version,
+		Matrix:      matrix,
+		Key:         key,
+		Files:       files,
+		InstallTime: time.Now(),
+	}
+	if err := p.saveReceipt(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Uninstall removes every file recorded in the receipt of modPath, prunes
+// directories left empty, and deletes the receipt.
+// The returned error satisfies errors.Is(err, fs.ErrNotExist) if modPath
+// is not installed.
+//
+// The caller is expected to hold the lock returned by Lock.
+func (p *Prefix) Uninstall(modPath string) error {
+	r, err := p.Receipt(modPath)
+	if err != nil {
+		return err
+	}
+	for _, f := range r.Files {
+		target := filepath.Join(p.dir, filepath.FromSlash(f))
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		p.pruneEmptyDirs(filepath.Dir(target))
+	}
+	file, err := p.receiptFile(modPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	p.pruneEmptyDirs(filepath.Dir(file))
+	return nil
+}
+
+// owners maps every file recorded in a receipt to its owning receipt.
+func (p *Prefix) owners() (map[string]*Receipt, error) {
+	receipts, err := p.Receipts()
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]*Receipt)
+	for _, r := range receipts {
+		for _, f := range r.Files {
+			owners[f] = r
+		}
+	}
+	return owners, nil
+}
+
+// pruneEmptyDirs removes dir and its parents while they are empty,
+// stopping at the prefix root and at the receipts root.
+func (p *Prefix) pruneEmptyDirs(dir string) {
+	stop := filepath.Join(p.dir, metaDir, receiptsDir)
+	for dir != p.dir && dir != stop && strings.HasPrefix(dir, p.dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// copyFile copies the slash-separated relative path rel from srcDir to
+// dstDir, the prefix or a staging directory in it. Symbolic links are
+// recreated rather than followed, so that shared library version links
+// (libz.so -> libz.so.1) survive.
+func (p *Prefix) copyFile(srcDir, rel, dstDir string) error {
+	src := filepath.Join(srcDir, filepath.FromSlash(rel))
+	dst := filepath.Join(dstDir, filepath.FromSlash(rel))
+
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+	}
+
+	if isPkgConfig(rel) {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		data = bytes.ReplaceAll(data, []byte(srcDir), []byte(p.dir))
+		return os.WriteFile(dst, data, info.Mode().Perm())
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// isPkgConfig reports whether rel is a pkg-config file.
+func isPkgConfig(rel string) bool {
+	return strings.HasSuffix(rel, ".pc")
+}
+
+// listFiles returns all non-directory entries under dir as sorted,
+// slash-separated relative paths.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	return files, nil
+}
@@ -0,0 +1,300 @@
+package prefix
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goplus/llar/mod/module"
+)
+
+// writeTree creates files (relative path -> content) under a fresh temp dir.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s): %v", path, err)
+	}
+	return string(data)
+}
+
+func TestInstall_CopiesFilesAndWritesReceipt(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := writeTree(t, map[string]string{
+		"include/zlib.h": "header",
+		"lib/libz.a":     "archive",
+	})
+
+	mod := module.Version{Path: "madler/zlib", Version: "v1.3.1"}
+	r, err := p.Install(mod, "amd64-linux", "k", src)
+	if err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if want := []string{"include/zlib.h", "lib/libz.a"}; !slices.Equal(r.Files, want) {
+		t.Errorf("Files = %v, want %v", r.Files, want)
+	}
+	if got := readFile(t, filepath.Join(p.Dir(), "lib", "libz.a")); got != "archive" {
+		t.Errorf("lib/libz.a = %q, want %q", got, "archive")
+	}
+
+	got, err := p.Receipt("madler/zlib")
+	if err != nil {
+		t.Fatalf("Receipt: %v", err)
+	}
+	if got.Version != "v1.3.1" || got.Matrix != "amd64-linux" || got.Key != "k" {
+		t.Errorf("receipt = %+v, want version v1.3.1 matrix amd64-linux key k", got)
+	}
+}
+
+func TestInstall_UpgradeRemovesOldFiles(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSrc := writeTree(t, map[string]string{
+		"include/foo.h":      "old",
+		"lib/libfoo.so.1":    "old",
+		"share/foo/old.conf": "old",
+	})
+	newSrc := writeTree(t, map[string]string{
+		"include/foo.h":   "new",
+		"lib/libfoo.so.2": "new",
+	})
+
+	if _, err := p.Install(module.Version{Path: "o/foo", Version: "1.0.0"}, "m", "", oldSrc); err != nil {
+		t.Fatalf("Install old: %v", err)
+	}
+	if _, err := p.Install(module.Version{Path: "o/foo", Version: "2.0.0"}, "m", "", newSrc); err != nil {
+		t.Fatalf("Install new: %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(p.Dir(), "include", "foo.h")); got != "new" {
+		t.Errorf("include/foo.h = %q, want %q", got, "new")
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir(), "lib", "libfoo.so.1")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("old lib/libfoo.so.1 should be removed, stat err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir(), "share")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("empty share/ should be pruned, stat err = %v", err)
+	}
+	r, err := p.Receipt("o/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != "2.0.0" {
+		t.Errorf("receipt version = %q, want %q", r.Version, "2.0.0")
+	}
+}
+
+func TestInstall_ConflictWithOtherModule(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := writeTree(t, map[string]string{"include/common.h": "a"})
+	if _, err := p.Install(module.Version{Path: "o/a", Version: "1.0.0"}, "m", "", src); err != nil {
+		t.Fatal(err)
+	}
+
+	other := writeTree(t, map[string]string{"include/common.h": "b", "lib/libb.a": "b"})
+	_, err = p.Install(module.Version{Path: "o/b", Version: "1.0.0"}, "m", "", other)
+	if err == nil {
+		t.Fatal("expected conflict error")
+	}
+	if !strings.Contains(err.Error(), "already installed by o/a@1.0.0") {
+		t.Errorf("error = %v, want conflict with o/a@1.0.0", err)
+	}
+	if got := readFile(t, filepath.Join(p.Dir(), "include", "common.h")); got != "a" {
+		t.Errorf("include/common.h = %q, want untouched %q", got, "a")
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir(), "lib", "libb.a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("conflicting install must not copy any file")
+	}
+}
+
+func TestInstall_UntrackedFile(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(p.Dir(), "include"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(p.Dir(), "include", "foo.h"), []byte("mine"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := writeTree(t, map[string]string{"include/foo.h": "new", "lib/libfoo.a": "new"})
+	_, err = p.Install(module.Version{Path: "o/foo", Version: "1.0.0"}, "m", "", src)
+	if err == nil || !strings.Contains(err.Error(), "not installed by any module") {
+		t.Fatalf("Install over an untracked file: error = %v, want conflict", err)
+	}
+	if got := readFile(t, filepath.Join(p.Dir(), "include", "foo.h")); got != "mine" {
+		t.Errorf("include/foo.h = %q, want untouched %q", got, "mine")
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir(), "lib", "libfoo.a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("conflicting install must not copy any file")
+	}
+}
+
+func TestInstall_FailedCopyKeepsOldVersion(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSrc := writeTree(t, map[string]string{"include/foo.h": "old", "lib/libfoo.so.1": "old"})
+	if _, err := p.Install(module.Version{Path: "o/foo", Version: "1.0.0"}, "m", "k1", oldSrc); err != nil {
+		t.Fatalf("Install old: %v", err)
+	}
+
+	// A socket is listed but cannot be copied.
+	newSrc := writeTree(t, map[string]string{"include/foo.h": "new", "lib/libfoo.so.2": "new"})
+	l, err := net.Listen("unix", filepath.Join(newSrc, "lib", "sock"))
+	if err != nil {
+		t.Skipf("cannot create a unix socket: %v", err)
+	}
+	defer l.Close()
+	if _, err := p.Install(module.Version{Path: "o/foo", Version: "2.0.0"}, "m", "k2", newSrc); err == nil {
+		t.Fatal("Install of an uncopyable file: error = nil")
+	}
+
+	if got := readFile(t, filepath.Join(p.Dir(), "include", "foo.h")); got != "old" {
+		t.Errorf("include/foo.h = %q, want %q", got, "old")
+	}
+	if got := readFile(t, filepath.Join(p.Dir(), "lib", "libfoo.so.1")); got != "old" {
+		t.Errorf("lib/libfoo.so.1 = %q, want %q", got, "old")
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir(), "lib", "libfoo.so.2")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("lib/libfoo.so.2 installed by a failed install, stat err = %v", err)
+	}
+	if r, err := p.Receipt("o/foo"); err != nil || r.Version != "1.0.0" || r.Key != "k1" {
+		t.Errorf("receipt = %+v, %v, want 1.0.0 with key k1", r, err)
+	}
+	if staged, _ := filepath.Glob(filepath.Join(p.Dir(), metaDir, "install-*")); len(staged) > 0 {
+		t.Errorf("staging dirs left behind: %v", staged)
+	}
+}
+
+func TestInstall_PreservesSymlinks(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := writeTree(t, map[string]string{"lib/libz.so.1.3.1": "so"})
+	if err := os.Symlink("libz.so.1.3.1", filepath.Join(src, "lib", "libz.so")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if _, err := p.Install(module.Version{Path: "madler/zlib", Version: "v1.3.1"}, "m", "", src); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	link, err := os.Readlink(filepath.Join(p.Dir(), "lib", "libz.so"))
+	if err != nil {
+		t.Fatalf("Readlink: %v", err)
+	}
+	if link != "libz.so.1.3.1" {
+		t.Errorf("link = %q, want %q", link, "libz.so.1.3.1")
+	}
+}
+
+func TestInstall_RelocatesPkgConfig(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	pc := "prefix=" + src + "\nlibdir=${prefix}/lib\n"
+	if err := os.MkdirAll(filepath.Join(src, "lib", "pkgconfig"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "lib", "pkgconfig", "zlib.pc"), []byte(pc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Install(module.Version{Path: "madler/zlib", Version: "v1.3.1"}, "m", "", src); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	got := readFile(t, filepath.Join(p.Dir(), "lib", "pkgconfig", "zlib.pc"))
+	if want := "prefix=" + p.Dir() + "\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("zlib.pc = %q, want prefix rewritten to %q", got, p.Dir())
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := writeTree(t, map[string]string{"include/a.h": "a"})
+	if _, err := p.Install(module.Version{Path: "o/a", Version: "1.0.0"}, "m", "", src); err != nil {
+		t.Fatal(err)
+	}
+	// A file the prefix does not track must survive.
+	untracked := filepath.Join(p.Dir(), "include", "mine.h")
+	if err := os.WriteFile(untracked, []byte("mine"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Uninstall("o/a"); err != nil {
+		t.Fatalf("Uninstall: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir(), "include", "a.h")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("include/a.h should be removed")
+	}
+	if _, err := os.Stat(untracked); err != nil {
+		t.Errorf("untracked file removed: %v", err)
+	}
+	if _, err := p.Receipt("o/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Receipt after Uninstall err = %v, want ErrNotExist", err)
+	}
+	if err := p.Uninstall("o/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("second Uninstall err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestReceipts_Sorted(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"z/z", "a/a", "m/m"} {
+		src := writeTree(t, map[string]string{"include/" + strings.ReplaceAll(path, "/", "_") + ".h": path})
+		if _, err := p.Install(module.Version{Path: path, Version: "1.0.0"}, "m", "", src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receipts, err := p.Receipts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range receipts {
+		got = append(got, r.Path)
+	}
+	if want := []string{"a/a", "m/m", "z/z"}; !slices.Equal(got, want) {
+		t.Errorf("Receipts paths = %v, want %v", got, want)
+	}
+}
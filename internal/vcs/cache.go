@@ -0,0 +1,305 @@
+// Copyright 2024 The llar Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goplus/llar/internal/lockedfile"
+)
+
+// Source cache directory layout:
+//
+//	cacheDir/
+//	  mirrors/
+//	    <name>.git/           # bare mirror of one repository
+//	    <name>.lock           # serializes fetches and worktree changes
+//	  worktrees/
+//	    <name>/<ref>/         # shared checkout of ref, never modified
+//	    <name>/<ref>.done     # marks the checkout above as complete
+//
+// <name> is derived from the remote URL, <ref> is path-escaped.
+const (
+	mirrorsDir   = "mirrors"
+	worktreesDir = "worktrees"
+)
+
+// SourceCache is a shared, on-disk cache of repository sources.
+//
+// Every repository is fetched into a single bare mirror; each ref is
+// fetched into the mirror at most once and is then available without
+// network access, both as a shared worktree (see Worktree) and as private
+// copies (see Checkout). A SourceCache is safe for concurrent use by
+// multiple goroutines and processes.
+type SourceCache struct {
+	dir string
+}
+
+// NewSourceCache returns a SourceCache rooted at dir.
+func NewSourceCache(dir string) *SourceCache {
+	return &SourceCache{dir: dir}
+}
+
+// DefaultSourceCacheDir returns the default source cache directory:
+// <UserCacheDir>/.llar/sources.
+func DefaultSourceCacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDir, ".llar", "sources"), nil
+}
+
+// Dir returns the root directory of the cache.
+func (c *SourceCache) Dir() string {
+	return c.dir
+}
+
+// Worktree returns a directory holding the files of remote at ref,
+// fetching ref on first use. The directory is shared by all users of the
+// cache and must not be modified; use Checkout for a writable copy.
+func (c *SourceCache) Worktree(ctx context.Context, remote, ref string) (string, error) {
+	name := mirrorName(remote)
+	dir := filepath.Join(c.dir, worktreesDir, name, url.PathEscape(ref))
+	done := dir + ".done"
+	if _, err := os.Stat(done); err == nil {
+		return dir, nil
+	}
+
+	unlock, err := c.lock(name)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Another process may have created it while we waited for the lock.
+	if _, err := os.Stat(done); err == nil {
+		return dir, nil
+	}
+	gitDir, err := c.fetch(ctx, remote, ref)
+	if err != nil {
+		return "", err
+	}
+	// Clean up a worktree left behind by an interrupted attempt.
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if _, err := git(ctx, gitDir, "worktree", "prune"); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return "", err
+	}
+	if _, err := git(ctx, gitDir, "worktree", "add", "--detach", "--force", dir, cacheRef(ref)); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(done, nil, 0o644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Checkout writes a private, writable checkout of remote at ref to destDir,
+// fetching ref into the mirror on first use. destDir is created if needed.
+func (c *SourceCache) Checkout(ctx context.Context, remote, ref, destDir string) error {
+	name := mirrorName(remote)
+	unlock, err := c.lock(name)
+	if err != nil {
+		return err
+	}
+	gitDir, err := c.fetch(ctx, remote, ref)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+	// A local fetch copies the objects, so destDir stays valid even if the
+	// mirror is pruned later.
+	if _, err := git(ctx, destDir, "init", "--quiet"); err != nil {
+		return err
+	}
+	if _, err := git(ctx, destDir, "fetch", "--quiet", "--depth=1", gitDir, cacheRef(ref)); err != nil {
+		return err
+	}
+	_, err = git(ctx, destDir, "checkout", "--quiet", "--detach", "FETCH_HEAD")
+	return err
+}
+
+// Mirror makes sure ref of remote is present in its bare mirror and returns
+// the mirror's git directory. Inside the mirror, ref is available as
+// "refs/llar/<ref>" (without a leading "refs/").
+func (c *SourceCache) Mirror(ctx context.Context, remote, ref string) (string, error) {
+	unlock, err := c.lock(mirrorName(remote))
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return c.fetch(ctx, remote, ref)
+}
+
+// fetch makes sure ref of remote is present in its mirror and returns the
+// mirror's git directory. The caller must hold the lock of the mirror.
+//
+// A ref fetched once is not fetched again, so a tag or branch is served as
+// it was on first use; callers that must follow a ref moved upstream
+// resolve it (see Repo.Resolve) and fetch the commit instead. A commit is
+// looked up by its object, which cannot change, and fetched by hash if the
+// mirror lacks it.
+func (c *SourceCache) fetch(ctx context.Context, remote, ref string) (string, error) {
+	gitDir := filepath.Join(c.dir, mirrorsDir, mirrorName(remote)+".git")
+	if _, err := os.Stat(filepath.Join(gitDir, "HEAD")); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(gitDir, 0o755); err != nil {
+			return "", err
+		}
+		if _, err := git(ctx, gitDir, "init", "--quiet", "--bare"); err != nil {
+			return "", err
+		}
+		if _, err := git(ctx, gitDir, "remote", "add", "origin", remote); err != nil {
+			return "", err
+		}
+	}
+	if isCommitHash(ref) {
+		// An earlier fetch of a tag or branch may have brought the commit.
+		if _, err := git(ctx, gitDir, "cat-file", "-e", ref+"^{commit}"); err == nil {
+			_, err = git(ctx, gitDir, "update-ref", cacheRef(ref), ref)
+			return gitDir, err
+		}
+	} else if _, err := git(ctx, gitDir, "rev-parse", "--verify", "--quiet", cacheRef(ref)); err == nil {
+		return gitDir, nil
+	}
+	if _, err := git(ctx, gitDir, "fetch", "--quiet", "--depth=1", "--no-tags", "origin", "+"+ref+":"+cacheRef(ref)); err != nil {
+		return "", err
+	}
+	if isCommitHash(ref) {
+		got, err := git(ctx, gitDir, "rev-parse", "--verify", "--quiet", cacheRef(ref)+"^{commit}")
+		if err != nil {
+			return "", err
+		}
+		if got != ref {
+			return "", fmt.Errorf("fetched %s for commit %s", got, ref)
+		}
+	}
+	return gitDir, nil
+}
+
+// lock acquires the lock of the mirror called name.
+func (c *SourceCache) lock(name string) (unlock func(), err error) {
+	dir := filepath.Join(c.dir, mirrorsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return lockedfile.MutexAt(filepath.Join(dir, name+".lock")).Lock()
+}
+
+// cacheRef returns the mirror ref that ref is fetched into. Keeping
+// fetched refs under a private namespace prevents them from being pruned
+// and lets later lookups succeed without network access.
+func cacheRef(ref string) string {
+	return "refs/llar/" + strings.TrimPrefix(ref, "refs/")
+}
+
+// mirrorName returns a file name identifying remote: the base name of the
+// repository for readability plus a hash of the full URL for uniqueness.
+func mirrorName(remote string) string {
+	sum := sha256.Sum256([]byte(remote))
+	base := strings.TrimSuffix(path.Base(strings.TrimRight(filepath.ToSlash(remote), "/")), ".git")
+	base = strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' || r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, base)
+	return base + "-" + hex.EncodeToString(sum[:6])
+}
+
+// git runs a git command in dir and returns its trimmed output.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	output, err := gitOutput(ctx, dir, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// gitOutput runs a git command in dir and returns its standard output as is.
+func gitOutput(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w\n%s%s", args[0], err, output, stderr.Bytes())
+	}
+	return output, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// worktreeFS is a read-only view of a ref served from a SourceCache
+// worktree. The ref is only fetched when the view is first used.
+type worktreeFS struct {
+	dir func() (string, error)
+}
+
+func newWorktreeFS(cache *SourceCache, remote, ref string) *worktreeFS {
+	return &worktreeFS{
+		dir: sync.OnceValues(func() (string, error) {
+			return cache.Worktree(context.Background(), remote, ref)
+		}),
+	}
+}
+
+func (w *worktreeFS) fsys() (fs.FS, error) {
+	dir, err := w.dir()
+	if err != nil {
+		return nil, err
+	}
+	return os.DirFS(dir), nil
+}
+
+// Open opens the named file.
+func (w *worktreeFS) Open(name string) (fs.File, error) {
+	fsys, err := w.fsys()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return fsys.Open(name)
+}
+
+// ReadFile reads the named file.
+func (w *worktreeFS) ReadFile(name string) ([]byte, error) {
+	fsys, err := w.fsys()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// ReadDir reads the named directory.
+func (w *worktreeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys, err := w.fsys()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return fs.ReadDir(fsys, name)
+}
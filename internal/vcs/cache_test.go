@@ -0,0 +1,244 @@
+// Copyright 2024 The llar Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestRemote creates a local git repository with README tagged v1.0.0
+// (annotated) and v2.0.0, which also adds docs/INSTALL, and returns its
+// path for use as a remote.
+func newTestRemote(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "README"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "--quiet")
+	write("v1")
+	run("add", "README")
+	run("commit", "--quiet", "-m", "v1")
+	run("tag", "-a", "v1.0.0", "-m", "v1.0.0")
+	write("v2")
+	if err := os.MkdirAll(filepath.Join(dir, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docs", "INSTALL"), []byte("make"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "README", "docs")
+	run("commit", "--quiet", "-m", "v2")
+	run("tag", "v2.0.0")
+	return dir
+}
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSourceCache_Worktree(t *testing.T) {
+	remote := newTestRemote(t)
+	c := NewSourceCache(t.TempDir())
+	ctx := context.Background()
+
+	v1, err := c.Worktree(ctx, remote, "v1.0.0")
+	if err != nil {
+		t.Fatalf("Worktree(v1.0.0) error = %v", err)
+	}
+	v2, err := c.Worktree(ctx, remote, "v2.0.0")
+	if err != nil {
+		t.Fatalf("Worktree(v2.0.0) error = %v", err)
+	}
+	if got := readString(t, filepath.Join(v1, "README")); got != "v1" {
+		t.Errorf("v1.0.0 README = %q, want %q", got, "v1")
+	}
+	if got := readString(t, filepath.Join(v2, "README")); got != "v2" {
+		t.Errorf("v2.0.0 README = %q, want %q", got, "v2")
+	}
+	mirrors, _ := filepath.Glob(filepath.Join(c.Dir(), mirrorsDir, "*.git"))
+	if len(mirrors) != 1 {
+		t.Errorf("mirrors = %v, want one mirror per repository", mirrors)
+	}
+
+	// Once fetched, a ref is served without contacting the remote.
+	if err := os.RemoveAll(remote); err != nil {
+		t.Fatal(err)
+	}
+	again, err := c.Worktree(ctx, remote, "v1.0.0")
+	if err != nil {
+		t.Fatalf("cached Worktree(v1.0.0) error = %v", err)
+	}
+	if again != v1 {
+		t.Errorf("cached Worktree = %q, want %q", again, v1)
+	}
+}
+
+func TestSourceCache_Checkout(t *testing.T) {
+	remote := newTestRemote(t)
+	c := NewSourceCache(t.TempDir())
+	ctx := context.Background()
+
+	shared, err := c.Worktree(ctx, remote, "v1.0.0")
+	if err != nil {
+		t.Fatalf("Worktree() error = %v", err)
+	}
+	// The ref is already in the mirror, so no network is needed.
+	if err := os.RemoveAll(remote); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "src")
+	if err := c.Checkout(ctx, remote, "v1.0.0", dest); err != nil {
+		t.Fatalf("Checkout() error = %v", err)
+	}
+	if got := readString(t, filepath.Join(dest, "README")); got != "v1" {
+		t.Errorf("checkout README = %q, want %q", got, "v1")
+	}
+
+	// A checkout is private: changing it leaves the shared worktree alone.
+	if err := os.WriteFile(filepath.Join(dest, "README"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, filepath.Join(shared, "README")); got != "v1" {
+		t.Errorf("shared README = %q after editing checkout, want %q", got, "v1")
+	}
+}
+
+func TestSourceCache_MovedTag(t *testing.T) {
+	remote := newTestRemote(t)
+	c := NewSourceCache(t.TempDir())
+	ctx := context.Background()
+
+	if err := c.Checkout(ctx, remote, "v2.0.0", filepath.Join(t.TempDir(), "src")); err != nil {
+		t.Fatalf("Checkout(v2.0.0) error = %v", err)
+	}
+	// The tag moves upstream to a new commit.
+	if err := os.WriteFile(filepath.Join(remote, "README"), []byte("v2.1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"commit", "--quiet", "-am", "v2.1"}, {"tag", "-f", "v2.0.0"}} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = remote
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	// The cached tag still serves the first fetch; its resolved commit
+	// brings the new sources.
+	commit, err := lsRemoteResolve(ctx, remote, "v2.0.0")
+	if err != nil {
+		t.Fatalf("lsRemoteResolve() error = %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "src")
+	if err := c.Checkout(ctx, remote, commit, dest); err != nil {
+		t.Fatalf("Checkout(%s) error = %v", commit, err)
+	}
+	if got := readString(t, filepath.Join(dest, "README")); got != "v2.1" {
+		t.Errorf("checkout of the moved tag's commit README = %q, want %q", got, "v2.1")
+	}
+
+	// A commit already in the mirror is served without the remote.
+	if err := os.RemoveAll(remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Checkout(ctx, remote, commit, filepath.Join(t.TempDir(), "src")); err != nil {
+		t.Fatalf("cached Checkout(%s) error = %v", commit, err)
+	}
+}
+
+func TestSourceCache_UnknownRef(t *testing.T) {
+	remote := newTestRemote(t)
+	c := NewSourceCache(t.TempDir())
+	if _, err := c.Worktree(context.Background(), remote, "v9.9.9"); err == nil {
+		t.Fatal("Worktree(v9.9.9) error = nil, want fetch error")
+	}
+}
+
+func TestSourceCache_Concurrent(t *testing.T) {
+	remote := newTestRemote(t)
+	c := NewSourceCache(t.TempDir())
+
+	const n = 8
+	dirs := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dirs[i], errs[i] = c.Worktree(context.Background(), remote, "v2.0.0")
+		}()
+	}
+	wg.Wait()
+	for i := range n {
+		if errs[i] != nil {
+			t.Fatalf("Worktree #%d error = %v", i, errs[i])
+		}
+		if dirs[i] != dirs[0] {
+			t.Errorf("Worktree #%d = %q, want %q", i, dirs[i], dirs[0])
+		}
+	}
+}
+
+func TestWorktreeFS(t *testing.T) {
+	remote := newTestRemote(t)
+	c := NewSourceCache(t.TempDir())
+
+	fsys := newWorktreeFS(c, remote, "v2.0.0")
+	data, err := fsys.ReadFile("README")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(data) != "v2" {
+		t.Errorf("README = %q, want %q", data, "v2")
+	}
+	if _, err := fs.Stat(fsys, "README"); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	}
+
+	// Nothing is fetched until the view is used.
+	bad := newWorktreeFS(c, filepath.Join(t.TempDir(), "missing"), "v1.0.0")
+	if _, err := bad.ReadDir("."); err == nil {
+		t.Error("ReadDir() on missing remote error = nil, want error")
+	}
+}
+
+func TestMirrorName(t *testing.T) {
+	a := mirrorName("https://github.com/madler/zlib.git")
+	b := mirrorName("https://gitlab.com/madler/zlib.git")
+	if a == b {
+		t.Errorf("mirrorName does not distinguish hosts: %q", a)
+	}
+	if got := a[:len("zlib-")]; got != "zlib-" {
+		t.Errorf("mirrorName = %q, want zlib- prefix", a)
+	}
+}
@@ -6,7 +6,6 @@ package vcs
 
 import (
 	"context"
-	"fmt"
 	"io/fs"
 )
 
@@ -18,6 +17,9 @@ type client interface {
 	// Latest returns the latest commit hash on the default branch.
 	Latest(ctx context.Context, owner, repo string) (string, error)
 
+	// Resolve returns the commit hash that ref (a tag, branch or commit) points to.
+	Resolve(ctx context.Context, owner, repo, ref string) (string, error)
+
 	// Stat returns file info for the given path.
 	Stat(ctx context.Context, owner, repo, ref, path string) (fs.FileInfo, error)
 
@@ -27,13 +29,3 @@ type client interface {
 	// SyncDir downloads a directory to the destination directory.
 	SyncDir(ctx context.Context, owner, repo, ref, path, destDir string) error
 }
-
-// newClient creates a client for the specified host.
-func newClient(host string) (client, error) {
-	switch host {
-	case "github.com":
-		return newGitHubClient(), nil
-	default:
-		return nil, fmt.Errorf("unsupported host: %s", host)
-	}
-}
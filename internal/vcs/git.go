@@ -0,0 +1,109 @@
+// Copyright 2024 The llar Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// gitClient implements the client interface for any git remote (https://,
+// ssh://, file:// or scp-like user@host:path) using git plumbing only.
+// Refs are fetched into the bare mirror of a SourceCache and single files
+// are read from there, so no host-specific API is needed.
+//
+// The owner and repo arguments of the client methods are ignored: a
+// gitClient is bound to a single remote.
+type gitClient struct {
+	remote string
+	cache  *SourceCache
+}
+
+func newGitClient(remote string, cache *SourceCache) *gitClient {
+	return &gitClient{remote: remote, cache: cache}
+}
+
+// Tags returns all tags from the repository using git ls-remote.
+func (g *gitClient) Tags(ctx context.Context, owner, repo string) ([]string, error) {
+	return lsRemoteTags(ctx, g.remote)
+}
+
+// Latest returns the latest commit hash on the default branch using git ls-remote.
+func (g *gitClient) Latest(ctx context.Context, owner, repo string) (string, error) {
+	return lsRemoteHead(ctx, g.remote)
+}
+
+// Resolve returns the commit hash that ref points to using git ls-remote.
+func (g *gitClient) Resolve(ctx context.Context, owner, repo, ref string) (string, error) {
+	return lsRemoteResolve(ctx, g.remote, ref)
+}
+
+// Stat returns file info for the given path using git cat-file.
+func (g *gitClient) Stat(ctx context.Context, owner, repo, ref, name string) (fs.FileInfo, error) {
+	gitDir, err := g.cache.Mirror(ctx, g.remote, gitRef(ref))
+	if err != nil {
+		return nil, err
+	}
+	object := objectName(ref, name)
+	typ, err := git(ctx, gitDir, "cat-file", "-t", object)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	info := &fileInfo{name: path.Base(name), mode: 0o644}
+	if typ == "tree" {
+		info.mode = fs.ModeDir | 0o755
+		info.isDir = true
+		return info, nil
+	}
+	size, err := git(ctx, gitDir, "cat-file", "-s", object)
+	if err != nil {
+		return nil, err
+	}
+	if info.size, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid size of %s: %w", name, err)
+	}
+	return info, nil
+}
+
+// ReadFile reads the content of a file using git cat-file.
+func (g *gitClient) ReadFile(ctx context.Context, owner, repo, ref, name string) ([]byte, error) {
+	gitDir, err := g.cache.Mirror(ctx, g.remote, gitRef(ref))
+	if err != nil {
+		return nil, err
+	}
+	data, err := gitOutput(ctx, gitDir, "cat-file", "blob", objectName(ref, name))
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %s", name)
+	}
+	return data, nil
+}
+
+// SyncDir downloads a directory to the destination directory. The whole
+// repository is copied from the source cache; a subdirectory is fetched
+// with a sparse checkout.
+func (g *gitClient) SyncDir(ctx context.Context, owner, repo, ref, path, destDir string) error {
+	if path == "" || path == "." {
+		return g.cache.Checkout(ctx, g.remote, gitRef(ref), destDir)
+	}
+	return syncDir(ctx, g.remote, gitRef(ref), path, destDir)
+}
+
+// gitRef returns the ref to fetch for ref, which is HEAD (the default
+// branch) if ref is empty.
+func gitRef(ref string) string {
+	if ref == "" {
+		return "HEAD"
+	}
+	return ref
+}
+
+// objectName returns the git object name of path at ref in a mirror.
+func objectName(ref, path string) string {
+	return cacheRef(gitRef(ref)) + ":" + strings.TrimPrefix(path, "./")
+}
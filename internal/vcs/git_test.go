@@ -0,0 +1,136 @@
+// Copyright 2024 The llar Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vcs
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestGitRepo returns a Repo for a local remote created by
+// newTestRemote, accessed through a file:// URL.
+func newTestGitRepo(t *testing.T) (Repo, string) {
+	t.Helper()
+	remote := newTestRemote(t)
+	r, err := NewCachedRepo("file://"+filepath.ToSlash(remote), NewSourceCache(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewCachedRepo() error = %v", err)
+	}
+	return r, remote
+}
+
+func TestGitClient_Versions(t *testing.T) {
+	r, remote := newTestGitRepo(t)
+	ctx := context.Background()
+
+	tags, err := r.Tags(ctx)
+	if err != nil {
+		t.Fatalf("Tags() error = %v", err)
+	}
+	slices.Sort(tags)
+	if want := []string{"v1.0.0", "v2.0.0"}; !slices.Equal(tags, want) {
+		t.Errorf("Tags() = %v, want %v", tags, want)
+	}
+
+	head, err := git(ctx, remote, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := r.Latest(ctx)
+	if err != nil {
+		t.Fatalf("Latest() error = %v", err)
+	}
+	if latest != head {
+		t.Errorf("Latest() = %q, want %q", latest, head)
+	}
+
+	// Annotated tags resolve to the commit, not the tag object.
+	want, err := git(ctx, remote, "rev-parse", "v1.0.0^{commit}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Resolve(ctx, "v1.0.0")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Resolve(v1.0.0) = %q, want %q", got, want)
+	}
+}
+
+func TestGitClient_ReadFile(t *testing.T) {
+	remote := newTestRemote(t)
+	c := newGitClient("file://"+filepath.ToSlash(remote), NewSourceCache(t.TempDir()))
+	ctx := context.Background()
+
+	data, err := c.ReadFile(ctx, "", "", "v1.0.0", "README")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(data) != "v1" {
+		t.Errorf("ReadFile(v1.0.0, README) = %q, want %q", data, "v1")
+	}
+	if data, err = c.ReadFile(ctx, "", "", "", "docs/INSTALL"); err != nil || string(data) != "make" {
+		t.Errorf("ReadFile(HEAD, docs/INSTALL) = %q, %v, want %q", data, err, "make")
+	}
+	if _, err := c.ReadFile(ctx, "", "", "v1.0.0", "docs/INSTALL"); err == nil {
+		t.Error("ReadFile of a file missing at v1.0.0 error = nil, want error")
+	}
+
+	info, err := c.Stat(ctx, "", "", "v2.0.0", "README")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Name() != "README" || info.Size() != 2 || info.IsDir() {
+		t.Errorf("Stat(README) = %s size %d dir %v, want README size 2 file", info.Name(), info.Size(), info.IsDir())
+	}
+	if info, err := c.Stat(ctx, "", "", "v2.0.0", "docs"); err != nil || !info.IsDir() {
+		t.Errorf("Stat(docs) = %v, %v, want a directory", info, err)
+	}
+	if _, err := c.Stat(ctx, "", "", "v2.0.0", "missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat(missing) error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestGitClient_SyncDir(t *testing.T) {
+	remote := newTestRemote(t)
+	c := newGitClient("file://"+filepath.ToSlash(remote), NewSourceCache(t.TempDir()))
+	ctx := context.Background()
+
+	all := t.TempDir()
+	if err := c.SyncDir(ctx, "", "", "v2.0.0", "", all); err != nil {
+		t.Fatalf("SyncDir(whole) error = %v", err)
+	}
+	if got := readString(t, filepath.Join(all, "docs", "INSTALL")); got != "make" {
+		t.Errorf("docs/INSTALL = %q, want %q", got, "make")
+	}
+
+	sub := t.TempDir()
+	if err := c.SyncDir(ctx, "", "", "v2.0.0", "docs", sub); err != nil {
+		t.Fatalf("SyncDir(docs) error = %v", err)
+	}
+	// Like a sparse checkout, the files keep their path in the repository.
+	if got := readString(t, filepath.Join(sub, "docs", "INSTALL")); got != "make" {
+		t.Errorf("docs/INSTALL = %q, want %q", got, "make")
+	}
+	if _, err := os.Stat(filepath.Join(sub, "README")); !os.IsNotExist(err) {
+		t.Errorf("SyncDir(docs) checked out README, stat error = %v", err)
+	}
+}
+
+func TestGitRepo_At(t *testing.T) {
+	r, _ := newTestGitRepo(t)
+	data, err := fs.ReadFile(r.At("v2.0.0", ""), "docs/INSTALL")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(data) != "make" {
+		t.Errorf("docs/INSTALL = %q, want %q", data, "make")
+	}
+}
@@ -7,9 +7,7 @@ package vcs
 import (
 	"context"
 	"fmt"
-	"io"
 	"io/fs"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,24 +15,13 @@ import (
 	"time"
 )
 
-// githubClient implements client interface using raw GitHub URLs (no API).
-type githubClient struct {
-	httpClient *http.Client
+// githubRemote returns the git URL of a GitHub repository.
+func githubRemote(owner, repo string) string {
+	return fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
 }
 
-// newGitHubClient creates a new GitHub client.
-func newGitHubClient() *githubClient {
-	return &githubClient{
-		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
-		},
-	}
-}
-
-// Tags returns all tags from the repository using git ls-remote.
-func (g *githubClient) Tags(ctx context.Context, owner, repo string) ([]string, error) {
-	repoURL := fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
-
+// lsRemoteTags returns all tags of repoURL using git ls-remote.
+func lsRemoteTags(ctx context.Context, repoURL string) ([]string, error) {
 	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--tags", "--refs", repoURL)
 	output, err := cmd.Output()
 	if err != nil {
@@ -60,10 +47,8 @@ func (g *githubClient) Tags(ctx context.Context, owner, repo string) ([]string,
 	return tags, nil
 }
 
-// Latest returns the latest commit hash on the default branch using git ls-remote.
-func (g *githubClient) Latest(ctx context.Context, owner, repo string) (string, error) {
-	repoURL := fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
-
+// lsRemoteHead returns the commit hash of HEAD of repoURL using git ls-remote.
+func lsRemoteHead(ctx context.Context, repoURL string) (string, error) {
 	cmd := exec.CommandContext(ctx, "git", "ls-remote", repoURL, "HEAD")
 	output, err := cmd.Output()
 	if err != nil {
@@ -84,68 +69,61 @@ func (g *githubClient) Latest(ctx context.Context, owner, repo string) (string,
 	return parts[0], nil
 }
 
-// Stat returns file info for the given path using HEAD request.
-func (g *githubClient) Stat(ctx context.Context, owner, repo, ref, path string) (fs.FileInfo, error) {
-	// Try as file first
-	url := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", owner, repo, ref, path)
-	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
-	if err != nil {
-		return nil, err
+// lsRemoteResolve returns the commit hash that ref of repoURL points to
+// using git ls-remote. A full commit hash is returned as is.
+func lsRemoteResolve(ctx context.Context, repoURL, ref string) (string, error) {
+	if isCommitHash(ref) {
+		return ref, nil
 	}
-
-	resp, err := g.httpClient.Do(req)
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", repoURL, ref, ref+"^{}")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.Output()
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		// It's a file
-		size := resp.ContentLength
-		if size < 0 {
-			size = 0
-		}
-		return &fileInfo{
-			name:  filepath.Base(path),
-			size:  size,
-			mode:  0644,
-			isDir: false,
-		}, nil
+		return "", fmt.Errorf("git ls-remote: %w", err)
 	}
-
-	return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	return parseResolvedRef(string(output), ref)
 }
 
-// ReadFile reads the content of a file using raw.githubusercontent.com.
-func (g *githubClient) ReadFile(ctx context.Context, owner, repo, ref, path string) ([]byte, error) {
-	url := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", owner, repo, ref, path)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
+// parseResolvedRef picks the commit for ref out of git ls-remote output.
+// Annotated tags are listed twice; the peeled "^{}" entry names the commit
+// rather than the tag object, so it wins.
+func parseResolvedRef(output, ref string) (string, error) {
+	var hash string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		// Format: <sha>\t<refname>
+		sha, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(name, "^{}") {
+			return sha, nil
+		}
+		if hash == "" {
+			hash = sha
+		}
 	}
-
-	resp, err := g.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("fetch file: %w", err)
+	if hash == "" {
+		return "", fmt.Errorf("ref %s not found", ref)
 	}
-	defer resp.Body.Close()
+	return hash, nil
+}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("file not found: %s", path)
+// isCommitHash reports whether ref is a full hexadecimal commit hash.
+func isCommitHash(ref string) bool {
+	if len(ref) != 40 && len(ref) != 64 {
+		return false
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, path)
+	for _, c := range ref {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
 	}
-
-	return io.ReadAll(resp.Body)
+	return true
 }
 
-// SyncDir downloads a directory to the destination directory using git sparse-checkout.
-// This is more efficient than tarball for large repositories when only a subdirectory is needed.
-// Falls back to tarball method if sparse-checkout fails.
-func (g *githubClient) SyncDir(ctx context.Context, owner, repo, ref, path, destDir string) error {
+// syncDir downloads path of repoURL at ref to destDir, preferring a sparse
+// checkout and falling back to a shallow clone of the whole repository.
+func syncDir(ctx context.Context, repoURL, ref, path, destDir string) error {
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return err
 	}
@@ -158,7 +136,7 @@ func (g *githubClient) SyncDir(ctx context.Context, owner, repo, ref, path, dest
 
 	// Try sparse-checkout first (more efficient for subdirectories)
 	if path != "" {
-		err := g.syncDirSparse(ctx, owner, repo, ref, path, destDir)
+		err := syncSparse(ctx, repoURL, ref, path, destDir)
 		if err == nil {
 			return nil
 		}
@@ -166,15 +144,13 @@ func (g *githubClient) SyncDir(ctx context.Context, owner, repo, ref, path, dest
 	}
 
 	// Use shallow clone for root directory or as fallback
-	return g.syncDirShallowClone(ctx, owner, repo, ref, destDir)
+	return syncShallowClone(ctx, repoURL, ref, destDir)
 }
 
-// syncDirSparse uses git sparse-checkout to download only the specified directory.
-// This is much more efficient for large repositories.
-// If the directory already contains a git repo, it will fetch and update instead of re-cloning.
-func (g *githubClient) syncDirSparse(ctx context.Context, owner, repo, ref, path, destDir string) error {
-	repoURL := fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
-
+// syncSparse uses git sparse-checkout to download only path of repoURL at
+// ref to destDir, which is much more efficient for large repositories. If
+// destDir already holds a sparse checkout, path is added to it.
+func syncSparse(ctx context.Context, repoURL, ref, path, destDir string) error {
 	// Helper to run git commands in destDir
 	runGit := func(args ...string) error {
 		cmd := exec.CommandContext(ctx, "git", args...)
@@ -224,12 +200,9 @@ func (g *githubClient) syncDirSparse(ctx context.Context, owner, repo, ref, path
 	return nil
 }
 
-// syncDirShallowClone uses git init + fetch + checkout to download the repository.
-// Used for root directory sync or as fallback when sparse-checkout fails.
-// Works with empty, non-empty, or existing git directories.
-func (g *githubClient) syncDirShallowClone(ctx context.Context, owner, repo, ref, destDir string) error {
-	repoURL := fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
-
+// syncShallowClone uses git init + fetch + checkout to download repoURL at
+// ref to destDir, which may be empty, non-empty or an existing checkout.
+func syncShallowClone(ctx context.Context, repoURL, ref, destDir string) error {
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return err
 	}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"slices"
 	"strings"
 )
 
@@ -18,39 +19,73 @@ type Repo interface {
 	Tags(ctx context.Context) ([]string, error)
 	// Latest returns the latest commit hash on the default branch.
 	Latest(ctx context.Context) (string, error)
+	// Resolve returns the commit hash that ref (a tag, branch or commit) points to.
+	Resolve(ctx context.Context, ref string) (string, error)
 	// At returns a filesystem view of the repository at the specified ref.
 	// The returned fs.FS also implements fs.ReadFileFS and fs.ReadDirFS.
+	// Repos backed by a SourceCache serve it from the shared worktree of
+	// ref and ignore localDir.
 	At(ref, localDir string) fs.FS
-	// Sync downloads the specified path to localDir.
+	// Sync downloads the specified path to localDir. Repos backed by a
+	// SourceCache copy the whole repository (empty path) from the cache,
+	// so it is only fetched over the network once.
 	Sync(ctx context.Context, ref, path, localDir string) error
 }
 
 // repo is the default implementation of Repo.
 type repo struct {
 	client client
+	cache  *SourceCache // nil disables the source cache
 	host   string
 	owner  string
 	name   string
+	url    string // git URL of the repository
 }
 
-// NewRepo creates a new Repo for the given repository path.
-// repoPath format: "github.com/owner/repo"
+// NewRepo creates a new Repo for the given repository path, backed by the
+// source cache in DefaultSourceCacheDir.
+//
+// repoPath is either "host/owner/repo", which is fetched over https from
+// any git host (e.g. "github.com/madler/zlib", "gitlab.com/group/sub/repo"),
+// or a git URL: "https://...", "ssh://...", "file://..." or the scp-like
+// "user@host:path".
 func NewRepo(repoPath string) (Repo, error) {
-	host, owner, repoName, err := parseRepoPath(repoPath)
+	cacheDir, err := DefaultSourceCacheDir()
 	if err != nil {
 		return nil, err
 	}
+	return NewCachedRepo(repoPath, NewSourceCache(cacheDir))
+}
 
-	c, err := newClient(host)
+// NewCachedRepo is like NewRepo but uses cache for sources. A nil cache
+// reads files and directories through the client instead of a shared
+// worktree; as plain git cannot read single files remotely, the client
+// still fetches into a source cache in DefaultSourceCacheDir then.
+//
+// Every host, GitHub included, is accessed with plain git, so private
+// repositories and mirrors work the same way everywhere.
+func NewCachedRepo(repoPath string, cache *SourceCache) (Repo, error) {
+	host, owner, repoName, url, err := parseRepoPath(repoPath)
 	if err != nil {
 		return nil, err
 	}
 
+	clientCache := cache
+	if clientCache == nil {
+		cacheDir, err := DefaultSourceCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		clientCache = NewSourceCache(cacheDir)
+	}
+
 	return &repo{
-		client: c,
+		client: newGitClient(url, clientCache),
+		cache:  cache,
 		host:   host,
 		owner:  owner,
 		name:   repoName,
+		url:    url,
 	}, nil
 }
 
@@ -64,8 +99,16 @@ func (r *repo) Latest(ctx context.Context) (string, error) {
 	return r.client.Latest(ctx, r.owner, r.name)
 }
 
+// Resolve returns the commit hash that ref points to.
+func (r *repo) Resolve(ctx context.Context, ref string) (string, error) {
+	return r.client.Resolve(ctx, r.owner, r.name, ref)
+}
+
 // At returns a filesystem view of the repository at the specified ref.
 func (r *repo) At(ref, localDir string) fs.FS {
+	if r.cache != nil {
+		return newWorktreeFS(r.cache, r.remote(), ref)
+	}
 	return &repoFS{
 		client:   r.client,
 		owner:    r.owner,
@@ -77,14 +120,53 @@ func (r *repo) At(ref, localDir string) fs.FS {
 
 // Sync downloads the specified path to localDir.
 func (r *repo) Sync(ctx context.Context, ref, path, localDir string) error {
+	if r.cache != nil && (path == "" || path == ".") {
+		return r.cache.Checkout(ctx, r.remote(), ref, localDir)
+	}
 	return r.client.SyncDir(ctx, r.owner, r.name, ref, path, localDir)
 }
 
-// parseRepoPath parses "github.com/owner/repo" into components.
-func parseRepoPath(repoPath string) (host, owner, repo string, err error) {
+// remote returns the git URL of the repository.
+func (r *repo) remote() string {
+	return r.url
+}
+
+// parseRepoPath parses repoPath (see NewRepo) into its host, owner and
+// repository name, and the git URL to fetch it from. For git URLs, owner
+// is empty and repo is the path of the repository on host.
+func parseRepoPath(repoPath string) (host, owner, repo, url string, err error) {
+	if scheme, rest, ok := strings.Cut(repoPath, "://"); ok {
+		switch scheme {
+		case "https", "http", "ssh", "git", "file":
+		default:
+			return "", "", "", "", fmt.Errorf("invalid repo path: %s, unsupported scheme %q", repoPath, scheme)
+		}
+		host, repo, _ = strings.Cut(rest, "/")
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		repo = strings.TrimSuffix(strings.Trim(repo, "/"), ".git")
+		if repo == "" {
+			return "", "", "", "", fmt.Errorf("invalid repo path: %s, missing repository", repoPath)
+		}
+		return host, "", repo, repoPath, nil
+	}
+	if userHost, rest, ok := strings.Cut(repoPath, ":"); ok && strings.Contains(userHost, "@") && !strings.Contains(userHost, "/") {
+		// scp-like syntax: user@host:path
+		repo = strings.TrimSuffix(strings.Trim(rest, "/"), ".git")
+		if repo == "" {
+			return "", "", "", "", fmt.Errorf("invalid repo path: %s, missing repository", repoPath)
+		}
+		return userHost[strings.LastIndex(userHost, "@")+1:], "", repo, repoPath, nil
+	}
+
 	parts := strings.Split(repoPath, "/")
-	if len(parts) < 3 {
-		return "", "", "", fmt.Errorf("invalid repo path: %s, expected host/owner/repo", repoPath)
+	if len(parts) < 3 || slices.Contains(parts, "") {
+		return "", "", "", "", fmt.Errorf("invalid repo path: %s, expected host/owner/repo or a git URL", repoPath)
+	}
+	host, owner, repo = parts[0], parts[1], strings.Join(parts[2:], "/")
+	if host == "github.com" {
+		return host, owner, repo, githubRemote(owner, repo), nil
 	}
-	return parts[0], parts[1], strings.Join(parts[2:], "/"), nil
+	return host, owner, repo, fmt.Sprintf("https://%s/%s/%s.git", host, owner, repo), nil
 }
@@ -20,43 +20,106 @@ func TestParseRepoPath(t *testing.T) {
 		wantHost  string
 		wantOwner string
 		wantRepo  string
+		wantURL   string
 		wantErr   bool
 	}{
-		{"github.com/owner/repo", "github.com", "owner", "repo", false},
-		{"github.com/owner/repo/sub", "github.com", "owner", "repo/sub", false},
-		{"gitlab.com/org/project", "gitlab.com", "org", "project", false},
-		{"invalid", "", "", "", true},
-		{"only/two", "", "", "", true},
+		{"github.com/owner/repo", "github.com", "owner", "repo", "https://github.com/owner/repo.git", false},
+		{"github.com/owner/repo/sub", "github.com", "owner", "repo/sub", "https://github.com/owner/repo/sub.git", false},
+		{"gitlab.com/org/project", "gitlab.com", "org", "project", "https://gitlab.com/org/project.git", false},
+		{"https://gitea.example.com/org/zlib.git", "gitea.example.com", "", "org/zlib", "https://gitea.example.com/org/zlib.git", false},
+		{"ssh://git@gitlab.com/group/sub/zlib.git", "gitlab.com", "", "group/sub/zlib", "ssh://git@gitlab.com/group/sub/zlib.git", false},
+		{"file:///srv/git/zlib", "", "", "srv/git/zlib", "file:///srv/git/zlib", false},
+		{"git@gitlab.com:group/zlib.git", "gitlab.com", "", "group/zlib", "git@gitlab.com:group/zlib.git", false},
+		{"invalid", "", "", "", "", true},
+		{"only/two", "", "", "", "", true},
+		{"github.com//repo", "", "", "", "", true},
+		{"ftp://example.com/repo", "", "", "", "", true},
+		{"https://example.com/", "", "", "", "", true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			host, owner, repo, err := parseRepoPath(tt.input)
+			host, owner, repo, url, err := parseRepoPath(tt.input)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseRepoPath(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
 				return
 			}
-			if host != tt.wantHost || owner != tt.wantOwner || repo != tt.wantRepo {
-				t.Errorf("parseRepoPath(%q) = (%q, %q, %q), want (%q, %q, %q)",
-					tt.input, host, owner, repo, tt.wantHost, tt.wantOwner, tt.wantRepo)
+			if host != tt.wantHost || owner != tt.wantOwner || repo != tt.wantRepo || url != tt.wantURL {
+				t.Errorf("parseRepoPath(%q) = (%q, %q, %q, %q), want (%q, %q, %q, %q)",
+					tt.input, host, owner, repo, url, tt.wantHost, tt.wantOwner, tt.wantRepo, tt.wantURL)
 			}
 		})
 	}
 }
 
+func TestParseResolvedRef(t *testing.T) {
+	const (
+		tagObj = "1111111111111111111111111111111111111111"
+		commit = "2222222222222222222222222222222222222222"
+	)
+	tests := []struct {
+		name    string
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{"lightweight tag", commit + "\trefs/tags/v1.0.0\n", commit, false},
+		{"annotated tag", tagObj + "\trefs/tags/v1.0.0\n" + commit + "\trefs/tags/v1.0.0^{}\n", commit, false},
+		{"not found", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResolvedRef(tt.output, "v1.0.0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseResolvedRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseResolvedRef() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCommitHash(t *testing.T) {
+	// A full commit hash resolves to itself without touching the network.
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	got, err := newGitClient(githubRemote("owner", "repo"), nil).Resolve(context.Background(), "owner", "repo", hash)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got != hash {
+		t.Errorf("Resolve() = %q, want %q", got, hash)
+	}
+	if isCommitHash("v1.0.0") {
+		t.Error("isCommitHash(v1.0.0) = true, want false")
+	}
+}
+
 func TestNewRepo(t *testing.T) {
-	// Test unsupported host
-	_, err := NewRepo("unsupported.com/owner/repo")
+	// Test invalid repo path
+	_, err := NewRepo("invalid")
 	if err == nil {
-		t.Error("NewRepo with unsupported host should return error")
+		t.Error("NewRepo with invalid path should return error")
 	}
 
-	// Test valid GitHub repo
-	r, err := NewRepo("github.com/golang/go")
+	// Test repo on another git host
+	r, err := NewRepo("gitlab.com/owner/repo")
+	if err != nil {
+		t.Fatalf("NewRepo failed: %v", err)
+	}
+	if _, ok := r.(*repo).client.(*gitClient); !ok {
+		t.Errorf("NewRepo(gitlab.com/...) client = %T, want *gitClient", r.(*repo).client)
+	}
+
+	// Test valid GitHub repo, also read with plain git
+	r, err = NewRepo("github.com/golang/go")
 	if err != nil {
 		t.Fatalf("NewRepo failed: %v", err)
 	}
 	rr := r.(*repo)
+	if c, ok := rr.client.(*gitClient); !ok || c.remote != "https://github.com/golang/go.git" {
+		t.Errorf("NewRepo(github.com/...) client = %#v, want *gitClient of https://github.com/golang/go.git", rr.client)
+	}
 	if rr.host != "github.com" || rr.owner != "golang" || rr.name != "go" {
 		t.Errorf("NewRepo parsed incorrectly: got host=%q owner=%q name=%q",
 			rr.host, rr.owner, rr.name)
@@ -64,9 +127,9 @@ func TestNewRepo(t *testing.T) {
 }
 
 func TestRepoAt(t *testing.T) {
-	r, err := NewRepo("github.com/golang/go")
+	r, err := NewCachedRepo("github.com/golang/go", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -88,9 +151,9 @@ func TestRepoTags(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	repo, err := NewRepo("github.com/google/go-github")
+	repo, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	ctx := context.Background()
@@ -109,9 +172,9 @@ func TestRepoLatest(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	repo, err := NewRepo("github.com/google/go-github")
+	repo, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	ctx := context.Background()
@@ -131,9 +194,9 @@ func TestRepoFSReadFile(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -172,9 +235,9 @@ func TestRepoFSReadDir(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -210,9 +273,9 @@ func TestRepoSync(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -245,9 +308,9 @@ func TestRepoFSOpen(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	repo, err := NewRepo("github.com/google/go-github")
+	repo, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -297,9 +360,9 @@ func TestRepoFSOpenStatBeforeRead(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	repo, err := NewRepo("github.com/google/go-github")
+	repo, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -333,9 +396,9 @@ func TestRepoFSOpenMultipleReads(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	repo, err := NewRepo("github.com/google/go-github")
+	repo, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -372,9 +435,9 @@ func TestRepoFSOpenNonExistent(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	repo, err := NewRepo("github.com/google/go-github")
+	repo, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -399,9 +462,9 @@ func TestRepoFSOpenFromCache(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	repo, err := NewRepo("github.com/google/go-github")
+	repo, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -436,7 +499,7 @@ func TestGitHubClientSyncDirSparse(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	client := newGitHubClient()
+	client := newGitClient(githubRemote("google", "go-github"), NewSourceCache(t.TempDir()))
 	ctx := context.Background()
 	destDir := t.TempDir()
 
@@ -498,7 +561,7 @@ func TestGitHubClientSyncDirShallowClone(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	client := newGitHubClient()
+	client := newGitClient(githubRemote("google", "go-github"), NewSourceCache(t.TempDir()))
 	ctx := context.Background()
 	destDir := t.TempDir()
 
@@ -532,7 +595,7 @@ func TestGitHubClientSyncDirSparseUpdate(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	client := newGitHubClient()
+	client := newGitClient(githubRemote("google", "go-github"), NewSourceCache(t.TempDir()))
 	ctx := context.Background()
 	destDir := t.TempDir()
 
@@ -565,7 +628,7 @@ func TestGitHubClientSyncDirShallowCloneUpdate(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	client := newGitHubClient()
+	client := newGitClient(githubRemote("google", "go-github"), NewSourceCache(t.TempDir()))
 	ctx := context.Background()
 	destDir := t.TempDir()
 
@@ -601,9 +664,9 @@ func TestRepoFS_ReadFileCacheVerification(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -636,9 +699,9 @@ func TestRepoFS_OpenReadCacheVerification(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -677,9 +740,9 @@ func TestRepoFS_StatCacheVerification(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -727,9 +790,9 @@ func TestRepoFS_ReadDirCacheVerification(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -769,7 +832,6 @@ func TestRepoFS_ReadDirCacheVerification(t *testing.T) {
 }
 
 func TestSyncDirSparse_InitBranchError(t *testing.T) {
-	c := newGitHubClient()
 	destDir := t.TempDir()
 
 	// Cancelled context makes exec.CommandContext fail
@@ -778,14 +840,13 @@ func TestSyncDirSparse_InitBranchError(t *testing.T) {
 
 	// .git does not exist → enters IsNotExist branch
 	// runGit("init") error is ignored, but sparse-checkout init will fail
-	err := c.syncDirSparse(ctx, "owner", "repo", "v1.0", "sub", destDir)
+	err := syncSparse(ctx, githubRemote("owner", "repo"), "v1.0", "sub", destDir)
 	if err == nil {
 		t.Error("expected error from cancelled context")
 	}
 }
 
 func TestSyncDirSparse_AddBranchError(t *testing.T) {
-	c := newGitHubClient()
 	destDir := t.TempDir()
 
 	// Create .git so it enters the else (add) branch
@@ -796,7 +857,7 @@ func TestSyncDirSparse_AddBranchError(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	err := c.syncDirSparse(ctx, "owner", "repo", "v1.0", "sub", destDir)
+	err := syncSparse(ctx, githubRemote("owner", "repo"), "v1.0", "sub", destDir)
 	if err == nil {
 		t.Error("expected error from cancelled context")
 	}
@@ -807,7 +868,7 @@ func TestGitHubClientSyncDirSparseMultiplePaths(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	client := newGitHubClient()
+	client := newGitClient(githubRemote("google", "go-github"), NewSourceCache(t.TempDir()))
 	ctx := context.Background()
 	destDir := t.TempDir()
 
@@ -861,9 +922,9 @@ func TestRepoFS_LoadMkdirError(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -888,9 +949,9 @@ func TestRepoFS_LoadWriteError(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -914,9 +975,9 @@ func TestRepoFS_ReadFileRemoteError(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -934,9 +995,9 @@ func TestRepoFS_StatRemoteFileInfo(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -980,9 +1041,9 @@ func TestRepoFS_ReadDirSyncError(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -1002,9 +1063,9 @@ func TestRepoFS_StatNonExistent(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -1029,9 +1090,9 @@ func TestRepoFS_ReadFileThenSync(t *testing.T) {
 		t.Skip("skipping integration test in short mode")
 	}
 
-	r, err := NewRepo("github.com/google/go-github")
+	r, err := NewCachedRepo("github.com/google/go-github", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -1095,9 +1156,9 @@ func TestRepoFS_ReadDirSyncFetchError(t *testing.T) {
 	}
 
 	// Use a non-existent repo so git fetch fails, covering repofs.go SyncDir error path
-	r, err := NewRepo("github.com/google/this-repo-does-not-exist-xyz-12345")
+	r, err := NewCachedRepo("github.com/google/this-repo-does-not-exist-xyz-12345", nil)
 	if err != nil {
-		t.Fatalf("NewRepo failed: %v", err)
+		t.Fatalf("NewCachedRepo failed: %v", err)
 	}
 
 	localDir := t.TempDir()
@@ -0,0 +1,408 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vet reports mistakes in formula modules on disk. It only parses
+// their files, like the resolver reads fromVer, without compiling or
+// running formulas and without network access.
+package vet
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goplus/ixgo/xgobuild"
+	"github.com/goplus/llar/mod/versions"
+	"github.com/goplus/xgo/ast"
+	"github.com/goplus/xgo/parser"
+	"github.com/goplus/xgo/scanner"
+	"github.com/goplus/xgo/token"
+)
+
+const (
+	formulaSuffix    = "_llar.gox"
+	comparatorSuffix = "_cmp.gox"
+)
+
+// Diagnostic is a problem found in a file of a formula module.
+type Diagnostic struct {
+	Pos     token.Position // Line is 0 if the problem is not at a line
+	Message string
+}
+
+// String returns the diagnostic as "file:line:column: message".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// Module vets the formula module in dir, the directory holding its
+// versions.json, and returns the problems found, ordered by file and
+// position. The error is only set if the files cannot be read.
+//
+// It checks that versions.json declares the path of the module, that the
+// path matches dir, which ends with it in a formula repository, and that
+// every formula of the module:
+//   - parses;
+//   - declares its id, matching that path, and its fromVer, as non-empty
+//     string literals;
+//   - has a fromVer no other formula of the module has;
+//   - only reads the matrix keys (ctx.matrix, deps.matrix) and options
+//     (ctx.option, deps.option) its matrix declares, if it declares one,
+//     and only gives default values to its options.
+//
+// A comparator (a _cmp.gox file) must parse and set compareVer, and a
+// module has at most one.
+func Module(dir string) ([]Diagnostic, error) {
+	v := &vetter{fset: token.NewFileSet(), fromVers: make(map[string]token.Position)}
+
+	vfile := filepath.Join(dir, "versions.json")
+	data, err := os.ReadFile(vfile)
+	if err != nil {
+		return nil, err
+	}
+	vers, err := versions.Parse(vfile, data)
+	switch {
+	case err != nil:
+		v.reportAt(token.Position{Filename: vfile}, "%v", err)
+	case vers.Path == "":
+		v.reportAt(token.Position{Filename: vfile}, "missing module path")
+	default:
+		v.modPath = vers.Path
+		if !strings.HasSuffix(filepath.ToSlash(filepath.Clean(dir)), "/"+vers.Path) {
+			v.reportAt(token.Position{Filename: vfile}, "module path %s does not match directory %s", vers.Path, dir)
+		}
+	}
+
+	var comparators []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			// A nested module is vetted on its own.
+			if path != dir {
+				if _, err := os.Stat(filepath.Join(path, "versions.json")); err == nil {
+					return filepath.SkipDir
+				}
+			}
+		case strings.HasSuffix(path, formulaSuffix):
+			return v.formula(path)
+		case strings.HasSuffix(path, comparatorSuffix):
+			comparators = append(comparators, path)
+			return v.comparator(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range comparators[min(1, len(comparators)):] {
+		v.reportAt(token.Position{Filename: path}, "more than one comparator: %s is used", comparators[0])
+	}
+
+	slices.SortStableFunc(v.diags, func(a, b Diagnostic) int {
+		if c := strings.Compare(a.Pos.Filename, b.Pos.Filename); c != 0 {
+			return c
+		}
+		if a.Pos.Line != b.Pos.Line {
+			return a.Pos.Line - b.Pos.Line
+		}
+		return a.Pos.Column - b.Pos.Column
+	})
+	return v.diags, nil
+}
+
+// vetter collects the diagnostics of a module.
+type vetter struct {
+	fset     *token.FileSet
+	modPath  string                    // from versions.json, "" if unknown
+	fromVers map[string]token.Position // fromVer -> where it is declared
+	diags    []Diagnostic
+}
+
+func (v *vetter) report(pos token.Pos, format string, args ...any) {
+	v.reportAt(v.fset.Position(pos), format, args...)
+}
+
+func (v *vetter) reportAt(pos token.Position, format string, args ...any) {
+	v.diags = append(v.diags, Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+// parse parses the classfile path, reporting syntax errors. It returns
+// nil if the file does not parse.
+func (v *vetter) parse(path string) (*ast.File, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parser.ParseEntry(v.fset, path, content, parser.Config{ClassKind: xgobuild.ClassKind})
+	if err != nil {
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				v.reportAt(e.Pos, "%s", e.Msg)
+			}
+		} else {
+			v.reportAt(token.Position{Filename: path}, "%v", err)
+		}
+		return nil, nil
+	}
+	return f, nil
+}
+
+// formula vets the formula file path.
+func (v *vetter) formula(path string) error {
+	f, err := v.parse(path)
+	if f == nil {
+		return err
+	}
+
+	calls := topLevelCalls(f)
+	if id, ok := v.stringArg(path, calls, "id"); ok && v.modPath != "" && id.value != v.modPath {
+		v.report(id.pos, "id %q does not match module path %s", id.value, v.modPath)
+	}
+	if fromVer, ok := v.stringArg(path, calls, "fromVer"); ok {
+		if prev, dup := v.fromVers[fromVer.value]; dup {
+			v.report(fromVer.pos, "fromVer %q is also declared at %s", fromVer.value, prev)
+		} else {
+			v.fromVers[fromVer.value] = v.fset.Position(fromVer.pos)
+		}
+	}
+
+	matrix := calls["matrix"]
+	if len(matrix) == 0 {
+		return nil
+	}
+	require, options, ok := v.matrix(matrix[0])
+	if !ok {
+		return nil
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		c, ok := n.(*ast.CallExpr)
+		if !ok || len(c.Args) != 1 {
+			return true
+		}
+		sel, ok := c.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		key, ok := stringLit(c.Args[0])
+		if !ok {
+			return true
+		}
+		switch sel.Sel.Name {
+		case "matrix", "Matrix":
+			if !require[key] {
+				v.report(c.Args[0].Pos(), "unknown matrix key %q", key)
+			}
+		case "option", "Option":
+			if _, ok := options[key]; !ok {
+				v.report(c.Args[0].Pos(), "unknown matrix option %q", key)
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// matrix vets the matrix declared by call and returns its keys and its
+// options with their values. ok is false if the matrix is not a literal.
+func (v *vetter) matrix(call *ast.CallExpr) (require map[string]bool, options map[string][]string, ok bool) {
+	if len(call.Args) != 1 {
+		return nil, nil, false
+	}
+	lit, ok := call.Args[0].(*ast.CompositeLit)
+	if !ok {
+		return nil, nil, false
+	}
+	fields := make(map[string]*ast.CompositeLit)
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, nil, false
+		}
+		name, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			return nil, nil, false
+		}
+		value, ok := kv.Value.(*ast.CompositeLit)
+		if !ok {
+			return nil, nil, false
+		}
+		fields[name.Name] = value
+	}
+
+	values := func(lit *ast.CompositeLit, field string) (map[string][]string, []*ast.KeyValueExpr, bool) {
+		m := make(map[string][]string)
+		var keys []*ast.KeyValueExpr
+		if lit == nil {
+			return m, nil, true
+		}
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				return nil, nil, false
+			}
+			key, ok := stringLit(kv.Key)
+			if !ok {
+				return nil, nil, false
+			}
+			vals, ok := stringList(kv.Value)
+			if !ok {
+				return nil, nil, false
+			}
+			if _, dup := m[key]; dup {
+				v.report(kv.Key.Pos(), "duplicate %s key %q", field, key)
+			}
+			m[key] = vals
+			keys = append(keys, kv)
+		}
+		return m, keys, true
+	}
+	req, _, ok := values(fields["Require"], "Require")
+	if !ok {
+		return nil, nil, false
+	}
+	options, _, ok = values(fields["Options"], "Options")
+	if !ok {
+		return nil, nil, false
+	}
+	defaults, defaultKeys, ok := values(fields["DefaultOptions"], "DefaultOptions")
+	if !ok {
+		return nil, nil, false
+	}
+	for _, kv := range defaultKeys {
+		key, _ := stringLit(kv.Key)
+		allowed, ok := options[key]
+		if !ok {
+			v.report(kv.Key.Pos(), "default of unknown matrix option %q", key)
+			continue
+		}
+		for _, value := range defaults[key] {
+			if !slices.Contains(allowed, value) {
+				v.report(kv.Value.Pos(), "default %q of matrix option %q is not one of its values %q", value, key, allowed)
+			}
+		}
+	}
+
+	require = make(map[string]bool, len(req))
+	for k := range req {
+		require[k] = true
+	}
+	return require, options, true
+}
+
+// comparator vets the comparator file path.
+func (v *vetter) comparator(path string) error {
+	f, err := v.parse(path)
+	if f == nil {
+		return err
+	}
+	if len(topLevelCalls(f)["compareVer"]) == 0 {
+		v.reportAt(token.Position{Filename: path}, "comparator does not set compareVer")
+	}
+	return nil
+}
+
+// stringValue is a string literal argument and its position.
+type stringValue struct {
+	value string
+	pos   token.Pos
+}
+
+// stringArg returns the string literal argument of the single call to
+// name among calls, reporting it if it is missing, declared more than
+// once or not a non-empty string literal.
+func (v *vetter) stringArg(path string, calls map[string][]*ast.CallExpr, name string) (stringValue, bool) {
+	list := calls[name]
+	if len(list) == 0 {
+		v.reportAt(token.Position{Filename: path}, "missing %s", name)
+		return stringValue{}, false
+	}
+	for _, c := range list[1:] {
+		v.report(c.Pos(), "%s is declared more than once", name)
+	}
+	c := list[0]
+	if len(c.Args) != 1 {
+		v.report(c.Pos(), "%s takes a single string literal", name)
+		return stringValue{}, false
+	}
+	s, ok := stringLit(c.Args[0])
+	if !ok {
+		v.report(c.Args[0].Pos(), "%s is not a string literal", name)
+		return stringValue{}, false
+	}
+	if s == "" {
+		v.report(c.Args[0].Pos(), "%s is empty", name)
+		return stringValue{}, false
+	}
+	return stringValue{value: s, pos: c.Args[0].Pos()}, true
+}
+
+// topLevelCalls returns the calls of the statements of the class body of
+// f, such as id and fromVer, by name.
+func topLevelCalls(f *ast.File) map[string][]*ast.CallExpr {
+	calls := make(map[string][]*ast.CallExpr)
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || !fn.Shadow || fn.Body == nil {
+			continue
+		}
+		for _, stmt := range fn.Body.List {
+			expr, ok := stmt.(*ast.ExprStmt)
+			if !ok {
+				continue
+			}
+			c, ok := expr.X.(*ast.CallExpr)
+			if !ok {
+				continue
+			}
+			if name, ok := c.Fun.(*ast.Ident); ok {
+				calls[name.Name] = append(calls[name.Name], c)
+			}
+		}
+	}
+	return calls
+}
+
+// stringLit returns the value of the string literal expr.
+func stringLit(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// stringList returns the values of the list of string literals expr.
+func stringList(expr ast.Expr) ([]string, bool) {
+	var elts []ast.Expr
+	switch lit := expr.(type) {
+	case *ast.SliceLit:
+		elts = lit.Elts
+	case *ast.CompositeLit:
+		elts = lit.Elts
+	default:
+		return nil, false
+	}
+	values := make([]string, 0, len(elts))
+	for _, elt := range elts {
+		s, ok := stringLit(elt)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, s)
+	}
+	return values, true
+}
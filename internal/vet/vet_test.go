@@ -0,0 +1,57 @@
+// Copyright (c) 2026 The XGo Authors (xgo.dev). All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vet
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestModule(t *testing.T) {
+	tests := []struct {
+		mod  string
+		want []string
+	}{
+		{"good", nil},
+		{"bad", []string{
+			`testdata/towner/bad/1.0.0/Bad_llar.gox:1:4: id "towner/other" does not match module path towner/bad`,
+			`testdata/towner/bad/1.1.0/Bad_llar.gox: missing id`,
+			`testdata/towner/bad/1.1.0/Bad_llar.gox:2:9: fromVer is not a string literal`,
+			`testdata/towner/bad/2.0.0/Bad_llar.gox:3:9: fromVer "1.0.0" is also declared at testdata/towner/bad/1.0.0/Bad_llar.gox:3:9`,
+			`testdata/towner/bad/2.0.0/Bad_llar.gox:8:29: default "yes" of matrix option "shared" is not one of its values ["on" "off"]`,
+			`testdata/towner/bad/2.0.0/Bad_llar.gox:8:38: default of unknown matrix option "pic"`,
+			`testdata/towner/bad/2.0.0/Bad_llar.gox:12:21: unknown matrix key "arch"`,
+			`testdata/towner/bad/2.0.0/Bad_llar.gox:12:63: unknown matrix option "ssl"`,
+			`testdata/towner/bad/Bad_cmp.gox: comparator does not set compareVer`,
+		}},
+		{"syntax", []string{
+			`testdata/towner/syntax/Syntax_llar.gox:6:5: expected statement, found '?'`,
+			`testdata/towner/syntax/Syntax_llar.gox:7:3: expected '}', found 'EOF'`,
+		}},
+		{"moved", []string{
+			`testdata/towner/moved/versions.json: module path towner/elsewhere does not match directory testdata/towner/moved`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mod, func(t *testing.T) {
+			diags, err := Module(filepath.Join("testdata", "towner", tt.mod))
+			if err != nil {
+				t.Fatalf("Module failed: %v", err)
+			}
+			var got []string
+			for _, d := range diags {
+				got = append(got, filepath.ToSlash(d.String()))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("diagnostics =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Module(filepath.Join("testdata", "towner")); err == nil {
+		t.Error("Module of a directory without versions.json succeeded")
+	}
+}
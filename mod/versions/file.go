@@ -8,16 +8,20 @@ package versions
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"maps"
 	"os"
+	"slices"
 
 	"github.com/goplus/llar/mod/module"
 )
 
 // Versions represents a module's version file containing its dependencies.
 type Versions struct {
-	Path         string                      `json:"path"` // Module Path
-	Dependencies map[string][]module.Version `json:"deps"` // Map of dependency name to dependency details
+	Path         string                      `json:"path"`             // Module Path
+	Source       string                      `json:"source,omitempty"` // Source repository: host/owner/repo or a git URL
+	Dependencies map[string][]module.Version `json:"deps"`             // Map of dependency name to dependency details
 }
 
 // Parse reads and parses a version file from either provided data or a file path.
@@ -47,3 +51,42 @@ func Parse(file string, data []byte) (*Versions, error) {
 
 	return &v, nil
 }
+
+// Format returns v in the layout of a versions.json file: indented with
+// tabs, with the dependencies of every version, in the order of the
+// versions given by compare, and one dependency per line.
+func (v *Versions) Format(compare func(v1, v2 string) int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "{\n\t\"path\": %s,\n", quote(v.Path))
+	if v.Source != "" {
+		fmt.Fprintf(&b, "\t\"source\": %s,\n", quote(v.Source))
+	}
+	b.WriteString("\t\"deps\": {")
+	for i, version := range slices.SortedFunc(maps.Keys(v.Dependencies), compare) {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "\n\t\t%s: [", quote(version))
+		deps := v.Dependencies[version]
+		for j, dep := range deps {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "\n\t\t\t{\"path\": %s, \"version\": %s}", quote(dep.Path), quote(dep.Version))
+		}
+		if len(deps) > 0 {
+			b.WriteString("\n\t\t")
+		}
+		b.WriteString("]")
+	}
+	if len(v.Dependencies) > 0 {
+		b.WriteString("\n\t")
+	}
+	b.WriteString("}\n}\n")
+	return b.Bytes()
+}
+
+func quote(s string) string {
+	data, _ := json.Marshal(s) // a string cannot fail to encode
+	return string(data)
+}
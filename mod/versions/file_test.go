@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/goplus/llar/mod/module"
+	"github.com/goplus/llar/x/gnu"
 )
 
 func TestParse_WithData(t *testing.T) {
@@ -56,6 +57,15 @@ func TestParse_WithData(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "source repository",
+			data: `{"path": "example/module", "source": "https://gitlab.com/group/module.git"}`,
+			want: &Versions{
+				Path:   "example/module",
+				Source: "https://gitlab.com/group/module.git",
+			},
+			wantErr: false,
+		},
 		{
 			name:    "invalpath json",
 			data:    `{"path": invalpath}`,
@@ -83,6 +93,9 @@ func TestParse_WithData(t *testing.T) {
 			if got.Path != tt.want.Path {
 				t.Errorf("Parse() Path = %v, want %v", got.Path, tt.want.Path)
 			}
+			if got.Source != tt.want.Source {
+				t.Errorf("Parse() Source = %v, want %v", got.Source, tt.want.Source)
+			}
 			if len(got.Dependencies) != len(tt.want.Dependencies) {
 				t.Errorf("Parse() Dependencies len = %v, want %v", len(got.Dependencies), len(tt.want.Dependencies))
 				return
@@ -163,3 +176,43 @@ func TestParse_DataTakesPrecedence(t *testing.T) {
 		t.Errorf("Parse() Path = %v, want from/data (data should take precedence)", got.Path)
 	}
 }
+
+func TestVersions_Format(t *testing.T) {
+	v := &Versions{
+		Path:   "towner/app",
+		Source: "https://gitlab.com/towner/app.git",
+		Dependencies: map[string][]module.Version{
+			"1.10.0": {{Path: "towner/liba", Version: "1.2.0"}, {Path: "towner/libb", Version: "1.0.0"}},
+			"1.9.0":  {{Path: "towner/liba", Version: "1.0.0"}},
+			"0.9.0":  {},
+		},
+	}
+	want := `{
+	"path": "towner/app",
+	"source": "https://gitlab.com/towner/app.git",
+	"deps": {
+		"0.9.0": [],
+		"1.9.0": [
+			{"path": "towner/liba", "version": "1.0.0"}
+		],
+		"1.10.0": [
+			{"path": "towner/liba", "version": "1.2.0"},
+			{"path": "towner/libb", "version": "1.0.0"}
+		]
+	}
+}
+`
+	got := v.Format(gnu.Compare)
+	if string(got) != want {
+		t.Errorf("Format() =\n%s\nwant\n%s", got, want)
+	}
+	parsed, err := Parse("", got)
+	if err != nil || !reflect.DeepEqual(parsed, v) {
+		t.Errorf("Parse(Format()) = %+v, %v, want %+v", parsed, err, v)
+	}
+
+	empty := (&Versions{Path: "towner/leaf"}).Format(gnu.Compare)
+	if want := "{\n\t\"path\": \"towner/leaf\",\n\t\"deps\": {}\n}\n"; string(empty) != want {
+		t.Errorf("Format() of no deps = %q, want %q", empty, want)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+
+	"github.com/goplus/llar/formula"
 )
 
 // AutoTools drives Autotools-style builds.
@@ -13,52 +15,70 @@ type AutoTools struct {
 	sourceDir  string
 	buildDir   string
 	installDir string
+	env        *formula.Env
 }
 
-// New returns a ready-to-use AutoTools.
+// New returns a ready-to-use AutoTools. It runs with the environment of the
+// build whose sources contain sourceDir, ctx.env, so that it sees the
+// variables the formula sets and the tools on its PATH, and Use changes
+// what exec sees too. Outside of a build it starts from a private snapshot
+// of the process environment.
 func New(sourceDir, buildDir, installDir string) *AutoTools {
 	return &AutoTools{
 		sourceDir:  sourceDir,
 		buildDir:   buildDir,
 		installDir: installDir,
+		env:        newEnv(sourceDir),
+	}
+}
+
+func newEnv(sourceDir string) *formula.Env {
+	if env := formula.BoundEnv(sourceDir); env != nil {
+		return env
 	}
+	return formula.NewEnv(os.Environ())
 }
 
 // Source overrides the source directory.
 func (a *AutoTools) Source(dir string) { a.sourceDir = dir }
 
-// Use configures the process environment so that compilers and build tools
-// find headers, libraries and pkg-config files from a non-system dependency
-// installed at root.
+// Env overrides the environment configure and make run with, e.g. with a
+// private ctx.env.clone so that Use does not change what exec sees.
+func (a *AutoTools) Env(env *formula.Env) { a.env = env }
+
+// Use configures the environment configure and make run with so that
+// compilers and build tools find headers, libraries and pkg-config files
+// from a non-system dependency installed at root. The process environment
+// is not modified.
 func (a *AutoTools) Use(root string) {
 	includeDir := filepath.Join(root, "include")
 	libDir := filepath.Join(root, "lib")
 	pkgconfigDir := filepath.Join(libDir, "pkgconfig")
 
 	if _, err := os.Stat(pkgconfigDir); err == nil {
-		prependPath("PKG_CONFIG_PATH", pkgconfigDir)
+		a.env.Prepend("PKG_CONFIG_PATH", pkgconfigDir)
 	}
-	prependPath("CMAKE_PREFIX_PATH", root)
+	a.env.Prepend("CMAKE_PREFIX_PATH", root)
 	if _, err := os.Stat(includeDir); err == nil {
-		prependPath("CMAKE_INCLUDE_PATH", includeDir)
+		a.env.Prepend("CMAKE_INCLUDE_PATH", includeDir)
 	}
 	if _, err := os.Stat(libDir); err == nil {
-		prependPath("CMAKE_LIBRARY_PATH", libDir)
+		a.env.Prepend("CMAKE_LIBRARY_PATH", libDir)
 	}
 
 	if runtime.GOOS == "windows" {
 		if _, err := os.Stat(includeDir); err == nil {
-			prependPath("INCLUDE", includeDir)
+			a.env.Prepend("INCLUDE", includeDir)
 		}
 		if _, err := os.Stat(libDir); err == nil {
-			prependPath("LIB", libDir)
+			a.env.Prepend("LIB", libDir)
 		}
 	} else {
 		if _, err := os.Stat(includeDir); err == nil {
-			appendFlag("CPPFLAGS", "-I"+includeDir)
+			a.env.AppendFlag("CPPFLAGS", "-I"+includeDir)
 		}
 		if _, err := os.Stat(libDir); err == nil {
-			appendFlag("LDFLAGS", "-L"+libDir)
+			a.env.AppendFlag("LDFLAGS", "-L"+libDir)
 		}
 	}
 }
@@ -72,7 +92,7 @@ func (a *AutoTools) Configure(args ...string) error {
 		return err
 	}
 	exe := filepath.Join(a.sourceDir, "configure")
-	if dir == "." {
+	if dir == "." || dir == a.sourceDir {
 		exe = "./configure"
 	}
 	flags := make([]string, 0, 1+len(args))
@@ -100,37 +120,23 @@ func (a *AutoTools) OutputDir() string {
 	return a.buildDir
 }
 
+// workDir returns the directory commands run in: buildDir if set, otherwise
+// sourceDir (an in-tree build), falling back to the current directory.
 func (a *AutoTools) workDir() string {
-	if a.buildDir == "" {
-		return "."
+	if a.buildDir != "" {
+		return a.buildDir
 	}
-	return a.buildDir
+	if a.sourceDir != "" {
+		return a.sourceDir
+	}
+	return "."
 }
 
 func (a *AutoTools) run(name string, args []string) error {
 	cmd := exec.Command(name, args...)
 	cmd.Dir = a.workDir()
+	cmd.Env = a.env.Environ()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
-
-// prependPath prepends value to a PATH-style env var.
-func prependPath(key, value string) {
-	sep := ":"
-	if runtime.GOOS == "windows" {
-		sep = ";"
-	}
-	if cur := os.Getenv(key); cur != "" {
-		value += sep + cur
-	}
-	os.Setenv(key, value)
-}
-
-// appendFlag appends a space-separated flag to an env var.
-func appendFlag(key, flag string) {
-	if cur := os.Getenv(key); cur != "" {
-		flag = cur + " " + flag
-	}
-	os.Setenv(key, flag)
-}
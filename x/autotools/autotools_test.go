@@ -7,6 +7,8 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/goplus/llar/formula"
 )
 
 func TestUseSetsEnv(t *testing.T) {
@@ -36,23 +38,23 @@ func TestUseSetsEnv(t *testing.T) {
 		"CMAKE_INCLUDE_PATH": includeDir,
 		"CMAKE_LIBRARY_PATH": libDir,
 	} {
-		if got := os.Getenv(key); got != want {
+		if got := a.env.Get(key); got != want {
 			t.Errorf("%s = %q, want %q", key, got, want)
 		}
 	}
 
 	if runtime.GOOS == "windows" {
-		if got := os.Getenv("INCLUDE"); got != includeDir {
+		if got := a.env.Get("INCLUDE"); got != includeDir {
 			t.Errorf("INCLUDE = %q, want %q", got, includeDir)
 		}
-		if got := os.Getenv("LIB"); got != libDir {
+		if got := a.env.Get("LIB"); got != libDir {
 			t.Errorf("LIB = %q, want %q", got, libDir)
 		}
 	} else {
-		if got := os.Getenv("CPPFLAGS"); strings.TrimSpace(got) != "-I"+includeDir {
+		if got := a.env.Get("CPPFLAGS"); strings.TrimSpace(got) != "-I"+includeDir {
 			t.Errorf("CPPFLAGS = %q, want %q", got, "-I"+includeDir)
 		}
-		if got := os.Getenv("LDFLAGS"); strings.TrimSpace(got) != "-L"+libDir {
+		if got := a.env.Get("LDFLAGS"); strings.TrimSpace(got) != "-L"+libDir {
 			t.Errorf("LDFLAGS = %q, want %q", got, "-L"+libDir)
 		}
 	}
@@ -76,8 +78,8 @@ func TestUseMultipleDeps(t *testing.T) {
 	a.Use(root1)
 	a.Use(root2)
 
-	// prependPath: root2 should be prepended before root1
-	got := os.Getenv("CMAKE_PREFIX_PATH")
+	// Prepend: root2 should be prepended before root1
+	got := a.env.Get("CMAKE_PREFIX_PATH")
 	if !strings.HasPrefix(got, root2) {
 		t.Errorf("CMAKE_PREFIX_PATH = %q, expected %q to be first", got, root2)
 	}
@@ -85,8 +87,8 @@ func TestUseMultipleDeps(t *testing.T) {
 		t.Errorf("CMAKE_PREFIX_PATH = %q, missing %q", got, root1)
 	}
 
-	// appendFlag: root1 flag should come before root2 flag
-	cppflags := os.Getenv("CPPFLAGS")
+	// AppendFlag: root1 flag should come before root2 flag
+	cppflags := a.env.Get("CPPFLAGS")
 	i1 := strings.Index(cppflags, filepath.Join(root1, "include"))
 	i2 := strings.Index(cppflags, filepath.Join(root2, "include"))
 	if i1 < 0 || i2 < 0 || i1 >= i2 {
@@ -107,10 +109,10 @@ func TestUsePartialDirs(t *testing.T) {
 	a := New("", "", "")
 	a.Use(root)
 
-	if got := os.Getenv("PKG_CONFIG_PATH"); got != "" {
+	if got := a.env.Get("PKG_CONFIG_PATH"); got != "" {
 		t.Errorf("PKG_CONFIG_PATH = %q, want empty", got)
 	}
-	if got := os.Getenv("CMAKE_LIBRARY_PATH"); got != "" {
+	if got := a.env.Get("CMAKE_LIBRARY_PATH"); got != "" {
 		t.Errorf("CMAKE_LIBRARY_PATH = %q, want empty", got)
 	}
 }
@@ -139,29 +141,48 @@ func TestWorkDir(t *testing.T) {
 	if got := New("", "/tmp/b", "").workDir(); got != "/tmp/b" {
 		t.Errorf("workDir set = %q, want %q", got, "/tmp/b")
 	}
+	if got := New("/tmp/src", "", "").workDir(); got != "/tmp/src" {
+		t.Errorf("workDir in-tree = %q, want %q", got, "/tmp/src")
+	}
+	if got := New("/tmp/src", "/tmp/b", "").workDir(); got != "/tmp/b" {
+		t.Errorf("workDir out-of-tree = %q, want %q", got, "/tmp/b")
+	}
 }
 
-func TestPrependPath(t *testing.T) {
-	t.Setenv("TEST_PREPEND", "/existing")
-	prependPath("TEST_PREPEND", "/new")
+func TestUseLeavesProcessEnv(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "lib", "pkgconfig"), 0o755)
+	t.Setenv("PKG_CONFIG_PATH", "/existing")
+
+	a := New("", "", "")
+	a.Use(root)
 
-	sep := ":"
-	if runtime.GOOS == "windows" {
-		sep = ";"
+	if got := os.Getenv("PKG_CONFIG_PATH"); got != "/existing" {
+		t.Errorf("process PKG_CONFIG_PATH = %q, want untouched %q", got, "/existing")
 	}
-	want := "/new" + sep + "/existing"
-	if got := os.Getenv("TEST_PREPEND"); got != want {
-		t.Errorf("TEST_PREPEND = %q, want %q", got, want)
+	want := filepath.Join(root, "lib", "pkgconfig") + string(os.PathListSeparator) + "/existing"
+	if got := a.env.Get("PKG_CONFIG_PATH"); got != want {
+		t.Errorf("PKG_CONFIG_PATH = %q, want %q", got, want)
 	}
 }
 
-func TestAppendFlag(t *testing.T) {
-	t.Setenv("TEST_FLAGS", "-Ifoo")
-	appendFlag("TEST_FLAGS", "-Ibar")
+func TestEnvShared(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "include"), 0o755)
 
-	want := "-Ifoo -Ibar"
-	if got := os.Getenv("TEST_FLAGS"); got != want {
-		t.Errorf("TEST_FLAGS = %q, want %q", got, want)
+	env := formula.NewEnv([]string{"CPPFLAGS=-DFOO"})
+	a := New("", "", "")
+	a.Env(env)
+	a.Use(root)
+
+	if runtime.GOOS != "windows" {
+		want := "-DFOO -I" + filepath.Join(root, "include")
+		if got := env.Get("CPPFLAGS"); got != want {
+			t.Errorf("CPPFLAGS = %q, want %q", got, want)
+		}
+	}
+	if got := env.Get("CMAKE_INCLUDE_PATH"); got != filepath.Join(root, "include") {
+		t.Errorf("CMAKE_INCLUDE_PATH = %q, want %q", got, filepath.Join(root, "include"))
 	}
 }
 
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+
+	"github.com/goplus/llar/formula"
 )
 
 type defineValue struct {
@@ -23,21 +25,38 @@ type CMake struct {
 	buildType  string
 	toolchain  string
 	defines    map[string]defineValue
+	env        *formula.Env
 }
 
-// New returns a ready-to-use CMake.
+// New returns a ready-to-use CMake. It runs with the environment of the
+// build whose sources contain sourceDir, ctx.env, so that it sees the
+// variables the formula sets and the tools on its PATH, and Use changes
+// what exec sees too. Outside of a build it starts from a private snapshot
+// of the process environment.
 func New(sourceDir, buildDir, installDir string) *CMake {
 	return &CMake{
 		sourceDir:  sourceDir,
 		buildDir:   buildDir,
 		installDir: installDir,
 		defines:    make(map[string]defineValue),
+		env:        newEnv(sourceDir),
+	}
+}
+
+func newEnv(sourceDir string) *formula.Env {
+	if env := formula.BoundEnv(sourceDir); env != nil {
+		return env
 	}
+	return formula.NewEnv(os.Environ())
 }
 
 // Source overrides the source directory.
 func (c *CMake) Source(dir string) { c.sourceDir = dir }
 
+// Env overrides the environment cmake runs with, e.g. with a private
+// ctx.env.clone so that Use does not change what exec sees.
+func (c *CMake) Env(env *formula.Env) { c.env = env }
+
 // Generator sets the CMake generator (e.g. "Ninja", "Unix Makefiles").
 func (c *CMake) Generator(name string) { c.generator = name }
 
@@ -61,9 +80,9 @@ func (c *CMake) DefineBool(key string, value bool) {
 	c.defines[key] = defineValue{value: v, typeName: "BOOL"}
 }
 
-// Use configures the process environment so that CMake and compilers find
-// headers, libraries and pkg-config files from a non-system dependency
-// installed at root.
+// Use configures the environment cmake runs with so that CMake and
+// compilers find headers, libraries and pkg-config files from a non-system
+// dependency installed at root. The process environment is not modified.
 func (c *CMake) Use(root string) {
 	includeDir := filepath.Join(root, "include")
 	libDir := filepath.Join(root, "lib")
@@ -76,30 +95,30 @@ func (c *CMake) Use(root string) {
 
 	if hasLib {
 		if _, err := os.Stat(pkgconfigDir); err == nil {
-			prependPath("PKG_CONFIG_PATH", pkgconfigDir)
+			c.env.Prepend("PKG_CONFIG_PATH", pkgconfigDir)
 		}
 	}
-	prependPath("CMAKE_PREFIX_PATH", root)
+	c.env.Prepend("CMAKE_PREFIX_PATH", root)
 	if hasInclude {
-		prependPath("CMAKE_INCLUDE_PATH", includeDir)
+		c.env.Prepend("CMAKE_INCLUDE_PATH", includeDir)
 	}
 	if hasLib {
-		prependPath("CMAKE_LIBRARY_PATH", libDir)
+		c.env.Prepend("CMAKE_LIBRARY_PATH", libDir)
 	}
 
 	if runtime.GOOS == "windows" {
 		if hasInclude {
-			prependPath("INCLUDE", includeDir)
+			c.env.Prepend("INCLUDE", includeDir)
 		}
 		if hasLib {
-			prependPath("LIB", libDir)
+			c.env.Prepend("LIB", libDir)
 		}
 	} else {
 		if hasInclude {
-			appendFlag("CPPFLAGS", "-I"+includeDir)
+			c.env.AppendFlag("CPPFLAGS", "-I"+includeDir)
 		}
 		if hasLib {
-			appendFlag("LDFLAGS", "-L"+libDir)
+			c.env.AppendFlag("LDFLAGS", "-L"+libDir)
 		}
 	}
 }
@@ -158,6 +177,7 @@ func (c *CMake) OutputDir() string {
 
 func (c *CMake) run(name string, args []string) error {
 	cmd := exec.Command(name, args...)
+	cmd.Env = c.env.Environ()
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -179,23 +199,3 @@ func (c *CMake) definesArgs() []string {
 	}
 	return args
 }
-
-// prependPath prepends value to a PATH-style env var.
-func prependPath(key, value string) {
-	sep := ":"
-	if runtime.GOOS == "windows" {
-		sep = ";"
-	}
-	if cur := os.Getenv(key); cur != "" {
-		value += sep + cur
-	}
-	os.Setenv(key, value)
-}
-
-// appendFlag appends a space-separated flag to an env var.
-func appendFlag(key, flag string) {
-	if cur := os.Getenv(key); cur != "" {
-		flag = cur + " " + flag
-	}
-	os.Setenv(key, flag)
-}
@@ -7,6 +7,8 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/goplus/llar/formula"
 )
 
 func TestUseSetsEnv(t *testing.T) {
@@ -36,23 +38,23 @@ func TestUseSetsEnv(t *testing.T) {
 		"CMAKE_INCLUDE_PATH": includeDir,
 		"CMAKE_LIBRARY_PATH": libDir,
 	} {
-		if got := os.Getenv(key); got != want {
+		if got := c.env.Get(key); got != want {
 			t.Errorf("%s = %q, want %q", key, got, want)
 		}
 	}
 
 	if runtime.GOOS == "windows" {
-		if got := os.Getenv("INCLUDE"); got != includeDir {
+		if got := c.env.Get("INCLUDE"); got != includeDir {
 			t.Errorf("INCLUDE = %q, want %q", got, includeDir)
 		}
-		if got := os.Getenv("LIB"); got != libDir {
+		if got := c.env.Get("LIB"); got != libDir {
 			t.Errorf("LIB = %q, want %q", got, libDir)
 		}
 	} else {
-		if got := os.Getenv("CPPFLAGS"); strings.TrimSpace(got) != "-I"+includeDir {
+		if got := c.env.Get("CPPFLAGS"); strings.TrimSpace(got) != "-I"+includeDir {
 			t.Errorf("CPPFLAGS = %q, want %q", got, "-I"+includeDir)
 		}
-		if got := os.Getenv("LDFLAGS"); strings.TrimSpace(got) != "-L"+libDir {
+		if got := c.env.Get("LDFLAGS"); strings.TrimSpace(got) != "-L"+libDir {
 			t.Errorf("LDFLAGS = %q, want %q", got, "-L"+libDir)
 		}
 	}
@@ -71,10 +73,10 @@ func TestUsePartialDirs(t *testing.T) {
 	c := New("", "", "")
 	c.Use(root)
 
-	if got := os.Getenv("PKG_CONFIG_PATH"); got != "" {
+	if got := c.env.Get("PKG_CONFIG_PATH"); got != "" {
 		t.Errorf("PKG_CONFIG_PATH = %q, want empty", got)
 	}
-	if got := os.Getenv("CMAKE_LIBRARY_PATH"); got != "" {
+	if got := c.env.Get("CMAKE_LIBRARY_PATH"); got != "" {
 		t.Errorf("CMAKE_LIBRARY_PATH = %q, want empty", got)
 	}
 }
@@ -128,26 +130,59 @@ func TestSource(t *testing.T) {
 	}
 }
 
-func TestPrependPath(t *testing.T) {
-	t.Setenv("TEST_PREPEND", "/existing")
-	prependPath("TEST_PREPEND", "/new")
+func TestUseLeavesProcessEnv(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "lib", "pkgconfig"), 0o755)
+	t.Setenv("PKG_CONFIG_PATH", "/existing")
 
-	sep := ":"
-	if runtime.GOOS == "windows" {
-		sep = ";"
+	c := New("", "", "")
+	c.Use(root)
+
+	if got := os.Getenv("PKG_CONFIG_PATH"); got != "/existing" {
+		t.Errorf("process PKG_CONFIG_PATH = %q, want untouched %q", got, "/existing")
+	}
+	want := filepath.Join(root, "lib", "pkgconfig") + string(os.PathListSeparator) + "/existing"
+	if got := c.env.Get("PKG_CONFIG_PATH"); got != want {
+		t.Errorf("PKG_CONFIG_PATH = %q, want %q", got, want)
+	}
+}
+
+func TestEnvShared(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "include"), 0o755)
+
+	env := formula.NewEnv([]string{"CPPFLAGS=-DFOO"})
+	c := New("", "", "")
+	c.Env(env)
+	c.Use(root)
+
+	if runtime.GOOS != "windows" {
+		want := "-DFOO -I" + filepath.Join(root, "include")
+		if got := env.Get("CPPFLAGS"); got != want {
+			t.Errorf("CPPFLAGS = %q, want %q", got, want)
+		}
 	}
-	want := "/new" + sep + "/existing"
-	if got := os.Getenv("TEST_PREPEND"); got != want {
-		t.Errorf("TEST_PREPEND = %q, want %q", got, want)
+	if got := env.Get("CMAKE_INCLUDE_PATH"); got != filepath.Join(root, "include") {
+		t.Errorf("CMAKE_INCLUDE_PATH = %q, want %q", got, filepath.Join(root, "include"))
 	}
 }
 
-func TestAppendFlag(t *testing.T) {
-	t.Setenv("TEST_FLAGS", "-Ifoo")
-	appendFlag("TEST_FLAGS", "-Ibar")
+func TestBoundEnv(t *testing.T) {
+	src := t.TempDir()
+	env := formula.NewEnv([]string{"PATH=/tools/bin"})
+	defer formula.BindEnv(src, env)()
+
+	c := New(filepath.Join(src, "tests"), filepath.Join(src, "_build"), "")
+	c.Use(t.TempDir())
+	if c.env != env {
+		t.Fatal("New did not pick up the environment bound to its source dir")
+	}
+	if got := env.Get("CMAKE_PREFIX_PATH"); got == "" {
+		t.Error("Use did not change the bound environment")
+	}
 
-	if got := os.Getenv("TEST_FLAGS"); got != "-Ifoo -Ibar" {
-		t.Errorf("TEST_FLAGS = %q, want %q", got, "-Ifoo -Ibar")
+	if New(t.TempDir(), "", "").env == env {
+		t.Error("New outside the bound dir shares its environment")
 	}
 }
 
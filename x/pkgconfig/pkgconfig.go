@@ -0,0 +1,214 @@
+// Package pkgconfig reads the pkg-config .pc files a build installs, to
+// describe its output as a formula.PkgConfig.
+package pkgconfig
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goplus/llar/formula"
+)
+
+// File is a parsed .pc file, with its variables expanded.
+type File struct {
+	Name        string
+	Description string
+	Version     string
+
+	Requires        []string // such as "zlib" or "libpng >= 1.6"
+	RequiresPrivate []string
+	Cflags          string
+	Libs            string
+	LibsPrivate     string
+
+	// Vars holds the variables the file defines, expanded.
+	Vars map[string]string
+}
+
+// Parse parses the content of a .pc file. vars predefines variables, such
+// as pcfiledir; they take precedence over the definitions of the file,
+// like pkg-config --define-variable.
+func Parse(data []byte, vars map[string]string) (*File, error) {
+	f := &File{Vars: make(map[string]string)}
+	lookup := func(name string) (string, bool) {
+		if v, ok := vars[name]; ok {
+			return v, true
+		}
+		v, ok := f.Vars[name]
+		return v, ok
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := sc.Text()
+		// A trailing backslash continues the line.
+		for strings.HasSuffix(line, "\\") && sc.Scan() {
+			line = line[:len(line)-1] + sc.Text()
+			lineno++
+		}
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		i := strings.IndexAny(line, ":=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: want a variable or a field, got %q", lineno, line)
+		}
+		name := strings.TrimSpace(line[:i])
+		value, err := expand(strings.TrimSpace(line[i+1:]), lookup)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+		if line[i] == '=' {
+			if _, ok := f.Vars[name]; ok {
+				return nil, fmt.Errorf("line %d: variable %s is defined twice", lineno, name)
+			}
+			f.Vars[name] = value
+			continue
+		}
+		switch name {
+		case "Name":
+			f.Name = value
+		case "Description":
+			f.Description = value
+		case "Version":
+			f.Version = value
+		case "Requires":
+			f.Requires = parseRequires(value)
+		case "Requires.private":
+			f.RequiresPrivate = parseRequires(value)
+		case "Cflags", "CFlags":
+			f.Cflags = value
+		case "Libs":
+			f.Libs = value
+		case "Libs.private":
+			f.LibsPrivate = value
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ParseFile parses the .pc file at path, with the variable pcfiledir set
+// to its directory.
+func ParseFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(data, map[string]string{"pcfiledir": filepath.Dir(path)})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Load returns the metadata of the build output installed in dir, read
+// from the .pc files of dir/lib/pkgconfig. The variable prefix of the
+// files is set to dir, like pkg-config --define-prefix does, so that the
+// flags point to dir wherever the files were configured for.
+//
+// The metadata of several files is merged in the order of their names by
+// formula.MergePkgConfig, and only the requirements on packages not in
+// dir/lib/pkgconfig are kept. It is an error if there is no .pc file.
+func Load(dir string) (formula.PkgConfig, error) {
+	pcDir := filepath.Join(dir, "lib", "pkgconfig")
+	files, err := filepath.Glob(filepath.Join(pcDir, "*.pc"))
+	if err != nil {
+		return formula.PkgConfig{}, err
+	}
+	if len(files) == 0 {
+		return formula.PkgConfig{}, fmt.Errorf("no .pc file in %s: %w", pcDir, fs.ErrNotExist)
+	}
+
+	local := make(map[string]bool, len(files))
+	for _, file := range files {
+		local[strings.TrimSuffix(filepath.Base(file), ".pc")] = true
+	}
+	pcs := make([]formula.PkgConfig, len(files))
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return formula.PkgConfig{}, err
+		}
+		f, err := Parse(data, map[string]string{"pcfiledir": pcDir, "prefix": dir})
+		if err != nil {
+			return formula.PkgConfig{}, fmt.Errorf("%s: %w", file, err)
+		}
+		pc := &pcs[i]
+		pc.AddCflags(f.Cflags)
+		pc.AddLibs(f.Libs)
+		pc.AddStaticLibs(f.LibsPrivate)
+		for _, req := range f.Requires {
+			if name, _, _ := strings.Cut(req, " "); !local[name] {
+				pc.Requires = append(pc.Requires, req)
+			}
+		}
+	}
+	return formula.MergePkgConfig(pcs...), nil
+}
+
+// expand replaces the references ${name} in s with the variables lookup
+// returns. "$$" stands for "$".
+func expand(s string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "$$"):
+			b.WriteByte('$')
+			i++
+		case strings.HasPrefix(s[i:], "${"):
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			name := s[i+2 : i+end]
+			v, ok := lookup(name)
+			if !ok {
+				return "", fmt.Errorf("undefined variable %s", name)
+			}
+			b.WriteString(v)
+			i += end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// parseRequires parses a Requires field: package names separated by
+// commas or spaces, each optionally followed by a version constraint.
+func parseRequires(s string) []string {
+	fields := strings.Fields(strings.ReplaceAll(s, ",", " "))
+	var reqs []string
+	for i := 0; i < len(fields); i++ {
+		req := fields[i]
+		if i+2 < len(fields) && isComparison(fields[i+1]) {
+			req += " " + fields[i+1] + " " + fields[i+2]
+			i += 2
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+func isComparison(s string) bool {
+	switch s {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
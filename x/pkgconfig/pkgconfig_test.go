@@ -0,0 +1,150 @@
+package pkgconfig
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/goplus/llar/formula"
+)
+
+const zlibPC = `prefix=/usr/local
+exec_prefix=${prefix}
+libdir=${exec_prefix}/lib
+includedir=${prefix}/include # headers
+
+Name: zlib
+Description: zlib compression library
+Version: 1.3.1
+
+Requires:
+Libs: -L${libdir} \
+ -lz
+Cflags: -I${includedir}
+`
+
+func TestParse(t *testing.T) {
+	f, err := Parse([]byte(zlibPC), nil)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if f.Name != "zlib" || f.Version != "1.3.1" || f.Description != "zlib compression library" {
+		t.Errorf("Name, Version, Description = %q, %q, %q", f.Name, f.Version, f.Description)
+	}
+	if f.Cflags != "-I/usr/local/include" {
+		t.Errorf("Cflags = %q, want %q", f.Cflags, "-I/usr/local/include")
+	}
+	if f.Libs != "-L/usr/local/lib  -lz" {
+		t.Errorf("Libs = %q, want %q", f.Libs, "-L/usr/local/lib  -lz")
+	}
+	if f.Requires != nil {
+		t.Errorf("Requires = %q, want none", f.Requires)
+	}
+	if got := f.Vars["libdir"]; got != "/usr/local/lib" {
+		t.Errorf("libdir = %q, want %q", got, "/usr/local/lib")
+	}
+
+	// Predefined variables override the ones of the file.
+	f, err = Parse([]byte(zlibPC), map[string]string{"prefix": "/opt/zlib"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if f.Cflags != "-I/opt/zlib/include" {
+		t.Errorf("Cflags with prefix = %q, want %q", f.Cflags, "-I/opt/zlib/include")
+	}
+}
+
+func TestParse_Requires(t *testing.T) {
+	f, err := Parse([]byte("Requires: zlib >= 1.2, libpng  openssl=3.0\nRequires.private: bzip2 < 2\n"), nil)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if want := []string{"zlib >= 1.2", "libpng", "openssl=3.0"}; !reflect.DeepEqual(f.Requires, want) {
+		t.Errorf("Requires = %q, want %q", f.Requires, want)
+	}
+	if want := []string{"bzip2 < 2"}; !reflect.DeepEqual(f.RequiresPrivate, want) {
+		t.Errorf("Requires.private = %q, want %q", f.RequiresPrivate, want)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, data := range []string{
+		"Libs: -L${libdir}\n",
+		"Cflags: -I${prefix\n",
+		"prefix=/a\nprefix=/b\n",
+		"not a field\n",
+	} {
+		if _, err := Parse([]byte(data), nil); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "x.pc")
+	if err := os.WriteFile(file, []byte("Cflags: -I${pcfiledir}/../include -DX=$$\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseFile(file)
+	if err != nil {
+		t.Fatalf("ParseFile failed: %v", err)
+	}
+	if want := "-I" + dir + "/../include -DX=$"; f.Cflags != want {
+		t.Errorf("Cflags = %q, want %q", f.Cflags, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	pcDir := filepath.Join(dir, "lib", "pkgconfig")
+	if err := os.MkdirAll(pcDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"png.pc": `prefix=/build/prefix
+Name: png
+Version: 1.6.0
+Requires: png16, zlib
+Libs: -L${prefix}/lib -lpng
+Cflags: -I${prefix}/include
+`,
+		"png16.pc": `prefix=/build/prefix
+Name: png16
+Version: 1.6.0
+Requires: zlib
+Libs: -L${prefix}/lib -lpng16
+Libs.private: -lm -lpng
+Cflags: -I${prefix}/include -I${prefix}/include/libpng16 -DPNG_SHARED
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(pcDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pc, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := formula.PkgConfig{
+		Cflags:     []string{"-I" + dir + "/include", "-I" + dir + "/include/libpng16"},
+		Defines:    []string{"PNG_SHARED"},
+		Libs:       []string{"-L" + dir + "/lib", "-lpng", "-lpng16"},
+		StaticLibs: []string{"-lm"},
+		Requires:   []string{"zlib"},
+	}
+	if !reflect.DeepEqual(pc, want) {
+		t.Errorf("Load = %+v, want %+v", pc, want)
+	}
+}
+
+func TestLoad_NoFile(t *testing.T) {
+	if _, err := Load(t.TempDir()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Load of a dir without .pc file: err = %v, want fs.ErrNotExist", err)
+	}
+}